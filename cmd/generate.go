@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/yaml"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+)
+
+// generateCommand prints the parameters the plugin endpoint would return for
+// a selector, reading the clusters with the credentials of the caller, so
+// selectors can be debugged before configuring an ApplicationSet.
+func generateCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("namespace-generator generate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	selector := flags.String("selector", "", "Label selector of the namespaces, e.g. konflux.ci/type=user,!app.kubernetes.io/instance")
+	allowAll := flags.Bool("allow-all", false, "Allow an empty selector to match all the namespaces")
+	kubeconfig := flags.String("kubeconfig", "", "Kubeconfig of the local cluster, defaults to $KUBECONFIG or ~/.kube/config")
+	clusterName := flags.String("cluster", "", "Name of the ArgoCD cluster secret of the remote cluster to list, read from the local cluster")
+	secretFile := flags.String("cluster-secret-file", "", "YAML file of the ArgoCD cluster secret of the remote cluster to list, instead of reading it from the local cluster")
+	argoCDNamespace := flags.String("argocd-namespace", generator.DefaultArgoCDNamespace, "Namespace of the ArgoCD cluster secrets")
+	exclude := flags.String("exclude", "", "Comma-separated namespaces to leave out of the result (v1alpha2)")
+	labelKeys := flags.String("label-keys", "", "Comma-separated keys of the namespace labels copied to the output (v1alpha2)")
+	apiVersion := flags.String("api-version", "v1alpha1", "Version of the API of the printed response: v1alpha1, as sent to ArgoCD, or v1alpha2")
+	timeout := flags.Duration("timeout", 30*time.Second, "Timeout of the command")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *apiVersion != "v1alpha1" && *apiVersion != v1alpha2.Version {
+		fmt.Fprintf(stderr, "Error: unsupported API version %q\n", *apiVersion)
+		return 2
+	}
+	if *apiVersion == "v1alpha1" && (*exclude != "" || *labelKeys != "") {
+		fmt.Fprintln(stderr, "Error: --exclude and --label-keys require --api-version=v1alpha2")
+		return 2
+	}
+	if *clusterName != "" && *secretFile != "" {
+		fmt.Fprintln(stderr, "Error: --cluster and --cluster-secret-file are mutually exclusive")
+		return 2
+	}
+
+	labelSelector, err := metav1.ParseToLabelSelector(*selector)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", generrors.Wrap(generrors.ErrSelectorInvalid, err))
+		return 1
+	}
+	if *selector == "" && !*allowAll {
+		fmt.Fprintln(stderr, "Error: the empty selector matches all the namespaces, --allow-all must be set to return them")
+		return 1
+	}
+	req := &v1alpha2.GenerateRequest{Input: v1alpha2.Input{Parameters: v1alpha2.InParameters{
+		LabelSelector:     *labelSelector,
+		ClusterName:       *clusterName,
+		AllowAll:          *allowAll,
+		ExcludeNamespaces: splitList(*exclude),
+		LabelKeys:         splitList(*labelKeys),
+	}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	authProvider := auth.NewCachedProvider(auth.NewGCPProvider(), nil)
+	options := generator.Options{ArgoCDNamespace: *argoCDNamespace, AuthProvider: authProvider}
+	var local client.Reader
+	if *secretFile != "" {
+		// The local cluster isn't needed when the secret is read from a file.
+		secret, err := readClusterSecret(*secretFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %s\n", err)
+			return 1
+		}
+		req.Input.Parameters.ClusterName = secret.Name
+		options.RemoteReaders = func(ctx context.Context, _ string) (client.Reader, error) {
+			return generator.NewRemoteClient(ctx, nil, secret, authProvider)
+		}
+	} else {
+		local, err = newLocalClient(*kubeconfig)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: failed to create the client of the local cluster: %s\n", err)
+			return 1
+		}
+	}
+
+	response, err := generator.New(local, options).Generate(ctx, req)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s (%s)\n", err, generrors.CodeOf(err))
+		return 1
+	}
+
+	var out any = response
+	if *apiVersion == "v1alpha1" {
+		out = v1alpha2.ConvertResponseToV1alpha1(response)
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newLocalClient returns a client of the cluster of the kubeconfig, or of
+// the default kubeconfig.
+func newLocalClient(kubeconfig string) (client.Client, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeconfig != "" {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		cfg, err = ctrlconfig.GetConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+// readClusterSecret reads an ArgoCD cluster secret from a YAML file, moving
+// its stringData to data like the API server does.
+func readClusterSecret(path string) (*corev1.Secret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{}
+	if err := yaml.UnmarshalStrict(data, secret); err != nil {
+		return nil, fmt.Errorf("invalid cluster secret %s: %w", path, err)
+	}
+	if secret.Name == "" {
+		return nil, fmt.Errorf("invalid cluster secret %s: the name is missing", path)
+	}
+	for key, value := range secret.StringData {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[key] = []byte(value)
+	}
+	return secret, nil
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -3,22 +3,63 @@ package main
 import (
 	"context"
 	"crypto/subtle"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/labstack/gommon/log"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"golang.org/x/net/http2"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/appsetrefresh"
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+	"github.com/konflux-ci/namespace-generator/pkg/config"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/expiry"
+	"github.com/konflux-ci/namespace-generator/pkg/features"
+	"github.com/konflux-ci/namespace-generator/pkg/generationreport"
+	"github.com/konflux-ci/namespace-generator/pkg/generatorconfig"
 	"github.com/konflux-ci/namespace-generator/pkg/handlers"
+	"github.com/konflux-ci/namespace-generator/pkg/leader"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/preflight"
+	"github.com/konflux-ci/namespace-generator/pkg/provisioning"
+	"github.com/konflux-ci/namespace-generator/pkg/recording"
+	"github.com/konflux-ci/namespace-generator/pkg/sharedcache"
+	"github.com/konflux-ci/namespace-generator/pkg/tenant"
+	"github.com/konflux-ci/namespace-generator/pkg/tokenreview"
+	"github.com/konflux-ci/namespace-generator/pkg/tracing"
+	"github.com/konflux-ci/namespace-generator/pkg/version"
+	"github.com/konflux-ci/namespace-generator/pkg/visibilitypolicy"
 )
 
 var (
@@ -27,80 +68,1121 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(generatorv1alpha1.AddToScheme(scheme))
 }
 
-func getK8sClient(logger echo.Logger) (client.Reader, error) {
-	cfg, err := config.GetConfig()
+var (
+	localCacheMu   sync.Mutex
+	localClient    client.Reader
+	stopLocalCache context.CancelFunc
+	// namespaceCacheSelector restricts the cached namespaces. It's set from
+	// the configuration before the cache is created.
+	namespaceCacheSelector string
+	// clusterSecretNames are the only cluster secrets read when set. They're
+	// read from the API server instead of the cache.
+	clusterSecretNames []string
+	// argoCDNamespaces hold the cluster secrets read from the local cluster:
+	// the ArgoCD namespace and the ones of the ArgoCD instances without an
+	// API endpoint.
+	argoCDNamespaces []string
+)
+
+// getK8sClient returns the informer cache shared by all the requests for
+// reading from the local cluster. The cache is created on the first call and
+// is retried on the next call if it fails to sync.
+func getK8sClient(logger *slog.Logger) (client.Reader, error) {
+	localCacheMu.Lock()
+	defer localCacheMu.Unlock()
+
+	if localClient != nil {
+		return localClient, nil
+	}
+
+	cfg, err := ctrlconfig.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheOptions, err := getLocalCacheOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	cl, err := cache.New(cfg, cacheOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := cl.Start(cacheCtx); err != nil {
+			logger.Error("Failed to start k8s client cache", logging.KeyError, err)
+		}
+	}()
+
+	syncCtx, syncCancel := context.WithTimeout(cacheCtx, time.Minute)
+	defer syncCancel()
+	if !cl.WaitForCacheSync(syncCtx) {
+		cancel()
+		return nil, errors.New("failed to sync k8s client cache")
+	}
+
+	var reader client.Reader = cl
+	if len(clusterSecretNames) > 0 {
+		// The informer of the cache would list and watch the secrets.
+		secrets, err := client.New(cfg, client.Options{Scheme: scheme})
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		reader = handlers.NewSecretAllowlistReader(cl, secrets, clusterSecretNames)
+	}
+
+	localClient, stopLocalCache = reader, cancel
+	return localClient, nil
+}
+
+// stopK8sClientCache stops the informers of the local cache, if it was created.
+func stopK8sClientCache() {
+	localCacheMu.Lock()
+	defer localCacheMu.Unlock()
+
+	if stopLocalCache != nil {
+		stopLocalCache()
+	}
+}
+
+// getLocalCacheOptions restricts the cached secrets and ConfigMaps to the
+// ArgoCD namespaces, and the cached namespaces to the configured selector if
+// set.
+func getLocalCacheOptions() (cache.Options, error) {
+	secretNamespaces := map[string]cache.Config{handlers.ArgoCDNamespace: {}}
+	for _, namespace := range argoCDNamespaces {
+		secretNamespaces[namespace] = cache.Config{}
+	}
+	options := cache.Options{
+		Scheme: scheme,
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {
+				Namespaces: secretNamespaces,
+			},
+			// The CA bundles referenced by the cluster secrets.
+			&corev1.ConfigMap{}: {
+				Namespaces: secretNamespaces,
+			},
+		},
+	}
+
+	if len(namespaceCacheSelector) > 0 {
+		selector, err := labels.Parse(namespaceCacheSelector)
+		if err != nil {
+			return options, fmt.Errorf("invalid namespace cache selector: %w", err)
+		}
+		// Namespaces are read as metadata only.
+		namespace := &metav1.PartialObjectMetadata{}
+		namespace.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Namespace"))
+		options.ByObject[namespace] = cache.ByObject{Label: selector}
+	}
+
+	return options, nil
+}
+
+// getLiveK8sClient returns a client that talks to the API server directly
+// without caching. It's used for checks that must reflect the current state
+// of the cluster.
+func getLiveK8sClient() (client.WithWatch, error) {
+	cfg, err := ctrlconfig.GetConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	cl, err := cache.New(cfg, cache.Options{Scheme: scheme})
+	return client.NewWithWatch(cfg, client.Options{Scheme: scheme})
+}
+
+// getClientset returns a clientset for the local cluster, for the client-go
+// tools which don't work with the controller-runtime clients.
+func getClientset() (kubernetes.Interface, error) {
+	cfg, err := ctrlconfig.GetConfig()
 	if err != nil {
 		return nil, err
 	}
-	go cl.Start(context.TODO())
+	return kubernetes.NewForConfig(cfg)
+}
 
-	if !cl.WaitForCacheSync(context.TODO()) {
-		logger.Error("Failed to sync k8s client cache")
+// getEventRecorder returns a recorder emitting Events to the local cluster.
+// Repeated Events are aggregated by the recorder, so failures of a cluster
+// called by every request don't flood the API server.
+func getEventRecorder() (record.EventRecorder, error) {
+	clientset, err := getClientset()
+	if err != nil {
 		return nil, err
 	}
 
-	return cl, nil
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "namespace-generator"}), nil
+}
+
+// runLeaderTasks runs the tasks which must only run on one replica. Without
+// leader election, they run on every replica.
+func runLeaderTasks(ctx context.Context, logger *slog.Logger, leaderConfig config.LeaderConfig, tasks ...leader.Task) {
+	if len(tasks) == 0 {
+		return
+	}
+	if !leaderConfig.Enabled {
+		leader.RunWithoutElection(ctx, tasks...)
+		return
+	}
+
+	clientset, err := getClientset()
+	if err != nil {
+		logger.Error("Failed to set up leader election, the leader tasks are disabled", logging.KeyError, err)
+		return
+	}
+	elector, err := leader.NewElector(clientset, leader.Config{
+		LeaseName:      leaderConfig.LeaseName,
+		LeaseNamespace: leaderConfig.Namespace,
+		LeaseDuration:  leaderConfig.LeaseDuration.Duration,
+		RenewDeadline:  leaderConfig.RenewDeadline.Duration,
+		RetryPeriod:    leaderConfig.RetryPeriod.Duration,
+	}, logger)
+	if err != nil {
+		logger.Error("Failed to set up leader election, the leader tasks are disabled", logging.KeyError, err)
+		return
+	}
+	elector.Run(ctx, tasks...)
+}
+
+// warmUpRemoteClients builds the clients of all the known remote clusters
+// once the local cache is available.
+func warmUpRemoteClients(e *echo.Echo, logger *slog.Logger, remoteClients *handlers.RemoteClientCache, concurrency int) {
+	localClient, err := getK8sClient(logger)
+	if err != nil {
+		logger.Error("Failed to warm up remote clients", logging.KeyError, err)
+		return
+	}
+	remoteClients.WarmUp(handlers.NewBackgroundContext(e, logging.WithLogger(context.Background(), logger)), localClient, concurrency)
+}
+
+// probeClusters probes the remote clusters in the background until the
+// context is done.
+func probeClusters(ctx context.Context, e *echo.Echo, logger *slog.Logger, prober *handlers.ClusterProber) {
+	localClient, err := getK8sClient(logger)
+	if err != nil {
+		logger.Error("Failed to start probing the remote clusters", logging.KeyError, err)
+		return
+	}
+	prober.Run(handlers.NewBackgroundContext(e, logging.WithLogger(ctx, logger)), localClient)
+}
+
+// getReadinessChecks returns the checks that must pass before the server is
+// considered ready to serve requests.
+func getReadinessChecks(liveClient client.WithWatch, liveClientErr error, authProvider auth.Provider, checkCloudCredentials bool) map[string]handlers.HealthCheck {
+	checks := map[string]handlers.HealthCheck{}
+
+	if liveClientErr != nil {
+		checks["kubernetes"] = func(context.Context) error { return liveClientErr }
+	} else {
+		checks["kubernetes"] = handlers.NamespaceListCheck(liveClient)
+	}
+
+	if checkCloudCredentials {
+		checks["cloud-credentials"] = handlers.CloudCredentialsCheck(authProvider)
+	}
+
+	return checks
+}
+
+// runPreflightChecks verifies that the service account has the permissions
+// required for serving requests. Missing permissions are only logged since
+// some of them may not be needed by every deployment.
+func runPreflightChecks(logger *slog.Logger, checker *preflight.Checker) {
+	status := checker.Run(context.TODO())
+	for _, result := range status.Results {
+		switch {
+		case result.Error != "":
+			logger.Warn("Preflight check could not be evaluated", "check", result.Check, logging.KeyError, result.Error)
+		case !result.Allowed:
+			logger.Warn("Preflight check failed, the service account is not allowed to perform the check", "check", result.Check)
+		default:
+			logger.Debug("Preflight check passed", "check", result.Check)
+		}
+	}
+	if status.Degraded {
+		logger.Warn("Preflight checks failed, running in a degraded state")
+	}
+}
+
+// startPprofServer serves the pprof handlers on a separate address when one
+// is set, so profiling is never exposed on the API port.
+func startPprofServer(logger *slog.Logger, address string) {
+	if len(address) == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		logger.Info("Serving pprof", "address", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			logger.Error("pprof server stopped", logging.KeyError, err)
+		}
+	}()
+}
+
+// getRoutes returns the routes of the configuration, overridden by the routes
+// of the GeneratorConfig resource if it sets any.
+func getRoutes(logger *slog.Logger, cfg *config.Config, liveClient client.Reader) config.RoutesConfig {
+	routes := cfg.Routes
+	if cfg.GeneratorConfigName == "" || liveClient == nil {
+		return routes
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	generatorConfig, err := generatorconfig.Get(ctx, liveClient, cfg.GeneratorConfigName)
+	if err != nil {
+		logger.Error("Failed to get GeneratorConfig, using the configured routes", "name", cfg.GeneratorConfigName, logging.KeyError, err)
+		return routes
+	}
+	if generatorConfig == nil || generatorConfig.Spec.Routes == nil {
+		return routes
+	}
+	if prefix := generatorConfig.Spec.Routes.V1alpha2Prefix; prefix != "" {
+		routes.V1alpha2Prefix = prefix
+	}
+	if prefix := generatorConfig.Spec.Routes.ClustersPrefix; prefix != "" {
+		routes.ClustersPrefix = prefix
+	}
+	return routes
+}
+
+// configureServers applies the configured timeouts, header limit and
+// keep-alive behavior to the given servers. The defaults leave room for
+// fanning out to slow remote clusters while making sure idle and stalled
+// connections are eventually closed.
+func configureServers(serverConfig config.ServerConfig, servers ...*http.Server) {
+	for _, server := range servers {
+		server.ReadTimeout = serverConfig.ReadTimeout.Duration
+		server.ReadHeaderTimeout = serverConfig.ReadHeaderTimeout.Duration
+		server.WriteTimeout = serverConfig.WriteTimeout.Duration
+		server.IdleTimeout = serverConfig.IdleTimeout.Duration
+		server.MaxHeaderBytes = serverConfig.MaxHeaderBytes
+		server.SetKeepAlivesEnabled(!serverConfig.DisableKeepAlives)
+	}
+}
+
+// getTenantVerifier returns the verifier of the tokens scoping callers to
+// tenants, or nil if tenant scoping is disabled. Without a JWKS URL, the keys
+// are fetched from the local API server, which signs the ServiceAccount tokens.
+func getTenantVerifier(ctx context.Context, tenantsConfig config.TenantsConfig) (*tenant.Verifier, error) {
+	if tenantsConfig.Issuer == "" {
+		return nil, nil
+	}
+
+	httpClient := http.DefaultClient
+	jwksURL := tenantsConfig.JWKSURL
+	if jwksURL == "" {
+		restConfig, err := ctrlconfig.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		if httpClient, err = rest.HTTPClientFor(restConfig); err != nil {
+			return nil, err
+		}
+		jwksURL = strings.TrimSuffix(restConfig.Host, "/") + "/openid/v1/jwks"
+	}
+	return tenant.NewVerifier(ctx, tenant.Config{
+		Issuer:    tenantsConfig.Issuer,
+		JWKSURL:   jwksURL,
+		Audiences: tenantsConfig.Audiences,
+		Claim:     tenantsConfig.Claim,
+		Label:     tenantsConfig.Label,
+	}, httpClient), nil
+}
+
+// setImpersonation sets the identities namespaces are listed as, if any is
+// configured.
+func setImpersonation(impersonationConfig config.ImpersonationConfig) error {
+	if impersonationConfig.User == "" && len(impersonationConfig.Routes) == 0 && len(impersonationConfig.AllowedUsers) == 0 {
+		return nil
+	}
+	restConfig, err := ctrlconfig.GetConfig()
+	if err != nil {
+		return err
+	}
+	handlers.SetImpersonation(handlers.Impersonation{
+		User:         impersonationConfig.User,
+		Groups:       impersonationConfig.Groups,
+		RouteUsers:   impersonationConfig.Routes,
+		RequestUsers: impersonationConfig.AllowedUsers,
+	}, restConfig)
+	return nil
+}
+
+// setArgoCDInstances sets the ArgoCD instances requests can select. The
+// cluster secrets of the instances with an API endpoint are read from it,
+// authenticated with the token of the remote clusters.
+func setArgoCDInstances(instancesConfig []config.ArgoCDInstanceConfig, authProvider auth.Provider) error {
+	instances := make([]handlers.ArgoCDInstance, 0, len(instancesConfig))
+	for _, instanceConfig := range instancesConfig {
+		instance := handlers.ArgoCDInstance{Name: instanceConfig.Name, Namespace: instanceConfig.Namespace}
+		if instanceConfig.APIEndpoint != "" {
+			restConfig := &rest.Config{
+				Host:            instanceConfig.APIEndpoint,
+				TLSClientConfig: rest.TLSClientConfig{CAFile: instanceConfig.CAFile},
+			}
+			restConfig.Wrap(auth.WrapTransport(authProvider))
+			restConfig.Wrap(tracing.WrapTransport)
+			reader, err := client.New(restConfig, client.Options{Scheme: scheme})
+			if err != nil {
+				return fmt.Errorf("failed to create the client of ArgoCD instance %s: %w", instanceConfig.Name, err)
+			}
+			instance.Reader = reader
+		}
+		instances = append(instances, instance)
+	}
+	handlers.SetArgoCDInstances(instances)
+	return nil
+}
+
+// getIPExtractor returns how the client IP is extracted from the requests.
+// The proxy headers are only trusted from the configured proxies, as the
+// default of echo trusts the headers of any client, which could then evade
+// the rate limits and the source allowlist.
+func getIPExtractor(serverConfig config.ServerConfig) echo.IPExtractor {
+	// Validated with the configuration.
+	proxies, _ := config.ParseCIDRs(serverConfig.TrustedProxyCIDRs)
+	trustOptions := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range proxies {
+		trustOptions = append(trustOptions, echo.TrustIPRange(proxy))
+	}
+	switch serverConfig.ClientIPHeader {
+	case config.ClientIPHeaderXForwardedFor:
+		return echo.ExtractIPFromXFFHeader(trustOptions...)
+	case config.ClientIPHeaderXRealIP:
+		return echo.ExtractIPFromRealIPHeader(trustOptions...)
+	default:
+		return echo.ExtractIPDirect()
+	}
+}
+
+// getAuditor returns the auditor recording to the configured sink, or nil if
+// auditing is disabled.
+func getAuditor(logger *slog.Logger, auditConfig config.AuditConfig) (*audit.Auditor, error) {
+	var sink audit.Sink
+	switch auditConfig.Sink {
+	case "":
+		return nil, nil
+	case config.AuditSinkStdout:
+		sink = audit.NewWriterSink(os.Stdout)
+	case config.AuditSinkFile:
+		fileSink, err := audit.NewFileSink(auditConfig.File)
+		if err != nil {
+			return nil, err
+		}
+		sink = fileSink
+	case config.AuditSinkWebhook:
+		sink = audit.NewWebhookSink(auditConfig.WebhookURL, auditConfig.WebhookTimeout.Duration)
+	}
+	return audit.NewAuditor(sink, auditConfig.BufferSize, logger), nil
+}
+
+func getRateLimitConfig(limits config.LimitsConfig) handlers.RateLimitConfig {
+	return handlers.RateLimitConfig{
+		GlobalRate:          limits.RateLimitGlobalRPS,
+		GlobalBurst:         limits.RateLimitGlobalBurst,
+		ClientRate:          limits.RateLimitClientRPS,
+		ClientBurst:         limits.RateLimitClientBurst,
+		ApplicationSetRate:  limits.RateLimitApplicationSetRPS,
+		ApplicationSetBurst: limits.RateLimitApplicationSetBurst,
+		KeyFunc:             handlers.CallerRateLimitKey,
+	}
+}
+
+// getQuotas returns the quotas of the ApplicationSets.
+func getQuotas(limits config.LimitsConfig) handlers.Quotas {
+	quotas := handlers.Quotas{
+		Default:         handlers.ApplicationSetQuota(limits.ApplicationSetQuota),
+		ApplicationSets: map[string]handlers.ApplicationSetQuota{},
+	}
+	for name, quota := range limits.ApplicationSetQuotas {
+		quotas.ApplicationSets[name] = handlers.ApplicationSetQuota(quota)
+	}
+	return quotas
+}
+
+// getFaults returns the faults injected in the requests for the clusters.
+func getFaults(faultInjection map[string]config.FaultConfig) map[string]handlers.Fault {
+	faults := make(map[string]handlers.Fault, len(faultInjection))
+	for cluster, fault := range faultInjection {
+		faults[cluster] = handlers.Fault{
+			Latency:       fault.Latency.Duration,
+			ErrorRate:     fault.ErrorRate,
+			Error:         generrors.ByCode(fault.ErrorCode),
+			MalformedRate: fault.MalformedRate,
+			Malformation:  fault.Malformation,
+		}
+	}
+	return faults
+}
+
+// getRefreshController returns the controller refreshing the ApplicationSets
+// when their namespaces change, or nil if no rules are configured. The local
+// cluster is watched with the live client and the remote clusters with their
+// cached clients.
+func getRefreshController(logger *slog.Logger, refreshConfig config.RefreshConfig, liveClient client.WithWatch, remoteClients *handlers.RemoteClientCache) (*appsetrefresh.Controller, error) {
+	if len(refreshConfig.Rules) == 0 {
+		return nil, nil
+	}
+	rules := make([]appsetrefresh.Rule, 0, len(refreshConfig.Rules))
+	for _, ruleConfig := range refreshConfig.Rules {
+		appSets := make([]appsetrefresh.ApplicationSet, 0, len(ruleConfig.ApplicationSets))
+		for _, appSet := range ruleConfig.ApplicationSets {
+			appSets = append(appSets, appsetrefresh.ApplicationSet(appSet))
+		}
+		rule, err := appsetrefresh.ParseRule(ruleConfig.Cluster, ruleConfig.LabelSelector, appSets)
+		if err != nil {
+			return nil, err
+		}
+		if rule.Cluster == "" && liveClient == nil {
+			logger.Warn("Watching the local cluster isn't available, its ApplicationSets aren't refreshed", "selector", rule.Selector.String())
+		}
+		rules = append(rules, rule)
+	}
+
+	clients := func() map[string]client.WithWatch {
+		clients := remoteClients.Clients()
+		if liveClient != nil {
+			clients[""] = liveClient
+		}
+		return clients
+	}
+	var notifier appsetrefresh.Notifier
+	switch refreshConfig.Method {
+	case config.RefreshMethodWebhook:
+		notifier = appsetrefresh.NewWebhookNotifier(refreshConfig.WebhookURL, refreshConfig.WebhookTokenPath, refreshConfig.WebhookTimeout.Duration)
+	case config.RefreshMethodAnnotation:
+		if liveClient == nil {
+			return nil, errors.New("annotating the ApplicationSets requires a client of the local cluster")
+		}
+		notifier = appsetrefresh.NewAnnotationNotifier(liveClient)
+	}
+	return appsetrefresh.NewController(rules, clients, notifier, appsetrefresh.Options{
+		Debounce:       refreshConfig.Debounce.Duration,
+		ResyncInterval: refreshConfig.ResyncInterval.Duration,
+	}, logger), nil
+}
+
+// getClaimsController returns the controller provisioning the namespaces of
+// the NamespaceClaims.
+func getClaimsController(logger *slog.Logger, claimsConfig config.ClaimsConfig, liveClient client.WithWatch) *provisioning.Controller {
+	defaultRoleBindings := make([]generatorv1alpha1.NamespaceRoleBinding, 0, len(claimsConfig.DefaultRoleBindings))
+	for _, bindingConfig := range claimsConfig.DefaultRoleBindings {
+		binding := generatorv1alpha1.NamespaceRoleBinding{ClusterRole: bindingConfig.ClusterRole}
+		for _, subject := range bindingConfig.Subjects {
+			apiGroup := rbacv1.GroupName
+			if subject.Kind == rbacv1.ServiceAccountKind {
+				apiGroup = ""
+			}
+			binding.Subjects = append(binding.Subjects, rbacv1.Subject{
+				APIGroup:  apiGroup,
+				Kind:      subject.Kind,
+				Name:      subject.Name,
+				Namespace: subject.Namespace,
+			})
+		}
+		defaultRoleBindings = append(defaultRoleBindings, binding)
+	}
+	return provisioning.NewController(liveClient, provisioning.Options{
+		Namespaces:          claimsConfig.Namespaces,
+		ResyncInterval:      claimsConfig.ResyncInterval.Duration,
+		DefaultRoleBindings: defaultRoleBindings,
+	}, logger)
+}
+
+// listenUnixSocket returns a server for plain HTTP on a Unix domain socket,
+// along with the listener to serve. This allows running the generator as a
+// sidecar without exposing it on the network.
+func listenUnixSocket(e *echo.Echo, logger *slog.Logger, socketPath string, serverConfig config.ServerConfig) (*http.Server, net.Listener, error) {
+	// Remove a socket left over by a previous run.
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := &http.Server{
+		Handler:  e,
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+	configureServers(serverConfig, server)
+	return server, listener, nil
+}
+
+// serveTCP serves on the configured address until the server is shut down.
+func serveTCP(e *echo.Echo, serverConfig config.ServerConfig) error {
+	address := serverConfig.Address
+	if serverConfig.UseHTTP {
+		if serverConfig.EnableH2C {
+			// Serve HTTP/2 over cleartext, for deployments where TLS is
+			// terminated by a service mesh.
+			return e.StartH2CServer(address, &http2.Server{IdleTimeout: e.Server.IdleTimeout})
+		}
+		return e.Start(address)
+	}
+	// HTTP/2 is negotiated using ALPN when serving TLS.
+	return e.StartTLS(address, serverConfig.TLSCertFile, serverConfig.TLSKeyFile)
+}
+
+// shutdown stops accepting requests and waits for the requests in flight
+// until the timeout, after which their connections are closed.
+func shutdown(logger *slog.Logger, e *echo.Echo, unixServer *http.Server, routes *handlers.Routes, timeout time.Duration) {
+	logger.Info("Shutting down, draining the requests in flight", "timeout", timeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	routes.NamespaceEvents.Shutdown()
+	routes.GetParams.Shutdown()
+	routes.NamespaceChanges.Shutdown()
+	err := e.Shutdown(ctx)
+	if unixServer != nil {
+		err = errors.Join(err, unixServer.Shutdown(ctx))
+	}
+	if err != nil {
+		logger.Warn("Requests were still in flight after the shutdown timeout", logging.KeyError, err)
+		err = e.Close()
+		if unixServer != nil {
+			err = errors.Join(err, unixServer.Close())
+		}
+		if err != nil {
+			logger.Error("Failed to close the connections", logging.KeyError, err)
+		}
+	}
 }
 
-func getKeyPath() string {
-	keyPath := os.Getenv("NS_GEN_KEY_PATH")
-	if len(keyPath) == 0 {
-		return "/mnt/key"
+// corsMiddleware returns the middleware answering the CORS requests of the
+// allowed origins, or nil if no origin is allowed. Only GET and HEAD are
+// allowed unless POST is explicitly allowed too.
+func corsMiddleware(server config.ServerConfig) echo.MiddlewareFunc {
+	if len(server.CORSAllowedOrigins) == 0 {
+		return nil
+	}
+	allowedMethods := []string{http.MethodGet, http.MethodHead}
+	if server.CORSAllowPost {
+		allowedMethods = append(allowedMethods, http.MethodPost)
 	}
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:  server.CORSAllowedOrigins,
+		AllowMethods:  allowedMethods,
+		AllowHeaders:  []string{echo.HeaderAuthorization, echo.HeaderContentType, echo.HeaderXRequestID, "If-None-Match"},
+		ExposeHeaders: []string{echo.HeaderXRequestID, "Retry-After", "ETag"},
+		MaxAge:        server.CORSMaxAge,
+	})
+}
 
-	return keyPath
+// keyValidator validates API keys against the content of the given file.
+// The file is read on every request so the key can be rotated without a restart.
+// With a token reviewer or a tenant verifier, JWTs are authenticated instead:
+// the reviewer sends them in a TokenReview, and the verifier scopes their
+// caller to its tenants. requireToken rejects the key, so every caller is
+// scoped.
+func keyValidator(keyPath string, reviewer *tokenreview.Reviewer, verifier *tenant.Verifier, requireToken bool) middleware.KeyAuthValidator {
+	return func(key string, c echo.Context) (bool, error) {
+		if (reviewer != nil || verifier != nil) && tenant.IsJWT(key) {
+			if verifier != nil {
+				scope, err := verifier.Verify(c.Request().Context(), key)
+				if err != nil {
+					return false, fmt.Errorf("failed to verify the token: %w", err)
+				}
+				handlers.SetTenantScope(c, scope)
+				handlers.SetCaller(c, scope.Subject)
+			}
+			// The user of the reviewed token is preferred to the subject of
+			// the tenant token, as it's the one RBAC knows.
+			if reviewer != nil {
+				user, err := reviewer.Review(c.Request().Context(), key)
+				if err != nil {
+					return false, fmt.Errorf("failed to authenticate the token: %w", err)
+				}
+				logging.FromContext(c.Request().Context()).Debug("Authenticated the token", "user", user)
+				handlers.SetCaller(c, user)
+			}
+			return true, nil
+		}
+		if requireToken {
+			return false, nil
+		}
+		validKey, err := os.ReadFile(keyPath)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to read key file, %s\n", err.Error()))
+		}
+		if subtle.ConstantTimeCompare([]byte(key), validKey) != 1 {
+			return false, nil
+		}
+		handlers.SetCaller(c, handlers.StaticKeyCaller)
+		return true, nil
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "generate":
+			os.Exit(generateCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "validate-cluster":
+			os.Exit(validateClusterCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "replay":
+			os.Exit(replayCommand(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	e := echo.New()
-	e.Logger.SetLevel(log.DEBUG)
+	// Only JSON lines are logged.
+	e.HideBanner = true
+	e.HidePort = true
+
+	// The level is set once the configuration is loaded, and on reloads.
+	logLevel := &slog.LevelVar{}
+	logger := logging.New(os.Stdout, logLevel)
+	slog.SetDefault(logger)
+	logger.Info("Starting namespace-generator", "version", version.Get().String())
+
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fatal(logger, "Failed to load the configuration", logging.KeyError, err)
+	}
+	logLevel.Set(cfg.LogLevel)
+	if err := features.Default.Set(cfg.FeatureGates); err != nil {
+		fatal(logger, "Failed to set the feature gates", logging.KeyError, err)
+	}
+	logger.Info("Feature gates set", "featureGates", features.Default.States())
+	handlers.ArgoCDNamespace = cfg.ArgoCDNamespace
+	handlers.DegradedRetryAfter = cfg.Limits.DegradedRetryAfter.Duration
+	handlers.AllowUnknownFields = cfg.Server.AllowUnknownFields
+	handlers.MaxBodyBytes = int64(cfg.Server.MaxBodyBytes)
+	namespaceCacheSelector = cfg.Cache.NamespaceSelector
+	clusterSecretNames = cfg.ClusterSecretNames
+	for _, instance := range cfg.ArgoCDInstances {
+		if instance.APIEndpoint == "" {
+			argoCDNamespaces = append(argoCDNamespaces, instance.Namespace)
+		}
+	}
+
+	if tracing.Enabled() {
+		shutdownTracing, err := tracing.Setup(context.Background())
+		if err != nil {
+			fatal(logger, "Failed to set up tracing", logging.KeyError, err)
+		}
+		defer shutdownTracing(context.Background())
+		e.Use(otelecho.Middleware(tracing.ServiceName, otelecho.WithSkipper(func(c echo.Context) bool {
+			return !strings.HasPrefix(c.Request().URL.Path, "/api/")
+		})))
+	}
 
 	e.Pre(middleware.RemoveTrailingSlash())
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
 	e.Use(middleware.RequestID())
-	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Skipper: func(c echo.Context) bool {
-			// Skip logging health probe requests.
-			return c.Request().URL.Path == "/health"
-		},
+	e.Use(handlers.RequestLogger(logger))
+	e.Use(handlers.AccessLog(func(c echo.Context) bool {
+		// Skip logging health probe requests.
+		switch c.Request().URL.Path {
+		case "/health", "/healthz", "/readyz", "/metrics":
+			return true
+		}
+		return false
 	}))
-	e.Use(middleware.Recover())
+	e.Use(handlers.Recover())
 
-	keyPath := getKeyPath()
+	if cors := corsMiddleware(cfg.Server); cors != nil {
+		e.Use(cors)
+	}
 
-	api := e.Group("/api")
-	api.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
-		validKey, err := os.ReadFile(keyPath)
+	startPprofServer(logger, cfg.Server.PprofAddress)
+
+	// The background tasks are stopped once the requests in flight are
+	// drained, so they can still record the outcome of the requests.
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	defer stopK8sClientCache()
+
+	liveClient, liveClientErr := getLiveK8sClient()
+	if liveClientErr != nil {
+		logger.Error("Failed to create k8s client", logging.KeyError, liveClientErr)
+	}
+
+	// Warm up the local cache, so the first request doesn't wait for it.
+	go func() {
+		if _, err := getK8sClient(logger); err != nil {
+			logger.Error("Failed to create k8s client cache", logging.KeyError, err)
+		}
+	}()
+
+	var tokenReviewer *tokenreview.Reviewer
+	if len(cfg.Auth.TokenReviewAudiences) > 0 {
+		if liveClient == nil {
+			fatal(logger, "Reviewing tokens requires a k8s client")
+		}
+		tokenReviewer = tokenreview.NewReviewer(liveClient, tokenreview.Config{
+			Audiences:   cfg.Auth.TokenReviewAudiences,
+			MaxLifetime: cfg.Auth.TokenReviewMaxLifetime.Duration,
+		})
+	}
+	tenantVerifier, err := getTenantVerifier(backgroundCtx, cfg.Tenants)
+	if err != nil {
+		fatal(logger, "Failed to set up the tenant token verifier", logging.KeyError, err)
+	}
+	if err := setImpersonation(cfg.Impersonation); err != nil {
+		fatal(logger, "Failed to set up impersonation", logging.KeyError, err)
+	}
+	handlers.SetQuotas(getQuotas(cfg.Limits))
+	handlers.SetFaults(getFaults(cfg.FaultInjection))
+	if features.Enabled(features.FaultInjection) {
+		logger.Warn("Fault injection is enabled, requests may fail on purpose", "clusters", len(cfg.FaultInjection))
+	}
+
+	e.IPExtractor = getIPExtractor(cfg.Server)
+	var apiMiddleware []echo.MiddlewareFunc
+	if len(cfg.Server.AllowedSourceCIDRs) > 0 {
+		// Validated with the configuration.
+		networks, _ := config.ParseCIDRs(cfg.Server.AllowedSourceCIDRs)
+		apiMiddleware = append(apiMiddleware, handlers.SourceAllowlist(networks))
+	}
+	apiMiddleware = append(apiMiddleware, handlers.IdentifyApplicationSet(cfg.Auth.RequireApplicationSetIdentity))
+	if !cfg.Server.DisableGzip {
+		// Responses are only compressed when the client accepts gzip.
+		apiMiddleware = append(apiMiddleware, middleware.GzipWithConfig(middleware.GzipConfig{
+			Skipper: func(c echo.Context) bool {
+				// Event streams are flushed event by event.
+				return c.Path() == "/api/v1/namespaces/events"
+			},
+			Level:     cfg.Server.GzipLevel,
+			MinLength: cfg.Server.GzipMinLength,
+		}))
+	}
+	apiAuthentication := middleware.KeyAuth(keyValidator(cfg.Auth.KeyPath, tokenReviewer, tenantVerifier, cfg.Tenants.RequireToken))
+	// The rate limits are keyed by the caller, so they're applied once it's
+	// authenticated and unauthenticated requests don't use up the buckets.
+	var authenticatedMiddleware []echo.MiddlewareFunc
+	if rateLimitConfig := getRateLimitConfig(cfg.Limits); rateLimitConfig.GlobalRate > 0 || rateLimitConfig.ClientRate > 0 || rateLimitConfig.ApplicationSetRate > 0 {
+		authenticatedMiddleware = append(authenticatedMiddleware, handlers.RateLimiter(rateLimitConfig))
+	}
+
+	auditor, err := getAuditor(logger, cfg.Audit)
+	if err != nil {
+		fatal(logger, "Failed to set up the audit sink", logging.KeyError, err)
+	}
+	if auditor != nil {
+		// The events of the drained requests are written before exiting.
+		defer func() {
+			if err := auditor.Close(); err != nil {
+				logger.Error("Failed to close the audit sink", logging.KeyError, err)
+			}
+		}()
+		authenticatedMiddleware = append(authenticatedMiddleware, handlers.Audit(auditor))
+	}
+	if cfg.Recording.Dir != "" {
+		recorder, err := recording.NewRecorder(cfg.Recording.Dir, cfg.Recording.MaxRecords)
 		if err != nil {
-			panic(fmt.Sprintf("Failed to read key file, %s\n", err.Error()))
+			fatal(logger, "Failed to set up the recording of the requests", logging.KeyError, err)
 		}
-		return subtle.ConstantTimeCompare([]byte(key), validKey) == 1, nil
+		logger.Warn("Recording the requests, replay them with the replay command", "dir", cfg.Recording.Dir)
+		authenticatedMiddleware = append(authenticatedMiddleware, handlers.Record(recorder))
+	}
+	if cfg.Reports.Enabled && liveClient != nil {
+		reporter := generationreport.NewReporter(liveClient, cfg.Reports.Namespace, logger)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			reporter.Run(backgroundCtx, cfg.Reports.Interval.Duration)
+		}()
+		authenticatedMiddleware = append(authenticatedMiddleware, handlers.GenerationReports(reporter))
+	}
+	authenticatedMiddleware = append(authenticatedMiddleware, handlers.WithStageTimeouts(handlers.StageTimeouts{
+		Secret: cfg.Timeouts.Secret.Duration,
+		Auth:   cfg.Timeouts.Token.Duration,
+		Client: cfg.Timeouts.Client.Duration,
+		List:   cfg.Timeouts.List.Duration,
 	}))
 
-	getParamsHandler := handlers.NewGetParamsHandler(getK8sClient)
+	// The caches are shared with the other replicas when a store is set.
+	var sharedStore sharedcache.Store
+	if url := cfg.Cache.SharedURL; len(url) > 0 {
+		redisStore, err := sharedcache.NewRedisStore(url)
+		if err != nil {
+			fatal(logger, "Invalid shared cache URL", logging.KeyError, err)
+		}
+		defer redisStore.Close()
+		sharedStore = redisStore
+	}
+
+	// Tasks calling the API servers on behalf of all the replicas only run
+	// on the leader.
+	var leaderTasks []leader.Task
+
+	authProvider := auth.NewCachedProvider(auth.NewGCPProvider(), sharedStore)
+	if refreshAhead := cfg.Auth.TokenRefreshAhead.Duration; refreshAhead > 0 {
+		refreshTokens := func(ctx context.Context) {
+			authProvider.RefreshInBackground(ctx, refreshAhead, func(err error) {
+				logger.Error("Failed to refresh the token in the background", logging.KeyError, err)
+			})
+		}
+		if sharedStore != nil {
+			// The other replicas read the refreshed token from the store.
+			leaderTasks = append(leaderTasks, refreshTokens)
+		} else {
+			go refreshTokens(backgroundCtx)
+		}
+	}
+	if err := setArgoCDInstances(cfg.ArgoCDInstances, authProvider); err != nil {
+		fatal(logger, "Failed to set the ArgoCD instances", logging.KeyError, err)
+	}
+	var recorder record.EventRecorder
+	if features.Enabled(features.ClusterSecretEvents) {
+		if recorder, err = getEventRecorder(); err != nil {
+			logger.Error("Failed to create the event recorder, Events are disabled", logging.KeyError, err)
+		}
+	}
+	remoteClients := handlers.NewRemoteClientCache(authProvider, handlers.RemoteClientOptions{
+		Retry: handlers.RetryConfig{
+			Attempts:       cfg.Retry.Attempts,
+			InitialBackoff: cfg.Retry.InitialBackoff.Duration,
+			MaxBackoff:     cfg.Retry.MaxBackoff.Duration,
+		},
+		WatchList:        cfg.RemoteClients.WatchList,
+		ProjectsFallback: cfg.RemoteClients.ProjectsFallback,
+		Recorder:         recorder,
+	})
+
+	if cfg.RemoteClients.WarmUp {
+		go warmUpRemoteClients(e, logger, remoteClients, cfg.RemoteClients.WarmUpConcurrency)
+	}
+
+	// Remote clusters aren't probed unless an interval is set.
+	var prober *handlers.ClusterProber
+	if interval := cfg.RemoteClients.ProbeInterval.Duration; interval > 0 {
+		prober = handlers.NewClusterProber(remoteClients, handlers.ProbeOptions{
+			Interval:    interval,
+			Timeout:     cfg.RemoteClients.ProbeTimeout.Duration,
+			Concurrency: cfg.RemoteClients.ProbeConcurrency,
+		})
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			probeClusters(backgroundCtx, e, logger, prober)
+		}()
+	}
+
+	refreshController, err := getRefreshController(logger, cfg.Refresh, liveClient, remoteClients)
+	if err != nil {
+		fatal(logger, "Invalid ApplicationSet refresh rules", logging.KeyError, err)
+	}
+	if refreshController != nil {
+		// A single replica refreshes the ApplicationSets.
+		leaderTasks = append(leaderTasks, refreshController.Run)
+	}
+
+	if cfg.Claims.Enabled {
+		if liveClient == nil {
+			fatal(logger, "Provisioning the NamespaceClaims requires a client of the local cluster")
+		}
+		// A single replica provisions the namespaces.
+		leaderTasks = append(leaderTasks, getClaimsController(logger, cfg.Claims, liveClient).Run)
+	}
+
+	if cfg.Expiry.Enabled {
+		if liveClient == nil {
+			fatal(logger, "Expiring the namespaces requires a client of the local cluster")
+		}
+		// A single replica expires the namespaces.
+		leaderTasks = append(leaderTasks, expiry.NewReconciler(liveClient, expiry.Options{
+			Interval: cfg.Expiry.Interval.Duration,
+			Delete:   cfg.Expiry.Delete,
+		}, logger).Run)
+	}
 
-	api.POST("/v1/getparams.execute", getParamsHandler.GetParams)
+	// Responses aren't cached unless a TTL is set.
+	responses := handlers.NewResponseCache(cfg.Cache.ResponseTTL.Duration, sharedStore)
+
+	// Snapshots are only kept when a directory is set.
+	var snapshots *handlers.SnapshotStore
+	if dir := cfg.Cache.SnapshotDir; len(dir) > 0 {
+		snapshots, err = handlers.NewSnapshotStore(dir, cfg.Cache.SnapshotMaxAge.Duration)
+		if err != nil {
+			fatal(logger, "Failed to load snapshots", "dir", dir, logging.KeyError, err)
+		}
+		logger.Info("Loaded snapshots", "count", snapshots.Len(), "dir", dir)
+	}
+
+	if cfg.Cache.InvalidateOnChange {
+		if liveClient == nil {
+			logger.Warn("Watching the local cluster isn't available, its namespaces and the cluster secrets aren't watched")
+		}
+		// Cluster secrets can only be watched when they may be listed.
+		invalidator := handlers.NewCacheInvalidator(liveClient, remoteClients, responses, handlers.InvalidationOptions{
+			ResyncInterval: cfg.Cache.InvalidationResyncInterval.Duration,
+			WatchSecrets:   len(cfg.ClusterSecretNames) == 0,
+		}, logger)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			invalidator.Run(backgroundCtx)
+		}()
+	}
+
+	policy := &policySources{responses: responses}
+	if err := policy.setFilters(logger, cfg.Filters); err != nil {
+		fatal(logger, "Invalid filters", logging.KeyError, err)
+	}
+	if cfg.GeneratorConfigName != "" && liveClient != nil {
+		watcher := generatorconfig.NewWatcher(liveClient, cfg.GeneratorConfigName, logger, func(spec generatorv1alpha1.GeneratorConfigSpec) {
+			if err := policy.setSpec(logger, spec); err != nil {
+				logger.Error("Failed to apply GeneratorConfig, keeping the current one", "name", cfg.GeneratorConfigName, logging.KeyError, err)
+			}
+		})
+		go watcher.Run(backgroundCtx)
+	}
+	if cfg.VisibilityPolicies {
+		// Requests are denied until the policies are loaded.
+		handlers.EnforceVisibilityPolicies(true)
+		if liveClient != nil {
+			watcher := visibilitypolicy.NewWatcher(liveClient, logger, func(policies []generatorv1alpha1.NamespaceVisibilityPolicy) {
+				logger.Info("NamespaceVisibilityPolicies changed", "count", len(policies))
+				handlers.SetVisibilityPolicies(policies)
+			})
+			go watcher.Run(backgroundCtx)
+		}
+	}
+
+	// The lease is released before exiting, so another replica takes over.
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		runLeaderTasks(backgroundCtx, logger, cfg.Leader, leaderTasks...)
+	}()
+
+	current := cfg
+	go config.Watch(backgroundCtx, cfg.Path, cfg.ReloadInterval.Duration, func() {
+		current = reloadConfig(logger, logLevel, os.Args[1:], current, policy, responses, snapshots)
+	})
+
+	routes := getRoutes(logger, cfg, liveClient)
+	// Provisioning namespaces uses its own key, as the CI systems calling it
+	// can create namespaces.
+	var provisioningAuthentication echo.MiddlewareFunc
+	if cfg.Auth.ProvisioningKeyPath != "" {
+		provisioningAuthentication = middleware.KeyAuth(keyValidator(cfg.Auth.ProvisioningKeyPath, nil, nil, false))
+	}
+	registered := handlers.Register(e, handlers.Options{
+		K8sClientFactory:        getK8sClient,
+		LiveClient:              liveClient,
+		RemoteClients:           remoteClients,
+		Responses:               responses,
+		Snapshots:               snapshots,
+		Prober:                  prober,
+		Middleware:              apiMiddleware,
+		Authentication:          apiAuthentication,
+		AuthenticatedMiddleware: authenticatedMiddleware,
+		// The admin endpoints use a separate key, so operators don't need
+		// to share the key used by ArgoCD.
+		AdminMiddleware:            []echo.MiddlewareFunc{middleware.KeyAuth(keyValidator(cfg.Auth.AdminKeyPath, nil, nil, false))},
+		ProvisioningAuthentication: provisioningAuthentication,
+		Provisioning: handlers.ProvisionOptions{
+			ClaimsNamespace: cfg.Claims.APINamespace,
+			Timeout:         cfg.Claims.ProvisionTimeout.Duration,
+		},
+		InFlight: handlers.InFlightConfig{
+			MaxInFlight:  cfg.Limits.MaxInFlight,
+			MaxQueued:    cfg.Limits.MaxQueued,
+			QueueTimeout: cfg.Limits.QueueTimeout.Duration,
+			RetryAfter:   cfg.Limits.InFlightRetryAfter.Duration,
+		},
+		StreamThreshold: cfg.Limits.StreamThreshold,
+		BatchMaxSize:    cfg.Limits.BatchMaxSize,
+		ChangeFeed: handlers.ChangeFeedOptions{
+			Retention:   cfg.Limits.ChangeFeedRetention,
+			IdleTimeout: cfg.Limits.ChangeFeedIdleTimeout.Duration,
+		},
+		Logger:          logger,
+		V1alpha2Prefix:  routes.V1alpha2Prefix,
+		ClustersPrefix:  routes.ClustersPrefix,
+		ClustersProject: routes.ClustersProject,
+		DebugUI:         cfg.Server.DebugUI,
+		Metrics:         true,
+	})
 
 	e.GET("/health", func(c echo.Context) error {
 		return c.NoContent(http.StatusOK)
 	})
 
-	address := ":5000"
-	if _, ok := os.LookupEnv("NS_GEN_USE_HTTP"); ok {
-		e.Logger.Fatal(e.Start(":5000"))
-	} else {
-		e.Logger.Fatal(
-			e.StartTLS(
-				address,
-				"/mnt/serving-certs/tls.crt",
-				"/mnt/serving-certs/tls.key",
-			),
-		)
+	healthHandler := handlers.NewHealthHandler(getReadinessChecks(liveClient, liveClientErr, authProvider, cfg.Auth.ReadyzCheckCloudCredentials))
+	e.GET("/healthz", healthHandler.Healthz)
+	e.GET("/readyz", healthHandler.Readyz)
+
+	if liveClient != nil {
+		checks := preflight.DefaultChecks(handlers.ArgoCDNamespace, cfg.ClusterSecretNames, recorder != nil)
+		if tokenReviewer != nil {
+			checks = append(checks, preflight.Check{Verb: "create", Group: "authentication.k8s.io", Resource: "tokenreviews"})
+		}
+		preflightChecker := preflight.NewChecker(liveClient, checks)
+		go runPreflightChecks(logger, preflightChecker)
+
+		e.GET("/preflight", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, preflightChecker.Status())
+		})
 	}
+
+	e.GET("/version", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, version.Get())
+	})
+
+	configureServers(cfg.Server, e.Server, e.TLSServer)
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	serverErrors := make(chan error, 2)
+	var unixServer *http.Server
+	if socketPath := cfg.Server.UnixSocket; len(socketPath) > 0 {
+		server, listener, err := listenUnixSocket(e, logger, socketPath, cfg.Server)
+		if err != nil {
+			fatal(logger, "Failed to listen on the unix socket", "path", socketPath, logging.KeyError, err)
+		}
+		unixServer = server
+		logger.Info("Serving on unix socket", "path", socketPath)
+		go func() { serverErrors <- unixServer.Serve(listener) }()
+	}
+	if !cfg.Server.DisableTCP {
+		logger.Info("Serving", "address", cfg.Server.Address)
+		go func() { serverErrors <- serveTCP(e, cfg.Server) }()
+	}
+
+	select {
+	case err := <-serverErrors:
+		fatal(logger, "Server stopped", logging.KeyError, err)
+	case <-signalCtx.Done():
+	}
+	// A second signal kills the generator right away.
+	stopSignals()
+
+	shutdown(logger, e, unixServer, registered, cfg.Server.ShutdownTimeout.Duration)
+	stopBackground()
+	background.Wait()
+	logger.Info("Stopped")
+}
+
+// fatal logs the message and exits.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/config"
+)
+
+var _ = Describe("corsMiddleware", func() {
+	preflight := func(server config.ServerConfig, origin string) *httptest.ResponseRecorder {
+		e := echo.New()
+		e.Use(corsMiddleware(server))
+		e.POST("/api/v1/getparams.execute", func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/getparams.execute", nil)
+		req.Header.Set(echo.HeaderOrigin, origin)
+		req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodPost)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("should be disabled without allowed origins", func() {
+		Expect(corsMiddleware(config.ServerConfig{CORSAllowPost: true})).To(BeNil())
+	})
+
+	It("should only allow POST when it's explicitly allowed", func() {
+		server := config.ServerConfig{CORSAllowedOrigins: []string{"https://console.example.com"}, CORSMaxAge: 600}
+		rec := preflight(server, "https://console.example.com")
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(rec.Header().Get(echo.HeaderAccessControlAllowOrigin)).To(Equal("https://console.example.com"))
+		Expect(rec.Header().Get(echo.HeaderAccessControlAllowMethods)).To(Equal("GET,HEAD"))
+		Expect(rec.Header().Get(echo.HeaderAccessControlMaxAge)).To(Equal("600"))
+
+		server.CORSAllowPost = true
+		rec = preflight(server, "https://console.example.com")
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(rec.Header().Get(echo.HeaderAccessControlAllowMethods)).To(Equal("GET,HEAD,POST"))
+	})
+
+	It("should not allow the other origins", func() {
+		server := config.ServerConfig{CORSAllowedOrigins: []string{"https://console.example.com"}, CORSAllowPost: true}
+		rec := preflight(server, "https://attacker.example.com")
+		Expect(rec.Header().Get(echo.HeaderAccessControlAllowOrigin)).To(BeEmpty())
+		Expect(rec.Header().Get(echo.HeaderAccessControlAllowMethods)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/config"
+	"github.com/konflux-ci/namespace-generator/pkg/handlers"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// policySources combines the filters of the configuration file with the
+// GeneratorConfig resource into the policy of the handlers.
+type policySources struct {
+	responses *handlers.ResponseCache
+
+	mu      sync.Mutex
+	applied bool
+	filters config.FiltersConfig
+	spec    generatorv1alpha1.GeneratorConfigSpec
+	// regoPolicy is the content of the Rego policy file, which may change
+	// while its path doesn't.
+	regoPolicy string
+}
+
+func (sources *policySources) setFilters(logger *slog.Logger, filters config.FiltersConfig) error {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	return sources.apply(logger, filters, sources.spec)
+}
+
+func (sources *policySources) setSpec(logger *slog.Logger, spec generatorv1alpha1.GeneratorConfigSpec) error {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	return sources.apply(logger, sources.filters, spec)
+}
+
+// apply sets the policy combined from the given sources, and drops the
+// responses cached with the previous policy. Both sources must allow a
+// cluster for requests to target it. The Rego policy file is read again on
+// every call, so it's reloaded along with the configuration.
+func (sources *policySources) apply(logger *slog.Logger, filters config.FiltersConfig, spec generatorv1alpha1.GeneratorConfigSpec) error {
+	regoPolicy := ""
+	if filters.RegoPolicyFile != "" {
+		data, err := os.ReadFile(filters.RegoPolicyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the Rego policy: %w", err)
+		}
+		regoPolicy = string(data)
+	}
+
+	allowed := filters.AllowedClusters
+	switch {
+	case len(allowed) == 0:
+		allowed = spec.Clusters.Allowed
+	case len(spec.Clusters.Allowed) > 0:
+		allowed = slices.DeleteFunc(slices.Clone(allowed), func(name string) bool {
+			return !slices.Contains(spec.Clusters.Allowed, name)
+		})
+		if len(allowed) == 0 {
+			// Nothing is allowed by both, which mustn't allow everything.
+			allowed = []string{""}
+		}
+	}
+
+	err := handlers.SetPolicy(handlers.Policy{
+		ExcludeNamespaces:       append(slices.Clone(filters.ExcludeNamespaces), spec.ExcludeNamespaces...),
+		AllowedClusters:         allowed,
+		DeniedClusters:          spec.Clusters.Denied,
+		OutputTemplates:         spec.OutputTemplates,
+		RegoPolicy:              regoPolicy,
+		AuthorizationPolicy:     filters.AuthorizationPolicy,
+		AllowMatchAll:           filters.AllowMatchAll,
+		RequiredLabels:          filters.RequiredLabels,
+		MaxSelectorRequirements: filters.MaxSelectorRequirements,
+		MaxSelectorValues:       filters.MaxSelectorValues,
+		MaxResults:              filters.MaxResults,
+		AuthorizationCostLimit:  uint64(filters.AuthorizationCostLimit),
+		SettlingPeriod:          filters.SettlingPeriod.Duration,
+	})
+	if err != nil {
+		return err
+	}
+
+	changed := !reflect.DeepEqual(filters, sources.filters) || !reflect.DeepEqual(spec, sources.spec) || regoPolicy != sources.regoPolicy
+	previouslyApplied := sources.applied
+	sources.filters, sources.spec, sources.regoPolicy, sources.applied = filters, spec, regoPolicy, true
+	if changed && previouslyApplied {
+		if _, err := sources.responses.InvalidateAll(context.Background()); err != nil {
+			logger.Error("Failed to invalidate the shared responses", logging.KeyError, err)
+		}
+	}
+	return nil
+}
+
+// reloadConfig reads the configuration again from the arguments and applies
+// it. The current configuration is kept when the new one is invalid.
+func reloadConfig(logger *slog.Logger, logLevel *slog.LevelVar, args []string, current *config.Config, policy *policySources, responses *handlers.ResponseCache, snapshots *handlers.SnapshotStore) *config.Config {
+	cfg, err := config.Load(args)
+	if err == nil {
+		err = applyConfig(logger, logLevel, current, cfg, policy, responses, snapshots)
+	}
+	if err != nil {
+		logger.Error("Failed to reload the configuration, keeping the current one", logging.KeyError, err)
+		return current
+	}
+	return cfg
+}
+
+// applyConfig applies the settings of the reloaded configuration which can
+// change while serving. Nothing is applied when the policy is invalid.
+func applyConfig(logger *slog.Logger, logLevel *slog.LevelVar, current, cfg *config.Config, policy *policySources, responses *handlers.ResponseCache, snapshots *handlers.SnapshotStore) error {
+	if err := policy.setFilters(logger, cfg.Filters); err != nil {
+		return err
+	}
+
+	responses.SetTTL(cfg.Cache.ResponseTTL.Duration)
+	snapshots.SetMaxAge(cfg.Cache.SnapshotMaxAge.Duration)
+	handlers.SetFaults(getFaults(cfg.FaultInjection))
+
+	logLevel.Set(cfg.LogLevel)
+	logger.Info("Reloaded the configuration")
+	if cfg.RequiresRestart(current) {
+		logger.Warn("Some of the changed settings only apply after a restart")
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/namespace-generator/pkg/config"
+	"github.com/konflux-ci/namespace-generator/pkg/handlers"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// memoryStore is a sharedcache.Store keeping the entries in memory.
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func (store *memoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	value, ok := store.entries[key]
+	return value, ok, nil
+}
+
+func (store *memoryStore) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.entries[key] = value
+	return nil
+}
+
+func (store *memoryStore) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	count := 0
+	for key := range store.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(store.entries, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+var _ = Describe("reloadConfig", func() {
+	var (
+		path      string
+		args      []string
+		logger    *slog.Logger
+		logLevel  *slog.LevelVar
+		store     *memoryStore
+		responses *handlers.ResponseCache
+		policy    *policySources
+		current   *config.Config
+	)
+
+	write := func(content string) {
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+		DeferCleanup(handlers.SetFaults, map[string]handlers.Fault(nil))
+
+		path = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		args = []string{"--config", path}
+		logger = logging.New(GinkgoWriter, slog.LevelInfo)
+		logLevel = &slog.LevelVar{}
+		store = &memoryStore{entries: map[string][]byte{}}
+		responses = handlers.NewResponseCache(time.Hour, store)
+		policy = &policySources{responses: responses}
+
+		write("filters:\n  excludeNamespaces: [kube-system]\n")
+		var err error
+		current, err = config.Load(args)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.setFilters(logger, current.Filters)).To(Succeed())
+		// A response cached with the current policy.
+		Expect(store.Set(context.Background(), "response:remote1:key", []byte("{}"), time.Hour)).To(Succeed())
+	})
+
+	It("should keep the current configuration when the file is invalid", func() {
+		write("filters: [\n")
+		Expect(reloadConfig(logger, logLevel, args, current, policy, responses, nil)).To(BeIdenticalTo(current))
+		Expect(policy.filters.ExcludeNamespaces).To(Equal([]string{"kube-system"}))
+		Expect(store.entries).To(HaveKey("response:remote1:key"))
+	})
+
+	It("should apply nothing when the policy is invalid", func() {
+		write("logLevel: debug\nfilters:\n  excludeNamespaces: [kube-system, other]\n  regoPolicyFile: " + filepath.Join(filepath.Dir(path), "missing.rego") + "\n")
+		Expect(reloadConfig(logger, logLevel, args, current, policy, responses, nil)).To(BeIdenticalTo(current))
+		Expect(policy.filters.ExcludeNamespaces).To(Equal([]string{"kube-system"}))
+		Expect(logLevel.Level()).To(Equal(slog.LevelInfo))
+		Expect(store.entries).To(HaveKey("response:remote1:key"))
+	})
+
+	It("should keep the cached responses when the policy didn't change", func() {
+		write("logLevel: debug\nfilters:\n  excludeNamespaces: [kube-system]\n")
+		reloaded := reloadConfig(logger, logLevel, args, current, policy, responses, nil)
+		Expect(reloaded).NotTo(BeIdenticalTo(current))
+		Expect(logLevel.Level()).To(Equal(slog.LevelDebug))
+		Expect(store.entries).To(HaveKey("response:remote1:key"))
+	})
+
+	It("should invalidate the cached responses when the policy changed", func() {
+		write("filters:\n  excludeNamespaces: [kube-system, other]\n")
+		reloaded := reloadConfig(logger, logLevel, args, current, policy, responses, nil)
+		Expect(reloaded.Filters.ExcludeNamespaces).To(Equal([]string{"kube-system", "other"}))
+		Expect(policy.filters.ExcludeNamespaces).To(Equal([]string{"kube-system", "other"}))
+		Expect(store.entries).To(BeEmpty())
+	})
+})
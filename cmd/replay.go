@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/namespace-generator/pkg/recording"
+)
+
+// replayCommand sends the requests recorded by an instance to another one,
+// usually a dev instance, and reports the responses which differ from the
+// recorded ones, so production incidents can be reproduced safely.
+func replayCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("namespace-generator replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	records := flags.String("records", "", "Directory of the records, or a single record file")
+	url := flags.String("url", "", "Base URL of the instance the requests are sent to, e.g. http://localhost:5000")
+	keyFile := flags.String("key-file", "", "File of the key of the instance")
+	timeout := flags.Duration("timeout", 30*time.Second, "Timeout of each request")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *records == "" || *url == "" {
+		fmt.Fprintln(stderr, "Error: --records and --url must be set")
+		return 2
+	}
+
+	var key []byte
+	if *keyFile != "" {
+		var err error
+		if key, err = os.ReadFile(*keyFile); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to read the key: %s\n", err)
+			return 1
+		}
+	}
+	loaded, err := recording.Load(*records)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to load the records: %s\n", err)
+		return 1
+	}
+
+	httpClient := &http.Client{Timeout: *timeout}
+	baseURL := strings.TrimSuffix(*url, "/")
+	mismatches := 0
+	for _, record := range loaded {
+		status, body, err := replay(httpClient, baseURL, key, record)
+		switch {
+		case err != nil:
+			mismatches++
+			fmt.Fprintf(stdout, "ERROR  %s %s (%s): %s\n", record.Method, record.Path, record.RequestID, err)
+		case status != record.Response.Status || (!record.Response.Truncated && !sameResponse(body, []byte(record.Response.Body))):
+			mismatches++
+			fmt.Fprintf(stdout, "DIFF   %s %s (%s): recorded %d, got %d\n", record.Method, record.Path, record.RequestID, record.Response.Status, status)
+			fmt.Fprintf(stdout, "  recorded: %s\n  got:      %s\n", strings.TrimSpace(record.Response.Body), strings.TrimSpace(string(body)))
+		default:
+			fmt.Fprintf(stdout, "MATCH  %s %s (%s): %d\n", record.Method, record.Path, record.RequestID, status)
+		}
+	}
+	fmt.Fprintf(stdout, "\n%d of %d requests replayed with a different response.\n", mismatches, len(loaded))
+	if mismatches > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replay sends a recorded request and returns the status and the body of the
+// response.
+func replay(httpClient *http.Client, baseURL string, key []byte, record recording.Record) (int, []byte, error) {
+	target := baseURL + record.Path
+	if record.Query != "" {
+		target += "?" + record.Query
+	}
+	req, err := http.NewRequestWithContext(context.Background(), record.Method, target, strings.NewReader(record.Body))
+	if err != nil {
+		return 0, nil, err
+	}
+	for name, value := range record.Headers {
+		req.Header.Set(name, value)
+	}
+	if len(key) > 0 {
+		req.Header.Set("Authorization", "Bearer "+string(key))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// sameResponse compares JSON bodies, ignoring the request IDs which differ
+// on every request. Other bodies are compared as is.
+func sameResponse(got, recorded []byte) bool {
+	var gotValue, recordedValue any
+	if json.Unmarshal(got, &gotValue) != nil || json.Unmarshal(recorded, &recordedValue) != nil {
+		return bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(recorded))
+	}
+	return reflect.DeepEqual(withoutRequestIDs(gotValue), withoutRequestIDs(recordedValue))
+}
+
+func withoutRequestIDs(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		delete(value, "requestId")
+		for k, v := range value {
+			value[k] = withoutRequestIDs(v)
+		}
+	case []any:
+		for i, v := range value {
+			value[i] = withoutRequestIDs(v)
+		}
+	}
+	return value
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/namespace-generator/pkg/recording"
+)
+
+func TestCommands(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Commands Suite")
+}
+
+var _ = Describe("replayCommand", func() {
+	var (
+		dir      string
+		keyFile  string
+		recorder *recording.Recorder
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		keyFile = filepath.Join(GinkgoT().TempDir(), "key")
+		Expect(os.WriteFile(keyFile, []byte("dev-key"), 0o600)).To(Succeed())
+		var err error
+		recorder, err = recording.NewRecorder(dir, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The instance returns the namespaces of the cluster of the request,
+		// with a new request ID every time.
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "Bearer dev-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := io.ReadAll(req.Body)
+			switch {
+			case bytes.Contains(body, []byte("missing-secret")):
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"message": "cluster not found", "code": "NotFound", "requestId": "` + time.Now().String() + `"}`))
+			default:
+				_, _ = w.Write([]byte(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+			}
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	record := func(body string, status int, response string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		_, err := recorder.Record(recording.NewRecord(time.Now(), "", req, []byte(body), status, []byte(response), false))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	replay := func() (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := replayCommand([]string{"--records", dir, "--url", server.URL + "/", "--key-file", keyFile}, &stdout, &stderr)
+		Expect(stderr.String()).To(BeEmpty())
+		return code, stdout.String()
+	}
+
+	It("should succeed when the responses match the recorded ones", func() {
+		record(`{"input": {"parameters": {"clusterName": "remote1-secret"}}}`, http.StatusOK,
+			`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`)
+		// The request IDs differ on every request.
+		record(`{"input": {"parameters": {"clusterName": "missing-secret"}}}`, http.StatusNotFound,
+			`{"message": "cluster not found", "code": "NotFound", "requestId": "recorded"}`)
+
+		code, output := replay()
+		Expect(code).To(BeZero(), output)
+		Expect(strings.Count(output, "MATCH")).To(Equal(2), output)
+		Expect(output).To(ContainSubstring("0 of 2 requests replayed with a different response."))
+	})
+
+	It("should report the responses differing from the recorded ones", func() {
+		record(`{"input": {"parameters": {"clusterName": "remote1-secret"}}}`, http.StatusOK,
+			`{"output": {"parameters": [{"namespace": "remote-ns"}, {"namespace": "deleted-ns"}]}}`)
+		record(`{"input": {"parameters": {"clusterName": "missing-secret"}}}`, http.StatusOK,
+			`{"output": {"parameters": []}}`)
+		record(`{"input": {"parameters": {"clusterName": "remote1-secret"}}}`, http.StatusOK,
+			`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`)
+
+		code, output := replay()
+		Expect(code).To(Equal(1))
+		Expect(output).To(ContainSubstring("DIFF   POST /api/v1/getparams.execute (): recorded 200, got 200"))
+		Expect(output).To(ContainSubstring("DIFF   POST /api/v1/getparams.execute (): recorded 200, got 404"))
+		Expect(strings.Count(output, "MATCH")).To(Equal(1), output)
+		Expect(output).To(ContainSubstring("2 of 3 requests replayed with a different response."))
+	})
+
+	It("should not compare the truncated responses", func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", nil)
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret"}}}`
+		_, err := recorder.Record(recording.NewRecord(time.Now(), "", req, []byte(body), http.StatusOK, []byte(`{"output": {"para`), true))
+		Expect(err).NotTo(HaveOccurred())
+
+		code, output := replay()
+		Expect(code).To(BeZero(), output)
+	})
+})
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+	"github.com/konflux-ci/namespace-generator/pkg/clusterconfig"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+)
+
+// validateClusterCommand goes through the steps of a request against a
+// cluster, like the check endpoint of the server, and reports which one would
+// fail at request time. It's meant as an onboarding check of new clusters,
+// run with the credentials the server will use.
+func validateClusterCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("namespace-generator validate-cluster", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	secretName := flags.String("secret", "", "Name of the ArgoCD cluster secret, read from the local cluster")
+	secretFile := flags.String("secret-file", "", "YAML file of the ArgoCD cluster secret, instead of reading it from the local cluster")
+	kubeconfig := flags.String("kubeconfig", "", "Kubeconfig of the local cluster, defaults to $KUBECONFIG or ~/.kube/config")
+	argoCDNamespace := flags.String("argocd-namespace", generator.DefaultArgoCDNamespace, "Namespace of the ArgoCD cluster secrets")
+	output := flags.String("output", "text", "Format of the report: text or json")
+	timeout := flags.Duration("timeout", 30*time.Second, "Timeout of the command")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if (*secretName == "") == (*secretFile == "") {
+		fmt.Fprintln(stderr, "Error: exactly one of --secret and --secret-file must be set")
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "Error: unsupported output %q\n", *output)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	authProvider := auth.NewCachedProvider(auth.NewGCPProvider(), nil)
+	report := &v1alpha1.ClusterCheckResponse{
+		ClusterName:  *secretName,
+		AuthProvider: authProvider.Name(),
+		Healthy:      true,
+	}
+	// The codes of the failed steps are only printed in the text report, as
+	// the response of the check endpoint doesn't have them.
+	codes := map[string]string{}
+	start := time.Now()
+	step := func(name string, run func() error) bool {
+		stepStart := time.Now()
+		err := run()
+		result := v1alpha1.ClusterCheckStep{
+			Name:          name,
+			Success:       err == nil,
+			LatencyMillis: time.Since(stepStart).Milliseconds(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			codes[name] = generrors.CodeOf(err)
+			report.Healthy = false
+		}
+		report.Steps = append(report.Steps, result)
+		return err == nil
+	}
+
+	var secret *corev1.Secret
+	var local client.Reader
+	var cfg *rest.Config
+	var remoteClient client.Client
+	ok := step("secret", func() error {
+		var err error
+		if *secretFile != "" {
+			if secret, err = readClusterSecret(*secretFile); err != nil {
+				return generrors.Wrap(generrors.ErrSecretInvalid, err)
+			}
+			report.ClusterName = secret.Name
+			return nil
+		}
+		if local, err = newLocalClient(*kubeconfig); err != nil {
+			return fmt.Errorf("failed to create the client of the local cluster: %w", err)
+		}
+		secret, err = generator.GetClusterSecret(ctx, local, *argoCDNamespace, *secretName)
+		return err
+	})
+	ok = ok && step("config", func() error {
+		var err error
+		if cfg, err = clusterconfig.LoadRESTConfig(ctx, local, secret); err != nil {
+			return generrors.Wrap(generrors.ErrSecretInvalid, err)
+		}
+		report.Server = cfg.Host
+		return nil
+	})
+	ok = ok && step("auth", func() error {
+		if _, err := authProvider.Token(ctx); err != nil {
+			return generrors.Wrap(generrors.ErrAuthFailed, err)
+		}
+		return nil
+	})
+	ok = ok && step("client", func() error {
+		cfg.Wrap(auth.WrapTransport(authProvider))
+		var err error
+		if remoteClient, err = client.New(cfg, client.Options{}); err != nil {
+			return generator.ClassifyRemoteError(err)
+		}
+		return nil
+	})
+	_ = ok && step("list", func() error {
+		if err := remoteClient.List(ctx, generator.NewNamespaceList(), client.Limit(1)); err != nil {
+			return generator.ClassifyRemoteError(err)
+		}
+		return nil
+	})
+	report.LatencyMillis = time.Since(start).Milliseconds()
+
+	if *output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		printClusterReport(stdout, report, codes)
+	}
+	if !report.Healthy {
+		return 1
+	}
+	return 0
+}
+
+// printClusterReport prints a line per step of the check of a cluster, with
+// the error code of the failed step.
+func printClusterReport(out io.Writer, report *v1alpha1.ClusterCheckResponse, codes map[string]string) {
+	fmt.Fprintf(out, "Cluster:       %s\n", report.ClusterName)
+	if report.Server != "" {
+		fmt.Fprintf(out, "Server:        %s\n", report.Server)
+	}
+	fmt.Fprintf(out, "Auth provider: %s\n\n", report.AuthProvider)
+	for _, step := range report.Steps {
+		if step.Success {
+			fmt.Fprintf(out, "OK    %-7s %dms\n", step.Name, step.LatencyMillis)
+			continue
+		}
+		fmt.Fprintf(out, "FAIL  %-7s %dms  %s: %s\n", step.Name, step.LatencyMillis, codes[step.Name], step.Error)
+	}
+	if report.Healthy {
+		fmt.Fprintln(out, "\nThe cluster is ready to be used in requests.")
+	} else {
+		fmt.Fprintln(out, "\nRequests for the cluster would fail.")
+	}
+}
@@ -0,0 +1,59 @@
+#!/usr/bin/env bash
+# Generates the manifests of the least-privilege overlay for the cluster
+# secret names given as arguments: a Role allowing to get only these secrets
+# and a patch of the Deployment restricting the generator to them.
+set -euo pipefail
+
+if [ "$#" -eq 0 ]; then
+  echo "usage: $0 SECRET_NAME..." >&2
+  exit 1
+fi
+
+dir="$(dirname "$0")/../manifests/least-privilege"
+header="# Generated by hack/least-privilege-rbac.sh, do not edit."
+
+{
+  echo "${header}"
+  echo "---"
+  echo "apiVersion: rbac.authorization.k8s.io/v1"
+  echo "kind: Role"
+  echo "metadata:"
+  echo "  name: namespace-generator-cluster-secrets"
+  echo "rules:"
+  echo "  - apiGroups: [ \"\" ]"
+  echo "    resources: [ \"secrets\" ]"
+  echo "    verbs: [ \"get\" ]"
+  echo "    resourceNames:"
+  for name in "$@"; do
+    echo "      - ${name}"
+  done
+  echo "---"
+  echo "apiVersion: rbac.authorization.k8s.io/v1"
+  echo "kind: RoleBinding"
+  echo "metadata:"
+  echo "  name: namespace-generator-cluster-secrets"
+  echo "roleRef:"
+  echo "  apiGroup: rbac.authorization.k8s.io"
+  echo "  kind: Role"
+  echo "  name: namespace-generator-cluster-secrets"
+  echo "subjects:"
+  echo "  - kind: ServiceAccount"
+  echo "    name: namespace-generator"
+  echo "    namespace: argocd"
+} > "${dir}/secrets-role.yaml"
+
+{
+  echo "${header}"
+  echo "apiVersion: apps/v1"
+  echo "kind: Deployment"
+  echo "metadata:"
+  echo "  name: namespace-generator"
+  echo "spec:"
+  echo "  template:"
+  echo "    spec:"
+  echo "      containers:"
+  echo "      - name: manager"
+  echo "        env:"
+  echo "          - name: NS_GEN_CLUSTER_SECRET_NAMES"
+  echo "            value: \"$(IFS=,; echo "$*")\""
+} > "${dir}/deployment-patch.yaml"
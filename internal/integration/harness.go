@@ -0,0 +1,385 @@
+// Package integration runs the generator end-to-end against a real API
+// server, started by envtest or already running such as a kind cluster. The
+// generator is built from the repository and started as a separate process,
+// optionally with the permissions of its ServiceAccount only, so regressions
+// of the authentication and of the RBAC manifests are caught before release.
+package integration
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	nsgenclient "github.com/konflux-ci/namespace-generator/pkg/client"
+	"github.com/konflux-ci/namespace-generator/pkg/clusterconfig"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+)
+
+const (
+	// serviceAccount is the user the generator runs as with RBAC, the
+	// subject of the binding of manifests/rbac.yaml.
+	serviceAccount = "system:serviceaccount:argocd:namespace-generator"
+	// defaultAssetsDirectory holds the envtest binaries installed by the
+	// Makefile, relative to the repository.
+	defaultAssetsDirectory = "bin/k8s/1.29.0-linux-amd64"
+	startTimeout           = 30 * time.Second
+)
+
+// Options configures a Harness.
+type Options struct {
+	// UseExistingCluster runs against the cluster of the current kubeconfig,
+	// e.g. a kind cluster, instead of starting envtest.
+	UseExistingCluster bool
+	// BinaryAssetsDirectory holds the envtest binaries. It defaults to
+	// $KUBEBUILDER_ASSETS, then to the binaries installed by the Makefile.
+	BinaryAssetsDirectory string
+	// RBAC runs the generator as its ServiceAccount, with the permissions
+	// granted by manifests/rbac.yaml, instead of as a cluster admin.
+	RBAC bool
+	// Env are additional settings of the generator, e.g. NS_GEN_LOG_LEVEL=info.
+	Env []string
+}
+
+// Harness is a running generator and the cluster it reads.
+type Harness struct {
+	// Client is a client of the cluster with the permissions of the
+	// caller, for seeding and inspecting the cluster.
+	Client client.Client
+	// Config is the config of Client.
+	Config *rest.Config
+	// URL is the base URL of the generator.
+	URL string
+	// Key authenticates the requests to the generator.
+	Key string
+	// LogFile holds the output of the generator. It's kept after stopping.
+	LogFile string
+
+	env        *envtest.Environment
+	server     *exec.Cmd
+	stopServer context.CancelFunc
+	tempDir    string
+	// seeded are the objects created by the harness, deleted on Stop when
+	// running against an existing cluster.
+	seeded []client.Object
+}
+
+// Start starts the cluster and the generator, and waits for the generator to
+// serve. The harness must be stopped, even if Start fails.
+func Start(ctx context.Context, options Options) (*Harness, error) {
+	root, err := repositoryRoot()
+	if err != nil {
+		return nil, err
+	}
+	assets := options.BinaryAssetsDirectory
+	if assets == "" && os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		assets = filepath.Join(root, defaultAssetsDirectory)
+	}
+	harness := &Harness{env: &envtest.Environment{
+		UseExistingCluster:    &options.UseExistingCluster,
+		BinaryAssetsDirectory: assets,
+		CRDDirectoryPaths:     []string{filepath.Join(root, "manifests", "crd")},
+		ErrorIfCRDPathMissing: true,
+	}}
+	if harness.tempDir, err = os.MkdirTemp("", "namespace-generator-integration"); err != nil {
+		return harness, err
+	}
+
+	if harness.Config, err = harness.env.Start(); err != nil {
+		return harness, fmt.Errorf("failed to start the cluster: %w", err)
+	}
+	scheme := k8sruntime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(generatorv1alpha1.AddToScheme(scheme))
+	if harness.Client, err = client.New(harness.Config, client.Options{Scheme: scheme}); err != nil {
+		return harness, err
+	}
+
+	argoCDNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: generator.DefaultArgoCDNamespace}}
+	if err := harness.create(ctx, argoCDNamespace); err != nil {
+		return harness, err
+	}
+	impersonate := ""
+	if options.RBAC {
+		if err := harness.applyManifests(ctx, filepath.Join(root, "manifests", "rbac.yaml")); err != nil {
+			return harness, err
+		}
+		impersonate = serviceAccount
+	}
+	kubeconfig, err := harness.writeKubeconfig(options.UseExistingCluster, impersonate)
+	if err != nil {
+		return harness, err
+	}
+	if harness.Key, err = randomKey(); err != nil {
+		return harness, err
+	}
+	keyPath := filepath.Join(harness.tempDir, "key")
+	if err := os.WriteFile(keyPath, []byte(harness.Key), 0o600); err != nil {
+		return harness, err
+	}
+
+	address, err := freeAddress()
+	if err != nil {
+		return harness, err
+	}
+	harness.URL = "http://" + address
+	env := append([]string{
+		"KUBECONFIG=" + kubeconfig,
+		"NS_GEN_USE_HTTP=true",
+		"NS_GEN_ADDRESS=" + address,
+		"NS_GEN_KEY_PATH=" + keyPath,
+	}, options.Env...)
+	if err := harness.startServer(ctx, root, env); err != nil {
+		return harness, err
+	}
+	return harness, nil
+}
+
+// Stop stops the generator and the cluster. The objects seeded in an
+// existing cluster are deleted.
+func (harness *Harness) Stop(ctx context.Context) error {
+	var errs []error
+	if harness.server != nil {
+		harness.stopServer()
+		_ = harness.server.Wait()
+	}
+	if harness.Client != nil && harness.env.UseExistingCluster != nil && *harness.env.UseExistingCluster {
+		for i := len(harness.seeded) - 1; i >= 0; i-- {
+			if err := harness.Client.Delete(ctx, harness.seeded[i]); err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if harness.Config != nil {
+		if err := harness.env.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if harness.tempDir != "" {
+		errs = append(errs, os.RemoveAll(harness.tempDir))
+	}
+	return errors.Join(errs...)
+}
+
+// NewClient returns a client of the generator, authenticated with its key.
+func (harness *Harness) NewClient(options nsgenclient.Options) (*nsgenclient.Client, error) {
+	options.Token = nsgenclient.StaticToken(harness.Key)
+	return nsgenclient.New(harness.URL, options)
+}
+
+// SeedNamespace creates a namespace with the given labels.
+func (harness *Harness) SeedNamespace(ctx context.Context, name string, labels map[string]string) error {
+	return harness.create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}})
+}
+
+// SeedClusterSecret creates an ArgoCD cluster secret of the cluster itself,
+// so requests for the cluster go through the remote cluster path of the
+// generator.
+func (harness *Harness) SeedClusterSecret(ctx context.Context, name string, labels map[string]string) error {
+	secretConfig := clusterconfig.Config{}
+	secretConfig.TLSClientConfig.CAData = base64.StdEncoding.EncodeToString(harness.Config.CAData)
+	config, err := json.Marshal(secretConfig)
+	if err != nil {
+		return err
+	}
+	secretLabels := map[string]string{"argocd.argoproj.io/secret-type": "cluster"}
+	for key, value := range labels {
+		secretLabels[key] = value
+	}
+	return harness.create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: generator.DefaultArgoCDNamespace, Labels: secretLabels},
+		Data: map[string][]byte{
+			"name":   []byte(name),
+			"server": []byte(harness.Config.Host),
+			"config": config,
+		},
+	})
+}
+
+// create creates an object, and records it for deleting it on Stop. Objects
+// which already exist, e.g. in an existing cluster, are left as is.
+func (harness *Harness) create(ctx context.Context, obj client.Object) error {
+	if err := harness.Client.Create(ctx, obj); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to create %s %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), err)
+	}
+	harness.seeded = append(harness.seeded, obj)
+	return nil
+}
+
+// applyManifests creates the objects of a multi-document YAML file.
+func (harness *Harness) applyManifests(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(file))
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		obj := &unstructured.Unstructured{}
+		if err := utilyaml.Unmarshal(document, &obj.Object); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if err := harness.create(ctx, obj); err != nil {
+			return err
+		}
+	}
+}
+
+// writeKubeconfig writes the kubeconfig of the generator, impersonating the
+// given user if set. Against an existing cluster, the current kubeconfig is
+// copied.
+func (harness *Harness) writeKubeconfig(existingCluster bool, impersonate string) (string, error) {
+	var config *clientcmdapi.Config
+	if existingCluster {
+		loaded, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+		if err != nil {
+			return "", err
+		}
+		config = loaded
+	} else {
+		config = &clientcmdapi.Config{
+			Clusters: map[string]*clientcmdapi.Cluster{"envtest": {
+				Server:                   harness.Config.Host,
+				CertificateAuthorityData: harness.Config.CAData,
+			}},
+			AuthInfos: map[string]*clientcmdapi.AuthInfo{"envtest": {
+				ClientCertificateData: harness.Config.CertData,
+				ClientKeyData:         harness.Config.KeyData,
+			}},
+			Contexts:       map[string]*clientcmdapi.Context{"envtest": {Cluster: "envtest", AuthInfo: "envtest"}},
+			CurrentContext: "envtest",
+		}
+	}
+	if impersonate != "" {
+		current, ok := config.Contexts[config.CurrentContext]
+		if !ok || config.AuthInfos[current.AuthInfo] == nil {
+			return "", fmt.Errorf("the kubeconfig has no user for the context %q", config.CurrentContext)
+		}
+		config.AuthInfos[current.AuthInfo].Impersonate = impersonate
+	}
+
+	path := filepath.Join(harness.tempDir, "kubeconfig")
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// startServer builds the generator and starts it, then waits for its health
+// endpoint.
+func (harness *Harness) startServer(ctx context.Context, root string, env []string) error {
+	binPath := filepath.Join(harness.tempDir, "manager")
+	build := exec.CommandContext(ctx, "go", "build", "-o", binPath, "./cmd")
+	build.Dir = root
+	if output, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build the generator: %w\n%s", err, output)
+	}
+
+	// The log is kept after stopping, for inspecting failures.
+	logFile, err := os.CreateTemp("", "namespace-generator-*.log")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	harness.LogFile = logFile.Name()
+
+	serverCtx, cancel := context.WithCancel(context.Background())
+	harness.server = exec.CommandContext(serverCtx, binPath)
+	harness.server.Env = append(os.Environ(), env...)
+	harness.server.Stdout = logFile
+	harness.server.Stderr = logFile
+	if err := harness.server.Start(); err != nil {
+		cancel()
+		harness.server = nil
+		return fmt.Errorf("failed to start the generator: %w", err)
+	}
+	harness.stopServer = cancel
+
+	deadline := time.Now().Add(startTimeout)
+	for {
+		response, err := http.Get(harness.URL + "/health")
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the generator didn't serve within %s, see its log in %s", startTimeout, harness.LogFile)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// repositoryRoot returns the root of the repository holding this file.
+func repositoryRoot() (string, error) {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		return "", errors.New("failed to locate the repository")
+	}
+	return filepath.Join(filepath.Dir(file), "..", ".."), nil
+}
+
+func randomKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// freeAddress returns a local address with a free port.
+func freeAddress() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
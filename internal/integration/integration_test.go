@@ -0,0 +1,95 @@
+package integration_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/namespace-generator/internal/integration"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/client"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+)
+
+func TestIntegration(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Integration Suite")
+}
+
+var harness *integration.Harness
+
+var _ = BeforeSuite(func(ctx context.Context) {
+	var err error
+	harness, err = integration.Start(ctx, integration.Options{
+		UseExistingCluster: os.Getenv("USE_EXISTING_CLUSTER") == "true",
+		// The generator runs with the permissions of manifests/rbac.yaml.
+		RBAC: true,
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	labels := map[string]string{"integration.konflux.ci/type": "user"}
+	Expect(harness.SeedNamespace(ctx, "integration-ns1", labels)).To(Succeed())
+	Expect(harness.SeedNamespace(ctx, "integration-ns2", labels)).To(Succeed())
+	Expect(harness.SeedNamespace(ctx, "integration-ns3", nil)).To(Succeed())
+	Expect(harness.SeedClusterSecret(ctx, "integration-remote", nil)).To(Succeed())
+})
+
+var _ = AfterSuite(func(ctx context.Context) {
+	if harness != nil {
+		GinkgoWriter.Printf("namespace-generator logs were written to: %s\n", harness.LogFile)
+		Expect(harness.Stop(ctx)).To(Succeed())
+	}
+})
+
+var _ = Describe("namespace-generator", func() {
+	var cl *client.Client
+
+	BeforeEach(func() {
+		var err error
+		cl, err = harness.NewClient(client.Options{Retry: &client.RetryConfig{Attempts: 1}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	userNamespaces := func() *client.RequestBuilder {
+		return client.NewRequest().MatchLabels(map[string]string{"integration.konflux.ci/type": "user"})
+	}
+
+	It("should list the namespaces of the local cluster with its ServiceAccount", func(ctx context.Context) {
+		Eventually(func(g Gomega) {
+			response, err := cl.Generate(ctx, userNamespaces().Build())
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(response.Output.Parameters).To(ConsistOf(
+				v1alpha2.OutParameters{Namespace: "integration-ns1"},
+				v1alpha2.OutParameters{Namespace: "integration-ns2"},
+			))
+		}).WithContext(ctx).Should(Succeed())
+	})
+
+	It("should list the cluster secrets", func(ctx context.Context) {
+		response, err := cl.Clusters(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Clusters).To(ContainElement(HaveField("SecretName", "integration-remote")))
+	})
+
+	It("should classify the requests for a missing cluster secret", func(ctx context.Context) {
+		_, err := cl.Generate(ctx, userNamespaces().Cluster("integration-missing").Build())
+		Expect(client.ErrorCode(err)).To(Equal(generrors.ErrSecretNotFound.Code))
+	})
+
+	It("should reject the requests with a wrong key", func(ctx context.Context) {
+		unauthenticated, err := client.New(harness.URL, client.Options{
+			Token: client.StaticToken("wrong"),
+			Retry: &client.RetryConfig{Attempts: 1},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = unauthenticated.Generate(ctx, userNamespaces().Build())
+		var apiErr *client.Error
+		Expect(errors.As(err, &apiErr)).To(BeTrue())
+		Expect(apiErr.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,65 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GenerationReportSpec identifies the route and the cluster a report is about.
+type GenerationReportSpec struct {
+	// Route is the path of the plugin endpoint serving the requests.
+	Route string `json:"route"`
+	// Cluster is the name of the ArgoCD cluster secret targeted by the
+	// requests, or in-cluster for the local cluster.
+	Cluster string `json:"cluster"`
+}
+
+// GenerationReportStatus summarizes the requests served for the route and the
+// cluster since the report was created.
+type GenerationReportStatus struct {
+	// +optional
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// +optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+	// LastError is the error of the last failed request.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+	// +optional
+	Successes int64 `json:"successes,omitempty"`
+	// +optional
+	Failures int64 `json:"failures,omitempty"`
+	// Namespaces is the number of namespaces returned by the last successful
+	// request.
+	// +optional
+	Namespaces int `json:"namespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Route",type=string,JSONPath=`.spec.route`
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.cluster`
+// +kubebuilder:printcolumn:name="Last Success",type=date,JSONPath=`.status.lastSuccessTime`
+// +kubebuilder:printcolumn:name="Failures",type=integer,JSONPath=`.status.failures`
+// +kubebuilder:printcolumn:name="Last Error",type=string,JSONPath=`.status.lastError`,priority=1
+
+// GenerationReport is the health of the requests served for a route and a
+// cluster, written by the generator.
+type GenerationReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GenerationReportSpec   `json:"spec,omitempty"`
+	Status GenerationReportStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// GenerationReportList contains a list of GenerationReport.
+type GenerationReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GenerationReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GenerationReport{}, &GenerationReportList{})
+}
@@ -0,0 +1,68 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GeneratorConfigSpec declares the configuration of the generator. It's
+// combined with the configuration file, so both can be used at once.
+type GeneratorConfigSpec struct {
+	// Routes overrides the prefixes of the plugins. Routes are only read on
+	// startup.
+	// +optional
+	Routes *RoutesSpec `json:"routes,omitempty"`
+	// Clusters restricts the remote clusters requests can target.
+	// +optional
+	Clusters ClusterScope `json:"clusters,omitempty"`
+	// ExcludeNamespaces are never returned.
+	// +optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// OutputTemplates are Go templates rendered for every namespace into the
+	// values of its v1alpha2 output, keyed by the name of the value.
+	// +optional
+	OutputTemplates map[string]string `json:"outputTemplates,omitempty"`
+}
+
+type RoutesSpec struct {
+	// +optional
+	V1alpha2Prefix string `json:"v1alpha2Prefix,omitempty"`
+	// +optional
+	ClustersPrefix string `json:"clustersPrefix,omitempty"`
+}
+
+// ClusterScope holds the names of the ArgoCD cluster secrets requests can or
+// can't target. The local cluster is always allowed.
+type ClusterScope struct {
+	// Allowed restricts the clusters to the listed ones. Empty allows all of
+	// them.
+	// +optional
+	Allowed []string `json:"allowed,omitempty"`
+	// Denied clusters are rejected even if they're allowed.
+	// +optional
+	Denied []string `json:"denied,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// GeneratorConfig is the configuration of the generator, managed like the
+// other resources of the cluster.
+type GeneratorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GeneratorConfigSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// GeneratorConfigList contains a list of GeneratorConfig.
+type GeneratorConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GeneratorConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GeneratorConfig{}, &GeneratorConfigList{})
+}
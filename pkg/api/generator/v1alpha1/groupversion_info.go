@@ -0,0 +1,20 @@
+// Package v1alpha1 holds the custom resources configuring the generator.
+// +kubebuilder:object:generate=true
+// +groupName=generator.konflux-ci.dev
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the group version of the resources.
+	GroupVersion = schema.GroupVersion{Group: "generator.konflux-ci.dev", Version: "v1alpha1"}
+
+	// SchemeBuilder adds the resources to a scheme.
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the resources of the group version to a scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,124 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespaceDeletionPolicy is what happens to a provisioned namespace when its
+// claim is deleted.
+// +kubebuilder:validation:Enum=Retain;Delete
+type NamespaceDeletionPolicy string
+
+const (
+	// NamespaceDeletionRetain keeps the namespace.
+	NamespaceDeletionRetain NamespaceDeletionPolicy = "Retain"
+	// NamespaceDeletionDelete deletes the namespace with its claim.
+	NamespaceDeletionDelete NamespaceDeletionPolicy = "Delete"
+)
+
+// NamespaceClaimReady is the condition type reporting whether the namespace
+// of a claim is provisioned.
+const NamespaceClaimReady = "Ready"
+
+// NamespaceClaimSpec describes the namespace to provision.
+type NamespaceClaimSpec struct {
+	// Namespace is the name of the namespace. It defaults to the name of the
+	// claim.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Template is the name of the NamespaceTemplate stamped into the
+	// namespace.
+	// +optional
+	Template string `json:"template,omitempty"`
+	// Labels are set on the namespace, so the generator requests select it
+	// like any other namespace.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are set on the namespace.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Quota is the hard limits of the ResourceQuota of the namespace. Empty
+	// creates no ResourceQuota.
+	// +optional
+	Quota corev1.ResourceList `json:"quota,omitempty"`
+	// RoleBindings are created in the namespace, in addition to the default
+	// ones of the generator.
+	// +optional
+	RoleBindings []NamespaceRoleBinding `json:"roleBindings,omitempty"`
+	// DeletionPolicy is what happens to the namespace when the claim is
+	// deleted. It defaults to Retain.
+	// +optional
+	DeletionPolicy NamespaceDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// NamespaceRoleBinding binds a ClusterRole to subjects in the namespace.
+type NamespaceRoleBinding struct {
+	// ClusterRole is the name of the bound ClusterRole.
+	ClusterRole string `json:"clusterRole"`
+	// +kubebuilder:validation:MinItems=1
+	Subjects []rbacv1.Subject `json:"subjects"`
+}
+
+// NamespaceClaimStatus reports the provisioning of the namespace.
+type NamespaceClaimStatus struct {
+	// Namespace is the name of the provisioned namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// ObservedGeneration is the generation of the claim last provisioned.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Template is the NamespaceTemplate last stamped into the namespace.
+	// +optional
+	Template *AppliedTemplate `json:"template,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// AppliedTemplate identifies the version of a NamespaceTemplate stamped into
+// a namespace.
+type AppliedTemplate struct {
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.status.namespace`
+// +kubebuilder:printcolumn:name="Template",type=string,JSONPath=`.spec.template`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`,priority=1
+
+// NamespaceClaim requests a namespace, which the generator provisions with
+// its labels, quota and RoleBindings.
+type NamespaceClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespaceClaimSpec   `json:"spec,omitempty"`
+	Status NamespaceClaimStatus `json:"status,omitempty"`
+}
+
+// NamespaceName returns the name of the namespace of the claim.
+func (claim *NamespaceClaim) NamespaceName() string {
+	if claim.Spec.Namespace != "" {
+		return claim.Spec.Namespace
+	}
+	return claim.Name
+}
+
+// +kubebuilder:object:root=true
+
+// NamespaceClaimList contains a list of NamespaceClaim.
+type NamespaceClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespaceClaim `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespaceClaim{}, &NamespaceClaimList{})
+}
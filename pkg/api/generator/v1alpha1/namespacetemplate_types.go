@@ -0,0 +1,81 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespaceTemplateSpec declares what is stamped into the namespaces of the
+// claims using the template.
+type NamespaceTemplateSpec struct {
+	// Labels are set on the namespaces. The labels of the claims override
+	// them.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are set on the namespaces. The annotations of the claims
+	// override them.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// +optional
+	ResourceQuotas []TemplateResourceQuota `json:"resourceQuotas,omitempty"`
+	// +optional
+	LimitRanges []TemplateLimitRange `json:"limitRanges,omitempty"`
+	// +optional
+	NetworkPolicies []TemplateNetworkPolicy `json:"networkPolicies,omitempty"`
+}
+
+// The specs of the stamped resources are validated by the API server when
+// the resources are created in the namespaces.
+
+// TemplateResourceQuota is a ResourceQuota created in the namespaces.
+type TemplateResourceQuota struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Spec corev1.ResourceQuotaSpec `json:"spec"`
+}
+
+// TemplateLimitRange is a LimitRange created in the namespaces.
+type TemplateLimitRange struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Spec corev1.LimitRangeSpec `json:"spec"`
+}
+
+// TemplateNetworkPolicy is a NetworkPolicy created in the namespaces.
+type TemplateNetworkPolicy struct {
+	Name string `json:"name"`
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Spec networkingv1.NetworkPolicySpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// NamespaceTemplate is the labels, annotations and resources stamped into
+// the namespaces provisioned for the NamespaceClaims referencing it.
+type NamespaceTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespaceTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NamespaceTemplateList contains a list of NamespaceTemplate.
+type NamespaceTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespaceTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespaceTemplate{}, &NamespaceTemplateList{})
+}
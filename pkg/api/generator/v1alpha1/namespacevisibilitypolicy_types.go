@@ -0,0 +1,67 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespaceVisibilityPolicySpec declares what some consumers of the generator
+// may query. A request matching several policies is allowed if one of them
+// allows it, and a request matching none is denied.
+type NamespaceVisibilityPolicySpec struct {
+	// Consumers are the requests the policy applies to.
+	// +kubebuilder:validation:MinItems=1
+	Consumers []Consumer `json:"consumers"`
+	// LabelDomains are the domains of the label keys the selectors of the
+	// consumers may use, e.g. team.konflux-ci.dev, which also allows its
+	// subdomains. An empty domain allows the keys without a domain. Empty
+	// allows all the keys.
+	// +optional
+	LabelDomains []string `json:"labelDomains,omitempty"`
+	// Clusters are the cluster secrets the consumers may target, in-cluster
+	// being the local cluster. Empty allows all of them.
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// Consumer identifies requests. Empty fields match any request.
+type Consumer struct {
+	// Caller is the authenticated caller of the requests: the user of a
+	// reviewed token, the subject of a tenant token, or static-key for the
+	// static API key.
+	// +optional
+	Caller string `json:"caller,omitempty"`
+	// ApplicationSet is the name of the ApplicationSet sending the requests.
+	// It's sent by the caller, so it only tells apart the requests of the
+	// callers the other fields match.
+	// +optional
+	ApplicationSet string `json:"applicationSet,omitempty"`
+	// Route is the path of the route serving the requests, e.g.
+	// /api/v1/getparams.execute.
+	// +optional
+	Route string `json:"route,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// NamespaceVisibilityPolicy restricts the label domains and the clusters some
+// consumers may query, giving security teams a declarative control point.
+type NamespaceVisibilityPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespaceVisibilityPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NamespaceVisibilityPolicyList contains a list of NamespaceVisibilityPolicy.
+type NamespaceVisibilityPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespaceVisibilityPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespaceVisibilityPolicy{}, &NamespaceVisibilityPolicyList{})
+}
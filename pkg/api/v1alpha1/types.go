@@ -1,12 +1,28 @@
 package v1alpha1
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type InParameters struct {
 	LabelSelector metav1.LabelSelector `json:"labelSelector"`
 	ClusterName   string               `json:"clusterName,omitempty"`
+	// ArgoCDInstance selects the ArgoCD instance whose cluster secrets are
+	// read. Empty reads the ones of the ArgoCD namespace.
+	ArgoCDInstance string `json:"argocdInstance,omitempty"`
+	// TimeoutSeconds bounds the time spent on looking up the cluster secret,
+	// authenticating and listing namespaces. Zero means no timeout.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// Debug adds diagnostics to the response.
+	Debug bool `json:"debug,omitempty"`
+	// AllowAll confirms an empty label selector is meant to match all the
+	// namespaces. The server must allow it too.
+	AllowAll bool `json:"allowAll,omitempty"`
+	// Project restricts the clusters plugin to the clusters assigned to an
+	// ArgoCD project. The namespaces plugin ignores it.
+	Project string `json:"project,omitempty"`
 }
 
 type Input struct {
@@ -28,4 +44,255 @@ type Output struct {
 
 type GenerateResponse struct {
 	Output Output `json:"output"`
+	// Debug is only set when requested.
+	Debug *DebugInfo `json:"debug,omitempty"`
+}
+
+// DebugInfo holds the diagnostics of a generate request. It never contains
+// credentials.
+type DebugInfo struct {
+	TotalMillis  int64         `json:"totalMillis"`
+	Stages       []StageTiming `json:"stages"`
+	ClusterName  string        `json:"clusterName,omitempty"`
+	Server       string        `json:"server,omitempty"`
+	AuthProvider string        `json:"authProvider,omitempty"`
+	// CacheHits reports for each cache consulted whether it had the entry.
+	CacheHits      map[string]bool `json:"cacheHits,omitempty"`
+	NamespaceCount int             `json:"namespaceCount"`
+}
+
+type ErrorResponse struct {
+	Message string `json:"message"`
+	// Code classifies the failure, e.g. SecretNotFound or ClusterUnreachable.
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// Timeout is set when the request exceeded its timeoutSeconds.
+	Timeout *TimeoutDetails `json:"timeout,omitempty"`
+	// Quota is set when the request exceeded the quota of its ApplicationSet.
+	Quota *QuotaDetails `json:"quota,omitempty"`
+	// RetryAfterSeconds is set when the failure is likely to go away, to the
+	// delay clients should wait before retrying. It's also sent in the
+	// Retry-After header.
+	RetryAfterSeconds int `json:"retryAfterSeconds,omitempty"`
+}
+
+// StageTiming is the duration of a stage of a generate request, such as
+// getting the cluster secret or listing namespaces.
+type StageTiming struct {
+	Name           string `json:"name"`
+	DurationMillis int64  `json:"durationMillis"`
+	Error          string `json:"error,omitempty"`
+}
+
+// TimeoutDetails describes how far a request got before timing out.
+type TimeoutDetails struct {
+	TimeoutSeconds int   `json:"timeoutSeconds"`
+	ElapsedMillis  int64 `json:"elapsedMillis"`
+	// Stage is the stage which was in progress when the timeout expired.
+	Stage           string        `json:"stage,omitempty"`
+	CompletedStages []StageTiming `json:"completedStages,omitempty"`
+}
+
+// QuotaDetails describes the quota of the ApplicationSet a request exceeded.
+type QuotaDetails struct {
+	ApplicationSet string `json:"applicationSet"`
+	// Resource is what the quota limits: namespaces or clusters.
+	Resource  string `json:"resource"`
+	Limit     int    `json:"limit"`
+	Requested int    `json:"requested"`
+}
+
+const (
+	NamespaceEventAdded   = "added"
+	NamespaceEventRemoved = "removed"
+	NamespaceEventError   = "error"
+)
+
+// NamespaceEvent is the payload of the server-sent events streamed by the
+// namespace events endpoint.
+type NamespaceEvent struct {
+	Type        string `json:"type"`
+	Namespace   string `json:"namespace,omitempty"`
+	ClusterName string `json:"clusterName,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// NamespaceChanges is the response of the namespace changes endpoint.
+type NamespaceChanges struct {
+	// Cursor is passed as the since query parameter to get the next changes.
+	Cursor string `json:"cursor"`
+	// Full is set when Added holds all the matching namespaces rather than
+	// the changes since the cursor.
+	Full    bool     `json:"full,omitempty"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// BatchGenerateResult is the outcome of a single request of a batch. Exactly
+// one of Output and Error is set.
+type BatchGenerateResult struct {
+	Output *Output        `json:"output,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+	Status int            `json:"status"`
+	Debug  *DebugInfo     `json:"debug,omitempty"`
+	// Stale is set when the output is the last known result of the request.
+	Stale bool `json:"stale,omitempty"`
+	// Truncated is set when more namespaces matched than the server returns.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// BatchGenerateResponse holds the results of a batch keyed by the index of
+// the request in the batch.
+type BatchGenerateResponse struct {
+	Results map[int]BatchGenerateResult `json:"results"`
+}
+
+// FilterResult tells whether a namespace passed a single filter.
+type FilterResult struct {
+	Filter  string `json:"filter"`
+	Matched bool   `json:"matched"`
+}
+
+// NamespaceExplanation tells whether a namespace would be part of the
+// result, and which filters included or excluded it.
+type NamespaceExplanation struct {
+	Namespace string         `json:"namespace"`
+	Matched   bool           `json:"matched"`
+	Filters   []FilterResult `json:"filters"`
+}
+
+type ExplainResponse struct {
+	Namespaces []NamespaceExplanation `json:"namespaces"`
+}
+
+// RemoteClientStatus describes a cached client of a remote cluster.
+type RemoteClientStatus struct {
+	ClusterName  string     `json:"clusterName"`
+	Server       string     `json:"server"`
+	AuthProvider string     `json:"authProvider"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	AgeSeconds   int64      `json:"ageSeconds"`
+	TokenExpiry  *time.Time `json:"tokenExpiry,omitempty"`
+	LastSuccess  *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure  *time.Time `json:"lastFailure,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+}
+
+type RemoteClientsResponse struct {
+	Clients []RemoteClientStatus `json:"clients"`
+}
+
+type InvalidateResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// Sources the namespaces are desired by, when looking for orphans.
+const (
+	OrphanSourceClaims       = "claims"
+	OrphanSourceApplications = "applications"
+)
+
+// Reasons of the orphaned namespaces.
+const (
+	// OrphanReasonClaimDeleted is a namespace provisioned for a claim which
+	// was deleted, or which now claims another namespace.
+	OrphanReasonClaimDeleted = "ClaimDeleted"
+	// OrphanReasonUnclaimed is a namespace which was never claimed.
+	OrphanReasonUnclaimed = "Unclaimed"
+	// OrphanReasonNoApplication is a namespace no Application deploys to.
+	OrphanReasonNoApplication = "NoApplication"
+)
+
+// OrphanedNamespace is a namespace matching the selector which no source
+// desires.
+type OrphanedNamespace struct {
+	Namespace         string      `json:"namespace"`
+	Reason            string      `json:"reason"`
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+	// Claim is the claim the namespace was provisioned for, if any.
+	Claim string `json:"claim,omitempty"`
+}
+
+// OrphanedNamespacesResponse lists the orphaned namespaces of the local
+// cluster, sorted by name.
+type OrphanedNamespacesResponse struct {
+	Source  string              `json:"source"`
+	Orphans []OrphanedNamespace `json:"orphans"`
+}
+
+// ClusterCheckStep is the outcome of a single step of a cluster check.
+type ClusterCheckStep struct {
+	Name          string `json:"name"`
+	Success       bool   `json:"success"`
+	LatencyMillis int64  `json:"latencyMillis"`
+	Error         string `json:"error,omitempty"`
+}
+
+type ClusterCheckResponse struct {
+	ClusterName   string             `json:"clusterName"`
+	Server        string             `json:"server,omitempty"`
+	AuthProvider  string             `json:"authProvider"`
+	Healthy       bool               `json:"healthy"`
+	LatencyMillis int64              `json:"latencyMillis"`
+	Steps         []ClusterCheckStep `json:"steps"`
+}
+
+// ClusterInfo describes an ArgoCD cluster secret visible to the generator.
+type ClusterInfo struct {
+	// SecretName is the name used for referring to the cluster in requests.
+	SecretName string `json:"secretName"`
+	// Instance is the ArgoCD instance holding the secret, empty for the
+	// ArgoCD namespace.
+	Instance     string            `json:"instance,omitempty"`
+	Name         string            `json:"name,omitempty"`
+	Server       string            `json:"server,omitempty"`
+	Project      string            `json:"project,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	AuthProvider string            `json:"authProvider"`
+	// Reachable is unset until the generator called the cluster.
+	Reachable   *bool      `json:"reachable,omitempty"`
+	LastChecked *time.Time `json:"lastChecked,omitempty"`
+}
+
+type ClustersResponse struct {
+	Clusters []ClusterInfo `json:"clusters"`
+}
+
+// ClusterHealth is the outcome of the last background probe of a cluster.
+type ClusterHealth struct {
+	ClusterName string `json:"clusterName"`
+	Server      string `json:"server,omitempty"`
+	Healthy     bool   `json:"healthy"`
+	// Version is the version reported by the API server of the cluster.
+	Version       string    `json:"version,omitempty"`
+	LatencyMillis int64     `json:"latencyMillis"`
+	LastProbe     time.Time `json:"lastProbe"`
+	// LastHealthy is unset until a probe of the cluster succeeded.
+	LastHealthy         *time.Time `json:"lastHealthy,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures,omitempty"`
+	Error               string     `json:"error,omitempty"`
+	// Code classifies the error, e.g. AuthFailed or ClusterUnreachable.
+	Code string `json:"code,omitempty"`
+}
+
+type ClusterHealthResponse struct {
+	Clusters []ClusterHealth `json:"clusters"`
+}
+
+// ClusterParameters are the parameters generated for each cluster when the
+// generator backs a clusters-style ApplicationSet generator.
+type ClusterParameters struct {
+	Name       string            `json:"name"`
+	Server     string            `json:"server"`
+	SecretName string            `json:"secretName"`
+	Project    string            `json:"project,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+type ClusterOutput struct {
+	Parameters []ClusterParameters `json:"parameters"`
+}
+
+type ClusterGenerateResponse struct {
+	Output ClusterOutput `json:"output"`
 }
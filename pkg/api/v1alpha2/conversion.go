@@ -0,0 +1,33 @@
+package v1alpha2
+
+import (
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+)
+
+// ConvertRequestFromV1alpha1 converts a v1alpha1 request. The fields added by
+// v1alpha2 are left empty, which preserves the v1alpha1 behaviour.
+func ConvertRequestFromV1alpha1(in *v1alpha1.GenerateRequest) *GenerateRequest {
+	return &GenerateRequest{
+		ApplicationSetName: in.ApplicationSetName,
+		Input: Input{
+			Parameters: InParameters{
+				LabelSelector:  in.Input.Parameters.LabelSelector,
+				ClusterName:    in.Input.Parameters.ClusterName,
+				ArgoCDInstance: in.Input.Parameters.ArgoCDInstance,
+				TimeoutSeconds: in.Input.Parameters.TimeoutSeconds,
+				Debug:          in.Input.Parameters.Debug,
+				AllowAll:       in.Input.Parameters.AllowAll,
+			},
+		},
+	}
+}
+
+// ConvertResponseToV1alpha1 converts a response to v1alpha1, dropping the
+// output fields v1alpha1 doesn't have.
+func ConvertResponseToV1alpha1(in *GenerateResponse) *v1alpha1.GenerateResponse {
+	out := &v1alpha1.GenerateResponse{Debug: in.Debug}
+	for _, parameters := range in.Output.Parameters {
+		out.Output.Parameters = append(out.Output.Parameters, v1alpha1.OutParameters{Namespace: parameters.Namespace})
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+// Package v1alpha2 holds the second version of the plugin API. It adds
+// filtering and output fields to v1alpha1, which is converted to this
+// version before requests are served.
+package v1alpha2
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+)
+
+const Version = "v1alpha2"
+
+// MediaType selects v1alpha2 when sent in the Accept header.
+const MediaType = "application/vnd.namespace-generator.v1alpha2+json"
+
+type InParameters struct {
+	LabelSelector metav1.LabelSelector `json:"labelSelector"`
+	ClusterName   string               `json:"clusterName,omitempty"`
+	// ArgoCDInstance selects the ArgoCD instance whose cluster secrets are
+	// read. Empty reads the ones of the ArgoCD namespace.
+	ArgoCDInstance string `json:"argocdInstance,omitempty"`
+	// TimeoutSeconds bounds the time spent on looking up the cluster secret,
+	// authenticating and listing namespaces. Zero means no timeout.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// WaitSeconds holds the request until the response changes, for at most
+	// that many seconds. The response is compared with the If-None-Match
+	// header if set. Zero responds right away.
+	WaitSeconds int `json:"waitSeconds,omitempty"`
+	// Debug adds diagnostics to the response.
+	Debug bool `json:"debug,omitempty"`
+	// AllowAll confirms an empty label selector is meant to match all the
+	// namespaces. The server must allow it too.
+	AllowAll bool `json:"allowAll,omitempty"`
+	// ExcludeNamespaces are left out of the result even if they match the
+	// label selector.
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// LabelKeys are the keys of the namespace labels copied to the output.
+	LabelKeys []string `json:"labelKeys,omitempty"`
+	// AnnotationKeys are the keys of the namespace annotations copied to the
+	// output, e.g. the annotations of OpenShift projects.
+	AnnotationKeys []string `json:"annotationKeys,omitempty"`
+	// OpenShiftProject adds the metadata of the OpenShift project of each
+	// namespace to the output.
+	OpenShiftProject bool `json:"openshiftProject,omitempty"`
+}
+
+type Input struct {
+	Parameters InParameters `json:"parameters"`
+}
+
+type GenerateRequest struct {
+	ApplicationSetName string `json:"applicationSetName"`
+	Input              Input  `json:"input"`
+	// ClusterRef is the cluster secret of the request as resolved by the
+	// server, qualified by its ArgoCD instance. It isn't part of the API.
+	ClusterRef string `json:"-"`
+}
+
+type OutParameters struct {
+	Namespace   string            `json:"namespace"`
+	ClusterName string            `json:"clusterName,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Project is only set when requested, for the namespaces of OpenShift
+	// projects.
+	Project *ProjectMetadata `json:"project,omitempty"`
+	// Values are rendered from the output templates of the generator
+	// configuration.
+	Values map[string]string `json:"values,omitempty"`
+	// Phase is Active, or Terminating once the namespace is being deleted.
+	Phase string `json:"phase,omitempty"`
+}
+
+// ProjectMetadata is the metadata OpenShift keeps in the annotations of the
+// namespace of a project.
+type ProjectMetadata struct {
+	DisplayName string `json:"displayName,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Requester is the user who requested the project.
+	Requester string `json:"requester,omitempty"`
+}
+
+type Output struct {
+	Parameters []OutParameters `json:"parameters"`
+}
+
+type GenerateResponse struct {
+	Output Output `json:"output"`
+	// Debug is only set when requested.
+	Debug *DebugInfo `json:"debug,omitempty"`
+	// Stale is set when the output is the last known result of the request,
+	// served because the cluster couldn't be listed.
+	Stale *StaleInfo `json:"stale,omitempty"`
+	// Truncated is set when more namespaces matched than the server returns,
+	// in which case the output holds the first ones by name and Total is
+	// the number of namespaces which matched.
+	Truncated bool `json:"truncated,omitempty"`
+	Total     int  `json:"total,omitempty"`
+}
+
+type StaleInfo struct {
+	SnapshotAt time.Time `json:"snapshotAt"`
+	// Reason is the error which prevented listing the cluster.
+	Reason string `json:"reason"`
+	// RetryAfterSeconds is the delay clients should wait before asking for
+	// a fresh response. It's also sent in the Retry-After header.
+	RetryAfterSeconds int `json:"retryAfterSeconds,omitempty"`
+}
+
+// BatchGenerateResponse is the response of a v1alpha2 batch. It merges the
+// parameters of the requests which succeeded, and reports the requests which
+// were degraded or failed, so an empty result from clusters which failed
+// isn't mistaken for no namespace matching.
+type BatchGenerateResponse struct {
+	Parameters []OutParameters `json:"parameters"`
+	Warnings   []BatchWarning  `json:"warnings,omitempty"`
+	Errors     []BatchError    `json:"errors,omitempty"`
+}
+
+// BatchWarning reports a request of a batch whose parameters are part of the
+// response, but may be incomplete or outdated.
+type BatchWarning struct {
+	// Index is the index of the request in the batch.
+	Index       int    `json:"index"`
+	ClusterName string `json:"clusterName,omitempty"`
+	// Code is Stale or Truncated.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BatchError reports a request of a batch which failed, and whose
+// parameters are missing from the response.
+type BatchError struct {
+	// Index is the index of the request in the batch.
+	Index       int    `json:"index"`
+	ClusterName string `json:"clusterName,omitempty"`
+	// Status is the status the request would have failed with on its own.
+	Status int           `json:"status"`
+	Error  ErrorResponse `json:"error"`
+}
+
+const (
+	// WarningStale is the code of the warnings of stale results.
+	WarningStale = "Stale"
+	// WarningTruncated is the code of the warnings of truncated results.
+	WarningTruncated = "Truncated"
+)
+
+// The types below didn't change from v1alpha1.
+type (
+	DebugInfo     = v1alpha1.DebugInfo
+	ErrorResponse = v1alpha1.ErrorResponse
+)
+
+// ProvisionNamespaceRequest is the body of POST /api/v1/namespaces.
+type ProvisionNamespaceRequest struct {
+	// Namespace is the name of the namespace, and of its NamespaceClaim.
+	Namespace string `json:"namespace"`
+	// Template is the name of the NamespaceTemplate stamped into the
+	// namespace.
+	Template string `json:"template"`
+	// Labels and Annotations are set on the namespace, over the ones of the
+	// template.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// LabelKeys are the keys of the namespace labels copied to the output.
+	LabelKeys []string `json:"labelKeys,omitempty"`
+}
+
+// ProvisionNamespaceResponse holds the parameters generated for a
+// provisioned namespace.
+type ProvisionNamespaceResponse struct {
+	// Claim is the NamespaceClaim of the namespace, as namespace/name.
+	Claim string `json:"claim"`
+	// Parameters are what the plugin returns for the namespace when selected.
+	Parameters *OutParameters `json:"parameters,omitempty"`
+	// ExcludedBy is the filter of the server excluding the namespace from
+	// every result, in which case there are no parameters.
+	ExcludedBy string `json:"excludedBy,omitempty"`
+}
@@ -0,0 +1,57 @@
+package appsetrefresh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RefreshAnnotation makes the applicationset-controller regenerate an
+// ApplicationSet right away. The controller removes it once done.
+const RefreshAnnotation = "argocd.argoproj.io/application-set-refresh"
+
+var applicationSetGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "ApplicationSet"}
+
+// annotationNotifier sets the refresh annotation on the ApplicationSets.
+type annotationNotifier struct {
+	client client.Client
+}
+
+// NewAnnotationNotifier returns a notifier patching the refresh annotation on
+// the ApplicationSets with the given client of the cluster running ArgoCD.
+// It only needs the permission to patch the ApplicationSets.
+func NewAnnotationNotifier(cl client.Client) Notifier {
+	return &annotationNotifier{client: cl}
+}
+
+func (notifier *annotationNotifier) Refresh(ctx context.Context, appSets []ApplicationSet, _ []Change) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{RefreshAnnotation: "true"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The other ApplicationSets are still refreshed when one fails, e.g.
+	// because it was deleted.
+	var errs []error
+	for _, appSet := range appSets {
+		object := &metav1.PartialObjectMetadata{}
+		object.SetGroupVersionKind(applicationSetGVK)
+		object.SetNamespace(appSet.Namespace)
+		object.SetName(appSet.Name)
+		if err := notifier.client.Patch(ctx, object, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to annotate ApplicationSet %s: %w", appSet, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,354 @@
+// Package appsetrefresh refreshes the ApplicationSets generating from the
+// namespaces as soon as the namespaces change, instead of leaving them
+// stale until ArgoCD calls the plugin again.
+package appsetrefresh
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+const watchRetryInterval = 5 * time.Second
+
+// ApplicationSet identifies an ApplicationSet to refresh.
+type ApplicationSet struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func (appSet ApplicationSet) String() string {
+	return appSet.Namespace + "/" + appSet.Name
+}
+
+// Rule refreshes ApplicationSets when namespaces start or stop matching a
+// selector on a cluster.
+type Rule struct {
+	// Cluster is the name of the cluster secret of the watched cluster, empty
+	// for the local cluster.
+	Cluster         string
+	Selector        labels.Selector
+	ApplicationSets []ApplicationSet
+}
+
+// Change is a namespace starting or stopping to match the selector of a
+// rule.
+type Change struct {
+	// Type is added or removed, as in the namespace events.
+	Type        string `json:"type"`
+	ClusterName string `json:"clusterName,omitempty"`
+	Namespace   string `json:"namespace"`
+}
+
+// Notifier refreshes the ApplicationSets after the given changes.
+type Notifier interface {
+	Refresh(ctx context.Context, appSets []ApplicationSet, changes []Change) error
+}
+
+// Clients returns the clients of the clusters which can be watched, keyed by
+// the name of their cluster secret, the local cluster being empty.
+type Clients func() map[string]client.WithWatch
+
+// Options configures a Controller.
+type Options struct {
+	// Debounce is how long changes are collected before refreshing, so a
+	// burst of changes refreshes each ApplicationSet once.
+	Debounce time.Duration
+	// ResyncInterval is how often the watches are matched with the clients,
+	// e.g. to start watching a remote cluster once its client is cached.
+	ResyncInterval time.Duration
+}
+
+// Controller watches the namespaces selected by the rules and refreshes the
+// ApplicationSets of the rules when the namespaces change. A cluster is only
+// watched while Clients returns a client for it.
+type Controller struct {
+	rules    []Rule
+	clients  Clients
+	notifier Notifier
+	options  Options
+	logger   *slog.Logger
+
+	changes chan pendingChange
+}
+
+// pendingChange is a change along with the ApplicationSets of its rule.
+type pendingChange struct {
+	change  Change
+	appSets []ApplicationSet
+}
+
+func NewController(rules []Rule, clients Clients, notifier Notifier, options Options, logger *slog.Logger) *Controller {
+	return &Controller{
+		rules:    rules,
+		clients:  clients,
+		notifier: notifier,
+		options:  options,
+		logger:   logger,
+		changes:  make(chan pendingChange, 100),
+	}
+}
+
+// ruleWatch is a running watch of a rule.
+type ruleWatch struct {
+	client client.WithWatch
+	cancel context.CancelFunc
+	// done is closed once the watch stopped.
+	done chan struct{}
+}
+
+// Run watches the namespaces and refreshes the ApplicationSets until the
+// context is done.
+func (controller *Controller) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		controller.dispatch(ctx)
+	}()
+
+	ticker := time.NewTicker(controller.options.ResyncInterval)
+	defer ticker.Stop()
+	watches := make([]*ruleWatch, len(controller.rules))
+	// The namespaces known to match each rule are kept across its watches,
+	// so the changes made while a client is replaced are reported once the
+	// next client lists the namespaces.
+	known := make([]map[string]struct{}, len(controller.rules))
+	for {
+		clients := controller.clients()
+		for i, rule := range controller.rules {
+			cl := clients[rule.Cluster]
+			if watches[i] != nil && watches[i].client == cl {
+				continue
+			}
+			// The client was dropped or rebuilt, e.g. after its cluster
+			// secret changed.
+			if watches[i] != nil {
+				watches[i].cancel()
+				// The known namespaces are only handed over once the
+				// previous watch stopped updating them.
+				<-watches[i].done
+				watches[i] = nil
+			}
+			if cl == nil {
+				continue
+			}
+			watchCtx, cancelWatch := context.WithCancel(ctx)
+			done := make(chan struct{})
+			watches[i] = &ruleWatch{client: cl, cancel: cancelWatch, done: done}
+			wg.Add(1)
+			go func(rule Rule, known *map[string]struct{}) {
+				defer wg.Done()
+				defer close(done)
+				controller.follow(watchCtx, rule, cl, known)
+			}(rule, &known[i])
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// follow watches the namespaces of a rule until the context is done,
+// updating the known namespaces. The namespaces matching when the first watch
+// of the rule starts, with no known namespaces, aren't changes.
+func (controller *Controller) follow(ctx context.Context, rule Rule, cl client.WithWatch, known *map[string]struct{}) {
+	logger := controller.logger.With(logging.KeyCluster, rule.Cluster, "selector", rule.Selector.String())
+	for {
+		resourceVersion, err := controller.sync(ctx, rule, cl, known)
+		if err == nil {
+			err = controller.watch(ctx, rule, cl, resourceVersion, *known)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to watch namespaces for refreshing ApplicationSets", logging.KeyError, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// sync lists the matching namespaces and reports the differences with the
+// known ones, unless none are known yet. It returns the resource version of
+// the list for starting a watch from.
+func (controller *Controller) sync(ctx context.Context, rule Rule, cl client.WithWatch, known *map[string]struct{}) (string, error) {
+	nsList := generator.NewNamespaceList()
+	if err := generator.ListNamespacePages(ctx, cl, nsList, rule.Selector); err != nil {
+		return "", err
+	}
+
+	current := map[string]struct{}{}
+	for _, namespace := range nsList.Items {
+		current[namespace.Name] = struct{}{}
+	}
+	if *known != nil {
+		for name := range current {
+			if _, ok := (*known)[name]; !ok {
+				controller.report(ctx, rule, v1alpha1.NamespaceEventAdded, name)
+			}
+		}
+		for name := range *known {
+			if _, ok := current[name]; !ok {
+				controller.report(ctx, rule, v1alpha1.NamespaceEventRemoved, name)
+			}
+		}
+	}
+	*known = current
+
+	return nsList.ResourceVersion, nil
+}
+
+// watch reports changes until the watch is closed by the API server. A nil
+// error means the caller should sync again and restart the watch.
+func (controller *Controller) watch(ctx context.Context, rule Rule, cl client.WithWatch, resourceVersion string, known map[string]struct{}) error {
+	watcher, err := cl.Watch(ctx, generator.NewNamespaceList(), &client.ListOptions{
+		LabelSelector: rule.Selector,
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Error {
+				// Most likely the resource version is too old, start over.
+				return nil
+			}
+			object, ok := event.Object.(metav1.Object)
+			if !ok {
+				continue
+			}
+			name := object.GetName()
+			// The API server deletes the namespaces stopping to match the
+			// selector from the watch, the labels are still checked in case
+			// the watch isn't filtered.
+			matches := event.Type != watch.Deleted && rule.Selector.Matches(labels.Set(object.GetLabels()))
+			switch {
+			case event.Type == watch.Bookmark:
+			case matches:
+				if _, ok := known[name]; !ok {
+					known[name] = struct{}{}
+					controller.report(ctx, rule, v1alpha1.NamespaceEventAdded, name)
+				}
+			default:
+				if _, ok := known[name]; ok {
+					delete(known, name)
+					controller.report(ctx, rule, v1alpha1.NamespaceEventRemoved, name)
+				}
+			}
+		}
+	}
+}
+
+func (controller *Controller) report(ctx context.Context, rule Rule, changeType, namespace string) {
+	change := pendingChange{
+		change:  Change{Type: changeType, ClusterName: rule.Cluster, Namespace: namespace},
+		appSets: rule.ApplicationSets,
+	}
+	select {
+	case <-ctx.Done():
+	case controller.changes <- change:
+	}
+}
+
+// dispatch collects the changes for the debounce duration and then
+// refreshes the ApplicationSets of all the collected changes at once.
+func (controller *Controller) dispatch(ctx context.Context) {
+	var pending []pendingChange
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change := <-controller.changes:
+			if len(pending) == 0 {
+				timer = time.After(controller.options.Debounce)
+			}
+			pending = append(pending, change)
+		case <-timer:
+			controller.refresh(ctx, pending)
+			pending = nil
+			timer = nil
+		}
+	}
+}
+
+func (controller *Controller) refresh(ctx context.Context, pending []pendingChange) {
+	changes := make([]Change, 0, len(pending))
+	seen := map[ApplicationSet]struct{}{}
+	var appSets []ApplicationSet
+	for _, p := range pending {
+		changes = append(changes, p.change)
+		for _, appSet := range p.appSets {
+			if _, ok := seen[appSet]; !ok {
+				seen[appSet] = struct{}{}
+				appSets = append(appSets, appSet)
+			}
+		}
+	}
+	sort.Slice(appSets, func(i, j int) bool { return appSets[i].String() < appSets[j].String() })
+
+	if err := controller.notifier.Refresh(ctx, appSets, changes); err != nil {
+		// ArgoCD still refreshes them on its own schedule.
+		metrics.ApplicationSetRefreshes.WithLabelValues(metrics.ResultError).Add(float64(len(appSets)))
+		controller.logger.Error("Failed to refresh ApplicationSets", "applicationSets", appSetNames(appSets), logging.KeyError, err)
+		return
+	}
+	metrics.ApplicationSetRefreshes.WithLabelValues(metrics.ResultSuccess).Add(float64(len(appSets)))
+	controller.logger.Info("Refreshed ApplicationSets after namespace changes", "applicationSets", appSetNames(appSets), "changes", len(changes))
+}
+
+func appSetNames(appSets []ApplicationSet) []string {
+	names := make([]string, 0, len(appSets))
+	for _, appSet := range appSets {
+		names = append(names, appSet.String())
+	}
+	return names
+}
+
+// ParseRule builds a rule from a label selector.
+func ParseRule(cluster string, selector metav1.LabelSelector, appSets []ApplicationSet) (Rule, error) {
+	parsed, err := metav1.LabelSelectorAsSelector(&selector)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid label selector: %w", err)
+	}
+	return Rule{Cluster: cluster, Selector: parsed, ApplicationSets: appSets}, nil
+}
@@ -0,0 +1,258 @@
+package appsetrefresh_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/konflux-ci/namespace-generator/pkg/appsetrefresh"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+func TestAppSetRefresh(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AppSetRefresh Suite")
+}
+
+func newFakeClient(objects ...client.Object) client.WithWatch {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// refresh is a call of the fake notifier.
+type refresh struct {
+	appSets []appsetrefresh.ApplicationSet
+	changes []appsetrefresh.Change
+}
+
+// fakeNotifier reports its calls, failing with err when set.
+type fakeNotifier struct {
+	refreshes chan refresh
+	err       error
+}
+
+func (notifier *fakeNotifier) Refresh(_ context.Context, appSets []appsetrefresh.ApplicationSet, changes []appsetrefresh.Change) error {
+	notifier.refreshes <- refresh{appSets: appSets, changes: changes}
+	return notifier.err
+}
+
+var (
+	teamA = appsetrefresh.ApplicationSet{Namespace: "argocd", Name: "team-a"}
+	teamB = appsetrefresh.ApplicationSet{Namespace: "argocd", Name: "team-b"}
+)
+
+func rule(cluster string, team string, appSets ...appsetrefresh.ApplicationSet) appsetrefresh.Rule {
+	parsed, err := appsetrefresh.ParseRule(cluster, metav1.LabelSelector{MatchLabels: map[string]string{"team": team}}, appSets)
+	Expect(err).NotTo(HaveOccurred())
+	return parsed
+}
+
+var _ = Describe("Controller", func() {
+	var (
+		notifier *fakeNotifier
+		mu       sync.Mutex
+		clients  map[string]client.WithWatch
+		run      func(ctx context.Context, rules ...appsetrefresh.Rule)
+	)
+
+	BeforeEach(func() {
+		notifier = &fakeNotifier{refreshes: make(chan refresh, 10)}
+		clients = map[string]client.WithWatch{}
+		run = func(ctx context.Context, rules ...appsetrefresh.Rule) {
+			controller := appsetrefresh.NewController(rules, func() map[string]client.WithWatch {
+				mu.Lock()
+				defer mu.Unlock()
+				copied := make(map[string]client.WithWatch, len(clients))
+				for name, cl := range clients {
+					copied[name] = cl
+				}
+				return copied
+			}, notifier, appsetrefresh.Options{Debounce: 100 * time.Millisecond, ResyncInterval: 10 * time.Millisecond}, slog.Default())
+			runCtx, cancel := context.WithCancel(ctx)
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				controller.Run(runCtx)
+			}()
+			DeferCleanup(func() {
+				cancel()
+				Eventually(stopped).Should(BeClosed())
+			})
+		}
+	})
+
+	setClient := func(cluster string, cl client.WithWatch) {
+		mu.Lock()
+		defer mu.Unlock()
+		clients[cluster] = cl
+	}
+
+	It("should refresh the ApplicationSets of a burst of changes at once", func(ctx SpecContext) {
+		local := newFakeClient(namespace("a-1", map[string]string{"team": "a"}))
+		setClient("", local)
+		run(ctx, rule("", "a", teamA, teamB), rule("", "b", teamB))
+		// The namespaces matching when the watches start aren't changes.
+		Consistently(notifier.refreshes, 200*time.Millisecond).ShouldNot(Receive())
+
+		Expect(local.Create(ctx, namespace("a-2", map[string]string{"team": "a"}))).To(Succeed())
+		Expect(local.Create(ctx, namespace("b-1", map[string]string{"team": "b"}))).To(Succeed())
+		Expect(local.Create(ctx, namespace("other", map[string]string{"team": "c"}))).To(Succeed())
+		Expect(local.Delete(ctx, namespace("a-1", nil))).To(Succeed())
+
+		var got refresh
+		Eventually(notifier.refreshes).Should(Receive(&got))
+		Expect(got.appSets).To(Equal([]appsetrefresh.ApplicationSet{teamA, teamB}))
+		Expect(got.changes).To(ConsistOf(
+			appsetrefresh.Change{Type: "added", Namespace: "a-2"},
+			appsetrefresh.Change{Type: "added", Namespace: "b-1"},
+			appsetrefresh.Change{Type: "removed", Namespace: "a-1"},
+		))
+		Consistently(notifier.refreshes, 200*time.Millisecond).ShouldNot(Receive())
+
+		// A namespace stopping to match its selector is removed.
+		Expect(local.Patch(ctx, namespace("b-1", map[string]string{"team": "c"}), client.Merge)).To(Succeed())
+		Eventually(notifier.refreshes).Should(Receive(Equal(refresh{
+			appSets: []appsetrefresh.ApplicationSet{teamB},
+			changes: []appsetrefresh.Change{{Type: "removed", Namespace: "b-1"}},
+		})))
+	})
+
+	It("should report the changes made while the client of a cluster was replaced", func(ctx SpecContext) {
+		setClient("remote1-secret", newFakeClient(
+			namespace("a-1", map[string]string{"team": "a"}),
+			namespace("a-2", map[string]string{"team": "a"}),
+		))
+		run(ctx, rule("remote1-secret", "a", teamA))
+		Consistently(notifier.refreshes, 200*time.Millisecond).ShouldNot(Receive())
+
+		// The rebuilt client lists the namespaces as changed in between.
+		setClient("remote1-secret", newFakeClient(
+			namespace("a-2", map[string]string{"team": "a"}),
+			namespace("a-3", map[string]string{"team": "a"}),
+		))
+		var got refresh
+		Eventually(notifier.refreshes).Should(Receive(&got))
+		Expect(got.appSets).To(Equal([]appsetrefresh.ApplicationSet{teamA}))
+		Expect(got.changes).To(ConsistOf(
+			appsetrefresh.Change{Type: "added", ClusterName: "remote1-secret", Namespace: "a-3"},
+			appsetrefresh.Change{Type: "removed", ClusterName: "remote1-secret", Namespace: "a-1"},
+		))
+	})
+
+	It("should count the failed refreshes and keep refreshing", func(ctx SpecContext) {
+		notifier.err = errors.New("ArgoCD is unavailable")
+		local := newFakeClient()
+		setClient("", local)
+		run(ctx, rule("", "a", teamA, teamB))
+		Consistently(notifier.refreshes, 200*time.Millisecond).ShouldNot(Receive())
+		failed := testutil.ToFloat64(metrics.ApplicationSetRefreshes.WithLabelValues(metrics.ResultError))
+
+		Expect(local.Create(ctx, namespace("a-1", map[string]string{"team": "a"}))).To(Succeed())
+		Eventually(notifier.refreshes).Should(Receive())
+		Eventually(func() float64 {
+			return testutil.ToFloat64(metrics.ApplicationSetRefreshes.WithLabelValues(metrics.ResultError))
+		}).Should(Equal(failed + 2))
+
+		Expect(local.Create(ctx, namespace("a-2", map[string]string{"team": "a"}))).To(Succeed())
+		Eventually(notifier.refreshes).Should(Receive(Equal(refresh{
+			appSets: []appsetrefresh.ApplicationSet{teamA, teamB},
+			changes: []appsetrefresh.Change{{Type: "added", Namespace: "a-2"}},
+		})))
+	})
+})
+
+var _ = Describe("WebhookNotifier", func() {
+	It("should post the ApplicationSets with the current token", func(ctx SpecContext) {
+		status := http.StatusOK
+		var requests []*http.Request
+		var bodies []appsetrefresh.WebhookRequest
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body := appsetrefresh.WebhookRequest{}
+			Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+			requests = append(requests, req)
+			bodies = append(bodies, body)
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		tokenPath := filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(tokenPath, []byte("token-1\n"), 0o600)).To(Succeed())
+		notifier := appsetrefresh.NewWebhookNotifier(server.URL, tokenPath, time.Second)
+
+		changes := []appsetrefresh.Change{{Type: "added", ClusterName: "remote1-secret", Namespace: "a-1"}}
+		Expect(notifier.Refresh(ctx, []appsetrefresh.ApplicationSet{teamA}, changes)).To(Succeed())
+		Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer token-1"))
+		Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(bodies[0]).To(Equal(appsetrefresh.WebhookRequest{ApplicationSets: []appsetrefresh.ApplicationSet{teamA}, Changes: changes}))
+
+		// The token is read again on every call, and the responses other
+		// than 2xx fail.
+		Expect(os.WriteFile(tokenPath, []byte("token-2"), 0o600)).To(Succeed())
+		status = http.StatusServiceUnavailable
+		Expect(notifier.Refresh(ctx, []appsetrefresh.ApplicationSet{teamA}, changes)).To(MatchError(ContainSubstring("status 503")))
+		Expect(requests[1].Header.Get("Authorization")).To(Equal("Bearer token-2"))
+
+		Expect(os.Remove(tokenPath)).To(Succeed())
+		Expect(notifier.Refresh(ctx, []appsetrefresh.ApplicationSet{teamA}, changes)).To(MatchError(ContainSubstring("failed to read the webhook token")))
+		Expect(requests).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("AnnotationNotifier", func() {
+	applicationSet := func(appSet appsetrefresh.ApplicationSet) *unstructured.Unstructured {
+		object := &unstructured.Unstructured{}
+		object.SetAPIVersion("argoproj.io/v1alpha1")
+		object.SetKind("ApplicationSet")
+		object.SetNamespace(appSet.Namespace)
+		object.SetName(appSet.Name)
+		return object
+	}
+
+	It("should annotate the ApplicationSets to refresh, even when one is missing", func(ctx SpecContext) {
+		teamC := appsetrefresh.ApplicationSet{Namespace: "argocd", Name: "team-c"}
+		other := applicationSet(teamB)
+		other.SetAnnotations(map[string]string{"team": "b"})
+		cl := fake.NewClientBuilder().WithObjects(applicationSet(teamA), other, applicationSet(teamC)).Build()
+		notifier := appsetrefresh.NewAnnotationNotifier(cl)
+
+		missing := appsetrefresh.ApplicationSet{Namespace: "argocd", Name: "deleted"}
+		err := notifier.Refresh(ctx, []appsetrefresh.ApplicationSet{teamA, missing, teamB}, nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to annotate ApplicationSet argocd/deleted")))
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		for appSet, annotations := range map[appsetrefresh.ApplicationSet]map[string]string{
+			teamA: {appsetrefresh.RefreshAnnotation: "true"},
+			teamB: {appsetrefresh.RefreshAnnotation: "true", "team": "b"},
+			teamC: nil,
+		} {
+			object := applicationSet(appSet)
+			Expect(cl.Get(ctx, client.ObjectKeyFromObject(object), object)).To(Succeed())
+			Expect(object.GetAnnotations()).To(Equal(annotations), "ApplicationSet %s", appSet)
+		}
+	})
+})
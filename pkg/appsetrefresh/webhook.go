@@ -0,0 +1,67 @@
+package appsetrefresh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// WebhookRequest is the body posted to the refresh webhook.
+type WebhookRequest struct {
+	ApplicationSets []ApplicationSet `json:"applicationSets"`
+	Changes         []Change         `json:"changes"`
+}
+
+// webhookNotifier posts the ApplicationSets to refresh to a URL.
+type webhookNotifier struct {
+	url       string
+	tokenPath string
+	client    *http.Client
+}
+
+// NewWebhookNotifier returns a notifier posting a WebhookRequest to the URL.
+// When tokenPath is set, the token in the file is sent as a bearer token; the
+// file is read on every call so the token can be rotated. Refreshes not
+// accepted with a 2xx status within the timeout are reported as failed.
+func NewWebhookNotifier(url, tokenPath string, timeout time.Duration) Notifier {
+	return &webhookNotifier{url: url, tokenPath: tokenPath, client: &http.Client{Timeout: timeout}}
+}
+
+func (notifier *webhookNotifier) Refresh(ctx context.Context, appSets []ApplicationSet, changes []Change) error {
+	body, err := json.Marshal(WebhookRequest{ApplicationSets: appSets, Changes: changes})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if notifier.tokenPath != "" {
+		token, err := os.ReadFile(notifier.tokenPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the webhook token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := notifier.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the refresh webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Package audit records the generation requests served by the generator to a
+// sink, for compliance and capacity analysis.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// Outcomes of the audited requests.
+const (
+	OutcomeSuccess = "success"
+	// OutcomeStale is a response served from a snapshot after a failure.
+	OutcomeStale   = "stale"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// ActionProvision is the action of the requests provisioning a namespace.
+// The generation requests don't have an action.
+const ActionProvision = "provision"
+
+// LocalCluster is the name the cluster the generator runs in is recorded
+// with, as in ArgoCD.
+const LocalCluster = "in-cluster"
+
+// Event is the record of a generation request, or of a request provisioning a
+// namespace.
+type Event struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestID,omitempty"`
+	Caller    Caller    `json:"caller"`
+	// Action is ActionProvision for the requests provisioning a namespace,
+	// and empty for the generation requests.
+	Action string `json:"action,omitempty"`
+	// Namespace is the namespace a request provisioned.
+	Namespace string `json:"namespace,omitempty"`
+	// ApplicationSet is the name of the ApplicationSet the request was made
+	// for, if ArgoCD sent it.
+	ApplicationSet string `json:"applicationSet,omitempty"`
+	// ApplicationSetNamespace is the namespace of the ApplicationSet, if the
+	// request identified it with headers.
+	ApplicationSetNamespace string   `json:"applicationSetNamespace,omitempty"`
+	Selector                string   `json:"selector"`
+	Clusters                []string `json:"clusters"`
+	// Namespaces is the number of namespaces returned.
+	Namespaces      int     `json:"namespaces"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Outcome         string  `json:"outcome"`
+	Status          int     `json:"status"`
+	Error           string  `json:"error,omitempty"`
+	// ErrorCode classifies the failure, e.g. SecretNotFound.
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+// Caller identifies the client which made a request.
+type Caller struct {
+	// Subject is the authenticated identity of the caller, e.g. the service
+	// account of its reviewed token, or static-key for the static API key.
+	Subject   string `json:"subject,omitempty"`
+	Address   string `json:"address"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Tenants are the tenants of the token the caller presented, if any.
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// Sink stores audit events.
+type Sink interface {
+	Write(ctx context.Context, event Event) error
+	Close() error
+}
+
+// Auditor passes events to a sink in the background, so requests aren't
+// slowed down by the sink. Events are dropped when the sink can't keep up.
+type Auditor struct {
+	sink   Sink
+	logger *slog.Logger
+	events chan Event
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAuditor returns an auditor buffering up to bufferSize events before
+// dropping them.
+func NewAuditor(sink Sink, bufferSize int, logger *slog.Logger) *Auditor {
+	auditor := &Auditor{
+		sink:   sink,
+		logger: logger,
+		events: make(chan Event, max(bufferSize, 1)),
+		done:   make(chan struct{}),
+	}
+	go auditor.run()
+	return auditor
+}
+
+// Record queues the event for the sink. It never blocks.
+func (auditor *Auditor) Record(event Event) {
+	auditor.mu.RLock()
+	defer auditor.mu.RUnlock()
+	if auditor.closed {
+		return
+	}
+
+	select {
+	case auditor.events <- event:
+	default:
+		metrics.AuditEvents.WithLabelValues(metrics.ResultDropped).Inc()
+		auditor.logger.Warn("Dropped an audit event, the sink can't keep up", logging.KeyRequestID, event.RequestID)
+	}
+}
+
+// Close writes the queued events and closes the sink.
+func (auditor *Auditor) Close() error {
+	auditor.mu.Lock()
+	if !auditor.closed {
+		auditor.closed = true
+		close(auditor.events)
+	}
+	auditor.mu.Unlock()
+
+	<-auditor.done
+	return auditor.sink.Close()
+}
+
+func (auditor *Auditor) run() {
+	defer close(auditor.done)
+	for event := range auditor.events {
+		if err := auditor.sink.Write(context.Background(), event); err != nil {
+			metrics.AuditEvents.WithLabelValues(metrics.ResultError).Inc()
+			auditor.logger.Error("Failed to write an audit event", logging.KeyRequestID, event.RequestID, logging.KeyError, err)
+			continue
+		}
+		metrics.AuditEvents.WithLabelValues(metrics.ResultSuccess).Inc()
+	}
+}
@@ -0,0 +1,159 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+func TestAudit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Audit Suite")
+}
+
+// fakeSink records the IDs of the events written, and blocks the writes while
+// its gate is held.
+type fakeSink struct {
+	gate    sync.Mutex
+	writing chan string
+
+	mu      sync.Mutex
+	written []string
+	closed  bool
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{writing: make(chan string, 100)}
+}
+
+func (sink *fakeSink) Write(_ context.Context, event audit.Event) error {
+	sink.writing <- event.RequestID
+	sink.gate.Lock()
+	defer sink.gate.Unlock()
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.written = append(sink.written, event.RequestID)
+	return nil
+}
+
+func (sink *fakeSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.closed = true
+	return nil
+}
+
+var _ = Describe("Auditor", func() {
+	It("should drop the events when the sink can't keep up", func() {
+		sink := newFakeSink()
+		sink.gate.Lock()
+		auditor := audit.NewAuditor(sink, 2, slog.Default())
+		dropped := testutil.ToFloat64(metrics.AuditEvents.WithLabelValues(metrics.ResultDropped))
+
+		// The first event is being written while the next ones fill the
+		// buffer.
+		auditor.Record(audit.Event{RequestID: "1"})
+		Eventually(sink.writing).Should(Receive(Equal("1")))
+		for _, id := range []string{"2", "3", "4", "5"} {
+			auditor.Record(audit.Event{RequestID: id})
+		}
+
+		sink.gate.Unlock()
+		Expect(auditor.Close()).To(Succeed())
+		Expect(sink.written).To(Equal([]string{"1", "2", "3"}))
+		Expect(testutil.ToFloat64(metrics.AuditEvents.WithLabelValues(metrics.ResultDropped)) - dropped).To(Equal(2.0))
+	})
+
+	It("should write the queued events when closed", func() {
+		sink := newFakeSink()
+		sink.gate.Lock()
+		auditor := audit.NewAuditor(sink, 10, slog.Default())
+		for _, id := range []string{"1", "2", "3"} {
+			auditor.Record(audit.Event{RequestID: id})
+		}
+
+		closed := make(chan error)
+		go func() { closed <- auditor.Close() }()
+		Consistently(closed).ShouldNot(Receive())
+		sink.gate.Unlock()
+		Eventually(closed).Should(Receive(BeNil()))
+		Expect(sink.written).To(Equal([]string{"1", "2", "3"}))
+		Expect(sink.closed).To(BeTrue())
+
+		// The events recorded once closed are ignored.
+		auditor.Record(audit.Event{RequestID: "4"})
+		Expect(sink.written).To(HaveLen(3))
+	})
+})
+
+var _ = Describe("Sinks", func() {
+	event := audit.Event{
+		RequestID: "request-1",
+		Caller:    audit.Caller{Subject: "static-key", Address: "10.0.0.1"},
+		Clusters:  []string{audit.LocalCluster},
+		Outcome:   audit.OutcomeSuccess,
+		Status:    http.StatusOK,
+	}
+
+	It("should write the events as JSON lines", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "audit.log")
+		sink, err := audit.NewFileSink(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sink.Write(ctx, event)).To(Succeed())
+		Expect(sink.Write(ctx, event)).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(ContainSubstring(`"caller":{"subject":"static-key","address":"10.0.0.1"}`))
+	})
+
+	It("should only accept the 2xx responses of the webhook", func(ctx SpecContext) {
+		status := http.StatusNoContent
+		var received bytes.Buffer
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+			_, _ = io.Copy(&received, req.Body)
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		sink := audit.NewWebhookSink(server.URL, time.Second)
+		defer sink.Close()
+
+		Expect(sink.Write(ctx, event)).To(Succeed())
+		posted := audit.Event{}
+		Expect(json.Unmarshal(received.Bytes(), &posted)).To(Succeed())
+		Expect(posted.RequestID).To(Equal("request-1"))
+
+		for _, status = range []int{http.StatusMovedPermanently, http.StatusBadRequest, http.StatusInternalServerError} {
+			Expect(sink.Write(ctx, event)).To(MatchError(ContainSubstring("responded with status")), "status %d", status)
+		}
+
+		// The auditor counts the events the webhook rejected as failed.
+		failed := testutil.ToFloat64(metrics.AuditEvents.WithLabelValues(metrics.ResultError))
+		auditor := audit.NewAuditor(audit.NewWebhookSink(server.URL, time.Second), 10, slog.Default())
+		auditor.Record(event)
+		Expect(auditor.Close()).To(Succeed())
+		Expect(testutil.ToFloat64(metrics.AuditEvents.WithLabelValues(metrics.ResultError)) - failed).To(Equal(1.0))
+	})
+})
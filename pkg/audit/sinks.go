@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// writerSink writes events as JSON lines.
+type writerSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewWriterSink returns a sink writing events as JSON lines to the writer,
+// such as stdout. The writer isn't closed with the sink.
+func NewWriterSink(writer io.Writer) Sink {
+	return &writerSink{encoder: json.NewEncoder(writer)}
+}
+
+// NewFileSink returns a sink appending events as JSON lines to the file,
+// which is created if it doesn't exist.
+func NewFileSink(path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &writerSink{encoder: json.NewEncoder(file), closer: file}, nil
+}
+
+func (sink *writerSink) Write(_ context.Context, event Event) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.encoder.Encode(event)
+}
+
+func (sink *writerSink) Close() error {
+	if sink.closer == nil {
+		return nil
+	}
+	return sink.closer.Close()
+}
+
+// webhookSink posts each event as JSON to a URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting each event to the URL. Events not
+// accepted with a 2xx status within the timeout are reported as failed.
+func NewWebhookSink(url string, timeout time.Duration) Sink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (sink *webhookSink) Write(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the audit webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (sink *webhookSink) Close() error {
+	sink.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+	"github.com/konflux-ci/namespace-generator/pkg/sharedcache"
+)
+
+// expiryDelta is how long before its expiry a cached token is replaced, so a
+// token never expires while a request is in flight.
+const expiryDelta = time.Minute
+
+// Provider obtains tokens for accessing remote clusters.
+type Provider interface {
+	// Name identifies the provider in logs and diagnostics.
+	Name() string
+	// Token returns a valid token.
+	Token(ctx context.Context) (*oauth2.Token, error)
+}
+
+const (
+	// minRefreshRetryDelay and maxRefreshRetryDelay bound the delay before
+	// retrying a failed background refresh.
+	minRefreshRetryDelay = 10 * time.Second
+	maxRefreshRetryDelay = 5 * time.Minute
+)
+
+// sharedStoreTimeout bounds the calls to the shared store, which is only an
+// optimization over minting tokens.
+const sharedStoreTimeout = time.Second
+
+// CachedProvider caches the token of a provider until shortly before it
+// expires. When a shared store is given, tokens are also shared with the
+// other replicas, so a token isn't minted by every replica.
+type CachedProvider struct {
+	provider Provider
+	store    sharedcache.Store
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// NewCachedProvider returns a provider caching the tokens of the given
+// provider. The store is optional.
+func NewCachedProvider(provider Provider, store sharedcache.Store) *CachedProvider {
+	return &CachedProvider{provider: provider, store: store}
+}
+
+func (cached *CachedProvider) Name() string {
+	return cached.provider.Name()
+}
+
+func (cached *CachedProvider) Token(ctx context.Context) (*oauth2.Token, error) {
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if cached.token != nil && !expiresWithin(cached.token, expiryDelta) {
+		return cached.token, nil
+	}
+
+	if token := cached.sharedToken(ctx); token != nil {
+		cached.token = token
+		return token, nil
+	}
+
+	token, err := cached.provider.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cached.token = token
+	cached.shareToken(ctx, token)
+	return token, nil
+}
+
+func (cached *CachedProvider) sharedTokenKey() string {
+	return "token:" + cached.provider.Name()
+}
+
+// sharedToken returns the token shared by another replica, or nil if there
+// is no usable token in the shared store.
+func (cached *CachedProvider) sharedToken(ctx context.Context) *oauth2.Token {
+	if cached.store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+	defer cancel()
+	data, ok, err := cached.store.Get(ctx, cached.sharedTokenKey())
+	if err != nil {
+		metrics.SharedCacheErrors.WithLabelValues("token").Inc()
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(data, token); err != nil || expiresWithin(token, expiryDelta) {
+		return nil
+	}
+	return token
+}
+
+// shareToken stores the token in the shared store until it would be replaced.
+func (cached *CachedProvider) shareToken(ctx context.Context, token *oauth2.Token) {
+	if cached.store == nil {
+		return
+	}
+
+	ttl := time.Hour
+	if !token.Expiry.IsZero() {
+		ttl = time.Until(token.Expiry) - expiryDelta
+	}
+	data, err := json.Marshal(token)
+	if err != nil || ttl <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+	defer cancel()
+	if err := cached.store.Set(ctx, cached.sharedTokenKey(), data, ttl); err != nil {
+		metrics.SharedCacheErrors.WithLabelValues("token").Inc()
+	}
+}
+
+// RefreshInBackground replaces the cached token the given duration before it
+// expires, with jitter, until the context is done. Requests therefore never
+// wait for a token to be minted. Failures are passed to onError and retried
+// with backoff; requests mint a token themselves if the cached one expires.
+func (cached *CachedProvider) RefreshInBackground(ctx context.Context, ahead time.Duration, onError func(error)) {
+	retryDelay := minRefreshRetryDelay
+	for {
+		if !sleep(ctx, cached.nextRefresh(ahead)) {
+			return
+		}
+
+		if err := cached.refresh(ctx); err != nil {
+			metrics.TokenRefreshes.WithLabelValues(metrics.ResultError).Inc()
+			onError(err)
+			if !sleep(ctx, retryDelay) {
+				return
+			}
+			retryDelay = min(retryDelay*2, maxRefreshRetryDelay)
+			continue
+		}
+		metrics.TokenRefreshes.WithLabelValues(metrics.ResultSuccess).Inc()
+		retryDelay = minRefreshRetryDelay
+	}
+}
+
+// nextRefresh returns how long to wait before refreshing the cached token.
+func (cached *CachedProvider) nextRefresh(ahead time.Duration) time.Duration {
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	switch {
+	case cached.token == nil:
+		return 0
+	case cached.token.Expiry.IsZero():
+		// The token doesn't expire, check again later in case it's replaced.
+		return time.Hour
+	}
+	jitter := time.Duration(rand.Int63n(int64(ahead/4) + 1))
+	return max(time.Until(cached.token.Expiry)-ahead-jitter, 0)
+}
+
+// refresh mints a new token and caches it. The lock isn't held while
+// minting, so requests keep using the current token meanwhile.
+func (cached *CachedProvider) refresh(ctx context.Context) error {
+	token, err := cached.provider.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	cached.token = token
+	cached.shareToken(ctx, token)
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Expiry returns the expiry of the cached token. It's zero if no token is
+// cached or if the token doesn't expire.
+func (cached *CachedProvider) Expiry() time.Time {
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if cached.token == nil {
+		return time.Time{}
+	}
+	return cached.token.Expiry
+}
+
+func expiresWithin(token *oauth2.Token, d time.Duration) bool {
+	if token.Expiry.IsZero() {
+		return false
+	}
+	return time.Until(token.Expiry) < d
+}
+
+// WrapTransport returns a function for rest.Config.WrapTransport that adds a
+// bearer token from the provider to every request, so long lived clients
+// keep working after the token they were created with expires.
+func WrapTransport(provider Provider) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &transport{provider: provider, base: rt}
+	}
+}
+
+type transport struct {
+	provider Provider
+	base     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.provider.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	token.SetAuthHeader(req)
+	return t.base.RoundTrip(req)
+}
+
+// Invalidate drops the cached token, so the next call mints a new one.
+func (cached *CachedProvider) Invalidate() {
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	cached.token = nil
+
+	if cached.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), sharedStoreTimeout)
+		defer cancel()
+		if _, err := cached.store.DeletePrefix(ctx, cached.sharedTokenKey()); err != nil {
+			metrics.SharedCacheErrors.WithLabelValues("token").Inc()
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package authtest holds the conformance suite of the auth providers. Every
+// auth.Provider implementation must pass it, so the behaviors the rest of the
+// generator relies on, like reporting the expiry of the tokens or not caching
+// failures, don't regress when a new cloud integration is added:
+//
+//	func TestConformance(t *testing.T) {
+//		authtest.RunConformance(t, func(t *testing.T, server *authtest.TokenServer) auth.Provider {
+//			return newProviderUsing(server.URL)
+//		})
+//	}
+package authtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+)
+
+// TokenServer is a fake OAuth2 token endpoint, which the providers under test
+// get their tokens from. It issues a new token on every request.
+type TokenServer struct {
+	// URL is the URL of the token endpoint. Any path is served.
+	URL string
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	expiresIn time.Duration
+	status    int
+	requests  int
+	lastToken string
+}
+
+// NewTokenServer starts a token server issuing tokens valid for an hour.
+func NewTokenServer() *TokenServer {
+	server := &TokenServer{expiresIn: time.Hour}
+	server.server = httptest.NewServer(http.HandlerFunc(server.serveToken))
+	server.URL = server.server.URL
+	return server
+}
+
+// Close shuts the server down.
+func (server *TokenServer) Close() {
+	server.server.Close()
+}
+
+// SetExpiresIn sets how long the issued tokens are valid for.
+func (server *TokenServer) SetExpiresIn(expiresIn time.Duration) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.expiresIn = expiresIn
+}
+
+// Fail makes the requests fail with the given status. Zero issues tokens
+// again.
+func (server *TokenServer) Fail(status int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.status = status
+}
+
+// Requests returns the number of requests received so far.
+func (server *TokenServer) Requests() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.requests
+}
+
+// LastToken returns the last token issued, or an empty string if none was.
+func (server *TokenServer) LastToken() string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.lastToken
+}
+
+func (server *TokenServer) serveToken(w http.ResponseWriter, _ *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.requests++
+
+	w.Header().Set("Content-Type", "application/json")
+	if server.status != 0 {
+		w.WriteHeader(server.status)
+		_, _ = w.Write([]byte(`{"error":"access_denied","error_description":"failure injected by the token server"}`))
+		return
+	}
+	server.lastToken = fmt.Sprintf("token-%d", server.requests)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": server.lastToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(server.expiresIn.Seconds()),
+	})
+}
+
+// Factory returns the provider under test, getting its tokens from the
+// server. It may set environment variables with t.Setenv, the suite doesn't
+// run in parallel.
+type Factory func(t *testing.T, server *TokenServer) auth.Provider
+
+// expirySlack is the difference tolerated between the expiry of a token and
+// the one the server issued it with.
+const expirySlack = 10 * time.Second
+
+// RunConformance runs the conformance suite against the providers returned
+// by newProvider, with a new token server for each test.
+func RunConformance(t *testing.T, newProvider Factory) {
+	t.Helper()
+	run := func(name string, test func(t *testing.T, provider auth.Provider, server *TokenServer)) {
+		t.Run(name, func(t *testing.T) {
+			server := NewTokenServer()
+			t.Cleanup(server.Close)
+			test(t, newProvider(t, server), server)
+		})
+	}
+
+	run("has a stable name", func(t *testing.T, provider auth.Provider, _ *TokenServer) {
+		name := provider.Name()
+		if name == "" {
+			t.Fatal("the name is empty")
+		}
+		if provider.Name() != name {
+			t.Fatalf("the name changed from %q to %q", name, provider.Name())
+		}
+	})
+
+	run("returns the issued token with its expiry", func(t *testing.T, provider auth.Provider, server *TokenServer) {
+		token, err := provider.Token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get a token: %v", err)
+		}
+		if token.AccessToken != server.LastToken() {
+			t.Fatalf("got token %q, the server issued %q", token.AccessToken, server.LastToken())
+		}
+		if token.Type() != "Bearer" {
+			t.Fatalf("got token type %q, expected Bearer", token.Type())
+		}
+		// The CachedProvider refreshes the tokens ahead of their expiry.
+		if expected := time.Now().Add(time.Hour); token.Expiry.Before(expected.Add(-expirySlack)) || token.Expiry.After(expected.Add(expirySlack)) {
+			t.Fatalf("got expiry %s, expected about %s", token.Expiry, expected)
+		}
+	})
+
+	run("returns a new token once the previous one expired", func(t *testing.T, provider auth.Provider, server *TokenServer) {
+		server.SetExpiresIn(time.Second)
+		first, err := provider.Token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get a token: %v", err)
+		}
+		time.Sleep(time.Until(first.Expiry) + 100*time.Millisecond)
+
+		server.SetExpiresIn(time.Hour)
+		second, err := provider.Token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get a new token: %v", err)
+		}
+		if second.AccessToken == first.AccessToken || !second.Valid() {
+			t.Fatalf("got the expired token %q again", second.AccessToken)
+		}
+	})
+
+	for _, status := range []int{http.StatusUnauthorized, http.StatusServiceUnavailable} {
+		run(fmt.Sprintf("reports a %d failure of the token endpoint", status), func(t *testing.T, provider auth.Provider, server *TokenServer) {
+			server.Fail(status)
+			token, err := provider.Token(context.Background())
+			if err == nil {
+				t.Fatalf("got token %v, expected an error", token)
+			}
+			if token != nil {
+				t.Fatalf("got token %v along with error %v", token, err)
+			}
+			// Callers tell rejected credentials from outages with the
+			// status of the response.
+			var retrieveErr *oauth2.RetrieveError
+			if !errors.As(err, &retrieveErr) || retrieveErr.Response.StatusCode != status {
+				t.Fatalf("got error %v, expected an oauth2.RetrieveError with status %d", err, status)
+			}
+		})
+	}
+
+	run("recovers once the token endpoint is back", func(t *testing.T, provider auth.Provider, server *TokenServer) {
+		server.Fail(http.StatusServiceUnavailable)
+		if _, err := provider.Token(context.Background()); err == nil {
+			t.Fatal("got a token, expected an error")
+		}
+		server.Fail(0)
+		token, err := provider.Token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get a token after the failure: %v", err)
+		}
+		if token.AccessToken != server.LastToken() {
+			t.Fatalf("got token %q, the server issued %q", token.AccessToken, server.LastToken())
+		}
+	})
+
+	run("honors the cancellation of the context", func(t *testing.T, provider auth.Provider, _ *TokenServer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := provider.Token(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got error %v, expected %v", err, context.Canceled)
+		}
+	})
+
+	run("is cached by the CachedProvider", func(t *testing.T, provider auth.Provider, server *TokenServer) {
+		cached := auth.NewCachedProvider(provider, nil)
+		for i := 0; i < 2; i++ {
+			if _, err := cached.Token(context.Background()); err != nil {
+				t.Fatalf("failed to get a token: %v", err)
+			}
+		}
+		if server.Requests() != 1 {
+			t.Fatalf("the server got %d requests, expected 1", server.Requests())
+		}
+		cached.Invalidate()
+		token, err := cached.Token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get a token after invalidating the cached one: %v", err)
+		}
+		if token.AccessToken != server.LastToken() || server.Requests() != 2 {
+			t.Fatalf("got token %q after %d requests, expected a new one", token.AccessToken, server.Requests())
+		}
+	})
+}
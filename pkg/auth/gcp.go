@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+var defaultGCPScopes = []string{
+	"https://www.googleapis.com/auth/cloud-platform",
+	"https://www.googleapis.com/auth/userinfo.email",
+}
+
+// GCPProvider gets tokens using the Google Cloud Workload Identity.
+// This is exactly what argocd-k8s-auth uses.
+type GCPProvider struct{}
+
+func NewGCPProvider() *GCPProvider {
+	return &GCPProvider{}
+}
+
+func (provider *GCPProvider) Name() string {
+	return "gcp"
+}
+
+func (provider *GCPProvider) Token(ctx context.Context) (*oauth2.Token, error) {
+	// The token source of the default credentials doesn't take a context.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cred, err := google.FindDefaultCredentials(ctx, defaultGCPScopes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default credentials: %w", err)
+	}
+	t, err := cred.TokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+	return t, nil
+}
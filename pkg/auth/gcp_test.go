@@ -0,0 +1,45 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+	"github.com/konflux-ci/namespace-generator/pkg/auth/authtest"
+)
+
+func TestGCPProviderConformance(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	authtest.RunConformance(t, func(t *testing.T, server *authtest.TokenServer) auth.Provider {
+		// The default credentials are read from the key of a service
+		// account exchanged at the token server.
+		credentials, err := json.Marshal(map[string]string{
+			"type":           "service_account",
+			"project_id":     "namespace-generator",
+			"private_key_id": "conformance",
+			"private_key":    string(privateKey),
+			"client_email":   "namespace-generator@namespace-generator.iam.gserviceaccount.com",
+			"token_uri":      server.URL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		if err := os.WriteFile(path, credentials, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+		return auth.NewGCPProvider()
+	})
+}
@@ -0,0 +1,331 @@
+// Package client calls the namespace-generator API. It authenticates the
+// requests, identifies the ApplicationSet they are made for and retries the
+// requests rejected because the server is busy, so tools and tests don't need
+// to hand-roll the requests.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+)
+
+// Headers identifying the ApplicationSet the requests are made for.
+const (
+	headerApplicationSetName      = "X-ApplicationSet-Name"
+	headerApplicationSetNamespace = "X-ApplicationSet-Namespace"
+)
+
+// TokenSource returns the token authenticating a request. It's called before
+// every attempt, so rotated tokens are picked up.
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken returns a source of a fixed token, such as the plugin key.
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+// FileToken returns a source reading the token from a file on every request,
+// such as a projected ServiceAccount token which the kubelet rotates.
+func FileToken(path string) TokenSource {
+	return func(context.Context) (string, error) {
+		token, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the token: %w", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+}
+
+// RetryConfig configures retrying the requests which failed with a transient
+// error. Attempts includes the first request, so 1 disables retries.
+type RetryConfig struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetry retries a request twice.
+var DefaultRetry = RetryConfig{Attempts: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// delay returns the jittered delay before the given retry, starting at 1.
+func (config RetryConfig) delay(retry int) time.Duration {
+	delay := config.InitialBackoff
+	for i := 1; i < retry && (config.MaxBackoff <= 0 || delay < config.MaxBackoff); i++ {
+		delay *= 2
+	}
+	delay = wait.Jitter(delay, 0.5)
+	if config.MaxBackoff > 0 {
+		delay = min(delay, config.MaxBackoff)
+	}
+	return delay
+}
+
+// Options configures a Client.
+type Options struct {
+	// HTTPClient sends the requests. It defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Token authenticates the requests. They aren't authenticated without
+	// one.
+	Token TokenSource
+	// ApplicationSetName and ApplicationSetNamespace identify the
+	// ApplicationSet the requests are made for, to the server and its
+	// quotas.
+	ApplicationSetName      string
+	ApplicationSetNamespace string
+	// Retry defaults to DefaultRetry.
+	Retry *RetryConfig
+}
+
+// Client calls the API of a namespace-generator server.
+type Client struct {
+	baseURL *url.URL
+	options Options
+}
+
+// New returns a client of the server at baseURL, which is the base URL of the
+// plugin ConfigMap, e.g. http://namespace-generator.argocd.svc.cluster.local.
+func New(baseURL string, options Options) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: the scheme must be http or https", baseURL)
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = http.DefaultClient
+	}
+	if options.Retry == nil {
+		options.Retry = &DefaultRetry
+	}
+	return &Client{baseURL: parsed, options: options}, nil
+}
+
+// Error is returned for the requests the server failed.
+type Error struct {
+	StatusCode int
+	// Response is the error returned by the server. Its message is the
+	// status text when the body isn't an error response.
+	Response v1alpha1.ErrorResponse
+	// RetryAfter is the delay the server asked for before retrying, zero
+	// when it didn't.
+	RetryAfter time.Duration
+}
+
+func (err *Error) Error() string {
+	if err.Response.Code != "" {
+		return fmt.Sprintf("namespace-generator returned %d (%s): %s", err.StatusCode, err.Response.Code, err.Response.Message)
+	}
+	return fmt.Sprintf("namespace-generator returned %d: %s", err.StatusCode, err.Response.Message)
+}
+
+// ErrorCode returns the code of the error returned by the server, such as
+// SecretNotFound, or an empty string if err isn't one.
+func ErrorCode(err error) string {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Response.Code
+	}
+	return ""
+}
+
+// Generate returns the namespaces matching a request, with the v1alpha2
+// fields.
+func (cl *Client) Generate(ctx context.Context, req *v1alpha2.GenerateRequest) (*v1alpha2.GenerateResponse, error) {
+	response := &v1alpha2.GenerateResponse{}
+	if err := cl.do(ctx, http.MethodPost, "/api/v1/getparams.execute", v1alpha2.MediaType, req, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Explain returns which filters included or excluded the namespaces matching
+// the label selector of a request.
+func (cl *Client) Explain(ctx context.Context, req *v1alpha2.GenerateRequest) (*v1alpha1.ExplainResponse, error) {
+	response := &v1alpha1.ExplainResponse{}
+	if err := cl.do(ctx, http.MethodPost, "/api/v1/explain", v1alpha2.MediaType, req, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Batch runs v1alpha1 requests in a single call. A failed request is reported
+// in its result and doesn't fail the batch.
+func (cl *Client) Batch(ctx context.Context, reqs []*v1alpha1.GenerateRequest) (*v1alpha1.BatchGenerateResponse, error) {
+	response := &v1alpha1.BatchGenerateResponse{}
+	if err := cl.do(ctx, http.MethodPost, "/api/v1/getparams.batch", "", reqs, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// GenerateBatch runs v1alpha2 requests in a single call and merges their
+// parameters. The failed requests are reported in the errors of the
+// response and don't fail the batch.
+func (cl *Client) GenerateBatch(ctx context.Context, reqs []*v1alpha2.GenerateRequest) (*v1alpha2.BatchGenerateResponse, error) {
+	response := &v1alpha2.BatchGenerateResponse{}
+	if err := cl.do(ctx, http.MethodPost, "/api/v1/getparams.batch", v1alpha2.MediaType, reqs, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Clusters lists the cluster secrets visible to the server.
+func (cl *Client) Clusters(ctx context.Context) (*v1alpha1.ClustersResponse, error) {
+	response := &v1alpha1.ClustersResponse{}
+	if err := cl.do(ctx, http.MethodGet, "/api/v1/clusters", "", nil, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// CheckCluster checks the server can list the namespaces of a cluster. A
+// cluster failing the check is reported in the response, not as an error.
+func (cl *Client) CheckCluster(ctx context.Context, name string) (*v1alpha1.ClusterCheckResponse, error) {
+	response := &v1alpha1.ClusterCheckResponse{}
+	if err := cl.do(ctx, http.MethodGet, "/api/v1/clusters/"+url.PathEscape(name)+"/check", "", nil, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// do sends a request, retrying it while it fails with a transient error, and
+// decodes the response into out.
+func (cl *Client) do(ctx context.Context, method, path, accept string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode the request: %w", err)
+		}
+	}
+
+	retry := cl.options.Retry
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := cl.send(ctx, method, path, accept, body, out)
+		if err == nil || attempt >= retry.Attempts || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := retry.delay(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends a single request and returns the delay the server asked for
+// before retrying it.
+func (cl *Client) send(ctx context.Context, method, path, accept string, body []byte, out any) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cl.baseURL.String()+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if cl.options.Token != nil {
+		token, err := cl.options.Token(ctx)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if cl.options.ApplicationSetName != "" {
+		req.Header.Set(headerApplicationSetName, cl.options.ApplicationSetName)
+	}
+	if cl.options.ApplicationSetNamespace != "" {
+		req.Header.Set(headerApplicationSetNamespace, cl.options.ApplicationSetNamespace)
+	}
+
+	resp, err := cl.options.HTTPClient.Do(req)
+	if err != nil {
+		return 0, &transientError{err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, &transientError{err: err}
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp.Header)}
+		if err := json.Unmarshal(data, &apiErr.Response); err != nil || apiErr.Response.Message == "" {
+			apiErr.Response.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr.RetryAfter, apiErr
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return 0, fmt.Errorf("failed to decode the response: %w", err)
+	}
+	return 0, nil
+}
+
+// transientError is a failure to reach the server.
+type transientError struct {
+	err error
+}
+
+func (err *transientError) Error() string {
+	return err.err.Error()
+}
+
+func (err *transientError) Unwrap() error {
+	return err.err
+}
+
+// isTransient reports whether the request may succeed when retried: the
+// server couldn't be reached, or it was too busy or throttled the client.
+func isTransient(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr *transientError
+	return errors.As(err, &netErr)
+}
+
+// retryAfter returns the delay of the Retry-After header, which the server
+// sets in seconds.
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
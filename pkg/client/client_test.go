@@ -0,0 +1,118 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/client"
+)
+
+func TestClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Client Suite")
+}
+
+var _ = Describe("Client", func() {
+	var (
+		server  *httptest.Server
+		handler http.HandlerFunc
+		calls   atomic.Int32
+		cl      *client.Client
+	)
+
+	BeforeEach(func() {
+		calls.Store(0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			handler(w, r)
+		}))
+		var err error
+		cl, err = client.New(server.URL, client.Options{
+			Token:                   client.StaticToken("secret"),
+			ApplicationSetName:      "appset",
+			ApplicationSetNamespace: "argocd",
+			Retry:                   &client.RetryConfig{Attempts: 3, InitialBackoff: time.Millisecond},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should send an authenticated v1alpha2 request", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/api/v1/getparams.execute"))
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer secret"))
+			Expect(r.Header.Get("Accept")).To(Equal(v1alpha2.MediaType))
+			Expect(r.Header.Get("X-ApplicationSet-Name")).To(Equal("appset"))
+			Expect(r.Header.Get("X-ApplicationSet-Namespace")).To(Equal("argocd"))
+
+			req := &v1alpha2.GenerateRequest{}
+			Expect(json.NewDecoder(r.Body).Decode(req)).To(Succeed())
+			Expect(req.Input.Parameters.LabelSelector.MatchLabels).To(Equal(map[string]string{"konflux.ci/type": "user"}))
+			Expect(req.Input.Parameters.ExcludeNamespaces).To(Equal([]string{"ns2"}))
+			_ = json.NewEncoder(w).Encode(v1alpha2.GenerateResponse{Output: v1alpha2.Output{
+				Parameters: []v1alpha2.OutParameters{{Namespace: "ns1"}},
+			}})
+		}
+
+		response, err := cl.Generate(context.Background(), client.NewRequest().
+			MatchLabels(map[string]string{"konflux.ci/type": "user"}).
+			Exclude("ns2").
+			Build())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Output.Parameters).To(Equal([]v1alpha2.OutParameters{{Namespace: "ns1"}}))
+	})
+
+	It("should retry the requests the server is too busy for", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if calls.Load() < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(v1alpha1.ClustersResponse{Clusters: []v1alpha1.ClusterInfo{{SecretName: "remote1"}}})
+		}
+
+		response, err := cl.Clusters(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Clusters).To(HaveLen(1))
+		Expect(calls.Load()).To(BeEquivalentTo(3))
+	})
+
+	It("should return the errors of the server without retrying them", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(v1alpha1.ErrorResponse{Message: "cluster secret remote1 not found", Code: "SecretNotFound"})
+		}
+
+		_, err := cl.Generate(context.Background(), client.NewRequest().AllowAll().Cluster("remote1").Build())
+		Expect(err).To(HaveOccurred())
+		Expect(client.ErrorCode(err)).To(Equal("SecretNotFound"))
+		Expect(calls.Load()).To(BeEquivalentTo(1))
+	})
+})
+
+var _ = Describe("RequestBuilder", func() {
+	It("should reject v1alpha2 fields in v1alpha1 requests", func() {
+		_, err := client.NewRequest().AllowAll().LabelKeys("team").BuildV1alpha1()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should round the timeout up to a second", func() {
+		req := client.NewRequest().Timeout(1500 * time.Millisecond).Build()
+		Expect(req.Input.Parameters.TimeoutSeconds).To(Equal(2))
+	})
+})
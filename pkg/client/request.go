@@ -0,0 +1,149 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+)
+
+// RequestBuilder builds generate requests:
+//
+//	req := client.NewRequest().
+//		MatchLabels(map[string]string{"konflux.ci/type": "user"}).
+//		Cluster("remote1").
+//		Build()
+type RequestBuilder struct {
+	req v1alpha2.GenerateRequest
+}
+
+func NewRequest() *RequestBuilder {
+	return &RequestBuilder{}
+}
+
+// ApplicationSet sets the name of the ApplicationSet in the body of the
+// request, as ArgoCD does.
+func (builder *RequestBuilder) ApplicationSet(name string) *RequestBuilder {
+	builder.req.ApplicationSetName = name
+	return builder
+}
+
+// Selector replaces the label selector.
+func (builder *RequestBuilder) Selector(selector metav1.LabelSelector) *RequestBuilder {
+	builder.req.Input.Parameters.LabelSelector = selector
+	return builder
+}
+
+// MatchLabels adds labels the namespaces must have.
+func (builder *RequestBuilder) MatchLabels(labels map[string]string) *RequestBuilder {
+	selector := &builder.req.Input.Parameters.LabelSelector
+	if selector.MatchLabels == nil {
+		selector.MatchLabels = map[string]string{}
+	}
+	for key, value := range labels {
+		selector.MatchLabels[key] = value
+	}
+	return builder
+}
+
+// MatchExpression adds a requirement on a label of the namespaces.
+func (builder *RequestBuilder) MatchExpression(key string, operator metav1.LabelSelectorOperator, values ...string) *RequestBuilder {
+	selector := &builder.req.Input.Parameters.LabelSelector
+	selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      key,
+		Operator: operator,
+		Values:   values,
+	})
+	return builder
+}
+
+// Cluster lists the namespaces of the cluster of an ArgoCD cluster secret
+// instead of the local cluster.
+func (builder *RequestBuilder) Cluster(name string) *RequestBuilder {
+	builder.req.Input.Parameters.ClusterName = name
+	return builder
+}
+
+// Timeout bounds the time the server spends on the request, rounded up to a
+// second.
+func (builder *RequestBuilder) Timeout(timeout time.Duration) *RequestBuilder {
+	builder.req.Input.Parameters.TimeoutSeconds = int((timeout + time.Second - 1) / time.Second)
+	return builder
+}
+
+// Debug adds diagnostics to the response.
+func (builder *RequestBuilder) Debug() *RequestBuilder {
+	builder.req.Input.Parameters.Debug = true
+	return builder
+}
+
+// AllowAll confirms an empty label selector is meant to match all the
+// namespaces.
+func (builder *RequestBuilder) AllowAll() *RequestBuilder {
+	builder.req.Input.Parameters.AllowAll = true
+	return builder
+}
+
+// Exclude leaves namespaces out of the result. It requires v1alpha2.
+func (builder *RequestBuilder) Exclude(namespaces ...string) *RequestBuilder {
+	builder.req.Input.Parameters.ExcludeNamespaces = append(builder.req.Input.Parameters.ExcludeNamespaces, namespaces...)
+	return builder
+}
+
+// LabelKeys copies the values of namespace labels to the output. It requires
+// v1alpha2.
+func (builder *RequestBuilder) LabelKeys(keys ...string) *RequestBuilder {
+	builder.req.Input.Parameters.LabelKeys = append(builder.req.Input.Parameters.LabelKeys, keys...)
+	return builder
+}
+
+// AnnotationKeys copies the values of namespace annotations to the output.
+// It requires v1alpha2.
+func (builder *RequestBuilder) AnnotationKeys(keys ...string) *RequestBuilder {
+	builder.req.Input.Parameters.AnnotationKeys = append(builder.req.Input.Parameters.AnnotationKeys, keys...)
+	return builder
+}
+
+// OpenShiftProject adds the metadata of the OpenShift projects to the
+// output. It requires v1alpha2.
+func (builder *RequestBuilder) OpenShiftProject() *RequestBuilder {
+	builder.req.Input.Parameters.OpenShiftProject = true
+	return builder
+}
+
+// Build returns the request. The builder can be reused to build variants of
+// the request, which don't share its slices and maps.
+func (builder *RequestBuilder) Build() *v1alpha2.GenerateRequest {
+	req := builder.req
+	parameters := &req.Input.Parameters
+	parameters.LabelSelector = *parameters.LabelSelector.DeepCopy()
+	parameters.ExcludeNamespaces = append([]string(nil), parameters.ExcludeNamespaces...)
+	parameters.LabelKeys = append([]string(nil), parameters.LabelKeys...)
+	parameters.AnnotationKeys = append([]string(nil), parameters.AnnotationKeys...)
+	return &req
+}
+
+// BuildV1alpha1 returns the request for the endpoints only taking v1alpha1
+// requests, such as batches. It fails if fields added by v1alpha2 are set, as
+// the server would ignore them.
+func (builder *RequestBuilder) BuildV1alpha1() (*v1alpha1.GenerateRequest, error) {
+	parameters := builder.req.Input.Parameters
+	if len(parameters.ExcludeNamespaces) > 0 || len(parameters.LabelKeys) > 0 || len(parameters.AnnotationKeys) > 0 || parameters.OpenShiftProject {
+		return nil, errors.New("excluded namespaces, label keys, annotation keys and OpenShift projects require v1alpha2")
+	}
+	return &v1alpha1.GenerateRequest{
+		ApplicationSetName: builder.req.ApplicationSetName,
+		Input: v1alpha1.Input{
+			Parameters: v1alpha1.InParameters{
+				LabelSelector:  *parameters.LabelSelector.DeepCopy(),
+				ClusterName:    parameters.ClusterName,
+				TimeoutSeconds: parameters.TimeoutSeconds,
+				Debug:          parameters.Debug,
+				AllowAll:       parameters.AllowAll,
+			},
+		},
+	}, nil
+}
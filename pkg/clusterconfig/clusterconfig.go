@@ -0,0 +1,214 @@
+// Package clusterconfig parses the ArgoCD cluster secrets and builds the
+// rest.Config of the clusters they describe. It's shared with the other
+// components which need to reach the clusters registered in ArgoCD the same
+// way the generator does.
+package clusterconfig
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Keys of the data of an ArgoCD cluster secret.
+const (
+	ServerKey = "server"
+	ConfigKey = "config"
+	NameKey   = "name"
+)
+
+// DefaultCAKey is the key of the CA bundle in the referenced ConfigMaps when
+// none is set, the key of the bundles published by Kubernetes and
+// cert-manager's trust-manager.
+const DefaultCAKey = "ca.crt"
+
+// Config is the config key of an ArgoCD cluster secret.
+type Config struct {
+	ExecProviderConfig *ExecProviderConfig `json:"execProviderConfig,omitempty"`
+	TLSClientConfig    TLSClientConfig     `json:"tlsClientConfig"`
+}
+
+// ExecProviderConfig is the command ArgoCD runs to get the credentials of the
+// cluster. The generator gets them from its auth provider instead.
+type ExecProviderConfig struct {
+	APIVersion string   `json:"apiVersion"`
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+}
+
+// TLSClientConfig is the TLS configuration of the connections to the
+// cluster.
+type TLSClientConfig struct {
+	// Insecure is parsed but not honored: the certificate of the cluster is
+	// always verified.
+	Insecure bool `json:"insecure"`
+	// CAData is the base64 encoded PEM bundle of the certificate
+	// authorities of the cluster. The system roots are used when it's
+	// empty.
+	CAData string `json:"caData"`
+	// CAConfigMapRef references a ConfigMap holding the PEM bundle instead
+	// of CAData, so the bundle of many clusters is rotated at once.
+	CAConfigMapRef *ConfigMapKeyRef `json:"caConfigMapRef,omitempty"`
+}
+
+// ConfigMapKeyRef references a key of a ConfigMap.
+type ConfigMapKeyRef struct {
+	// Namespace defaults to the namespace of the cluster secret, and must be
+	// that namespace.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Key defaults to DefaultCAKey.
+	Key string `json:"key,omitempty"`
+}
+
+// Cluster is a cluster described by an ArgoCD cluster secret.
+type Cluster struct {
+	// Name is the name of the cluster in ArgoCD. It defaults to the name of
+	// the secret.
+	Name string
+	// Server is the URL of the API server of the cluster.
+	Server string
+	Config Config
+	// CAData is the decoded CAData of the TLS configuration, or the bundle
+	// loaded from its ConfigMap by LoadCA.
+	CAData []byte
+}
+
+// Parse parses and validates an ArgoCD cluster secret.
+func Parse(secret *corev1.Secret) (*Cluster, error) {
+	server, ok := secret.Data[ServerKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s missing '%s' key", secret.Name, ServerKey)
+	}
+	if err := validateServer(string(server)); err != nil {
+		return nil, fmt.Errorf("invalid server of secret %s: %w", secret.Name, err)
+	}
+
+	configData, ok := secret.Data[ConfigKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s missing '%s' key", secret.Name, ConfigKey)
+	}
+	cluster := &Cluster{Name: string(secret.Data[NameKey]), Server: string(server)}
+	if cluster.Name == "" {
+		cluster.Name = secret.Name
+	}
+	if err := json.Unmarshal(configData, &cluster.Config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the config of secret %s: %w", secret.Name, err)
+	}
+
+	if ref := cluster.Config.TLSClientConfig.CAConfigMapRef; ref != nil {
+		if err := validateCAConfigMapRef(ref, secret.Namespace); err != nil {
+			return nil, fmt.Errorf("invalid CA ConfigMap of secret %s: %w", secret.Name, err)
+		}
+		if cluster.Config.TLSClientConfig.CAData != "" {
+			return nil, fmt.Errorf("secret %s sets both the CA data and a CA ConfigMap", secret.Name)
+		}
+		return cluster, nil
+	}
+
+	// Decode the inner CA data from base64.
+	var err error
+	if cluster.CAData, err = base64.StdEncoding.DecodeString(cluster.Config.TLSClientConfig.CAData); err != nil {
+		return nil, fmt.Errorf("failed to decode the CA data of secret %s: %w", secret.Name, err)
+	}
+	return cluster, nil
+}
+
+// validateCAConfigMapRef validates a reference, setting its defaults. The
+// ConfigMap must be in the namespace of the secret, as the generator can
+// only read the ConfigMaps of the ArgoCD namespace.
+func validateCAConfigMapRef(ref *ConfigMapKeyRef, namespace string) error {
+	if ref.Name == "" {
+		return errors.New("the name is empty")
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = namespace
+	}
+	if ref.Namespace != namespace {
+		return fmt.Errorf("ConfigMap %s/%s isn't in namespace %s", ref.Namespace, ref.Name, namespace)
+	}
+	if ref.Key == "" {
+		ref.Key = DefaultCAKey
+	}
+	return nil
+}
+
+// LoadCA reads the CA bundle from the ConfigMap referenced by the cluster,
+// if any, and returns the resource version of the ConfigMap, so the clients
+// built from it can be rebuilt once it changes. It's a no-op for the clusters
+// embedding their CA data.
+func (cluster *Cluster) LoadCA(ctx context.Context, reader client.Reader) (string, error) {
+	ref := cluster.Config.TLSClientConfig.CAConfigMapRef
+	if ref == nil {
+		return "", nil
+	}
+	if reader == nil {
+		return "", fmt.Errorf("the CA bundle of cluster %s is in ConfigMap %s/%s, which requires a client of the local cluster", cluster.Name, ref.Namespace, ref.Name)
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, configMap); err != nil {
+		return "", fmt.Errorf("failed to get the CA ConfigMap %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	bundle, ok := configMap.Data[ref.Key]
+	if !ok || bundle == "" {
+		return "", fmt.Errorf("CA ConfigMap %s/%s has no %s key", ref.Namespace, ref.Name, ref.Key)
+	}
+	cluster.CAData = []byte(bundle)
+	return configMap.ResourceVersion, nil
+}
+
+func validateServer(server string) error {
+	if server == "" {
+		return fmt.Errorf("the server is empty")
+	}
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	if serverURL.Scheme != "https" && serverURL.Scheme != "http" {
+		return fmt.Errorf("unsupported scheme %q, expected https or http", serverURL.Scheme)
+	}
+	if serverURL.Host == "" {
+		return fmt.Errorf("the server %q has no host", server)
+	}
+	return nil
+}
+
+// RESTConfig returns the rest config for accessing the cluster.
+// Authentication is left to the caller.
+func (cluster *Cluster) RESTConfig() *rest.Config {
+	return &rest.Config{
+		Host: cluster.Server,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: cluster.CAData,
+		},
+	}
+}
+
+// RESTConfig builds the rest config for accessing the cluster described by
+// the given ArgoCD cluster secret. Authentication is left to the caller. The
+// secrets referencing a CA ConfigMap require LoadRESTConfig.
+func RESTConfig(secret *corev1.Secret) (*rest.Config, error) {
+	return LoadRESTConfig(context.Background(), nil, secret)
+}
+
+// LoadRESTConfig is RESTConfig, reading the CA ConfigMap referenced by the
+// secret with the reader of the local cluster.
+func LoadRESTConfig(ctx context.Context, reader client.Reader, secret *corev1.Secret) (*rest.Config, error) {
+	cluster, err := Parse(secret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cluster.LoadCA(ctx, reader); err != nil {
+		return nil, err
+	}
+	return cluster.RESTConfig(), nil
+}
@@ -0,0 +1,152 @@
+package clusterconfig_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/clusterconfig"
+)
+
+func TestClusterConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cluster Config Suite")
+}
+
+func clusterSecret(data map[string]string) *core.Secret {
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "remote1-secret", Namespace: "argocd"},
+		Data:       map[string][]byte{},
+	}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+var _ = Describe("Parse", func() {
+	It("should parse a cluster secret", func() {
+		cluster, err := clusterconfig.Parse(clusterSecret(map[string]string{
+			"name":   "remote1",
+			"server": "https://remote1:6443",
+			"config": `{
+				"execProviderConfig": {"apiVersion": "client.authentication.k8s.io/v1beta1", "command": "argocd-k8s-auth", "args": ["gcp"]},
+				"tlsClientConfig": {"insecure": false, "caData": "Y2E="}
+			}`,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.Name).To(Equal("remote1"))
+		Expect(cluster.Server).To(Equal("https://remote1:6443"))
+		Expect(cluster.CAData).To(Equal([]byte("ca")))
+		Expect(cluster.Config.ExecProviderConfig).To(Equal(&clusterconfig.ExecProviderConfig{
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+			Command:    "argocd-k8s-auth",
+			Args:       []string{"gcp"},
+		}))
+	})
+
+	It("should default the name of the cluster to the name of the secret", func() {
+		cluster, err := clusterconfig.Parse(clusterSecret(map[string]string{"server": "https://remote1:6443", "config": "{}"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.Name).To(Equal("remote1-secret"))
+	})
+
+	It("should use the system roots without CA data", func() {
+		cluster, err := clusterconfig.Parse(clusterSecret(map[string]string{"server": "https://remote1:6443", "config": `{"tlsClientConfig": {}}`}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.CAData).To(BeEmpty())
+		Expect(cluster.Config.ExecProviderConfig).To(BeNil())
+	})
+
+	DescribeTable("should reject invalid secrets",
+		func(data map[string]string, message string) {
+			_, err := clusterconfig.Parse(clusterSecret(data))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+			Expect(err).To(MatchError(ContainSubstring("remote1-secret")))
+		},
+		Entry("without a server", map[string]string{"config": "{}"}, "missing 'server' key"),
+		Entry("with an empty server", map[string]string{"server": "", "config": "{}"}, "the server is empty"),
+		Entry("with a server which isn't a URL", map[string]string{"server": "remote1:6443", "config": "{}"}, "unsupported scheme"),
+		Entry("with a server without a host", map[string]string{"server": "https://", "config": "{}"}, "has no host"),
+		Entry("without a config", map[string]string{"server": "https://remote1:6443"}, "missing 'config' key"),
+		Entry("with a config which isn't JSON", map[string]string{"server": "https://remote1:6443", "config": "tlsClientConfig"}, "failed to unmarshal the config"),
+		Entry("with CA data which isn't base64", map[string]string{"server": "https://remote1:6443", "config": `{"tlsClientConfig": {"caData": "not base64"}}`}, "failed to decode the CA data"),
+	)
+})
+
+var _ = Describe("RESTConfig", func() {
+	It("should build the config of the cluster", func() {
+		cfg, err := clusterconfig.RESTConfig(clusterSecret(map[string]string{
+			"server": "https://remote1:6443",
+			"config": `{"tlsClientConfig": {"caData": "Y2E="}}`,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Host).To(Equal("https://remote1:6443"))
+		Expect(cfg.TLSClientConfig.CAData).To(Equal([]byte("ca")))
+	})
+
+	It("should leave authentication to the caller", func() {
+		cfg, err := clusterconfig.RESTConfig(clusterSecret(map[string]string{
+			"server": "https://remote1:6443",
+			"config": `{"execProviderConfig": {"command": "argocd-k8s-auth", "args": ["gcp"]}}`,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.ExecProvider).To(BeNil())
+		Expect(cfg.BearerToken).To(BeEmpty())
+	})
+
+	It("should always verify the certificate of the cluster", func() {
+		cfg, err := clusterconfig.RESTConfig(clusterSecret(map[string]string{
+			"server": "https://remote1:6443",
+			"config": `{"tlsClientConfig": {"insecure": true}}`,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.TLSClientConfig.Insecure).To(BeFalse())
+	})
+
+	It("should fail for an invalid secret", func() {
+		_, err := clusterconfig.RESTConfig(clusterSecret(map[string]string{"config": "{}"}))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("LoadRESTConfig", func() {
+	secret := func(ref string) *core.Secret {
+		return clusterSecret(map[string]string{
+			"server": "https://remote1:6443",
+			"config": `{"tlsClientConfig": {"caConfigMapRef": ` + ref + `}}`,
+		})
+	}
+
+	It("should read the CA bundle from the referenced ConfigMap", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(core.AddToScheme(scheme)).To(Succeed())
+		reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&core.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "argocd", Name: "cluster-cas"},
+			Data:       map[string]string{"ca.crt": "ca"},
+		}).Build()
+		cfg, err := clusterconfig.LoadRESTConfig(ctx, reader, secret(`{"name": "cluster-cas"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.TLSClientConfig.CAData).To(Equal([]byte("ca")))
+
+		_, err = clusterconfig.LoadRESTConfig(ctx, reader, secret(`{"name": "cluster-cas", "key": "missing"}`))
+		Expect(err).To(MatchError(ContainSubstring("has no missing key")))
+	})
+
+	It("should reject the ConfigMaps of other namespaces", func() {
+		_, err := clusterconfig.Parse(secret(`{"namespace": "kube-system", "name": "kube-root-ca.crt"}`))
+		Expect(err).To(MatchError(ContainSubstring("isn't in namespace argocd")))
+	})
+
+	It("should require a reader", func() {
+		_, err := clusterconfig.RESTConfig(secret(`{"name": "cluster-cas"}`))
+		Expect(err).To(MatchError(ContainSubstring("requires a client of the local cluster")))
+	})
+})
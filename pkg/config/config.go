@@ -0,0 +1,1235 @@
+// Package config holds the settings of the generator. Settings are read from
+// an optional YAML file, then overridden by environment variables and flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/features"
+)
+
+// envPrefix is the prefix of the environment variables overriding settings.
+// The flag of a setting is derived from its variable, e.g. NS_GEN_LIST_TIMEOUT
+// is overridden by --list-timeout.
+const envPrefix = "NS_GEN_"
+
+type Config struct {
+	// ArgoCDNamespace is the namespace of the ArgoCD cluster secrets.
+	ArgoCDNamespace string `json:"argocdNamespace"`
+	// ClusterSecretNames restricts the cluster secrets to these names, which
+	// are read with GETs only. Secrets are never listed or watched, so the
+	// generator only needs the permission to get these secrets.
+	ClusterSecretNames []string `json:"clusterSecretNames"`
+	// ArgoCDInstances are the ArgoCD instances whose cluster secrets requests
+	// can select, besides the ones of ArgoCDNamespace. They can only be set
+	// in the configuration file.
+	ArgoCDInstances []ArgoCDInstanceConfig `json:"argocdInstances"`
+	// LogLevel is the minimum level of the logged lines: debug, info, warn
+	// or error.
+	LogLevel      slog.Level          `json:"logLevel"`
+	Server        ServerConfig        `json:"server"`
+	Auth          AuthConfig          `json:"auth"`
+	Tenants       TenantsConfig       `json:"tenants"`
+	Impersonation ImpersonationConfig `json:"impersonation"`
+	Timeouts      TimeoutsConfig      `json:"timeouts"`
+	Retry         RetryConfig         `json:"retry"`
+	Cache         CacheConfig         `json:"cache"`
+	RemoteClients RemoteClientsConfig `json:"remoteClients"`
+	Limits        LimitsConfig        `json:"limits"`
+	Routes        RoutesConfig        `json:"routes"`
+	Filters       FiltersConfig       `json:"filters"`
+	Audit         AuditConfig         `json:"audit"`
+	Recording     RecordingConfig     `json:"recording"`
+	Reports       ReportsConfig       `json:"generationReports"`
+	Refresh       RefreshConfig       `json:"applicationSetRefresh"`
+	Leader        LeaderConfig        `json:"leaderElection"`
+	Claims        ClaimsConfig        `json:"namespaceClaims"`
+	Expiry        ExpiryConfig        `json:"namespaceExpiry"`
+	// FeatureGates enables or disables the features by name, e.g.
+	// ClusterSecretEvents: false.
+	FeatureGates map[string]bool `json:"featureGates"`
+	// FaultInjection are the faults injected in the requests for some
+	// clusters, keyed by cluster name, the local cluster being in-cluster.
+	// They're only injected when the FaultInjection feature gate is enabled.
+	FaultInjection map[string]FaultConfig `json:"faultInjection"`
+	// VisibilityPolicies enforces the NamespaceVisibilityPolicy resources.
+	VisibilityPolicies bool `json:"visibilityPolicies"`
+	// GeneratorConfigName is the name of the GeneratorConfig resource
+	// combined with this configuration. Empty disables the resource.
+	GeneratorConfigName string `json:"generatorConfigName"`
+	// ReloadInterval is how often the file is checked for changes. Zero
+	// disables the checks, the file is still reloaded on SIGHUP.
+	ReloadInterval metav1.Duration `json:"reloadInterval"`
+
+	// Path is the path of the file the configuration was read from.
+	Path string `json:"-"`
+}
+
+type ServerConfig struct {
+	Address string `json:"address"`
+	// UseHTTP serves plain HTTP instead of TLS, and EnableH2C adds cleartext
+	// HTTP/2 on top of it.
+	UseHTTP      bool            `json:"useHTTP"`
+	EnableH2C    bool            `json:"enableH2C"`
+	TLSCertFile  string          `json:"tlsCertFile"`
+	TLSKeyFile   string          `json:"tlsKeyFile"`
+	UnixSocket   string          `json:"unixSocket"`
+	DisableTCP   bool            `json:"disableTCP"`
+	ReadTimeout  metav1.Duration `json:"readTimeout"`
+	WriteTimeout metav1.Duration `json:"writeTimeout"`
+	IdleTimeout  metav1.Duration `json:"idleTimeout"`
+	// ReadHeaderTimeout bounds reading the headers of a request, so slow
+	// clients can't hold connections open, and MaxHeaderBytes their size.
+	ReadHeaderTimeout metav1.Duration `json:"readHeaderTimeout"`
+	MaxHeaderBytes    int             `json:"maxHeaderBytes"`
+	// MaxBodyBytes bounds the size of the request bodies.
+	MaxBodyBytes int `json:"maxBodyBytes"`
+	// DisableKeepAlives closes the connections after each response.
+	DisableKeepAlives bool `json:"disableKeepAlives"`
+	// AllowUnknownFields accepts the requests with unknown fields, e.g. the
+	// ones of a newer ArgoCD release, instead of rejecting them.
+	AllowUnknownFields bool `json:"allowUnknownFields"`
+	// PprofAddress serves pprof on a separate address when set.
+	PprofAddress       string   `json:"pprofAddress"`
+	CORSAllowedOrigins []string `json:"corsAllowedOrigins"`
+	// CORSAllowPost lets the allowed origins send POST requests on top of
+	// the read-only GET and HEAD ones, e.g. to generate parameters.
+	CORSAllowPost bool `json:"corsAllowPost"`
+	// AllowedSourceCIDRs restricts the clients of the API to these networks,
+	// e.g. the pod CIDR of the applicationset-controller. Empty allows all
+	// of them.
+	AllowedSourceCIDRs []string `json:"allowedSourceCIDRs"`
+	// ClientIPHeader is the header the proxies put the client IP in,
+	// X-Forwarded-For or X-Real-IP. Empty uses the address of the connection.
+	ClientIPHeader string `json:"clientIPHeader"`
+	// TrustedProxyCIDRs are the networks of the proxies whose ClientIPHeader
+	// is trusted.
+	TrustedProxyCIDRs []string `json:"trustedProxyCIDRs"`
+	CORSMaxAge        int      `json:"corsMaxAge"`
+	DisableGzip       bool     `json:"disableGzip"`
+	GzipLevel         int      `json:"gzipLevel"`
+	GzipMinLength     int      `json:"gzipMinLength"`
+	// ShutdownTimeout is how long the requests in flight are waited for on
+	// SIGTERM before their connections are closed.
+	ShutdownTimeout metav1.Duration `json:"shutdownTimeout"`
+	// DebugUI serves the page trying selectors on /ui, whose requests are
+	// authenticated with the admin key.
+	DebugUI bool `json:"debugUI"`
+}
+
+type AuthConfig struct {
+	KeyPath string `json:"keyPath"`
+	// AdminKeyPath defaults to KeyPath.
+	AdminKeyPath string `json:"adminKeyPath"`
+	// ProvisioningKeyPath authenticates the requests provisioning namespaces,
+	// which are only served when it's set.
+	ProvisioningKeyPath string `json:"provisioningKeyPath"`
+	// TokenRefreshAhead is how long before its expiry the token is refreshed
+	// in the background. Zero disables the background refresh.
+	TokenRefreshAhead metav1.Duration `json:"tokenRefreshAhead"`
+	// ReadyzCheckCloudCredentials makes /readyz verify a token can be obtained.
+	ReadyzCheckCloudCredentials bool `json:"readyzCheckCloudCredentials"`
+	// TokenReviewAudiences enables authenticating the ServiceAccount tokens
+	// of the callers with TokenReviews. The tokens must be bound to one of
+	// these audiences, and expire.
+	TokenReviewAudiences []string `json:"tokenReviewAudiences"`
+	// TokenReviewMaxLifetime rejects the reviewed tokens valid for longer.
+	// Zero accepts any lifetime.
+	TokenReviewMaxLifetime metav1.Duration `json:"tokenReviewMaxLifetime"`
+	// RequireApplicationSetIdentity rejects the API requests without the
+	// headers identifying their ApplicationSet.
+	RequireApplicationSetIdentity bool `json:"requireApplicationSetIdentity"`
+}
+
+// TenantsConfig scopes the callers presenting a JWT to the namespaces of the
+// tenants in its claims. An empty issuer disables the scoping.
+type TenantsConfig struct {
+	// Issuer is the iss claim of the accepted tokens, e.g.
+	// https://kubernetes.default.svc.cluster.local for ServiceAccount tokens.
+	Issuer string `json:"issuer"`
+	// JWKSURL serves the keys the tokens are signed with. It defaults to the
+	// keys of the local API server, which sign its ServiceAccount tokens.
+	JWKSURL string `json:"jwksURL"`
+	// Audiences accepted in the aud claim. Empty accepts any audience.
+	Audiences []string `json:"audiences"`
+	// Claim is the path of the claim holding the tenants, with "/" between
+	// nested claims.
+	Claim string `json:"claim"`
+	// Label is the namespace label holding the tenant of a namespace.
+	Label string `json:"label"`
+	// RequireToken rejects the static API key, so every caller is scoped.
+	RequireToken bool `json:"requireToken"`
+}
+
+// ImpersonationConfig lists namespaces as another identity, so the namespaces
+// returned are bounded by its RBAC rather than the generator's permissions.
+type ImpersonationConfig struct {
+	// User is impersonated by every route, e.g.
+	// system:serviceaccount:argocd:namespace-viewer. Empty lists namespaces
+	// as the generator.
+	User string `json:"user"`
+	// Groups are impersonated along with the user.
+	Groups []string `json:"groups"`
+	// Routes overrides the user per route path, e.g.
+	// /api/v1/getparams.execute.
+	Routes map[string]string `json:"routes"`
+	// AllowedUsers may be impersonated by the requests sending the
+	// Impersonate-User header. Empty ignores the header.
+	AllowedUsers []string `json:"allowedUsers"`
+}
+
+type TimeoutsConfig struct {
+	Secret metav1.Duration `json:"secret"`
+	Token  metav1.Duration `json:"token"`
+	Client metav1.Duration `json:"client"`
+	List   metav1.Duration `json:"list"`
+}
+
+type RetryConfig struct {
+	Attempts       int             `json:"attempts"`
+	InitialBackoff metav1.Duration `json:"initialBackoff"`
+	MaxBackoff     metav1.Duration `json:"maxBackoff"`
+}
+
+type CacheConfig struct {
+	// NamespaceSelector restricts the namespaces cached from the local cluster.
+	NamespaceSelector string `json:"namespaceSelector"`
+	// ResponseTTL is how long responses are cached. Zero disables the cache.
+	ResponseTTL metav1.Duration `json:"responseTTL"`
+	// SharedURL is the Redis URL of the cache shared by the replicas.
+	SharedURL string `json:"sharedURL"`
+	// SnapshotDir keeps the last known responses when set.
+	SnapshotDir    string          `json:"snapshotDir"`
+	SnapshotMaxAge metav1.Duration `json:"snapshotMaxAge"`
+	// InvalidateOnChange watches the namespaces and the cluster secrets to
+	// drop the cached responses and clients as soon as they change.
+	InvalidateOnChange bool `json:"invalidateOnChange"`
+	// InvalidationResyncInterval is how often the remote clusters with a
+	// cached client start being watched.
+	InvalidationResyncInterval metav1.Duration `json:"invalidationResyncInterval"`
+}
+
+type RemoteClientsConfig struct {
+	WatchList         bool `json:"watchList"`
+	WarmUp            bool `json:"warmUp"`
+	WarmUpConcurrency int  `json:"warmUpConcurrency"`
+	// ProjectsFallback lists the OpenShift projects of the remote clusters
+	// which forbid listing their namespaces.
+	ProjectsFallback bool `json:"projectsFallback"`
+	// ProbeInterval is how often the remote clusters are probed in the
+	// background. Zero disables the probes.
+	ProbeInterval    metav1.Duration `json:"probeInterval"`
+	ProbeTimeout     metav1.Duration `json:"probeTimeout"`
+	ProbeConcurrency int             `json:"probeConcurrency"`
+}
+
+type LimitsConfig struct {
+	RateLimitGlobalRPS   float64 `json:"rateLimitGlobalRPS"`
+	RateLimitGlobalBurst int     `json:"rateLimitGlobalBurst"`
+	RateLimitClientRPS   float64 `json:"rateLimitClientRPS"`
+	RateLimitClientBurst int     `json:"rateLimitClientBurst"`
+	// RateLimitApplicationSetRPS limits each ApplicationSet of a client on
+	// top of the client itself.
+	RateLimitApplicationSetRPS   float64         `json:"rateLimitApplicationSetRPS"`
+	RateLimitApplicationSetBurst int             `json:"rateLimitApplicationSetBurst"`
+	MaxInFlight                  int             `json:"maxInFlight"`
+	MaxQueued                    int             `json:"maxQueued"`
+	QueueTimeout                 metav1.Duration `json:"queueTimeout"`
+	InFlightRetryAfter           metav1.Duration `json:"inFlightRetryAfter"`
+	DegradedRetryAfter           metav1.Duration `json:"degradedRetryAfter"`
+	BatchMaxSize                 int             `json:"batchMaxSize"`
+	StreamThreshold              int             `json:"streamThreshold"`
+	// ChangeFeedRetention is the number of namespace changes kept per
+	// cluster for the change feed, and ChangeFeedIdleTimeout how long a
+	// cluster is watched after its last change feed request.
+	ChangeFeedRetention   int             `json:"changeFeedRetention"`
+	ChangeFeedIdleTimeout metav1.Duration `json:"changeFeedIdleTimeout"`
+	// ApplicationSetQuota applies to the ApplicationSets without their own
+	// quota in ApplicationSetQuotas, which are keyed by namespace/name or
+	// by name.
+	ApplicationSetQuota  ApplicationSetQuota            `json:"applicationSetQuota"`
+	ApplicationSetQuotas map[string]ApplicationSetQuota `json:"applicationSetQuotas"`
+}
+
+// ApplicationSetQuota caps what a single ApplicationSet may generate. Zero
+// fields are unlimited.
+type ApplicationSetQuota struct {
+	// MaxNamespaces is the number of namespaces a generate request may return.
+	MaxNamespaces int `json:"maxNamespaces"`
+	// MaxClusters is the number of clusters a batch may target, or the
+	// clusters plugin may return.
+	MaxClusters int `json:"maxClusters"`
+}
+
+const (
+	MalformationEmpty        = "empty"
+	MalformationDuplicates   = "duplicates"
+	MalformationTruncated    = "truncated"
+	MalformationInvalidNames = "invalidNames"
+)
+
+// FaultConfig describes the faults injected in the requests for a cluster.
+type FaultConfig struct {
+	// Latency is added before listing the namespaces.
+	Latency metav1.Duration `json:"latency"`
+	// ErrorRate is the fraction of the requests failing, from 0 to 1.
+	ErrorRate float64 `json:"errorRate"`
+	// ErrorCode is the error code of the failures. It defaults to
+	// ClusterUnreachable.
+	ErrorCode string `json:"errorCode"`
+	// MalformedRate is the fraction of the successful requests whose result
+	// is malformed, from 0 to 1.
+	MalformedRate float64 `json:"malformedRate"`
+	// Malformation is how the results are malformed: empty, duplicates,
+	// truncated or invalidNames. It defaults to duplicates.
+	Malformation string `json:"malformation"`
+}
+
+// ArgoCDInstanceConfig is an ArgoCD control plane of a sharded ArgoCD
+// deployment.
+type ArgoCDInstanceConfig struct {
+	// Name is the name requests select the instance by.
+	Name string `json:"name"`
+	// Namespace holds the cluster secrets of the instance.
+	Namespace string `json:"namespace"`
+	// APIEndpoint is the API server of the cluster running the instance,
+	// which is authenticated to like the remote clusters. Empty reads the
+	// cluster secrets from the local cluster.
+	APIEndpoint string `json:"apiEndpoint"`
+	// CAFile holds the CA bundle verifying APIEndpoint. Empty uses the
+	// system roots.
+	CAFile string `json:"caFile"`
+}
+
+// RoutesConfig holds the prefixes of the plugins served besides the default
+// one. ArgoCD appends the plugin path to the base URL, so each of them needs
+// its own prefix.
+type RoutesConfig struct {
+	V1alpha2Prefix string `json:"v1alpha2Prefix"`
+	ClustersPrefix string `json:"clustersPrefix"`
+	// ClustersProject restricts the clusters plugin to the clusters assigned
+	// to an ArgoCD project, whatever the project of the request.
+	ClustersProject string `json:"clustersProject"`
+}
+
+// FiltersConfig holds the server-wide rules applied to every request.
+type FiltersConfig struct {
+	// ExcludeNamespaces are never returned.
+	ExcludeNamespaces []string `json:"excludeNamespaces"`
+	// AllowedClusters restricts the remote clusters requests can target.
+	// Empty allows all of them.
+	AllowedClusters []string `json:"allowedClusters"`
+	// RegoPolicyFile is the path of a Rego policy evaluated against every
+	// candidate namespace, which can deny its inclusion.
+	RegoPolicyFile string `json:"regoPolicyFile"`
+	// RequiredLabels must be carried by every namespace returned, e.g.
+	// managed-by: konflux, whatever the label selector of the request.
+	RequiredLabels map[string]string `json:"requiredLabels"`
+	// AllowMatchAll lets the requests setting allowAll use an empty label
+	// selector, which matches all the namespaces.
+	AllowMatchAll bool `json:"allowMatchAll"`
+	// AuthorizationPolicy is a CEL expression the requests must satisfy. It
+	// requires the CELAuthorization feature gate.
+	AuthorizationPolicy string `json:"authorizationPolicy"`
+	// AuthorizationCostLimit bounds the cost of evaluating the authorization
+	// policy for a request, which is denied when it's exceeded. Zero
+	// disables the limit.
+	AuthorizationCostLimit int `json:"authorizationCostLimit"`
+	// MaxSelectorRequirements bounds the requirements of the label selector
+	// of a request, each of matchLabels and matchExpressions, and
+	// MaxSelectorValues the values of its matchExpressions. Zero disables
+	// the limit.
+	MaxSelectorRequirements int `json:"maxSelectorRequirements"`
+	MaxSelectorValues       int `json:"maxSelectorValues"`
+	// MaxResults bounds the namespaces returned by a request, which keeps
+	// the first ones by name when more match. Zero disables the limit.
+	MaxResults int `json:"maxResults"`
+	// SettlingPeriod leaves out the namespaces created less than that long
+	// ago, unless they're annotated as ready, so Applications aren't
+	// generated before the namespaces are bootstrapped. Zero disables it.
+	SettlingPeriod metav1.Duration `json:"settlingPeriod"`
+}
+
+// Headers the proxies put the client IP in.
+const (
+	ClientIPHeaderXForwardedFor = "X-Forwarded-For"
+	ClientIPHeaderXRealIP       = "X-Real-IP"
+)
+
+// Sinks of the audit events.
+const (
+	AuditSinkStdout  = "stdout"
+	AuditSinkFile    = "file"
+	AuditSinkWebhook = "webhook"
+)
+
+// AuditConfig configures recording the generation requests.
+type AuditConfig struct {
+	// Sink is where the events are recorded: stdout, file or webhook. Empty
+	// disables auditing.
+	Sink           string          `json:"sink"`
+	File           string          `json:"file"`
+	WebhookURL     string          `json:"webhookURL"`
+	WebhookTimeout metav1.Duration `json:"webhookTimeout"`
+	// BufferSize is the number of events queued for the sink before events
+	// are dropped.
+	BufferSize int `json:"bufferSize"`
+}
+
+// RecordingConfig configures recording the sanitized API requests and their
+// responses, to be replayed with the replay command.
+type RecordingConfig struct {
+	// Dir is the directory of the records. Empty disables recording.
+	Dir string `json:"dir"`
+	// MaxRecords is the number of records written before recording stops.
+	// Zero is unlimited.
+	MaxRecords int `json:"maxRecords"`
+}
+
+// ReportsConfig configures writing the GenerationReport resources.
+type ReportsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Namespace holds the reports. It defaults to the ArgoCD namespace.
+	Namespace string `json:"namespace"`
+	// Interval is how often the results of the requests are written.
+	Interval metav1.Duration `json:"interval"`
+}
+
+// Methods of refreshing the ApplicationSets.
+const (
+	RefreshMethodWebhook    = "webhook"
+	RefreshMethodAnnotation = "annotation"
+)
+
+// RefreshConfig configures refreshing the ApplicationSets as soon as the
+// namespaces they generate from change.
+type RefreshConfig struct {
+	// Rules map the namespaces to the ApplicationSets generating from them.
+	// They're only read from the configuration file. Empty disables the
+	// refreshes.
+	Rules []RefreshRule `json:"rules"`
+	// Method is how the ApplicationSets are refreshed: webhook posts them to
+	// WebhookURL, annotation sets the refresh annotation of ArgoCD on them.
+	Method string `json:"method"`
+	// WebhookURL is posted the ApplicationSets to refresh.
+	WebhookURL string `json:"webhookURL"`
+	// WebhookTokenPath is the file of the bearer token sent to the webhook.
+	WebhookTokenPath string          `json:"webhookTokenPath"`
+	WebhookTimeout   metav1.Duration `json:"webhookTimeout"`
+	// Debounce is how long the changes are collected before refreshing.
+	Debounce metav1.Duration `json:"debounce"`
+	// ResyncInterval is how often the remote clusters with a cached client
+	// start being watched.
+	ResyncInterval metav1.Duration `json:"resyncInterval"`
+}
+
+// RefreshRule refreshes ApplicationSets when namespaces start or stop
+// matching a label selector on a cluster.
+type RefreshRule struct {
+	// Cluster is the name of the cluster secret of the watched cluster,
+	// empty for the local cluster. Remote clusters are only watched once
+	// their client is cached, e.g. after a request for them.
+	Cluster         string                    `json:"cluster"`
+	LabelSelector   metav1.LabelSelector      `json:"labelSelector"`
+	ApplicationSets []ApplicationSetReference `json:"applicationSets"`
+}
+
+// ApplicationSetReference identifies an ApplicationSet.
+type ApplicationSetReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// ClaimsConfig configures provisioning the namespaces of the NamespaceClaim
+// resources.
+type ClaimsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Namespaces are the namespaces the claims are accepted from. Empty
+	// accepts them from all namespaces.
+	Namespaces []string `json:"namespaces"`
+	// ResyncInterval is how often all the claims are provisioned again, which
+	// restores what was changed in their namespaces.
+	ResyncInterval metav1.Duration `json:"resyncInterval"`
+	// DefaultRoleBindings are created in every provisioned namespace. They're
+	// only read from the configuration file.
+	DefaultRoleBindings []RoleBindingConfig `json:"defaultRoleBindings"`
+	// APINamespace holds the claims created by the requests provisioning
+	// namespaces. It defaults to the first of Namespaces, or to the ArgoCD
+	// namespace.
+	APINamespace string `json:"apiNamespace"`
+	// ProvisionTimeout bounds the wait of the requests provisioning
+	// namespaces.
+	ProvisionTimeout metav1.Duration `json:"provisionTimeout"`
+}
+
+// RoleBindingConfig binds a ClusterRole to subjects.
+type RoleBindingConfig struct {
+	ClusterRole string          `json:"clusterRole"`
+	Subjects    []SubjectConfig `json:"subjects"`
+}
+
+// SubjectConfig is a user, a group or a service account.
+type SubjectConfig struct {
+	// Kind is User, Group or ServiceAccount.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Namespace is the namespace of a service account.
+	Namespace string `json:"namespace"`
+}
+
+// ExpiryConfig configures expiring the namespaces of the local cluster with a
+// TTL annotation. The expired namespaces are excluded from the results even
+// when it's disabled, but only once their TTL elapsed.
+type ExpiryConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interval is how often the namespaces are checked for expiry.
+	Interval metav1.Duration `json:"interval"`
+	// Delete deletes the expired namespaces, instead of labeling them.
+	Delete bool `json:"delete"`
+}
+
+// LeaderConfig configures the election of the replica running the tasks
+// which must only run once per deployment.
+type LeaderConfig struct {
+	Enabled   bool   `json:"enabled"`
+	LeaseName string `json:"leaseName"`
+	// Namespace holds the lease. It defaults to the ArgoCD namespace.
+	Namespace     string          `json:"namespace"`
+	LeaseDuration metav1.Duration `json:"leaseDuration"`
+	RenewDeadline metav1.Duration `json:"renewDeadline"`
+	RetryPeriod   metav1.Duration `json:"retryPeriod"`
+}
+
+// Default returns the default settings.
+func Default() *Config {
+	return &Config{
+		ArgoCDNamespace: "argocd",
+		LogLevel:        slog.LevelDebug,
+		Server: ServerConfig{
+			Address:       ":5000",
+			TLSCertFile:   "/mnt/serving-certs/tls.crt",
+			TLSKeyFile:    "/mnt/serving-certs/tls.key",
+			ReadTimeout:   metav1.Duration{Duration: 30 * time.Second},
+			WriteTimeout:  metav1.Duration{Duration: 120 * time.Second},
+			IdleTimeout:   metav1.Duration{Duration: 120 * time.Second},
+			CORSMaxAge:    600,
+			GzipLevel:     5,
+			GzipMinLength: 1024,
+			// Headers are small, only the body of a generate request takes
+			// time to read.
+			ReadHeaderTimeout: metav1.Duration{Duration: 10 * time.Second},
+			MaxHeaderBytes:    1 << 20,
+			MaxBodyBytes:      1 << 20,
+			// Leaves time for writing the audit and the reports within the
+			// default termination grace period of 30s.
+			ShutdownTimeout: metav1.Duration{Duration: 25 * time.Second},
+		},
+		Auth: AuthConfig{
+			KeyPath:           "/mnt/key",
+			TokenRefreshAhead: metav1.Duration{Duration: 5 * time.Minute},
+		},
+		Tenants: TenantsConfig{
+			// ServiceAccount tokens hold the namespace of the ServiceAccount.
+			Claim: "kubernetes.io/namespace",
+		},
+		Timeouts: TimeoutsConfig{
+			Secret: metav1.Duration{Duration: 10 * time.Second},
+			Token:  metav1.Duration{Duration: 30 * time.Second},
+			Client: metav1.Duration{Duration: 10 * time.Second},
+			List:   metav1.Duration{Duration: 60 * time.Second},
+		},
+		Filters: FiltersConfig{
+			AuthorizationCostLimit:  10000,
+			MaxSelectorRequirements: 32,
+			MaxSelectorValues:       256,
+			MaxResults:              10000,
+		},
+		Retry: RetryConfig{
+			Attempts:       3,
+			InitialBackoff: metav1.Duration{Duration: 200 * time.Millisecond},
+			MaxBackoff:     metav1.Duration{Duration: 5 * time.Second},
+		},
+		Cache: CacheConfig{
+			SnapshotMaxAge:             metav1.Duration{Duration: time.Hour},
+			InvalidationResyncInterval: metav1.Duration{Duration: 30 * time.Second},
+		},
+		RemoteClients: RemoteClientsConfig{
+			WarmUpConcurrency: 4,
+			ProbeTimeout:      metav1.Duration{Duration: 10 * time.Second},
+			ProbeConcurrency:  4,
+		},
+		Limits: LimitsConfig{
+			RateLimitGlobalBurst:         1,
+			RateLimitClientBurst:         1,
+			RateLimitApplicationSetBurst: 1,
+			MaxQueued:                    100,
+			QueueTimeout:                 metav1.Duration{Duration: 10 * time.Second},
+			InFlightRetryAfter:           metav1.Duration{Duration: time.Second},
+			DegradedRetryAfter:           metav1.Duration{Duration: 30 * time.Second},
+			BatchMaxSize:                 50,
+			StreamThreshold:              5000,
+			ChangeFeedRetention:          10000,
+			ChangeFeedIdleTimeout:        metav1.Duration{Duration: 10 * time.Minute},
+		},
+		Routes: RoutesConfig{
+			V1alpha2Prefix: "/v1alpha2",
+			ClustersPrefix: "/clusters",
+		},
+		Audit: AuditConfig{
+			WebhookTimeout: metav1.Duration{Duration: 5 * time.Second},
+			BufferSize:     1000,
+		},
+		Recording: RecordingConfig{
+			MaxRecords: 1000,
+		},
+		Reports: ReportsConfig{
+			Interval: metav1.Duration{Duration: 30 * time.Second},
+		},
+		Refresh: RefreshConfig{
+			Method:         RefreshMethodWebhook,
+			WebhookTimeout: metav1.Duration{Duration: 5 * time.Second},
+			Debounce:       metav1.Duration{Duration: 5 * time.Second},
+			ResyncInterval: metav1.Duration{Duration: 30 * time.Second},
+		},
+		Leader: LeaderConfig{
+			LeaseName:     "namespace-generator",
+			LeaseDuration: metav1.Duration{Duration: 15 * time.Second},
+			RenewDeadline: metav1.Duration{Duration: 10 * time.Second},
+			RetryPeriod:   metav1.Duration{Duration: 2 * time.Second},
+		},
+		Claims: ClaimsConfig{
+			ResyncInterval:   metav1.Duration{Duration: 10 * time.Minute},
+			ProvisionTimeout: metav1.Duration{Duration: 30 * time.Second},
+		},
+		Expiry: ExpiryConfig{
+			Interval: metav1.Duration{Duration: time.Minute},
+		},
+		ReloadInterval: metav1.Duration{Duration: 10 * time.Second},
+	}
+}
+
+// setting binds an environment variable to a field of the configuration.
+type setting struct {
+	env   string
+	value any
+}
+
+// presenceSettings are the boolean environment variables which predate the
+// configuration file. They keep enabling their setting whenever they're set,
+// whatever their value, so NS_GEN_USE_HTTP=false still serves plain HTTP.
+var presenceSettings = map[string]bool{
+	"NS_GEN_USE_HTTP":                       true,
+	"NS_GEN_ENABLE_H2C":                     true,
+	"NS_GEN_DISABLE_TCP":                    true,
+	"NS_GEN_DISABLE_GZIP":                   true,
+	"NS_GEN_READYZ_CHECK_CLOUD_CREDENTIALS": true,
+	"NS_GEN_ENABLE_WATCH_LIST":              true,
+	"NS_GEN_WARM_UP_REMOTE_CLIENTS":         true,
+}
+
+func (cfg *Config) settings() []setting {
+	return []setting{
+		{"NS_GEN_ARGOCD_NAMESPACE", &cfg.ArgoCDNamespace},
+		{"NS_GEN_CLUSTER_SECRET_NAMES", &cfg.ClusterSecretNames},
+		{"NS_GEN_LOG_LEVEL", &cfg.LogLevel},
+
+		{"NS_GEN_ADDRESS", &cfg.Server.Address},
+		{"NS_GEN_USE_HTTP", &cfg.Server.UseHTTP},
+		{"NS_GEN_ENABLE_H2C", &cfg.Server.EnableH2C},
+		{"NS_GEN_TLS_CERT_FILE", &cfg.Server.TLSCertFile},
+		{"NS_GEN_TLS_KEY_FILE", &cfg.Server.TLSKeyFile},
+		{"NS_GEN_UNIX_SOCKET", &cfg.Server.UnixSocket},
+		{"NS_GEN_DISABLE_TCP", &cfg.Server.DisableTCP},
+		{"NS_GEN_SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout},
+		{"NS_GEN_SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout},
+		{"NS_GEN_SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout},
+		{"NS_GEN_SERVER_READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout},
+		{"NS_GEN_SERVER_MAX_HEADER_BYTES", &cfg.Server.MaxHeaderBytes},
+		{"NS_GEN_SERVER_MAX_BODY_BYTES", &cfg.Server.MaxBodyBytes},
+		{"NS_GEN_SERVER_DISABLE_KEEP_ALIVES", &cfg.Server.DisableKeepAlives},
+		{"NS_GEN_ALLOW_UNKNOWN_FIELDS", &cfg.Server.AllowUnknownFields},
+		{"NS_GEN_PPROF_ADDRESS", &cfg.Server.PprofAddress},
+		{"NS_GEN_CORS_ALLOWED_ORIGINS", &cfg.Server.CORSAllowedOrigins},
+		{"NS_GEN_CORS_ALLOW_POST", &cfg.Server.CORSAllowPost},
+		{"NS_GEN_ALLOWED_SOURCE_CIDRS", &cfg.Server.AllowedSourceCIDRs},
+		{"NS_GEN_CLIENT_IP_HEADER", &cfg.Server.ClientIPHeader},
+		{"NS_GEN_TRUSTED_PROXY_CIDRS", &cfg.Server.TrustedProxyCIDRs},
+		{"NS_GEN_CORS_MAX_AGE", &cfg.Server.CORSMaxAge},
+		{"NS_GEN_DISABLE_GZIP", &cfg.Server.DisableGzip},
+		{"NS_GEN_GZIP_LEVEL", &cfg.Server.GzipLevel},
+		{"NS_GEN_GZIP_MIN_LENGTH", &cfg.Server.GzipMinLength},
+		{"NS_GEN_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout},
+		{"NS_GEN_DEBUG_UI", &cfg.Server.DebugUI},
+
+		{"NS_GEN_KEY_PATH", &cfg.Auth.KeyPath},
+		{"NS_GEN_ADMIN_KEY_PATH", &cfg.Auth.AdminKeyPath},
+		{"NS_GEN_PROVISIONING_KEY_PATH", &cfg.Auth.ProvisioningKeyPath},
+		{"NS_GEN_TOKEN_REFRESH_AHEAD", &cfg.Auth.TokenRefreshAhead},
+		{"NS_GEN_READYZ_CHECK_CLOUD_CREDENTIALS", &cfg.Auth.ReadyzCheckCloudCredentials},
+		{"NS_GEN_REQUIRE_APPLICATIONSET_IDENTITY", &cfg.Auth.RequireApplicationSetIdentity},
+		{"NS_GEN_TOKEN_REVIEW_AUDIENCES", &cfg.Auth.TokenReviewAudiences},
+		{"NS_GEN_TOKEN_REVIEW_MAX_LIFETIME", &cfg.Auth.TokenReviewMaxLifetime},
+
+		{"NS_GEN_TENANT_ISSUER", &cfg.Tenants.Issuer},
+		{"NS_GEN_TENANT_JWKS_URL", &cfg.Tenants.JWKSURL},
+		{"NS_GEN_TENANT_AUDIENCES", &cfg.Tenants.Audiences},
+		{"NS_GEN_TENANT_CLAIM", &cfg.Tenants.Claim},
+		{"NS_GEN_TENANT_LABEL", &cfg.Tenants.Label},
+		{"NS_GEN_TENANT_REQUIRE_TOKEN", &cfg.Tenants.RequireToken},
+
+		{"NS_GEN_IMPERSONATE_USER", &cfg.Impersonation.User},
+		{"NS_GEN_IMPERSONATE_GROUPS", &cfg.Impersonation.Groups},
+		{"NS_GEN_IMPERSONATE_ROUTES", &cfg.Impersonation.Routes},
+		{"NS_GEN_IMPERSONATE_ALLOWED_USERS", &cfg.Impersonation.AllowedUsers},
+
+		{"NS_GEN_SECRET_TIMEOUT", &cfg.Timeouts.Secret},
+		{"NS_GEN_TOKEN_TIMEOUT", &cfg.Timeouts.Token},
+		{"NS_GEN_CLIENT_TIMEOUT", &cfg.Timeouts.Client},
+		{"NS_GEN_LIST_TIMEOUT", &cfg.Timeouts.List},
+
+		{"NS_GEN_RETRY_ATTEMPTS", &cfg.Retry.Attempts},
+		{"NS_GEN_RETRY_INITIAL_BACKOFF", &cfg.Retry.InitialBackoff},
+		{"NS_GEN_RETRY_MAX_BACKOFF", &cfg.Retry.MaxBackoff},
+
+		{"NS_GEN_NAMESPACE_CACHE_SELECTOR", &cfg.Cache.NamespaceSelector},
+		{"NS_GEN_RESPONSE_CACHE_TTL", &cfg.Cache.ResponseTTL},
+		{"NS_GEN_SHARED_CACHE_URL", &cfg.Cache.SharedURL},
+		{"NS_GEN_SNAPSHOT_DIR", &cfg.Cache.SnapshotDir},
+		{"NS_GEN_SNAPSHOT_MAX_AGE", &cfg.Cache.SnapshotMaxAge},
+		{"NS_GEN_CACHE_INVALIDATE_ON_CHANGE", &cfg.Cache.InvalidateOnChange},
+		{"NS_GEN_CACHE_INVALIDATION_RESYNC_INTERVAL", &cfg.Cache.InvalidationResyncInterval},
+
+		{"NS_GEN_ENABLE_WATCH_LIST", &cfg.RemoteClients.WatchList},
+		{"NS_GEN_PROJECTS_FALLBACK", &cfg.RemoteClients.ProjectsFallback},
+		{"NS_GEN_WARM_UP_REMOTE_CLIENTS", &cfg.RemoteClients.WarmUp},
+		{"NS_GEN_WARM_UP_CONCURRENCY", &cfg.RemoteClients.WarmUpConcurrency},
+		{"NS_GEN_CLUSTER_PROBE_INTERVAL", &cfg.RemoteClients.ProbeInterval},
+		{"NS_GEN_CLUSTER_PROBE_TIMEOUT", &cfg.RemoteClients.ProbeTimeout},
+		{"NS_GEN_CLUSTER_PROBE_CONCURRENCY", &cfg.RemoteClients.ProbeConcurrency},
+
+		{"NS_GEN_RATE_LIMIT_GLOBAL_RPS", &cfg.Limits.RateLimitGlobalRPS},
+		{"NS_GEN_RATE_LIMIT_GLOBAL_BURST", &cfg.Limits.RateLimitGlobalBurst},
+		{"NS_GEN_RATE_LIMIT_CLIENT_RPS", &cfg.Limits.RateLimitClientRPS},
+		{"NS_GEN_RATE_LIMIT_CLIENT_BURST", &cfg.Limits.RateLimitClientBurst},
+		{"NS_GEN_RATE_LIMIT_APPLICATIONSET_RPS", &cfg.Limits.RateLimitApplicationSetRPS},
+		{"NS_GEN_RATE_LIMIT_APPLICATIONSET_BURST", &cfg.Limits.RateLimitApplicationSetBurst},
+		{"NS_GEN_MAX_IN_FLIGHT", &cfg.Limits.MaxInFlight},
+		{"NS_GEN_MAX_QUEUED", &cfg.Limits.MaxQueued},
+		{"NS_GEN_QUEUE_TIMEOUT", &cfg.Limits.QueueTimeout},
+		{"NS_GEN_IN_FLIGHT_RETRY_AFTER", &cfg.Limits.InFlightRetryAfter},
+		{"NS_GEN_DEGRADED_RETRY_AFTER", &cfg.Limits.DegradedRetryAfter},
+		{"NS_GEN_BATCH_MAX_SIZE", &cfg.Limits.BatchMaxSize},
+		{"NS_GEN_APPLICATIONSET_MAX_NAMESPACES", &cfg.Limits.ApplicationSetQuota.MaxNamespaces},
+		{"NS_GEN_APPLICATIONSET_MAX_CLUSTERS", &cfg.Limits.ApplicationSetQuota.MaxClusters},
+		{"NS_GEN_STREAM_THRESHOLD", &cfg.Limits.StreamThreshold},
+		{"NS_GEN_CHANGE_FEED_RETENTION", &cfg.Limits.ChangeFeedRetention},
+		{"NS_GEN_CHANGE_FEED_IDLE_TIMEOUT", &cfg.Limits.ChangeFeedIdleTimeout},
+
+		{"NS_GEN_V1ALPHA2_PREFIX", &cfg.Routes.V1alpha2Prefix},
+		{"NS_GEN_CLUSTERS_PREFIX", &cfg.Routes.ClustersPrefix},
+		{"NS_GEN_CLUSTERS_PROJECT", &cfg.Routes.ClustersProject},
+
+		{"NS_GEN_EXCLUDE_NAMESPACES", &cfg.Filters.ExcludeNamespaces},
+		{"NS_GEN_ALLOWED_CLUSTERS", &cfg.Filters.AllowedClusters},
+		{"NS_GEN_REGO_POLICY_FILE", &cfg.Filters.RegoPolicyFile},
+		{"NS_GEN_AUTHORIZATION_POLICY", &cfg.Filters.AuthorizationPolicy},
+		{"NS_GEN_ALLOW_MATCH_ALL", &cfg.Filters.AllowMatchAll},
+		{"NS_GEN_REQUIRED_LABELS", &cfg.Filters.RequiredLabels},
+		{"NS_GEN_AUTHORIZATION_COST_LIMIT", &cfg.Filters.AuthorizationCostLimit},
+		{"NS_GEN_MAX_SELECTOR_REQUIREMENTS", &cfg.Filters.MaxSelectorRequirements},
+		{"NS_GEN_MAX_SELECTOR_VALUES", &cfg.Filters.MaxSelectorValues},
+		{"NS_GEN_MAX_RESULTS", &cfg.Filters.MaxResults},
+		{"NS_GEN_SETTLING_PERIOD", &cfg.Filters.SettlingPeriod},
+
+		{"NS_GEN_AUDIT_SINK", &cfg.Audit.Sink},
+		{"NS_GEN_AUDIT_FILE", &cfg.Audit.File},
+		{"NS_GEN_AUDIT_WEBHOOK_URL", &cfg.Audit.WebhookURL},
+		{"NS_GEN_AUDIT_WEBHOOK_TIMEOUT", &cfg.Audit.WebhookTimeout},
+		{"NS_GEN_AUDIT_BUFFER_SIZE", &cfg.Audit.BufferSize},
+		{"NS_GEN_RECORD_DIR", &cfg.Recording.Dir},
+		{"NS_GEN_RECORD_MAX_RECORDS", &cfg.Recording.MaxRecords},
+
+		{"NS_GEN_GENERATION_REPORTS", &cfg.Reports.Enabled},
+		{"NS_GEN_GENERATION_REPORTS_NAMESPACE", &cfg.Reports.Namespace},
+		{"NS_GEN_GENERATION_REPORTS_INTERVAL", &cfg.Reports.Interval},
+
+		{"NS_GEN_REFRESH_METHOD", &cfg.Refresh.Method},
+		{"NS_GEN_REFRESH_WEBHOOK_URL", &cfg.Refresh.WebhookURL},
+		{"NS_GEN_REFRESH_WEBHOOK_TOKEN_PATH", &cfg.Refresh.WebhookTokenPath},
+		{"NS_GEN_REFRESH_WEBHOOK_TIMEOUT", &cfg.Refresh.WebhookTimeout},
+		{"NS_GEN_REFRESH_DEBOUNCE", &cfg.Refresh.Debounce},
+		{"NS_GEN_REFRESH_RESYNC_INTERVAL", &cfg.Refresh.ResyncInterval},
+
+		{"NS_GEN_LEADER_ELECTION", &cfg.Leader.Enabled},
+		{"NS_GEN_LEADER_ELECTION_LEASE_NAME", &cfg.Leader.LeaseName},
+		{"NS_GEN_LEADER_ELECTION_NAMESPACE", &cfg.Leader.Namespace},
+		{"NS_GEN_LEADER_ELECTION_LEASE_DURATION", &cfg.Leader.LeaseDuration},
+		{"NS_GEN_LEADER_ELECTION_RENEW_DEADLINE", &cfg.Leader.RenewDeadline},
+		{"NS_GEN_LEADER_ELECTION_RETRY_PERIOD", &cfg.Leader.RetryPeriod},
+
+		{"NS_GEN_NAMESPACE_CLAIMS", &cfg.Claims.Enabled},
+		{"NS_GEN_NAMESPACE_CLAIMS_NAMESPACES", &cfg.Claims.Namespaces},
+		{"NS_GEN_NAMESPACE_CLAIMS_RESYNC_INTERVAL", &cfg.Claims.ResyncInterval},
+		{"NS_GEN_NAMESPACE_CLAIMS_API_NAMESPACE", &cfg.Claims.APINamespace},
+		{"NS_GEN_NAMESPACE_CLAIMS_PROVISION_TIMEOUT", &cfg.Claims.ProvisionTimeout},
+		{"NS_GEN_NAMESPACE_EXPIRY", &cfg.Expiry.Enabled},
+		{"NS_GEN_NAMESPACE_EXPIRY_INTERVAL", &cfg.Expiry.Interval},
+		{"NS_GEN_NAMESPACE_EXPIRY_DELETE", &cfg.Expiry.Delete},
+
+		{"NS_GEN_FEATURE_GATES", &cfg.FeatureGates},
+
+		{"NS_GEN_CONFIG_RELOAD_INTERVAL", &cfg.ReloadInterval},
+		{"NS_GEN_GENERATOR_CONFIG", &cfg.GeneratorConfigName},
+		{"NS_GEN_VISIBILITY_POLICIES", &cfg.VisibilityPolicies},
+	}
+}
+
+// flagName returns the flag overriding the given environment variable.
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(env, envPrefix), "_", "-"))
+}
+
+// Load returns the configuration read from the file set by --config or
+// NS_GEN_CONFIG, overridden by the environment variables and then by the flags
+// in the given arguments.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("namespace-generator", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("NS_GEN_CONFIG"), "Path of the YAML configuration file.")
+	// Flags are applied once the file and the environment have been read.
+	type override struct {
+		setting setting
+		value   string
+	}
+	var overrides []override
+	for _, s := range settings {
+		s := s
+		record := func(value string) error {
+			overrides = append(overrides, override{setting: s, value: value})
+			return nil
+		}
+		usage := fmt.Sprintf("Overrides %s.", s.env)
+		if _, ok := s.value.(*bool); ok {
+			flags.BoolFunc(flagName(s.env), usage, record)
+		} else {
+			flags.Func(flagName(s.env), usage, record)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg.Path = *configPath
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the configuration file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", *configPath, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if presenceSettings[s.env] {
+				value = "true"
+			}
+			if err := set(s.value, value); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, o := range overrides {
+		if err := set(o.setting.value, o.value); err != nil {
+			return nil, fmt.Errorf("invalid value for --%s: %w", flagName(o.setting.env), err)
+		}
+	}
+
+	if cfg.Auth.AdminKeyPath == "" {
+		cfg.Auth.AdminKeyPath = cfg.Auth.KeyPath
+	}
+	if cfg.Reports.Namespace == "" {
+		cfg.Reports.Namespace = cfg.ArgoCDNamespace
+	}
+	if cfg.Leader.Namespace == "" {
+		cfg.Leader.Namespace = cfg.ArgoCDNamespace
+	}
+	if cfg.Claims.APINamespace == "" {
+		cfg.Claims.APINamespace = cfg.ArgoCDNamespace
+		if len(cfg.Claims.Namespaces) > 0 {
+			cfg.Claims.APINamespace = cfg.Claims.Namespaces[0]
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+// RequiresRestart reports whether the configuration differs from the previous
+// one in settings which can't be applied while serving. The filters and the
+// cache durations are applied on reload.
+func (cfg *Config) RequiresRestart(previous *Config) bool {
+	reloaded := *cfg
+	reloaded.Filters = previous.Filters
+	reloaded.Cache.ResponseTTL = previous.Cache.ResponseTTL
+	reloaded.Cache.SnapshotMaxAge = previous.Cache.SnapshotMaxAge
+	reloaded.FaultInjection = previous.FaultInjection
+	return !reflect.DeepEqual(&reloaded, previous)
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Server.DisableTCP && cfg.Server.UnixSocket == "" {
+		return errors.New("disabling TCP requires a unix socket to be set")
+	}
+	if cfg.ArgoCDNamespace == "" {
+		return errors.New("the ArgoCD namespace must be set")
+	}
+	if cfg.Server.ReadTimeout.Duration < 0 || cfg.Server.WriteTimeout.Duration < 0 || cfg.Server.IdleTimeout.Duration < 0 ||
+		cfg.Server.ReadHeaderTimeout.Duration < 0 || cfg.Server.MaxHeaderBytes < 0 {
+		return errors.New("the server timeouts and the maximum header size must not be negative")
+	}
+	if cfg.Server.MaxBodyBytes <= 0 {
+		return errors.New("the maximum body size must be positive")
+	}
+	if len(cfg.ArgoCDInstances) > 0 && len(cfg.ClusterSecretNames) > 0 {
+		return errors.New("ArgoCD instances can't be set along with cluster secret names")
+	}
+	instanceNames := map[string]bool{}
+	for _, instance := range cfg.ArgoCDInstances {
+		if instanceNames[instance.Name] {
+			return fmt.Errorf("duplicate ArgoCD instance %s", instance.Name)
+		}
+		instanceNames[instance.Name] = true
+		if err := instance.validate(); err != nil {
+			return fmt.Errorf("invalid ArgoCD instance %s: %w", instance.Name, err)
+		}
+	}
+	if _, err := ParseCIDRs(cfg.Server.AllowedSourceCIDRs); err != nil {
+		return fmt.Errorf("invalid allowed source: %w", err)
+	}
+	if _, err := ParseCIDRs(cfg.Server.TrustedProxyCIDRs); err != nil {
+		return fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	switch cfg.Server.ClientIPHeader {
+	case "":
+	case ClientIPHeaderXForwardedFor, ClientIPHeaderXRealIP:
+		if len(cfg.Server.TrustedProxyCIDRs) == 0 {
+			return errors.New("the client IP header requires trusted proxies to be set")
+		}
+	default:
+		return fmt.Errorf("unknown client IP header %q, expected %s or %s", cfg.Server.ClientIPHeader, ClientIPHeaderXForwardedFor, ClientIPHeaderXRealIP)
+	}
+	if err := features.Default.Validate(cfg.FeatureGates); err != nil {
+		return err
+	}
+	if cfg.Auth.TokenReviewMaxLifetime.Duration < 0 {
+		return errors.New("the token review maximum lifetime must not be negative")
+	}
+	if cfg.Auth.TokenReviewMaxLifetime.Duration > 0 && len(cfg.Auth.TokenReviewAudiences) == 0 {
+		return errors.New("the token review maximum lifetime requires token review audiences")
+	}
+	if tenants := cfg.Tenants; tenants.Issuer != "" {
+		if tenants.Label == "" || tenants.Claim == "" {
+			return errors.New("tenant scoping requires a tenant label and claim")
+		}
+	} else if tenants.RequireToken {
+		return errors.New("requiring tenant tokens requires a tenant issuer")
+	}
+	for name, quota := range cfg.Limits.ApplicationSetQuotas {
+		if quota.MaxNamespaces < 0 || quota.MaxClusters < 0 {
+			return fmt.Errorf("the quota of ApplicationSet %s must not be negative", name)
+		}
+	}
+	if quota := cfg.Limits.ApplicationSetQuota; quota.MaxNamespaces < 0 || quota.MaxClusters < 0 {
+		return errors.New("the ApplicationSet quota must not be negative")
+	}
+	if cfg.Limits.ChangeFeedRetention <= 0 || cfg.Limits.ChangeFeedIdleTimeout.Duration <= 0 {
+		return errors.New("the change feed retention and idle timeout must be positive")
+	}
+	if cfg.RemoteClients.ProbeInterval.Duration > 0 && cfg.RemoteClients.ProbeTimeout.Duration <= 0 {
+		return errors.New("the cluster probe timeout must be positive")
+	}
+	if cfg.Cache.InvalidateOnChange && cfg.Cache.InvalidationResyncInterval.Duration <= 0 {
+		return errors.New("the cache invalidation resync interval must be positive")
+	}
+	if cfg.Reports.Enabled && cfg.Reports.Interval.Duration <= 0 {
+		return errors.New("the generation reports interval must be positive")
+	}
+	if err := cfg.Refresh.validate(); err != nil {
+		return fmt.Errorf("invalid ApplicationSet refresh: %w", err)
+	}
+	if leader := cfg.Leader; leader.Enabled {
+		if leader.LeaseName == "" {
+			return errors.New("leader election requires a lease name")
+		}
+		if leader.LeaseDuration.Duration <= leader.RenewDeadline.Duration {
+			return errors.New("the leader election lease duration must be greater than the renew deadline")
+		}
+		if leader.RetryPeriod.Duration <= 0 || leader.RenewDeadline.Duration <= leader.RetryPeriod.Duration {
+			return errors.New("the leader election renew deadline must be greater than the retry period")
+		}
+	}
+	if err := cfg.Claims.validate(); err != nil {
+		return fmt.Errorf("invalid namespace claims: %w", err)
+	}
+	if cfg.Auth.ProvisioningKeyPath != "" && !cfg.Claims.Enabled {
+		return errors.New("provisioning namespaces requires the namespace claims to be enabled")
+	}
+	if cfg.Expiry.Enabled && cfg.Expiry.Interval.Duration <= 0 {
+		return errors.New("the namespace expiry interval must be positive")
+	}
+	switch cfg.Audit.Sink {
+	case "", AuditSinkStdout:
+	case AuditSinkFile:
+		if cfg.Audit.File == "" {
+			return errors.New("the file audit sink requires a file to be set")
+		}
+	case AuditSinkWebhook:
+		if cfg.Audit.WebhookURL == "" {
+			return errors.New("the webhook audit sink requires a webhook URL to be set")
+		}
+	default:
+		return fmt.Errorf("unknown audit sink %q, expected stdout, file or webhook", cfg.Audit.Sink)
+	}
+	if cfg.Recording.MaxRecords < 0 {
+		return errors.New("the maximum number of records must not be negative")
+	}
+	if cfg.Filters.AuthorizationCostLimit < 0 || cfg.Filters.MaxSelectorRequirements < 0 || cfg.Filters.MaxSelectorValues < 0 {
+		return errors.New("the authorization cost limit and the selector limits must not be negative")
+	}
+	if cfg.Filters.MaxResults < 0 {
+		return errors.New("the maximum number of results must not be negative")
+	}
+	if cfg.Filters.SettlingPeriod.Duration < 0 {
+		return errors.New("the settling period must not be negative")
+	}
+	for cluster, fault := range cfg.FaultInjection {
+		if err := fault.validate(); err != nil {
+			return fmt.Errorf("invalid faults of cluster %s: %w", cluster, err)
+		}
+	}
+	return nil
+}
+
+func (instance ArgoCDInstanceConfig) validate() error {
+	// The name prefixes the cluster names of the instance.
+	if errs := validation.IsDNS1123Label(instance.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid name: %s", errs[0])
+	}
+	if instance.Namespace == "" {
+		return errors.New("the namespace must be set")
+	}
+	if instance.APIEndpoint == "" {
+		if instance.CAFile != "" {
+			return errors.New("the CA file requires an API endpoint")
+		}
+		return nil
+	}
+	if u, err := url.Parse(instance.APIEndpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid API endpoint %q, expected an https URL", instance.APIEndpoint)
+	}
+	return nil
+}
+
+func (fault FaultConfig) validate() error {
+	if fault.Latency.Duration < 0 {
+		return errors.New("the latency must not be negative")
+	}
+	if fault.ErrorRate < 0 || fault.ErrorRate > 1 || fault.MalformedRate < 0 || fault.MalformedRate > 1 {
+		return errors.New("the rates must be between 0 and 1")
+	}
+	if fault.ErrorCode != "" && generrors.ByCode(fault.ErrorCode) == nil {
+		return fmt.Errorf("unknown error code %q", fault.ErrorCode)
+	}
+	switch fault.Malformation {
+	case "", MalformationEmpty, MalformationDuplicates, MalformationTruncated, MalformationInvalidNames:
+	default:
+		return fmt.Errorf("unknown malformation %q, expected %s, %s, %s or %s", fault.Malformation,
+			MalformationEmpty, MalformationDuplicates, MalformationTruncated, MalformationInvalidNames)
+	}
+	return nil
+}
+
+func (claims ClaimsConfig) validate() error {
+	if !claims.Enabled {
+		return nil
+	}
+	if claims.ResyncInterval.Duration <= 0 {
+		return errors.New("the resync interval must be positive")
+	}
+	if claims.ProvisionTimeout.Duration <= 0 {
+		return errors.New("the provision timeout must be positive")
+	}
+	if len(claims.Namespaces) > 0 && !slices.Contains(claims.Namespaces, claims.APINamespace) {
+		return fmt.Errorf("the API namespace %s must be one of the claim namespaces", claims.APINamespace)
+	}
+	for i, binding := range claims.DefaultRoleBindings {
+		if binding.ClusterRole == "" || len(binding.Subjects) == 0 {
+			return fmt.Errorf("default RoleBinding %d requires a ClusterRole and subjects", i)
+		}
+		for _, subject := range binding.Subjects {
+			switch subject.Kind {
+			case "User", "Group":
+			case "ServiceAccount":
+				if subject.Namespace == "" {
+					return fmt.Errorf("the service accounts of default RoleBinding %d require a namespace", i)
+				}
+			default:
+				return fmt.Errorf("unknown subject kind %q of default RoleBinding %d, expected User, Group or ServiceAccount", subject.Kind, i)
+			}
+			if subject.Name == "" {
+				return fmt.Errorf("the subjects of default RoleBinding %d require a name", i)
+			}
+		}
+	}
+	return nil
+}
+
+func (refresh RefreshConfig) validate() error {
+	if len(refresh.Rules) == 0 {
+		return nil
+	}
+	switch refresh.Method {
+	case RefreshMethodWebhook:
+		if refresh.WebhookURL == "" {
+			return errors.New("the webhook method requires a webhook URL to be set")
+		}
+	case RefreshMethodAnnotation:
+	default:
+		return fmt.Errorf("unknown method %q, expected %s or %s", refresh.Method, RefreshMethodWebhook, RefreshMethodAnnotation)
+	}
+	if refresh.Debounce.Duration < 0 {
+		return errors.New("the debounce must not be negative")
+	}
+	if refresh.ResyncInterval.Duration <= 0 {
+		return errors.New("the resync interval must be positive")
+	}
+	for i, rule := range refresh.Rules {
+		if _, err := metav1.LabelSelectorAsSelector(&rule.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label selector of rule %d: %w", i, err)
+		}
+		if len(rule.ApplicationSets) == 0 {
+			return fmt.Errorf("rule %d has no ApplicationSets", i)
+		}
+		for _, appSet := range rule.ApplicationSets {
+			if appSet.Namespace == "" || appSet.Name == "" {
+				return fmt.Errorf("the ApplicationSets of rule %d require a namespace and a name", i)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseCIDRs parses networks in the CIDR notation, e.g. 10.128.0.0/14.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// set parses the value into the field. Boolean settings are enabled by an
+// empty value, so setting their environment variable is enough. Map settings
+// are comma-separated key=value pairs added to the ones already set.
+func set(field any, value string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = value
+	case *bool:
+		if value == "" {
+			*field = true
+			return nil
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+	case *float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+	case *slog.Level:
+		return field.UnmarshalText([]byte(value))
+	case *metav1.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.Duration = parsed
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	case *map[string]bool:
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, raw, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			parsed, err := strconv.ParseBool(strings.TrimSpace(raw))
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", key, err)
+			}
+			if *field == nil {
+				*field = map[string]bool{}
+			}
+			(*field)[strings.TrimSpace(key)] = parsed
+		}
+	case *map[string]string:
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, raw, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			if *field == nil {
+				*field = map[string]string{}
+			}
+			(*field)[strings.TrimSpace(key)] = strings.TrimSpace(raw)
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/namespace-generator/pkg/config"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config Suite")
+}
+
+// setenv sets an environment variable for the duration of the spec.
+func setenv(name, value string) {
+	previous, ok := os.LookupEnv(name)
+	Expect(os.Setenv(name, value)).To(Succeed())
+	DeferCleanup(func() {
+		if ok {
+			Expect(os.Setenv(name, previous)).To(Succeed())
+		} else {
+			Expect(os.Unsetenv(name)).To(Succeed())
+		}
+	})
+}
+
+var _ = Describe("Load", func() {
+	It("should enable the settings predating the file whenever their variable is set", func() {
+		for _, value := range []string{"", "true", "false", "yes"} {
+			setenv("NS_GEN_USE_HTTP", value)
+			cfg, err := config.Load(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Server.UseHTTP).To(BeTrue(), "NS_GEN_USE_HTTP=%q", value)
+		}
+
+		cfg, err := config.Load([]string{"--use-http=false"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Server.UseHTTP).To(BeFalse())
+	})
+
+	It("should override the file with the environment and the environment with the flags", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte("server:\n  address: :6000\n  pprofAddress: :6060\n  maxBodyBytes: 4096\n"), 0o600)).To(Succeed())
+		setenv("NS_GEN_CONFIG", path)
+		setenv("NS_GEN_ADDRESS", ":7000")
+		setenv("NS_GEN_PPROF_ADDRESS", ":7070")
+
+		cfg, err := config.Load([]string{"--address=:8000"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Path).To(Equal(path))
+		Expect(cfg.Server.Address).To(Equal(":8000"))
+		Expect(cfg.Server.PprofAddress).To(Equal(":7070"))
+		Expect(cfg.Server.MaxBodyBytes).To(Equal(4096))
+	})
+})
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch calls reload when the process receives SIGHUP, or when the content of
+// the file at path changes. The file is polled every interval, since mounted
+// ConfigMaps are updated by swapping symlinks, which file notifications don't
+// follow reliably. Watch returns when the context is done.
+func Watch(ctx context.Context, path string, interval time.Duration, reload func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	var poll <-chan time.Time
+	if path != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	last := fileSum(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			last = fileSum(path)
+			reload()
+		case <-poll:
+			// A file being replaced may be missing for a moment, it's then
+			// reloaded again once it's back.
+			if current := fileSum(path); current != last {
+				last = current
+				reload()
+			}
+		}
+	}
+}
+
+// fileSum returns the checksum of the content of the file, or a zero sum if
+// it can't be read.
+func fileSum(path string) [sha256.Size]byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
@@ -0,0 +1,116 @@
+// Package errors classifies the failures of generate requests, so a failure is
+// reported with the same code in the HTTP responses, the metrics and the audit
+// log. Errors are classified by wrapping them with a kind, e.g.
+// Wrap(ErrSecretNotFound, err), and stay usable with the standard errors.Is
+// and errors.As.
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Kind is a class of failures. Kinds are errors themselves, so they can be
+// returned as is when there is no underlying error.
+type Kind struct {
+	// Code identifies the kind in the responses, the metrics and the audit
+	// events, e.g. SecretNotFound.
+	Code string
+	// Status is the HTTP status of the requests failing with this kind.
+	Status  int
+	message string
+}
+
+func (kind *Kind) Error() string {
+	return kind.message
+}
+
+var (
+	// ErrInvalidRequest is a request which can't be parsed or has invalid
+	// parameters.
+	ErrInvalidRequest = &Kind{Code: "InvalidRequest", Status: http.StatusBadRequest, message: "invalid request"}
+	// ErrSelectorInvalid is a label selector which can't be parsed.
+	ErrSelectorInvalid = &Kind{Code: "SelectorInvalid", Status: http.StatusBadRequest, message: "invalid label selector"}
+	// ErrMatchAllForbidden is an empty label selector, which matches all the
+	// namespaces, without the request and the server allowing it.
+	ErrMatchAllForbidden = &Kind{Code: "MatchAllForbidden", Status: http.StatusForbidden, message: "matching all the namespaces isn't allowed"}
+	// ErrClusterForbidden is a cluster the filters don't allow.
+	ErrClusterForbidden = &Kind{Code: "ClusterForbidden", Status: http.StatusForbidden, message: "cluster isn't allowed"}
+	// ErrRequestDenied is a request the authorization policy denies.
+	ErrRequestDenied = &Kind{Code: "RequestDenied", Status: http.StatusForbidden, message: "request denied by the authorization policy"}
+	// ErrVisibilityDenied is a request the NamespaceVisibilityPolicies of its
+	// consumer don't allow.
+	ErrVisibilityDenied = &Kind{Code: "VisibilityDenied", Status: http.StatusForbidden, message: "request denied by the namespace visibility policies"}
+	// ErrImpersonationForbidden is an identity the request may not
+	// impersonate, or which isn't allowed to list namespaces.
+	ErrImpersonationForbidden = &Kind{Code: "ImpersonationForbidden", Status: http.StatusForbidden, message: "impersonated identity isn't allowed"}
+	// ErrQuotaExceeded is a request generating more namespaces or targeting
+	// more clusters than the quota of its ApplicationSet.
+	ErrQuotaExceeded = &Kind{Code: "QuotaExceeded", Status: http.StatusForbidden, message: "quota of the ApplicationSet exceeded"}
+	// ErrSecretNotFound is a cluster without an ArgoCD cluster secret.
+	ErrSecretNotFound = &Kind{Code: "SecretNotFound", Status: http.StatusNotFound, message: "cluster secret not found"}
+	// ErrSecretInvalid is a cluster secret lacking the server or the config,
+	// or whose config can't be parsed.
+	ErrSecretInvalid = &Kind{Code: "SecretInvalid", Status: http.StatusInternalServerError, message: "invalid cluster secret"}
+	// ErrAuthFailed is a token which can't be obtained, or which is rejected
+	// by the cluster.
+	ErrAuthFailed = &Kind{Code: "AuthFailed", Status: http.StatusBadGateway, message: "authentication to the cluster failed"}
+	// ErrClusterUnreachable is a remote cluster whose API server can't be
+	// called.
+	ErrClusterUnreachable = &Kind{Code: "ClusterUnreachable", Status: http.StatusBadGateway, message: "cluster is unreachable"}
+	// ErrCursorExpired is a change feed cursor older than the changes kept
+	// by the server.
+	ErrCursorExpired = &Kind{Code: "CursorExpired", Status: http.StatusGone, message: "cursor expired"}
+	// ErrTimeout is a request which exceeded its timeout, or one of its
+	// stages.
+	ErrTimeout = &Kind{Code: "Timeout", Status: http.StatusGatewayTimeout, message: "request timed out"}
+	// ErrInternal is any other failure.
+	ErrInternal = &Kind{Code: "Internal", Status: http.StatusInternalServerError, message: "internal error"}
+)
+
+// kinds are the known kinds, looked up by code.
+var kinds = []*Kind{
+	ErrInvalidRequest, ErrSelectorInvalid, ErrMatchAllForbidden, ErrClusterForbidden, ErrRequestDenied,
+	ErrVisibilityDenied, ErrImpersonationForbidden, ErrQuotaExceeded, ErrSecretNotFound, ErrSecretInvalid,
+	ErrAuthFailed, ErrClusterUnreachable, ErrCursorExpired, ErrTimeout, ErrInternal,
+}
+
+// ByCode returns the kind with the given code, or nil if the code is unknown.
+func ByCode(code string) *Kind {
+	for _, kind := range kinds {
+		if kind.Code == code {
+			return kind
+		}
+	}
+	return nil
+}
+
+// Wrap classifies err as kind. The returned error wraps both, so err can
+// still be inspected.
+func Wrap(kind *Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// KindOf returns the kind err was classified as. Unclassified errors are
+// ErrInternal. When an error is classified more than once, the outermost
+// kind wins.
+func KindOf(err error) *Kind {
+	var kind *Kind
+	if errors.As(err, &kind) {
+		return kind
+	}
+	return ErrInternal
+}
+
+// CodeOf returns the code of the kind of err, or an empty string for a nil
+// error.
+func CodeOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	return KindOf(err).Code
+}
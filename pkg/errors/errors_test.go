@@ -0,0 +1,79 @@
+package errors_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+)
+
+func TestErrors(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Errors Suite")
+}
+
+var _ = Describe("Kind", func() {
+	It("should have a code and an HTTP status", func() {
+		for _, entry := range []struct {
+			kind   *generrors.Kind
+			code   string
+			status int
+		}{
+			{generrors.ErrInvalidRequest, "InvalidRequest", http.StatusBadRequest},
+			{generrors.ErrSelectorInvalid, "SelectorInvalid", http.StatusBadRequest},
+			{generrors.ErrMatchAllForbidden, "MatchAllForbidden", http.StatusForbidden},
+			{generrors.ErrClusterForbidden, "ClusterForbidden", http.StatusForbidden},
+			{generrors.ErrRequestDenied, "RequestDenied", http.StatusForbidden},
+			{generrors.ErrVisibilityDenied, "VisibilityDenied", http.StatusForbidden},
+			{generrors.ErrImpersonationForbidden, "ImpersonationForbidden", http.StatusForbidden},
+			{generrors.ErrQuotaExceeded, "QuotaExceeded", http.StatusForbidden},
+			{generrors.ErrSecretNotFound, "SecretNotFound", http.StatusNotFound},
+			{generrors.ErrSecretInvalid, "SecretInvalid", http.StatusInternalServerError},
+			{generrors.ErrAuthFailed, "AuthFailed", http.StatusBadGateway},
+			{generrors.ErrClusterUnreachable, "ClusterUnreachable", http.StatusBadGateway},
+			{generrors.ErrCursorExpired, "CursorExpired", http.StatusGone},
+			{generrors.ErrTimeout, "Timeout", http.StatusGatewayTimeout},
+			{generrors.ErrInternal, "Internal", http.StatusInternalServerError},
+		} {
+			Expect(entry.kind.Code).To(Equal(entry.code))
+			Expect(entry.kind.Status).To(Equal(entry.status), entry.code)
+			Expect(generrors.ByCode(entry.code)).To(BeIdenticalTo(entry.kind))
+
+			err := generrors.Wrap(entry.kind, io.ErrUnexpectedEOF)
+			Expect(generrors.KindOf(err)).To(BeIdenticalTo(entry.kind))
+			Expect(generrors.CodeOf(err)).To(Equal(entry.code))
+			Expect(errors.Is(err, io.ErrUnexpectedEOF)).To(BeTrue())
+		}
+		Expect(generrors.ByCode("Unknown")).To(BeNil())
+	})
+})
+
+var _ = Describe("KindOf", func() {
+	It("should return the outermost kind of the error", func() {
+		for _, entry := range []struct {
+			description string
+			err         error
+			kind        *generrors.Kind
+			code        string
+		}{
+			{"nil", nil, generrors.ErrInternal, ""},
+			{"unclassified", io.EOF, generrors.ErrInternal, "Internal"},
+			{"kind", generrors.ErrTimeout, generrors.ErrTimeout, "Timeout"},
+			{"wrapped kind", fmt.Errorf("listing: %w", generrors.Wrap(generrors.ErrSecretNotFound, io.EOF)), generrors.ErrSecretNotFound, "SecretNotFound"},
+			{"reclassified", generrors.Wrap(generrors.ErrAuthFailed, generrors.Wrap(generrors.ErrClusterUnreachable, io.EOF)), generrors.ErrAuthFailed, "AuthFailed"},
+		} {
+			Expect(generrors.KindOf(entry.err)).To(BeIdenticalTo(entry.kind), entry.description)
+			Expect(generrors.CodeOf(entry.err)).To(Equal(entry.code), entry.description)
+		}
+	})
+
+	It("should not wrap nil errors", func() {
+		Expect(generrors.Wrap(generrors.ErrInternal, nil)).To(BeNil())
+	})
+})
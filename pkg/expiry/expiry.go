@@ -0,0 +1,175 @@
+// Package expiry expires the namespaces with a TTL annotation, e.g. the
+// namespaces of preview environments. Expired namespaces are excluded from
+// the generated parameters, and optionally deleted.
+package expiry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+const (
+	// TTLAnnotation is how long a namespace lives after its creation, as a
+	// duration such as 72h.
+	TTLAnnotation = "generator.konflux-ci.dev/ttl"
+	// ExpiredLabel is set to true on the expired namespaces by the
+	// Reconciler. Changing the labels invalidates the cached responses and
+	// refreshes the ApplicationSets watching the namespaces.
+	ExpiredLabel = "generator.konflux-ci.dev/expired"
+)
+
+// TTL returns the TTL of a namespace, false if it has none. An invalid TTL
+// is an error.
+func TTL(object metav1.Object) (time.Duration, bool, error) {
+	value, ok := object.GetAnnotations()[TTLAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid TTL %q: %w", value, err)
+	}
+	if ttl < 0 {
+		return 0, false, fmt.Errorf("invalid TTL %q: must not be negative", value)
+	}
+	return ttl, true, nil
+}
+
+// ExpiresAt returns when a namespace expires, false if it never does.
+// Namespaces with an invalid TTL never expire.
+func ExpiresAt(object metav1.Object) (time.Time, bool) {
+	ttl, ok, err := TTL(object)
+	if err != nil || !ok {
+		return time.Time{}, false
+	}
+	return object.GetCreationTimestamp().Add(ttl), true
+}
+
+// Expired reports whether a namespace is expired at the given time, or was
+// labeled as expired.
+func Expired(object metav1.Object, now time.Time) bool {
+	if object.GetLabels()[ExpiredLabel] == "true" {
+		return true
+	}
+	expiresAt, ok := ExpiresAt(object)
+	return ok && !now.Before(expiresAt)
+}
+
+// Options configures a Reconciler.
+type Options struct {
+	// Interval is how often the namespaces are checked. The namespaces
+	// expiring sooner are checked when they expire.
+	Interval time.Duration
+	// Delete deletes the expired namespaces instead of only labeling them.
+	Delete bool
+}
+
+// Reconciler labels the expired namespaces of the local cluster, and deletes
+// them if configured to. It must only run on one replica.
+type Reconciler struct {
+	client  client.Client
+	options Options
+	logger  *slog.Logger
+}
+
+func NewReconciler(cl client.Client, options Options, logger *slog.Logger) *Reconciler {
+	return &Reconciler{client: cl, options: options, logger: logger}
+}
+
+// Run expires the namespaces until the context is done.
+func (reconciler *Reconciler) Run(ctx context.Context) {
+	for {
+		wait := reconciler.options.Interval
+		next, err := reconciler.expire(ctx, time.Now())
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			reconciler.logger.Error("Failed to expire the namespaces", logging.KeyError, err)
+		} else if !next.IsZero() {
+			wait = min(wait, max(time.Until(next), 0))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// expire labels or deletes the expired namespaces, and returns when the next
+// namespace expires, zero if none will.
+func (reconciler *Reconciler) expire(ctx context.Context, now time.Time) (time.Time, error) {
+	nsList := &corev1.NamespaceList{}
+	if err := reconciler.client.List(ctx, nsList); err != nil {
+		return time.Time{}, err
+	}
+
+	var next time.Time
+	for i := range nsList.Items {
+		ns := &nsList.Items[i]
+		if _, _, err := TTL(ns); err != nil {
+			reconciler.logger.Warn("Ignoring the TTL of the namespace", "namespace", ns.Name, logging.KeyError, err)
+			continue
+		}
+		expiresAt, ok := ExpiresAt(ns)
+		if !ok || ns.DeletionTimestamp != nil {
+			continue
+		}
+		if now.Before(expiresAt) {
+			if next.IsZero() || expiresAt.Before(next) {
+				next = expiresAt
+			}
+			continue
+		}
+
+		if err := reconciler.expireNamespace(ctx, ns); err != nil && !apierrors.IsNotFound(err) {
+			// The other namespaces are still expired.
+			reconciler.logger.Error("Failed to expire the namespace", "namespace", ns.Name, logging.KeyError, err)
+		}
+	}
+	return next, nil
+}
+
+func (reconciler *Reconciler) expireNamespace(ctx context.Context, ns *corev1.Namespace) error {
+	if reconciler.options.Delete {
+		reconciler.logger.Info("Deleting the expired namespace", "namespace", ns.Name, "created", ns.CreationTimestamp.Time)
+		if err := reconciler.client.Delete(ctx, ns); err != nil {
+			return err
+		}
+		metrics.ExpiredNamespaces.WithLabelValues("deleted").Inc()
+		return nil
+	}
+	if ns.Labels[ExpiredLabel] == "true" {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]string{ExpiredLabel: "true"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	reconciler.logger.Info("Labeling the expired namespace", "namespace", ns.Name, "created", ns.CreationTimestamp.Time)
+	if err := reconciler.client.Patch(ctx, ns, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return err
+	}
+	metrics.ExpiredNamespaces.WithLabelValues("labeled").Inc()
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package features holds the feature gates of the generator. Risky behaviors
+// are shipped behind a gate, so they can be enabled or disabled per
+// deployment with the featureGates setting.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Feature is the name of a feature gate.
+type Feature string
+
+// Stage is the maturity of a feature.
+type Stage string
+
+const (
+	// Alpha features are disabled by default and may change or be removed.
+	Alpha Stage = "ALPHA"
+	// Beta features are enabled by default and can still be disabled.
+	Beta Stage = "BETA"
+	// GA features are always enabled. Their gate is kept until it's removed
+	// from the configurations.
+	GA Stage = "GA"
+)
+
+// Spec describes a feature gate.
+type Spec struct {
+	Default bool
+	Stage   Stage
+}
+
+const (
+	// ClusterSecretEvents emits Events on the cluster secrets which are
+	// malformed or whose cluster is unreachable.
+	ClusterSecretEvents Feature = "ClusterSecretEvents"
+
+	// CELAuthorization rejects the requests denied by the CEL authorization
+	// policy of the filters.
+	CELAuthorization Feature = "CELAuthorization"
+
+	// FaultInjection injects the faults of the faultInjection setting in the
+	// requests, to test how the ApplicationSets behave when the generator
+	// degrades. It must never be enabled in production.
+	FaultInjection Feature = "FaultInjection"
+)
+
+// defaultFeatures are the known features.
+var defaultFeatures = map[Feature]Spec{
+	ClusterSecretEvents: {Default: true, Stage: Beta},
+	CELAuthorization:    {Default: false, Stage: Alpha},
+	FaultInjection:      {Default: false, Stage: Alpha},
+}
+
+// Gate tells whether the features are enabled.
+type Gate struct {
+	specs map[Feature]Spec
+
+	mu      sync.RWMutex
+	enabled map[Feature]bool
+}
+
+// NewGate returns a gate for the given features, with all of them set to
+// their default.
+func NewGate(specs map[Feature]Spec) *Gate {
+	return &Gate{specs: specs, enabled: map[Feature]bool{}}
+}
+
+// Validate returns an error if a feature is unknown or a GA feature is
+// disabled.
+func (gate *Gate) Validate(values map[string]bool) error {
+	for name, enabled := range values {
+		spec, ok := gate.specs[Feature(name)]
+		if !ok {
+			return fmt.Errorf("unknown feature gate %s, known gates: %s", name, strings.Join(gate.Known(), ", "))
+		}
+		if spec.Stage == GA && !enabled {
+			return fmt.Errorf("feature gate %s is GA and can't be disabled", name)
+		}
+	}
+	return nil
+}
+
+// Set enables or disables the features by name. Nothing is changed if the
+// values aren't valid.
+func (gate *Gate) Set(values map[string]bool) error {
+	if err := gate.Validate(values); err != nil {
+		return err
+	}
+
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+	for name, enabled := range values {
+		gate.enabled[Feature(name)] = enabled
+	}
+	return nil
+}
+
+// Enabled reports whether the feature is enabled. Unknown features are
+// disabled.
+func (gate *Gate) Enabled(feature Feature) bool {
+	gate.mu.RLock()
+	defer gate.mu.RUnlock()
+
+	if enabled, ok := gate.enabled[feature]; ok {
+		return enabled
+	}
+	return gate.specs[feature].Default
+}
+
+// States returns whether each known feature is enabled, keyed by name.
+func (gate *Gate) States() map[string]bool {
+	states := make(map[string]bool, len(gate.specs))
+	for feature := range gate.specs {
+		states[string(feature)] = gate.Enabled(feature)
+	}
+	return states
+}
+
+// Known describes the known features, e.g. "ClusterSecretEvents=true|false
+// (BETA - default=true)".
+func (gate *Gate) Known() []string {
+	known := make([]string, 0, len(gate.specs))
+	for feature, spec := range gate.specs {
+		known = append(known, fmt.Sprintf("%s=true|false (%s - default=%t)", feature, spec.Stage, spec.Default))
+	}
+	sort.Strings(known)
+	return known
+}
+
+// Default is the gate of the generator, set from the configuration on
+// startup.
+var Default = NewGate(defaultFeatures)
+
+// Enabled reports whether the feature is enabled by the default gate.
+func Enabled(feature Feature) bool {
+	return Default.Enabled(feature)
+}
@@ -0,0 +1,182 @@
+// Package generationreport writes the outcome of the generation requests to
+// GenerationReport resources, one per route and cluster.
+package generationreport
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// maxNameLength is the maximum length of the name of a resource.
+const maxNameLength = 253
+
+type reportKey struct {
+	route   string
+	cluster string
+}
+
+// pendingResults are the results recorded since the last flush.
+type pendingResults struct {
+	successes   int64
+	failures    int64
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	namespaces  int
+}
+
+func (pending *pendingResults) merge(newer *pendingResults) {
+	pending.successes += newer.successes
+	pending.failures += newer.failures
+	if newer.lastSuccess.After(pending.lastSuccess) {
+		pending.lastSuccess = newer.lastSuccess
+		pending.namespaces = newer.namespaces
+	}
+	if newer.lastFailure.After(pending.lastFailure) {
+		pending.lastFailure = newer.lastFailure
+		pending.lastError = newer.lastError
+	}
+}
+
+// Reporter collects the results of the requests and periodically adds them
+// to the reports, so the API server isn't called for every request. The
+// reports are updated with optimistic concurrency, so replicas can share them.
+type Reporter struct {
+	client    client.Client
+	namespace string
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	pending map[reportKey]*pendingResults
+}
+
+// NewReporter returns a reporter writing the reports in the given namespace.
+func NewReporter(cl client.Client, namespace string, logger *slog.Logger) *Reporter {
+	return &Reporter{
+		client:    cl,
+		namespace: namespace,
+		logger:    logger,
+		pending:   map[reportKey]*pendingResults{},
+	}
+}
+
+// Record adds the result of a request to the report of the route and the
+// cluster. A nil error is a success.
+func (reporter *Reporter) Record(route, cluster string, namespaces int, err error) {
+	result := &pendingResults{}
+	if err != nil {
+		result.failures, result.lastFailure, result.lastError = 1, time.Now(), logging.RedactString(err.Error())
+	} else {
+		result.successes, result.lastSuccess, result.namespaces = 1, time.Now(), namespaces
+	}
+	reporter.add(reportKey{route: route, cluster: cluster}, result)
+}
+
+func (reporter *Reporter) add(key reportKey, result *pendingResults) {
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+
+	if pending, ok := reporter.pending[key]; ok {
+		pending.merge(result)
+	} else {
+		reporter.pending[key] = result
+	}
+}
+
+// Run writes the recorded results every interval until the context is done,
+// and once more before returning.
+func (reporter *Reporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			reporter.Flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			reporter.Flush(ctx)
+		}
+	}
+}
+
+// Flush writes the results recorded since the last flush. Results which
+// can't be written are kept for the next flush.
+func (reporter *Reporter) Flush(ctx context.Context) {
+	reporter.mu.Lock()
+	pending := reporter.pending
+	reporter.pending = map[reportKey]*pendingResults{}
+	reporter.mu.Unlock()
+
+	for key, results := range pending {
+		if err := reporter.write(ctx, key, results); err != nil {
+			reporter.logger.Error("Failed to write the generation report", "route", key.route, logging.KeyCluster, key.cluster, logging.KeyError, err)
+			reporter.add(key, results)
+		}
+	}
+}
+
+func (reporter *Reporter) write(ctx context.Context, key reportKey, results *pendingResults) error {
+	name := reportName(key)
+	// The report may be created by another replica in the meantime.
+	retriable := func(err error) bool { return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) }
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		report := &generatorv1alpha1.GenerationReport{}
+		err := reporter.client.Get(ctx, client.ObjectKey{Namespace: reporter.namespace, Name: name}, report)
+		if apierrors.IsNotFound(err) {
+			report = &generatorv1alpha1.GenerationReport{
+				ObjectMeta: metav1.ObjectMeta{Namespace: reporter.namespace, Name: name},
+				Spec:       generatorv1alpha1.GenerationReportSpec{Route: key.route, Cluster: key.cluster},
+			}
+			err = reporter.client.Create(ctx, report)
+		}
+		if err != nil {
+			return err
+		}
+
+		status := &report.Status
+		status.Successes += results.successes
+		status.Failures += results.failures
+		if !results.lastSuccess.IsZero() && (status.LastSuccessTime == nil || results.lastSuccess.After(status.LastSuccessTime.Time)) {
+			status.LastSuccessTime = &metav1.Time{Time: results.lastSuccess}
+			status.Namespaces = results.namespaces
+		}
+		if !results.lastFailure.IsZero() && (status.LastFailureTime == nil || results.lastFailure.After(status.LastFailureTime.Time)) {
+			status.LastFailureTime = &metav1.Time{Time: results.lastFailure}
+			status.LastError = results.lastError
+		}
+		return reporter.client.Status().Update(ctx, report)
+	})
+}
+
+// reportName returns the name of the report of a route and a cluster, e.g.
+// api-v1-getparams.execute-in-cluster. Names too long for a resource are
+// shortened and suffixed with a hash, so they stay unique.
+func reportName(key reportKey) string {
+	route := strings.Trim(strings.NewReplacer("/", "-", "_", "-", ":", "-").Replace(strings.ToLower(key.route)), "-.")
+	name := route + "-" + key.cluster
+	if len(name) <= maxNameLength {
+		return name
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	return strings.TrimRight(name[:maxNameLength-len(suffix)], "-.") + suffix
+}
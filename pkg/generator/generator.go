@@ -0,0 +1,296 @@
+// Package generator generates the parameters of the namespaces matching the
+// requests of the ApplicationSet plugin. It doesn't depend on a transport, so
+// it can be embedded in other controllers and tested without a server. The
+// HTTP server adds its caches, policies, audit and observability on top of
+// the same functions.
+package generator
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+	"github.com/konflux-ci/namespace-generator/pkg/clusterconfig"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/expiry"
+)
+
+// Annotations OpenShift sets on the namespaces of projects.
+const (
+	DisplayNameAnnotation = "openshift.io/display-name"
+	DescriptionAnnotation = "openshift.io/description"
+	RequesterAnnotation   = "openshift.io/requester"
+)
+
+// namespaceListPageSize is the number of namespaces listed per call when
+// listing from an API server.
+const namespaceListPageSize = 500
+
+// DefaultArgoCDNamespace is the namespace of the ArgoCD cluster secrets when
+// none is set.
+const DefaultArgoCDNamespace = "argocd"
+
+// Options configures a Generator.
+type Options struct {
+	// ArgoCDNamespace holds the cluster secrets. It defaults to
+	// DefaultArgoCDNamespace.
+	ArgoCDNamespace string
+	// AuthProvider authenticates the calls to the remote clusters. Requests
+	// for remote clusters fail without one, unless RemoteReaders is set.
+	AuthProvider auth.Provider
+	// RemoteReaders replaces the clients created from the cluster secrets,
+	// e.g. with fakes in tests.
+	RemoteReaders RemoteReaderFactory
+}
+
+// RemoteReaderFactory returns a reader of the namespaces of the cluster of a
+// cluster secret.
+type RemoteReaderFactory func(ctx context.Context, clusterName string) (client.Reader, error)
+
+// Generator serves generate requests with a client of the local cluster,
+// which reads the namespaces of the local cluster and the cluster secrets of
+// the remote ones. Clients of the remote clusters are created per request.
+type Generator struct {
+	local   client.Reader
+	options Options
+}
+
+func New(local client.Reader, options Options) *Generator {
+	if options.ArgoCDNamespace == "" {
+		options.ArgoCDNamespace = DefaultArgoCDNamespace
+	}
+	return &Generator{local: local, options: options}
+}
+
+// Generate returns the parameters of the namespaces matching the request.
+// The errors are classified with the kinds of pkg/errors.
+func (generator *Generator) Generate(ctx context.Context, req *v1alpha2.GenerateRequest) (*v1alpha2.GenerateResponse, error) {
+	parameters := req.Input.Parameters
+	selector, err := metav1.LabelSelectorAsSelector(&parameters.LabelSelector)
+	if err != nil {
+		return nil, generrors.Wrap(generrors.ErrSelectorInvalid, err)
+	}
+
+	namespaces, err := generator.ListNamespaces(ctx, parameters.ClusterName, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := sets.New(parameters.ExcludeNamespaces...)
+	now := time.Now()
+	response := &v1alpha2.GenerateResponse{}
+	for i := range namespaces {
+		if excluded.Has(namespaces[i].Name) || expiry.Expired(&namespaces[i], now) {
+			continue
+		}
+		out := OutParameters(&namespaces[i], parameters.ClusterName, parameters.LabelKeys)
+		AddAnnotations(&out, &namespaces[i], parameters.AnnotationKeys, parameters.OpenShiftProject)
+		response.Output.Parameters = append(response.Output.Parameters, out)
+	}
+	return response, nil
+}
+
+// ListNamespaces returns the metadata of the namespaces matching the selector
+// on the local cluster, or on the remote cluster of the cluster secret if
+// clusterName is set.
+func (generator *Generator) ListNamespaces(ctx context.Context, clusterName string, selector labels.Selector) ([]metav1.PartialObjectMetadata, error) {
+	nsList := NewNamespaceList()
+	if clusterName == "" {
+		if err := generator.local.List(ctx, nsList, &client.ListOptions{LabelSelector: selector}); err != nil {
+			return nil, err
+		}
+		return nsList.Items, nil
+	}
+
+	if generator.options.RemoteReaders != nil {
+		remoteReader, err := generator.options.RemoteReaders(ctx, clusterName)
+		if err != nil {
+			return nil, err
+		}
+		if err := remoteReader.List(ctx, nsList, &client.ListOptions{LabelSelector: selector}); err != nil {
+			return nil, ClassifyRemoteError(err)
+		}
+		return nsList.Items, nil
+	}
+
+	remoteClient, err := generator.RemoteClient(ctx, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	if err := ListNamespacePages(ctx, remoteClient, nsList, selector); err != nil {
+		return nil, ClassifyRemoteError(err)
+	}
+	return nsList.Items, nil
+}
+
+// RemoteClient returns a client of the cluster described by the cluster
+// secret, authenticated by the auth provider.
+func (generator *Generator) RemoteClient(ctx context.Context, clusterName string) (client.Client, error) {
+	if generator.options.AuthProvider == nil {
+		return nil, generrors.Wrap(generrors.ErrAuthFailed, errors.New("no auth provider is set for the remote clusters"))
+	}
+	secret, err := GetClusterSecret(ctx, generator.local, generator.options.ArgoCDNamespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return NewRemoteClient(ctx, generator.local, secret, generator.options.AuthProvider)
+}
+
+// NewRemoteClient returns a client of the cluster described by an ArgoCD
+// cluster secret, authenticated by the auth provider. A token is obtained
+// before returning, so authentication failures are reported here rather than
+// by the first call. The CA ConfigMap referenced by the secret is read with
+// the local reader, which may be nil for the secrets embedding their CA.
+func NewRemoteClient(ctx context.Context, local client.Reader, secret *corev1.Secret, authProvider auth.Provider) (client.Client, error) {
+	cfg, err := clusterconfig.LoadRESTConfig(ctx, local, secret)
+	if err != nil {
+		return nil, generrors.Wrap(generrors.ErrSecretInvalid, err)
+	}
+	if _, err := authProvider.Token(ctx); err != nil {
+		return nil, generrors.Wrap(generrors.ErrAuthFailed, err)
+	}
+	cfg.Wrap(auth.WrapTransport(authProvider))
+
+	remoteClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, ClassifyRemoteError(err)
+	}
+	return remoteClient, nil
+}
+
+// GetClusterSecret gets an ArgoCD cluster secret. A missing secret is
+// classified as ErrSecretNotFound.
+func GetClusterSecret(ctx context.Context, cl client.Reader, namespace, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, generrors.Wrap(generrors.ErrSecretNotFound, err)
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+// NewNamespaceList returns a list for namespace metadata. Only the metadata of
+// namespaces is used, so it's all that's listed and cached.
+func NewNamespaceList() *metav1.PartialObjectMetadataList {
+	nsList := &metav1.PartialObjectMetadataList{}
+	nsList.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("NamespaceList"))
+	return nsList
+}
+
+// NewProjectList returns a list for the metadata of OpenShift projects. The
+// metadata of a project is the one of its namespace, so it's listed in place
+// of the namespaces by the identities only allowed to list their projects.
+func NewProjectList() *metav1.PartialObjectMetadataList {
+	projectList := &metav1.PartialObjectMetadataList{}
+	projectList.SetGroupVersionKind(schema.GroupVersionKind{Group: "project.openshift.io", Version: "v1", Kind: "ProjectList"})
+	return projectList
+}
+
+// ListNamespacePages lists the namespaces matching the selector in pages, so
+// a large cluster isn't listed with a single expensive call. It must only be
+// used with clients reading from an API server, as caches don't paginate.
+func ListNamespacePages(ctx context.Context, cl client.Reader, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	return listPages(ctx, cl, nsList, selector, NewNamespaceList)
+}
+
+// ListProjectPages lists the OpenShift projects matching the selector into
+// nsList, like ListNamespacePages.
+func ListProjectPages(ctx context.Context, cl client.Reader, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	return listPages(ctx, cl, nsList, selector, NewProjectList)
+}
+
+func listPages(ctx context.Context, cl client.Reader, nsList *metav1.PartialObjectMetadataList, selector labels.Selector, newList func() *metav1.PartialObjectMetadataList) error {
+	nsList.Items = nil
+	continueToken := ""
+	for {
+		page := newList()
+		err := cl.List(ctx, page, &client.ListOptions{
+			LabelSelector: selector,
+			Limit:         namespaceListPageSize,
+			Continue:      continueToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		nsList.Items = append(nsList.Items, page.Items...)
+		nsList.ResourceVersion = page.ResourceVersion
+		if continueToken = page.Continue; continueToken == "" {
+			return nil
+		}
+	}
+}
+
+// OutParameters returns the parameters generated for a namespace, with its
+// phase and the values of the requested label keys it has.
+func OutParameters(namespace *metav1.PartialObjectMetadata, clusterName string, labelKeys []string) v1alpha2.OutParameters {
+	parameters := v1alpha2.OutParameters{Namespace: namespace.Name, ClusterName: clusterName, Phase: namespacePhase(namespace)}
+	for _, key := range labelKeys {
+		if value, ok := namespace.Labels[key]; ok {
+			if parameters.Labels == nil {
+				parameters.Labels = map[string]string{}
+			}
+			parameters.Labels[key] = value
+		}
+	}
+	return parameters
+}
+
+// AddAnnotations adds the values of the requested annotation keys a namespace
+// has to its parameters, and the metadata of its OpenShift project if
+// requested. The metadata is read from the annotations of the namespace, so
+// it doesn't require access to the projects API.
+func AddAnnotations(parameters *v1alpha2.OutParameters, namespace *metav1.PartialObjectMetadata, annotationKeys []string, openshiftProject bool) {
+	for _, key := range annotationKeys {
+		if value, ok := namespace.Annotations[key]; ok {
+			if parameters.Annotations == nil {
+				parameters.Annotations = map[string]string{}
+			}
+			parameters.Annotations[key] = value
+		}
+	}
+	if !openshiftProject {
+		return
+	}
+	project := v1alpha2.ProjectMetadata{
+		DisplayName: namespace.Annotations[DisplayNameAnnotation],
+		Description: namespace.Annotations[DescriptionAnnotation],
+		Requester:   namespace.Annotations[RequesterAnnotation],
+	}
+	if project != (v1alpha2.ProjectMetadata{}) {
+		parameters.Project = &project
+	}
+}
+
+// namespacePhase returns the phase of a namespace from its metadata, as the
+// namespaces are listed without their status. A namespace is terminating
+// from the time its deletion is requested.
+func namespacePhase(namespace *metav1.PartialObjectMetadata) string {
+	if namespace.DeletionTimestamp != nil {
+		return string(corev1.NamespaceTerminating)
+	}
+	return string(corev1.NamespaceActive)
+}
+
+// ClassifyRemoteError classifies an error returned by a remote cluster.
+// Credentials rejected by the cluster are authentication failures, and any
+// other error makes the cluster unreachable.
+func ClassifyRemoteError(err error) error {
+	if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+		return generrors.Wrap(generrors.ErrAuthFailed, err)
+	}
+	return generrors.Wrap(generrors.ErrClusterUnreachable, err)
+}
@@ -0,0 +1,112 @@
+package generator_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/expiry"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+)
+
+func TestGenerator(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Generator Suite")
+}
+
+func namespace(name string, labels map[string]string) *core.Namespace {
+	return &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// expiring returns a preview namespace whose TTL elapses after the given
+// duration.
+func expiring(name string, remaining time.Duration) *core.Namespace {
+	ns := namespace(name, map[string]string{"konflux.ci/type": "preview"})
+	ns.CreationTimestamp = metav1.NewTime(time.Now().Add(remaining - 2*time.Hour))
+	ns.Annotations = map[string]string{expiry.TTLAnnotation: "2h"}
+	return ns
+}
+
+// terminating returns a namespace whose deletion was requested.
+func terminating(name string, labels map[string]string) *core.Namespace {
+	ns := namespace(name, labels)
+	ns.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	ns.Finalizers = []string{"kubernetes"}
+	return ns
+}
+
+var _ = Describe("Generator", func() {
+	var gen *generator.Generator
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(core.AddToScheme(scheme)).To(Succeed())
+		local := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			namespace("ns1", map[string]string{"konflux.ci/type": "user", "team": "a"}),
+			namespace("ns2", map[string]string{"konflux.ci/type": "user"}),
+			namespace("ns3", nil),
+			expiring("preview1", -time.Hour),
+			expiring("preview2", time.Hour),
+			terminating("old", map[string]string{"konflux.ci/type": "legacy"}),
+		).Build()
+		gen = generator.New(local, generator.Options{})
+	})
+
+	request := func(parameters v1alpha2.InParameters) *v1alpha2.GenerateRequest {
+		return &v1alpha2.GenerateRequest{Input: v1alpha2.Input{Parameters: parameters}}
+	}
+
+	It("should return the local namespaces matching the selector", func() {
+		response, err := gen.Generate(context.Background(), request(v1alpha2.InParameters{
+			LabelSelector:     metav1.LabelSelector{MatchLabels: map[string]string{"konflux.ci/type": "user"}},
+			ExcludeNamespaces: []string{"ns2"},
+			LabelKeys:         []string{"team"},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Output.Parameters).To(Equal([]v1alpha2.OutParameters{
+			{Namespace: "ns1", Labels: map[string]string{"team": "a"}, Phase: "Active"},
+		}))
+	})
+
+	It("should report the terminating namespaces", func() {
+		response, err := gen.Generate(context.Background(), request(v1alpha2.InParameters{
+			LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"konflux.ci/type": "legacy"}},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Output.Parameters).To(Equal([]v1alpha2.OutParameters{{Namespace: "old", Phase: "Terminating"}}))
+	})
+
+	It("should exclude the expired namespaces", func() {
+		response, err := gen.Generate(context.Background(), request(v1alpha2.InParameters{
+			LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"konflux.ci/type": "preview"}},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Output.Parameters).To(Equal([]v1alpha2.OutParameters{{Namespace: "preview2", Phase: "Active"}}))
+	})
+
+	It("should classify an invalid selector", func() {
+		_, err := gen.Generate(context.Background(), request(v1alpha2.InParameters{
+			LabelSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "a", Operator: "Bad"}}},
+		}))
+		Expect(generrors.KindOf(err)).To(Equal(generrors.ErrSelectorInvalid))
+	})
+
+	It("should fail remote clusters without an auth provider", func() {
+		_, err := gen.Generate(context.Background(), request(v1alpha2.InParameters{
+			LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"konflux.ci/type": "user"}},
+			ClusterName:   "remote1",
+		}))
+		Expect(generrors.KindOf(err)).To(Equal(generrors.ErrAuthFailed))
+	})
+})
@@ -0,0 +1,122 @@
+// Package generatorconfig follows the GeneratorConfig resource configuring
+// the generator.
+package generatorconfig
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const watchRetryInterval = 5 * time.Second
+
+// Watcher calls onChange with the spec of the named GeneratorConfig when it
+// changes, and with an empty spec when it doesn't exist.
+type Watcher struct {
+	client   client.WithWatch
+	name     string
+	logger   *slog.Logger
+	onChange func(spec generatorv1alpha1.GeneratorConfigSpec)
+}
+
+func NewWatcher(cl client.WithWatch, name string, logger *slog.Logger, onChange func(spec generatorv1alpha1.GeneratorConfigSpec)) *Watcher {
+	return &Watcher{client: cl, name: name, logger: logger, onChange: onChange}
+}
+
+// Get returns the named GeneratorConfig, or nil if it doesn't exist.
+func Get(ctx context.Context, cl client.Reader, name string) (*generatorv1alpha1.GeneratorConfig, error) {
+	generatorConfig := &generatorv1alpha1.GeneratorConfig{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: name}, generatorConfig); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return generatorConfig, nil
+}
+
+// Run follows the resource until the context is done.
+func (watcher *Watcher) Run(ctx context.Context) {
+	for {
+		resourceVersion, err := watcher.sync(ctx)
+		if err == nil {
+			err = watcher.watch(ctx, resourceVersion)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			watcher.logger.Error("Failed to watch GeneratorConfig", "name", watcher.name, logging.KeyError, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// sync reports the current spec and returns the resource version to start
+// watching from.
+func (watcher *Watcher) sync(ctx context.Context) (string, error) {
+	configList := &generatorv1alpha1.GeneratorConfigList{}
+	if err := watcher.client.List(ctx, configList, client.MatchingFields{"metadata.name": watcher.name}); err != nil {
+		return "", err
+	}
+
+	spec := generatorv1alpha1.GeneratorConfigSpec{}
+	if len(configList.Items) > 0 {
+		spec = configList.Items[0].Spec
+	}
+	watcher.onChange(spec)
+	return configList.ResourceVersion, nil
+}
+
+// watch reports changes until the watch is closed by the API server. A nil
+// error means the caller should sync again and restart the watch.
+func (watcher *Watcher) watch(ctx context.Context, resourceVersion string) error {
+	w, err := watcher.client.Watch(ctx, &generatorv1alpha1.GeneratorConfigList{}, &client.ListOptions{
+		Raw: &metav1.ListOptions{
+			FieldSelector:       "metadata.name=" + watcher.name,
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			switch event.Type {
+			case watch.Error:
+				// Most likely the resource version is too old, start over.
+				watcher.logger.Debug("GeneratorConfig watch returned an error", "object", event.Object)
+				return nil
+			case watch.Added, watch.Modified:
+				if generatorConfig, ok := event.Object.(*generatorv1alpha1.GeneratorConfig); ok {
+					watcher.onChange(generatorConfig.Spec)
+				}
+			case watch.Deleted:
+				watcher.onChange(generatorv1alpha1.GeneratorConfigSpec{})
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+type AdminHandler struct {
+	remoteClients *RemoteClientCache
+	responses     *ResponseCache
+}
+
+func NewAdminHandler(remoteClients *RemoteClientCache, responses *ResponseCache) *AdminHandler {
+	return &AdminHandler{remoteClients: remoteClients, responses: responses}
+}
+
+// ListClients reports the cached remote cluster clients, their age, the
+// expiry of the token they use and the outcome of their last calls.
+func (adminHandler *AdminHandler) ListClients(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, &v1alpha1.RemoteClientsResponse{Clients: adminHandler.remoteClients.Status()})
+}
+
+// InvalidateClients drops all the cached remote cluster clients.
+func (adminHandler *AdminHandler) InvalidateClients(ctx echo.Context) error {
+	count := adminHandler.remoteClients.InvalidateAll()
+	loggerFrom(ctx).Info("Invalidated the cached remote clients", "count", count)
+	return ctx.JSON(http.StatusOK, &v1alpha1.InvalidateResponse{Invalidated: count})
+}
+
+// InvalidateClient drops the cached client of a single cluster, of the ArgoCD
+// instance of the instance query parameter if set.
+func (adminHandler *AdminHandler) InvalidateClient(ctx echo.Context) error {
+	clusterName, err := clusterRef(ctx.QueryParam("instance"), ctx.Param("cluster"))
+	if err != nil {
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, err.Error())
+	}
+	if !adminHandler.remoteClients.Invalidate(clusterName) {
+		return errorResponse(ctx, http.StatusNotFound, fmt.Sprintf("no client is cached for cluster %s", clusterName))
+	}
+	loggerFrom(ctx).Info("Invalidated the cached remote client", logging.KeyCluster, clusterName)
+	return ctx.JSON(http.StatusOK, &v1alpha1.InvalidateResponse{Invalidated: 1})
+}
+
+// InvalidateTokens drops the cached token, so the next call to a remote
+// cluster mints a new one.
+func (adminHandler *AdminHandler) InvalidateTokens(ctx echo.Context) error {
+	adminHandler.remoteClients.authProvider.Invalidate()
+	loggerFrom(ctx).Info("Invalidated the cached token")
+	return ctx.JSON(http.StatusOK, &v1alpha1.InvalidateResponse{Invalidated: 1})
+}
+
+// InvalidateResponses drops all the cached generate responses.
+func (adminHandler *AdminHandler) InvalidateResponses(ctx echo.Context) error {
+	count, err := adminHandler.responses.InvalidateAll(ctx.Request().Context())
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to invalidate the shared responses", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusBadGateway, "failed to invalidate the shared responses")
+	}
+	loggerFrom(ctx).Info("Invalidated the cached responses", "count", count)
+	return ctx.JSON(http.StatusOK, &v1alpha1.InvalidateResponse{Invalidated: count})
+}
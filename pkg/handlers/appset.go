@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// Headers identifying the ApplicationSet a request is made for. ArgoCD only
+// sends the name of the ApplicationSet in the body of the generate requests,
+// the headers identify it on every endpoint along with its namespace.
+const (
+	HeaderApplicationSetName      = "X-ApplicationSet-Name"
+	HeaderApplicationSetNamespace = "X-ApplicationSet-Namespace"
+)
+
+const applicationSetIdentityKey = "applicationSetIdentity"
+
+// ApplicationSetIdentity identifies the ApplicationSet a request is made for.
+type ApplicationSetIdentity struct {
+	Name      string
+	Namespace string
+}
+
+func (identity ApplicationSetIdentity) String() string {
+	if identity.Namespace == "" {
+		return identity.Name
+	}
+	return identity.Namespace + "/" + identity.Name
+}
+
+// IdentifyApplicationSet returns a middleware reading the identity of the
+// ApplicationSet from the request headers, so the logs, the metrics, the
+// audit and the rate limits of the request are attributed to it. When
+// required, requests without the headers are rejected with 400.
+func IdentifyApplicationSet(required bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			identity := ApplicationSetIdentity{
+				Name:      ctx.Request().Header.Get(HeaderApplicationSetName),
+				Namespace: ctx.Request().Header.Get(HeaderApplicationSetNamespace),
+			}
+			if identity.Name == "" && identity.Namespace == "" {
+				if required {
+					return errorResponse(ctx, http.StatusBadRequest, fmt.Sprintf("the %s and %s headers are required", HeaderApplicationSetName, HeaderApplicationSetNamespace))
+				}
+				return next(ctx)
+			}
+			if err := validateApplicationSetIdentity(identity); err != nil {
+				return errorResponse(ctx, http.StatusBadRequest, err.Error())
+			}
+
+			ctx.Set(applicationSetIdentityKey, identity)
+			return next(withLogger(ctx, loggerFrom(ctx).With(
+				logging.KeyAppSet, identity.Name,
+				logging.KeyAppSetNamespace, identity.Namespace,
+			)))
+		}
+	}
+}
+
+// validateApplicationSetIdentity requires both headers to hold valid names,
+// as they end up in the logs and the labels of the metrics.
+func validateApplicationSetIdentity(identity ApplicationSetIdentity) error {
+	if errs := validation.IsDNS1123Subdomain(identity.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid %s header: %s", HeaderApplicationSetName, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Label(identity.Namespace); len(errs) > 0 {
+		return fmt.Errorf("invalid %s header: %s", HeaderApplicationSetNamespace, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// applicationSetIdentity returns the identity sent with the request, if any.
+func applicationSetIdentity(ctx echo.Context) (ApplicationSetIdentity, bool) {
+	identity, ok := ctx.Get(applicationSetIdentityKey).(ApplicationSetIdentity)
+	return identity, ok
+}
+
+// requestApplicationSet returns the identity of the ApplicationSet a generate
+// request is made for, from the headers or else from the name in the body.
+func requestApplicationSet(ctx echo.Context, bodyName string) ApplicationSetIdentity {
+	if identity, ok := applicationSetIdentity(ctx); ok {
+		return identity
+	}
+	return ApplicationSetIdentity{Name: bodyName}
+}
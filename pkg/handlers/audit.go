@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+)
+
+type auditorKey struct{}
+
+// Audit returns a middleware that makes the generation requests served by the
+// next handlers recorded by the auditor. A nil auditor disables auditing.
+func Audit(auditor *audit.Auditor) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if auditor == nil {
+			return next
+		}
+		return func(ctx echo.Context) error {
+			return next(withRequestContext(ctx, context.WithValue(ctx.Request().Context(), auditorKey{}, auditor)))
+		}
+	}
+}
+
+// auditGenerate records a generate request served since start, if auditing
+// is enabled. Each request of a batch is recorded separately.
+func auditGenerate(ctx echo.Context, req *v1alpha2.GenerateRequest, start time.Time, response *v1alpha2.GenerateResponse, httpErr *echo.HTTPError) {
+	auditor, ok := ctx.Request().Context().Value(auditorKey{}).(*audit.Auditor)
+	if !ok {
+		return
+	}
+
+	cluster := req.ClusterRef
+	if cluster == "" {
+		cluster = audit.LocalCluster
+	}
+	event := audit.Event{
+		Time:            start,
+		RequestID:       requestID(ctx),
+		Caller:          auditCaller(ctx),
+		Selector:        metav1.FormatLabelSelector(&req.Input.Parameters.LabelSelector),
+		Clusters:        []string{cluster},
+		DurationSeconds: time.Since(start).Seconds(),
+		Outcome:         audit.OutcomeSuccess,
+		Status:          http.StatusOK,
+	}
+	applicationSet := requestApplicationSet(ctx, req.ApplicationSetName)
+	event.ApplicationSet, event.ApplicationSetNamespace = applicationSet.Name, applicationSet.Namespace
+	switch {
+	case httpErr != nil:
+		event.Status = httpErr.Code
+		event.ErrorCode = generrors.CodeOf(httpErr.Internal)
+		event.Outcome = audit.OutcomeFailure
+		if httpErr.Code == http.StatusForbidden {
+			event.Outcome = audit.OutcomeDenied
+		}
+		if message, ok := httpErr.Message.(string); ok {
+			event.Error = message
+		}
+	case response.Stale != nil:
+		event.Outcome = audit.OutcomeStale
+		event.Namespaces = len(response.Output.Parameters)
+	default:
+		event.Namespaces = len(response.Output.Parameters)
+	}
+
+	auditor.Record(event)
+}
+
+// auditProvision records a request provisioning the namespace served since
+// start, once its response is written, if auditing is enabled.
+func auditProvision(ctx echo.Context, namespace string, start time.Time) {
+	auditor, ok := ctx.Request().Context().Value(auditorKey{}).(*audit.Auditor)
+	if !ok {
+		return
+	}
+
+	event := audit.Event{
+		Time:            start,
+		RequestID:       requestID(ctx),
+		Caller:          auditCaller(ctx),
+		Action:          audit.ActionProvision,
+		Namespace:       namespace,
+		Clusters:        []string{audit.LocalCluster},
+		DurationSeconds: time.Since(start).Seconds(),
+		Outcome:         audit.OutcomeSuccess,
+		Status:          ctx.Response().Status,
+	}
+	switch {
+	case event.Status == http.StatusForbidden:
+		event.Outcome = audit.OutcomeDenied
+	case event.Status >= http.StatusBadRequest:
+		event.Outcome = audit.OutcomeFailure
+	}
+
+	auditor.Record(event)
+}
+
+func auditCaller(ctx echo.Context) audit.Caller {
+	caller := audit.Caller{
+		Subject:   requestCaller(ctx),
+		Address:   clientIP(ctx),
+		UserAgent: ctx.Request().UserAgent(),
+	}
+	if scope := tenantScope(ctx); scope != nil {
+		caller.Tenants = scope.Tenants
+	}
+	return caller
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// authorizationRequest is what the CEL authorization policy knows about a
+// request.
+type authorizationRequest struct {
+	ApplicationSet string
+	// ClusterName is empty for the local cluster.
+	ClusterName string
+	Selector    labels.Selector
+}
+
+// compileAuthorizationPolicy compiles a CEL expression deciding whether a
+// request is allowed. It's evaluated with:
+//
+//   - request.applicationSet, request.clusterName and request.route;
+//   - request.labelSelector, the selector as a string, and
+//     request.matchLabels, the labels the selector requires a single value
+//     for;
+//   - caller.address and caller.userAgent.
+//
+// For example, `!(request.applicationSet.startsWith("dev-") &&
+// request.clusterName.startsWith("prod-"))`. A non-zero cost limit fails the
+// evaluations exceeding it.
+func compileAuthorizationPolicy(expression string, costLimit uint64) (cel.Program, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("caller", cel.MapType(cel.StringType, cel.StringType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("the expression must evaluate to a bool, got %s", ast.OutputType())
+	}
+	if costLimit > 0 {
+		return env.Program(ast, cel.CostLimit(costLimit))
+	}
+	return env.Program(ast)
+}
+
+// authorize returns ErrRequestDenied if the CEL authorization policy denies
+// the request. Requests are denied when the policy fails to evaluate, so a
+// broken policy doesn't allow everything.
+func (policy *Policy) authorize(ctx echo.Context, req authorizationRequest) error {
+	if policy.authorization == nil {
+		return nil
+	}
+
+	matchLabels := map[string]string{}
+	requirements, _ := req.Selector.Requirements()
+	for _, requirement := range requirements {
+		operator := requirement.Operator()
+		single := operator == selection.Equals || operator == selection.DoubleEquals || operator == selection.In
+		if values := requirement.Values(); single && values.Len() == 1 {
+			matchLabels[requirement.Key()] = values.UnsortedList()[0]
+		}
+	}
+	out, _, err := policy.authorization.ContextEval(ctx.Request().Context(), map[string]any{
+		"request": map[string]any{
+			"applicationSet": req.ApplicationSet,
+			"clusterName":    req.ClusterName,
+			"route":          ctx.Path(),
+			"labelSelector":  req.Selector.String(),
+			"matchLabels":    matchLabels,
+		},
+		"caller": map[string]string{
+			"address":   clientIP(ctx),
+			"userAgent": ctx.Request().UserAgent(),
+		},
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to evaluate the authorization policy, denying the request", logging.KeyError, err)
+		return generrors.ErrRequestDenied
+	}
+	if allowed, ok := out.Value().(bool); !ok || !allowed {
+		return generrors.ErrRequestDenied
+	}
+	return nil
+}
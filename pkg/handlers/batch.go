@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const batchConcurrency = 4
+
+type BatchHandler struct {
+	k8sClientFactory K8sClientFactory
+	remoteClients    *RemoteClientCache
+	responses        *ResponseCache
+	snapshots        *SnapshotStore
+	maxBatchSize     int
+}
+
+func NewBatchHandler(k8sClientFactory K8sClientFactory, remoteClients *RemoteClientCache, responses *ResponseCache, snapshots *SnapshotStore, maxBatchSize int) *BatchHandler {
+	return &BatchHandler{k8sClientFactory: k8sClientFactory, remoteClients: remoteClients, responses: responses, snapshots: snapshots, maxBatchSize: maxBatchSize}
+}
+
+// GetParamsBatch runs an array of generate requests and returns the result of
+// each request keyed by its index, or with v1alpha2 the parameters of all the
+// requests along with their warnings and errors. A failure of one request
+// doesn't fail the others, so the response status is 200 unless the batch
+// itself is invalid.
+func (batchHandler *BatchHandler) GetParamsBatch(ctx echo.Context) error {
+	requests, err := decodeBatchRequests(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to parse request body", logging.KeyError, err)
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("failed to parse request body: %s", err))
+	}
+	if len(requests) > batchHandler.maxBatchSize {
+		return errorResponse(
+			ctx,
+			http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("batch of %d requests exceeds the maximum of %d", len(requests), batchHandler.maxBatchSize),
+		)
+	}
+	for i := range requests {
+		if err := resolveClusterRef(requests[i]); err != nil {
+			return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("invalid request %d: %s", i, err))
+		}
+	}
+
+	start := time.Now()
+	localClient, err := batchHandler.k8sClientFactory(loggerFrom(ctx))
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to get k8s client", logging.KeyError, err)
+		// Snapshots may still be served without a client.
+		if batchHandler.snapshots.Len() == 0 {
+			httpErr := generateError(err, "failed to get k8s client")
+			for _, req := range requests {
+				recordGenerate(ctx, req, start, nil, httpErr)
+			}
+			return generateErrorJSON(ctx, httpErr)
+		}
+	}
+
+	// The requests of an ApplicationSet targeting more clusters than its
+	// quota fail, without failing the other requests.
+	quotaErrs := checkBatchClusterQuotas(ctx, requests)
+
+	// Each request only sets its own index.
+	generateResponses := make([]*v1alpha2.GenerateResponse, len(requests))
+	httpErrs := make([]*echo.HTTPError, len(requests))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, batchConcurrency)
+
+	for i := range requests {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			defer func() {
+				// The middleware doesn't recover the goroutines, and a panic
+				// only fails its own request.
+				if value := recover(); value != nil {
+					recordPanic(ctx, value)
+					generateResponses[i] = nil
+					httpErrs[i] = generateError(generrors.ErrInternal, "internal error")
+				}
+			}()
+
+			if httpErrs[i] = quotaErrs[i]; httpErrs[i] != nil {
+				recordGenerate(ctx, requests[i], time.Now(), nil, httpErrs[i])
+				return
+			}
+			generateResponses[i], httpErrs[i] = generate(ctx, localClient, batchHandler.remoteClients, batchHandler.responses, batchHandler.snapshots, requests[i])
+		}(i)
+	}
+	wg.Wait()
+
+	if requestedAPIVersion(ctx) == v1alpha2.Version {
+		return ctx.JSON(http.StatusOK, mergeBatchResults(ctx, requests, generateResponses, httpErrs))
+	}
+	response := &v1alpha1.BatchGenerateResponse{Results: make(map[int]v1alpha1.BatchGenerateResult, len(requests))}
+	for i := range requests {
+		result := v1alpha1.BatchGenerateResult{Status: http.StatusOK}
+		if httpErr := httpErrs[i]; httpErr != nil {
+			result.Status = httpErr.Code
+			result.Error = generateErrorResponse(ctx, httpErr)
+		} else {
+			v1alpha1Response := v1alpha2.ConvertResponseToV1alpha1(generateResponses[i])
+			result.Output = &v1alpha1Response.Output
+			result.Debug = v1alpha1Response.Debug
+			result.Stale = generateResponses[i].Stale != nil
+			result.Truncated = generateResponses[i].Truncated
+		}
+		response.Results[i] = result
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// decodeBatchRequests decodes the requests of a batch of the requested
+// version of the API, converted to v1alpha2.
+func decodeBatchRequests(ctx echo.Context) ([]*v1alpha2.GenerateRequest, error) {
+	if requestedAPIVersion(ctx) == v1alpha2.Version {
+		var reqs []*v1alpha2.GenerateRequest
+		if err := decodeJson(ctx, &reqs); err != nil {
+			return nil, err
+		}
+		for i, req := range reqs {
+			if req == nil {
+				return nil, fmt.Errorf("request %d is null", i)
+			}
+		}
+		return reqs, nil
+	}
+	var reqs []v1alpha1.GenerateRequest
+	if err := decodeJson(ctx, &reqs); err != nil {
+		return nil, err
+	}
+	requests := make([]*v1alpha2.GenerateRequest, len(reqs))
+	for i := range reqs {
+		requests[i] = v1alpha2.ConvertRequestFromV1alpha1(&reqs[i])
+	}
+	return requests, nil
+}
+
+// mergeBatchResults merges the parameters of the requests of a batch which
+// succeeded, in the order of the requests, and reports the stale and the
+// truncated results as warnings and the failed requests as errors.
+func mergeBatchResults(ctx echo.Context, requests []*v1alpha2.GenerateRequest, generateResponses []*v1alpha2.GenerateResponse, httpErrs []*echo.HTTPError) *v1alpha2.BatchGenerateResponse {
+	response := &v1alpha2.BatchGenerateResponse{Parameters: []v1alpha2.OutParameters{}}
+	for i, req := range requests {
+		clusterName := req.Input.Parameters.ClusterName
+		if httpErr := httpErrs[i]; httpErr != nil {
+			response.Errors = append(response.Errors, v1alpha2.BatchError{
+				Index:       i,
+				ClusterName: clusterName,
+				Status:      httpErr.Code,
+				Error:       *generateErrorResponse(ctx, httpErr),
+			})
+			continue
+		}
+		generateResponse := generateResponses[i]
+		response.Parameters = append(response.Parameters, generateResponse.Output.Parameters...)
+		if generateResponse.Stale != nil {
+			response.Warnings = append(response.Warnings, v1alpha2.BatchWarning{
+				Index:       i,
+				ClusterName: clusterName,
+				Code:        v1alpha2.WarningStale,
+				Message:     fmt.Sprintf("served the snapshot of %s: %s", generateResponse.Stale.SnapshotAt.Format(time.RFC3339), generateResponse.Stale.Reason),
+			})
+		}
+		if generateResponse.Truncated {
+			response.Warnings = append(response.Warnings, v1alpha2.BatchWarning{
+				Index:       i,
+				ClusterName: clusterName,
+				Code:        v1alpha2.WarningTruncated,
+				Message:     fmt.Sprintf("returned %d of %d namespaces", len(generateResponse.Output.Parameters), generateResponse.Total),
+			})
+		}
+	}
+	return response
+}
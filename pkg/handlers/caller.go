@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+const callerKey = "caller"
+
+// StaticKeyCaller is the caller of the requests authenticated with the static
+// API key, which doesn't tell its holders apart.
+const StaticKeyCaller = "static-key"
+
+// SetCaller records the authenticated caller of the request, e.g. the user of
+// its reviewed token. It's called by the authentication middleware, so unlike
+// the ApplicationSet sent with the request, the caller can be trusted for
+// access control.
+func SetCaller(ctx echo.Context, caller string) {
+	ctx.Set(callerKey, caller)
+}
+
+// CallerRateLimitKey keys the rate limits by the authenticated caller, so
+// each caller has its own bucket, falling back to the address of the
+// connection for the requests without one. Unlike the ApplicationSet
+// headers, the callers can't pick a fresh bucket per request. It must run
+// after the authentication middleware.
+func CallerRateLimitKey(ctx echo.Context) string {
+	if caller := requestCaller(ctx); caller != "" {
+		return "caller:" + caller
+	}
+	return echo.ExtractIPDirect()(ctx.Request())
+}
+
+// requestCaller returns the authenticated caller of the request, or "" if it
+// wasn't authenticated.
+func requestCaller(ctx echo.Context) string {
+	caller, _ := ctx.Get(callerKey).(string)
+	return caller
+}
@@ -0,0 +1,477 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const (
+	changeFeedRetryInterval    = time.Second
+	defaultChangeFeedRetention = 10000
+	defaultChangeFeedIdle      = 10 * time.Minute
+)
+
+// ChangeFeedOptions configures the namespace changes endpoint. Zero fields
+// take their default.
+type ChangeFeedOptions struct {
+	// Retention is the number of namespace changes kept per cluster. The
+	// cursors older than the changes kept are expired.
+	Retention int
+	// IdleTimeout stops watching a cluster when its changes weren't
+	// requested for that long.
+	IdleTimeout time.Duration
+}
+
+// NamespaceChangesHandler serves the namespaces which started or stopped
+// matching a selector since a cursor. The namespaces of a cluster are
+// watched from its first request on, and their recent changes are kept, so
+// the changes can be computed for any selector without listing the
+// namespaces again.
+type NamespaceChangesHandler struct {
+	k8sClientFactory K8sClientFactory
+	remoteClients    *RemoteClientCache
+	localWatchClient client.WithWatch
+	options          ChangeFeedOptions
+	logger           *slog.Logger
+	// shutdownCtx is canceled to stop watching the clusters.
+	shutdownCtx context.Context
+	shutdown    context.CancelFunc
+
+	mu    sync.Mutex
+	feeds map[string]*clusterFeed
+}
+
+func NewNamespaceChangesHandler(k8sClientFactory K8sClientFactory, remoteClients *RemoteClientCache, localWatchClient client.WithWatch, options ChangeFeedOptions, logger *slog.Logger) *NamespaceChangesHandler {
+	if options.Retention <= 0 {
+		options.Retention = defaultChangeFeedRetention
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = defaultChangeFeedIdle
+	}
+	shutdownCtx, shutdown := context.WithCancel(context.Background())
+	return &NamespaceChangesHandler{
+		k8sClientFactory: k8sClientFactory,
+		remoteClients:    remoteClients,
+		localWatchClient: localWatchClient,
+		options:          options,
+		logger:           logger,
+		shutdownCtx:      shutdownCtx,
+		shutdown:         shutdown,
+		feeds:            map[string]*clusterFeed{},
+	}
+}
+
+// Shutdown stops watching the clusters.
+func (changesHandler *NamespaceChangesHandler) Shutdown() {
+	changesHandler.shutdown()
+}
+
+// ListNamespaceChanges returns the namespaces which started or stopped
+// matching the label selector since the cursor of the since query parameter.
+// Without a cursor, all the matching namespaces are returned as added. The
+// response holds the cursor to pass next.
+func (changesHandler *NamespaceChangesHandler) ListNamespaceChanges(ctx echo.Context) error {
+	var since *uint64
+	if value := ctx.QueryParam("since"); value != "" {
+		cursor, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("invalid cursor %q", value))
+		}
+		since = &cursor
+	}
+
+	target, httpErr := resolveWatchTarget(ctx, changesHandler.k8sClientFactory, changesHandler.remoteClients, changesHandler.localWatchClient)
+	if httpErr != nil {
+		return generateErrorJSON(ctx, httpErr)
+	}
+
+	feed := changesHandler.feed(target.clusterName, target.client)
+	changes, err := feed.changes(ctx.Request().Context(), target.selector, since)
+	if errors.Is(err, generrors.ErrCursorExpired) {
+		return classifiedErrorResponse(ctx, err, "the cursor expired, list the namespaces again without it")
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to list namespace changes", logging.KeyCluster, target.clusterName, logging.KeyError, err)
+		return classifiedErrorResponse(ctx, err, fmt.Sprintf("failed to list namespaces: %s", generrors.KindOf(err)))
+	}
+	return ctx.JSON(http.StatusOK, changes)
+}
+
+// feed returns the feed of the cluster, starting to watch it on the first
+// request. A feed whose client was rebuilt, e.g. after its cluster secret
+// changed, watches with the new one.
+func (changesHandler *NamespaceChangesHandler) feed(clusterName string, cl client.WithWatch) *clusterFeed {
+	changesHandler.mu.Lock()
+	defer changesHandler.mu.Unlock()
+
+	feed, ok := changesHandler.feeds[clusterName]
+	if !ok {
+		feed = &clusterFeed{
+			handler:     changesHandler,
+			clusterName: clusterName,
+			client:      cl,
+			synced:      make(chan struct{}),
+			namespaces:  map[string]labels.Set{},
+		}
+		changesHandler.feeds[clusterName] = feed
+		go feed.run(changesHandler.shutdownCtx)
+	}
+	feed.lastUsed = time.Now()
+	feed.setClient(cl)
+	return feed
+}
+
+// removeIdle stops tracking the feed if it wasn't requested for longer than
+// the idle timeout, and reports whether it did.
+func (changesHandler *NamespaceChangesHandler) removeIdle(feed *clusterFeed) bool {
+	changesHandler.mu.Lock()
+	defer changesHandler.mu.Unlock()
+
+	if time.Since(feed.lastUsed) < changesHandler.options.IdleTimeout {
+		return false
+	}
+	delete(changesHandler.feeds, feed.clusterName)
+	return true
+}
+
+// clusterFeed keeps the recent namespace changes of a cluster. The cursors
+// are the resource versions of the namespaces, which the API server issues
+// in increasing order.
+type clusterFeed struct {
+	handler     *NamespaceChangesHandler
+	clusterName string
+	// lastUsed is guarded by the mutex of the handler.
+	lastUsed time.Time
+
+	mu     sync.Mutex
+	client client.WithWatch
+	// restart cancels the running watch, e.g. to restart it with a new
+	// client.
+	restart context.CancelFunc
+	// synced is closed once the namespaces were first listed, or failed to.
+	synced chan struct{}
+	listed bool
+	err    error
+	// namespaces are the labels of the namespaces of the cluster.
+	namespaces map[string]labels.Set
+	// history holds the changes ordered by resource version. The changes up
+	// to oldest aren't known anymore.
+	history []namespaceChange
+	oldest  uint64
+	cursor  uint64
+}
+
+// namespaceChange records the labels of a namespace before and after a
+// change. They are nil when the namespace didn't exist.
+type namespaceChange struct {
+	resourceVersion uint64
+	name            string
+	before          labels.Set
+	after           labels.Set
+}
+
+func (feed *clusterFeed) setClient(cl client.WithWatch) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	if feed.client != cl {
+		feed.client = cl
+		if feed.restart != nil {
+			feed.restart()
+		}
+	}
+}
+
+// run watches the namespaces until the context is done, or the feed is idle.
+func (feed *clusterFeed) run(ctx context.Context) {
+	idle := time.NewTicker(feed.handler.options.IdleTimeout)
+	defer idle.Stop()
+
+	for {
+		watchCtx, cancel := context.WithCancel(ctx)
+		feed.mu.Lock()
+		cl := feed.client
+		feed.restart = cancel
+		feed.mu.Unlock()
+
+		err := feed.follow(watchCtx, cl, idle.C)
+		cancel()
+		if ctx.Err() != nil || errors.Is(err, errFeedIdle) {
+			return
+		}
+		if err != nil {
+			feed.handler.logger.Error("Failed to watch namespaces for the change feed", logging.KeyCluster, feed.clusterName, logging.KeyError, err)
+		}
+
+		retry := time.NewTimer(changeFeedRetryInterval)
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				retry.Stop()
+				return
+			case <-idle.C:
+				if feed.handler.removeIdle(feed) {
+					retry.Stop()
+					return
+				}
+			case <-retry.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+var errFeedIdle = errors.New("the change feed is idle")
+
+// follow lists the namespaces and watches them until the watch is closed. The
+// differences with the namespaces known before the list are recorded as
+// changes, so the cursors stay valid when the watch is restarted.
+func (feed *clusterFeed) follow(ctx context.Context, cl client.WithWatch, idle <-chan time.Time) error {
+	nsList := generator.NewNamespaceList()
+	err := generator.ListNamespacePages(ctx, cl, nsList, labels.Everything())
+	if err == nil {
+		err = feed.sync(nsList)
+	}
+	if err != nil {
+		feed.mu.Lock()
+		feed.err = err
+		feed.markSynced()
+		feed.mu.Unlock()
+		return err
+	}
+
+	watcher, err := cl.Watch(ctx, generator.NewNamespaceList(), &client.ListOptions{
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     nsList.ResourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-idle:
+			if feed.handler.removeIdle(feed) {
+				return errFeedIdle
+			}
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type == watch.Error {
+				// Most likely the resource version is too old, start over.
+				return nil
+			}
+			object, ok := event.Object.(metav1.Object)
+			if !ok {
+				continue
+			}
+			if err := feed.record(event.Type, object); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sync records the differences between the listed namespaces and the known
+// ones as changes made at the resource version of the list.
+func (feed *clusterFeed) sync(nsList *metav1.PartialObjectMetadataList) error {
+	resourceVersion, err := listResourceVersion(nsList)
+	if err != nil {
+		return err
+	}
+
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	current := make(map[string]labels.Set, len(nsList.Items))
+	for i := range nsList.Items {
+		current[nsList.Items[i].Name] = namespaceLabels(&nsList.Items[i])
+	}
+	if feed.listed {
+		for name, before := range feed.namespaces {
+			if _, ok := current[name]; !ok {
+				feed.append(namespaceChange{resourceVersion: resourceVersion, name: name, before: before})
+			}
+		}
+		for name, after := range current {
+			if before, ok := feed.namespaces[name]; !ok || !labels.Equals(before, after) {
+				feed.append(namespaceChange{resourceVersion: resourceVersion, name: name, before: before, after: after})
+			}
+		}
+	} else {
+		// The changes start from the first list.
+		feed.oldest = resourceVersion
+		feed.listed = true
+	}
+	feed.namespaces = current
+	feed.cursor = max(feed.cursor, resourceVersion)
+	feed.err = nil
+	feed.markSynced()
+	return nil
+}
+
+// listResourceVersion returns the resource version of the list. Lists without
+// one, e.g. of fake clients, are at the version of their newest namespace.
+func listResourceVersion(nsList *metav1.PartialObjectMetadataList) (uint64, error) {
+	if nsList.ResourceVersion != "" {
+		resourceVersion, err := strconv.ParseUint(nsList.ResourceVersion, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid resource version %q: %w", nsList.ResourceVersion, err)
+		}
+		return resourceVersion, nil
+	}
+
+	var newest uint64
+	for i := range nsList.Items {
+		resourceVersion, err := strconv.ParseUint(nsList.Items[i].ResourceVersion, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid resource version %q: %w", nsList.Items[i].ResourceVersion, err)
+		}
+		newest = max(newest, resourceVersion)
+	}
+	return newest, nil
+}
+
+// markSynced closes synced unless it's closed already. The mutex must be
+// held.
+func (feed *clusterFeed) markSynced() {
+	select {
+	case <-feed.synced:
+	default:
+		close(feed.synced)
+	}
+}
+
+// record records a watch event. Bookmarks only move the cursor, and updates
+// which don't change the labels aren't recorded.
+func (feed *clusterFeed) record(eventType watch.EventType, object metav1.Object) error {
+	resourceVersion, err := strconv.ParseUint(object.GetResourceVersion(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid resource version %q: %w", object.GetResourceVersion(), err)
+	}
+
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	feed.cursor = max(feed.cursor, resourceVersion)
+	if eventType == watch.Bookmark {
+		return nil
+	}
+	name := object.GetName()
+	before := feed.namespaces[name]
+	var after labels.Set
+	if eventType == watch.Deleted {
+		delete(feed.namespaces, name)
+	} else {
+		after = namespaceLabels(object)
+		feed.namespaces[name] = after
+		if before != nil && labels.Equals(before, after) {
+			return nil
+		}
+	}
+	feed.append(namespaceChange{resourceVersion: resourceVersion, name: name, before: before, after: after})
+	return nil
+}
+
+// append records a change, dropping the oldest ones beyond the retention.
+// The mutex must be held.
+func (feed *clusterFeed) append(change namespaceChange) {
+	feed.history = append(feed.history, change)
+	if excess := len(feed.history) - feed.handler.options.Retention; excess > 0 {
+		feed.oldest = feed.history[excess-1].resourceVersion
+		feed.history = append(feed.history[:0], feed.history[excess:]...)
+	}
+}
+
+// changes returns the namespaces matching the selector which were added or
+// removed since the cursor, or all of them without a cursor.
+func (feed *clusterFeed) changes(ctx context.Context, selector labels.Selector, since *uint64) (*v1alpha1.NamespaceChanges, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-feed.synced:
+	}
+
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	// The changes known so far are still served while the watch is down.
+	if !feed.listed {
+		return nil, feed.err
+	}
+
+	response := &v1alpha1.NamespaceChanges{Added: []string{}, Removed: []string{}}
+	if since == nil {
+		response.Full = true
+		response.Cursor = strconv.FormatUint(feed.cursor, 10)
+		for name, namespaceLabels := range feed.namespaces {
+			if selector.Matches(namespaceLabels) {
+				response.Added = append(response.Added, name)
+			}
+		}
+		sort.Strings(response.Added)
+		return response, nil
+	}
+	if *since < feed.oldest {
+		return nil, generrors.ErrCursorExpired
+	}
+
+	// A cursor ahead of the feed comes from a replica which saw more
+	// changes, and is kept until this replica catches up.
+	response.Cursor = strconv.FormatUint(max(*since, feed.cursor), 10)
+	first := sort.Search(len(feed.history), func(i int) bool {
+		return feed.history[i].resourceVersion > *since
+	})
+	// Only the labels before the first change and after the last one of
+	// each namespace matter.
+	matchedBefore := map[string]bool{}
+	matchesAfter := map[string]bool{}
+	for _, change := range feed.history[first:] {
+		if _, ok := matchedBefore[change.name]; !ok {
+			matchedBefore[change.name] = change.before != nil && selector.Matches(change.before)
+		}
+		matchesAfter[change.name] = change.after != nil && selector.Matches(change.after)
+	}
+	for name, matched := range matchedBefore {
+		switch {
+		case !matched && matchesAfter[name]:
+			response.Added = append(response.Added, name)
+		case matched && !matchesAfter[name]:
+			response.Removed = append(response.Removed, name)
+		}
+	}
+	sort.Strings(response.Added)
+	sort.Strings(response.Removed)
+	return response, nil
+}
+
+// namespaceLabels returns the labels of a namespace, which are never nil so
+// they tell an existing namespace apart.
+func namespaceLabels(object metav1.Object) labels.Set {
+	if object.GetLabels() == nil {
+		return labels.Set{}
+	}
+	return labels.Set(object.GetLabels())
+}
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/tracing"
+)
+
+// RemoteClientCache keeps a client per remote cluster so connections and
+// tokens are reused across requests. A client is rebuilt when the cluster
+// secret it was created from changes.
+type RemoteClientCache struct {
+	authProvider TokenSource
+	options      RemoteClientOptions
+
+	mu      sync.Mutex
+	entries map[string]*remoteClientEntry
+}
+
+// RemoteClientOptions configures the calls made to remote clusters.
+type RemoteClientOptions struct {
+	Retry RetryConfig
+	// WatchList enables listing namespaces with streaming lists on the API
+	// servers supporting them.
+	WatchList bool
+	// ProjectsFallback lists the OpenShift projects of the clusters which
+	// forbid listing their namespaces, see listProjectsInstead.
+	ProjectsFallback bool
+	// Recorder emits Events on the cluster secrets which are malformed or
+	// whose cluster is unreachable. Nil disables the Events.
+	Recorder record.EventRecorder
+	// ClientFactory creates the clients of the remote clusters, including
+	// the ones impersonating users. Nil creates controller-runtime clients.
+	ClientFactory RemoteClientFactory
+}
+
+// TokenSource provides the tokens of the calls to the remote clusters.
+// auth.CachedProvider implements it.
+type TokenSource interface {
+	auth.Provider
+	// Expiry returns the expiry of the cached token. It's zero if no token
+	// is cached or if the token doesn't expire.
+	Expiry() time.Time
+	// Invalidate drops the cached token, so the next call mints a new one.
+	Invalidate()
+}
+
+var _ TokenSource = &auth.CachedProvider{}
+
+// RemoteClientFactory creates a client for the config of a remote cluster,
+// giving up when the context is done. The config already authenticates the
+// requests with the token source of the cache.
+type RemoteClientFactory func(ctx context.Context, cfg *rest.Config) (client.WithWatch, error)
+
+// Reasons of the Events emitted on cluster secrets.
+const (
+	EventReasonInvalidClusterSecret = "InvalidClusterSecret"
+	EventReasonClusterUnreachable   = "ClusterUnreachable"
+)
+
+type remoteClientEntry struct {
+	client client.WithWatch
+	// config is the config the client was created with, for creating the
+	// clients impersonating users.
+	config       *rest.Config
+	impersonated map[string]client.WithWatch
+	// discovery calls the non-resource endpoints of the API server, such
+	// as /version.
+	discovery *discovery.DiscoveryClient
+	// secret references the cluster secret the client was created from, for
+	// emitting Events on it.
+	secret    *corev1.Secret
+	server    string
+	watchList bool
+	// projects is set once the cluster forbade listing its namespaces and
+	// its projects were listed instead.
+	projects bool
+	// resourceVersion is the version of the config of the client, see
+	// getRemoteClusterConfig.
+	resourceVersion string
+	createdAt       time.Time
+	lastSuccess     time.Time
+	lastFailure     time.Time
+	lastError       string
+}
+
+func NewRemoteClientCache(authProvider TokenSource, options RemoteClientOptions) *RemoteClientCache {
+	if options.ClientFactory == nil {
+		options.ClientFactory = newRemoteClient
+	}
+	return &RemoteClientCache{
+		authProvider: authProvider,
+		options:      options,
+		entries:      map[string]*remoteClientEntry{},
+	}
+}
+
+// getClient returns a client for the cluster described by the given cluster
+// secret, referred to as by clusterRef, along with the address of its API
+// server.
+func (cache *RemoteClientCache) getClient(ctx echo.Context, localClient client.Reader, secretName string) (client.WithWatch, string, error) {
+	entry, err := cache.getEntry(ctx, localClient, secretName)
+	if err != nil {
+		return nil, "", err
+	}
+	return entry.client, entry.server, nil
+}
+
+// getEntry returns the cached entry of the cluster, creating its clients if
+// they aren't cached or if the cluster secret changed.
+func (cache *RemoteClientCache) getEntry(ctx echo.Context, localClient client.Reader, secretName string) (*remoteClientEntry, error) {
+	secret, secretReader, err := getClusterSecret(ctx, localClient, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get a token up front so authentication failures are reported as such,
+	// rather than as a failure of the first API call.
+	stageCtx, endStage := startStage(ctx.Request().Context(), stageAuth)
+	spanCtx, span := tracing.Start(stageCtx, "GetToken", trace.WithAttributes(attribute.String("auth.provider", cache.authProvider.Name())))
+	_, err = cache.authProvider.Token(spanCtx)
+	tracing.End(span, err)
+	endStage(err)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to get a token", logging.KeyCluster, secretName, logging.KeyError, err)
+		return nil, generrors.Wrap(generrors.ErrAuthFailed, err)
+	}
+
+	// The config is built before looking up the cached client, as its CA
+	// ConfigMap may have changed without the secret.
+	remoteCfg, version, err := getRemoteClusterConfig(ctx, secretReader, secret)
+	if err != nil {
+		_, endStage = startStage(ctx.Request().Context(), stageClient)
+		endStage(err)
+		cache.warn(secret, EventReasonInvalidClusterSecret, "Invalid cluster secret: %s", err)
+		return nil, generrors.Wrap(generrors.ErrSecretInvalid, err)
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[secretName]
+	cache.mu.Unlock()
+	hit := ok && entry.resourceVersion == version
+	recordCacheHit(ctx.Request().Context(), "remoteClient", hit)
+	if hit {
+		recordRemote(ctx.Request().Context(), entry.server, cache.authProvider.Name())
+		return entry, nil
+	}
+
+	stageCtx, endStage = startStage(ctx.Request().Context(), stageClient)
+	remoteCfg.Wrap(auth.WrapTransport(cache.authProvider))
+	remoteCfg.Wrap(tracing.WrapTransport)
+
+	// Create a remote Kubernetes client using controller-runtime.
+	remoteClient, err := cache.options.ClientFactory(stageCtx, remoteCfg)
+	if err != nil {
+		endStage(err)
+		loggerFrom(ctx).Error("Failed to create remote client", logging.KeyCluster, secretName, "server", remoteCfg.Host, logging.KeyError, err)
+		cache.warn(secret, EventReasonClusterUnreachable, "Failed to create a client for %s: %s", remoteCfg.Host, err)
+		return nil, generator.ClassifyRemoteError(err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(remoteCfg)
+	if err != nil {
+		endStage(err)
+		loggerFrom(ctx).Error("Failed to create remote discovery client", logging.KeyCluster, secretName, "server", remoteCfg.Host, logging.KeyError, err)
+		return nil, generator.ClassifyRemoteError(err)
+	}
+	watchList := false
+	if cache.options.WatchList {
+		if watchList, err = serverSupportsWatchList(discoveryClient); err != nil {
+			loggerFrom(ctx).Warn("Failed to check whether the cluster supports streaming lists", logging.KeyCluster, secretName, logging.KeyError, err)
+		}
+	}
+	endStage(nil)
+	loggerFrom(ctx).Debug("Created remote client", logging.KeyCluster, secretName, "server", remoteCfg.Host)
+	recordRemote(ctx.Request().Context(), remoteCfg.Host, cache.authProvider.Name())
+
+	entry = &remoteClientEntry{
+		client:          remoteClient,
+		config:          remoteCfg,
+		impersonated:    map[string]client.WithWatch{},
+		discovery:       discoveryClient,
+		secret:          secretReference(secret),
+		server:          remoteCfg.Host,
+		watchList:       watchList,
+		resourceVersion: version,
+		createdAt:       time.Now(),
+	}
+	cache.mu.Lock()
+	cache.entries[secretName] = entry
+	cache.mu.Unlock()
+
+	return entry, nil
+}
+
+// newRemoteClient creates a client for the given config, giving up when the
+// context is done.
+func newRemoteClient(ctx context.Context, cfg *rest.Config) (client.WithWatch, error) {
+	type result struct {
+		client client.WithWatch
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		remoteClient, err := client.NewWithWatch(cfg, client.Options{})
+		results <- result{client: remoteClient, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-results:
+		return result.client, result.err
+	}
+}
+
+// useWatchList reports whether namespaces of the cluster are listed with
+// streaming lists.
+func (cache *RemoteClientCache) useWatchList(secretName string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[secretName]
+	return ok && entry.watchList
+}
+
+// useProjects reports whether the projects of the cluster are listed instead
+// of its namespaces.
+func (cache *RemoteClientCache) useProjects(secretName string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[secretName]
+	return ok && entry.projects
+}
+
+// enableProjects lists the projects of the cluster instead of its
+// namespaces, until its client is rebuilt.
+func (cache *RemoteClientCache) enableProjects(secretName string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if entry, ok := cache.entries[secretName]; ok {
+		entry.projects = true
+	}
+}
+
+// disableWatchList falls back to paged lists for the cluster, until its
+// client is rebuilt.
+func (cache *RemoteClientCache) disableWatchList(secretName string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if entry, ok := cache.entries[secretName]; ok {
+		entry.watchList = false
+	}
+}
+
+// recordResult records the outcome of the last call to the given cluster.
+func (cache *RemoteClientCache) recordResult(secretName string, err error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[secretName]
+	if !ok {
+		return
+	}
+	if err != nil {
+		entry.lastFailure = time.Now()
+		entry.lastError = err.Error()
+		// Requests canceled by their client don't tell anything about the cluster.
+		if !errors.Is(err, context.Canceled) {
+			cache.warn(entry.secret, EventReasonClusterUnreachable, "Failed to reach %s: %s", entry.server, err)
+		}
+	} else {
+		entry.lastSuccess = time.Now()
+	}
+}
+
+// warn emits a warning Event on the cluster secret. Credentials are redacted
+// from the message, as Events are readable by more users than the secret.
+func (cache *RemoteClientCache) warn(secret *corev1.Secret, reason, messageFmt string, args ...any) {
+	if cache.options.Recorder == nil {
+		return
+	}
+	cache.options.Recorder.Event(secret, corev1.EventTypeWarning, reason, logging.RedactString(fmt.Sprintf(messageFmt, args...)))
+}
+
+// secretReference returns a copy of the metadata identifying the secret, so
+// the entries don't keep the credentials of the secret.
+func secretReference(secret *corev1.Secret) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            secret.Name,
+			Namespace:       secret.Namespace,
+			UID:             secret.UID,
+			ResourceVersion: secret.ResourceVersion,
+		},
+	}
+}
+
+// Status returns the status of the cached clients sorted by cluster name.
+func (cache *RemoteClientCache) Status() []v1alpha1.RemoteClientStatus {
+	var tokenExpiry *time.Time
+	if expiry := cache.authProvider.Expiry(); !expiry.IsZero() {
+		tokenExpiry = &expiry
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	statuses := make([]v1alpha1.RemoteClientStatus, 0, len(cache.entries))
+	for name, entry := range cache.entries {
+		statuses = append(statuses, v1alpha1.RemoteClientStatus{
+			ClusterName:  name,
+			Server:       entry.server,
+			AuthProvider: cache.authProvider.Name(),
+			CreatedAt:    entry.createdAt,
+			AgeSeconds:   int64(time.Since(entry.createdAt).Seconds()),
+			TokenExpiry:  tokenExpiry,
+			LastSuccess:  timeOrNil(entry.lastSuccess),
+			LastFailure:  timeOrNil(entry.lastFailure),
+			LastError:    entry.lastError,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ClusterName < statuses[j].ClusterName })
+
+	return statuses
+}
+
+// Clients returns the cached clients keyed by cluster name. A client is
+// replaced when its cluster secret changes.
+func (cache *RemoteClientCache) Clients() map[string]client.WithWatch {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	clients := make(map[string]client.WithWatch, len(cache.entries))
+	for name, entry := range cache.entries {
+		clients[name] = entry.client
+	}
+	return clients
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Invalidate drops the cached client of the given cluster. It reports whether
+// a client was cached.
+func (cache *RemoteClientCache) Invalidate(secretName string) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	_, ok := cache.entries[secretName]
+	delete(cache.entries, secretName)
+	return ok
+}
+
+// InvalidateAll drops all the cached clients and returns how many were cached.
+func (cache *RemoteClientCache) InvalidateAll() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	count := len(cache.entries)
+	cache.entries = map[string]*remoteClientEntry{}
+	return count
+}
+
+// reachability returns whether the last call to the given cluster succeeded
+// and when it happened. It returns nil if the cluster wasn't called yet.
+func (cache *RemoteClientCache) reachability(secretName string) (*bool, *time.Time) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[secretName]
+	if !ok || (entry.lastSuccess.IsZero() && entry.lastFailure.IsZero()) {
+		return nil, nil
+	}
+	reachable := entry.lastSuccess.After(entry.lastFailure)
+	lastChecked := entry.lastSuccess
+	if !reachable {
+		lastChecked = entry.lastFailure
+	}
+	return &reachable, &lastChecked
+}
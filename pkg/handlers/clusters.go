@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const (
+	clusterSecretTypeLabel = "argocd.argoproj.io/secret-type"
+	clusterSecretType      = "cluster"
+)
+
+type ClustersHandler struct {
+	k8sClientFactory K8sClientFactory
+	remoteClients    *RemoteClientCache
+	// project restricts the clusters plugin to the clusters of an ArgoCD
+	// project, whatever the project of the request.
+	project string
+}
+
+func NewClustersHandler(k8sClientFactory K8sClientFactory, remoteClients *RemoteClientCache, project string) *ClustersHandler {
+	return &ClustersHandler{k8sClientFactory: k8sClientFactory, remoteClients: remoteClients, project: project}
+}
+
+// Check goes through the same steps as a request against the named cluster,
+// and reports the latency and the error of each step. It allows validating
+// a cluster before wiring ApplicationSets to it.
+func (clustersHandler *ClustersHandler) Check(ctx echo.Context) error {
+	clusterName, err := clusterRef(ctx.QueryParam("instance"), ctx.Param("name"))
+	if err != nil {
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, err.Error())
+	}
+
+	localClient, err := clustersHandler.k8sClientFactory(loggerFrom(ctx))
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to get k8s client", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusInternalServerError, "failed to get k8s client")
+	}
+
+	checkResponse := &v1alpha1.ClusterCheckResponse{
+		ClusterName:  clusterName,
+		AuthProvider: clustersHandler.remoteClients.authProvider.Name(),
+		Healthy:      true,
+	}
+	start := time.Now()
+	step := func(name string, run func() error) bool {
+		stepStart := time.Now()
+		err := run()
+		result := v1alpha1.ClusterCheckStep{
+			Name:          name,
+			Success:       err == nil,
+			LatencyMillis: time.Since(stepStart).Milliseconds(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			checkResponse.Healthy = false
+		}
+		checkResponse.Steps = append(checkResponse.Steps, result)
+		return err == nil
+	}
+
+	var secret *corev1.Secret
+	var secretReader client.Reader
+	var remoteClient client.WithWatch
+	ok := step("secret", func() error {
+		secret, secretReader, err = getClusterSecret(ctx, localClient, clusterName)
+		return err
+	})
+	if !ok && apierrors.IsNotFound(err) {
+		return classifiedErrorResponse(ctx, err, fmt.Sprintf("cluster secret %s not found", clusterName))
+	}
+	ok = ok && step("config", func() error {
+		remoteCfg, _, err := getRemoteClusterConfig(ctx, secretReader, secret)
+		if err == nil {
+			checkResponse.Server = remoteCfg.Host
+		}
+		return err
+	})
+	ok = ok && step("auth", func() error {
+		_, err := clustersHandler.remoteClients.authProvider.Token(ctx.Request().Context())
+		return err
+	})
+	ok = ok && step("client", func() error {
+		remoteClient, _, err = clustersHandler.remoteClients.getClient(ctx, localClient, clusterName)
+		return err
+	})
+	_ = ok && step("list", func() error {
+		err := remoteClient.List(ctx.Request().Context(), generator.NewNamespaceList(), client.Limit(1))
+		clustersHandler.remoteClients.recordResult(clusterName, err)
+		return err
+	})
+	checkResponse.LatencyMillis = time.Since(start).Milliseconds()
+
+	return ctx.JSON(http.StatusOK, checkResponse)
+}
+
+// ListClusters lists the ArgoCD cluster secrets the generator can see, of
+// the ArgoCD instance of the instance query parameter if set. The
+// reachability of a cluster reflects the last call made to it.
+func (clustersHandler *ClustersHandler) ListClusters(ctx echo.Context) error {
+	instance := ctx.QueryParam("instance")
+	secrets, err := clustersHandler.listClusterSecrets(ctx, instance, labels.Everything())
+	if err != nil {
+		return classifiedErrorResponse(ctx, err, "failed to list cluster secrets")
+	}
+
+	clustersResponse := &v1alpha1.ClustersResponse{Clusters: make([]v1alpha1.ClusterInfo, 0, len(secrets))}
+	for _, secret := range secrets {
+		info := v1alpha1.ClusterInfo{
+			SecretName:   secret.Name,
+			Instance:     instance,
+			Name:         string(secret.Data["name"]),
+			Server:       string(secret.Data["server"]),
+			Project:      string(secret.Data["project"]),
+			Labels:       secret.Labels,
+			AuthProvider: clustersHandler.remoteClients.authProvider.Name(),
+		}
+		ref, _ := clusterRef(instance, secret.Name)
+		info.Reachable, info.LastChecked = clustersHandler.remoteClients.reachability(ref)
+		clustersResponse.Clusters = append(clustersResponse.Clusters, info)
+	}
+
+	return ctx.JSON(http.StatusOK, clustersResponse)
+}
+
+// GetClusterParams is an ApplicationSet plugin endpoint generating a set of
+// parameters per cluster secret matching the label selector of the request,
+// like the ArgoCD clusters generator does, in the ArgoCD instance of the
+// request. When the route or the request sets an ArgoCD project, only the
+// clusters assigned to it are returned.
+func (clustersHandler *ClustersHandler) GetClusterParams(ctx echo.Context) error {
+	req := &v1alpha1.GenerateRequest{}
+	if err := decodeJson(ctx, req); err != nil {
+		loggerFrom(ctx).Error("Failed to parse request body", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusBadRequest, fmt.Sprintf("failed to parse request body: %s", err))
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&req.Input.Parameters.LabelSelector)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to parse label selector", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusBadRequest, fmt.Sprintf("failed to parse label selector: %s", err))
+	}
+	if err := getPolicy().checkSelectorLimits(selector); err != nil {
+		return classifiedErrorResponse(ctx, err, err.Error())
+	}
+
+	project := req.Input.Parameters.Project
+	if clustersHandler.project != "" {
+		if project != "" && project != clustersHandler.project {
+			return classifiedErrorResponse(ctx, generrors.ErrClusterForbidden, fmt.Sprintf("project %s isn't allowed", project))
+		}
+		project = clustersHandler.project
+	}
+
+	secrets, err := clustersHandler.listClusterSecrets(ctx, req.Input.Parameters.ArgoCDInstance, selector)
+	if err != nil {
+		return classifiedErrorResponse(ctx, err, "failed to list cluster secrets")
+	}
+	if project != "" {
+		secrets = slices.DeleteFunc(secrets, func(secret corev1.Secret) bool {
+			return string(secret.Data["project"]) != project
+		})
+	}
+	applicationSet := requestApplicationSet(ctx, req.ApplicationSetName)
+	if httpErr := checkQuota(applicationSet, quotaClusters, quotaFor(applicationSet).MaxClusters, len(secrets)); httpErr != nil {
+		loggerFrom(ctx).Warn("Cluster quota exceeded", logging.KeyAppSet, applicationSet.Name, "clusters", len(secrets))
+		return generateErrorJSON(ctx, httpErr)
+	}
+
+	generateResponse := &v1alpha1.ClusterGenerateResponse{Output: v1alpha1.ClusterOutput{Parameters: []v1alpha1.ClusterParameters{}}}
+	for _, secret := range secrets {
+		generateResponse.Output.Parameters = append(generateResponse.Output.Parameters, v1alpha1.ClusterParameters{
+			Name:       string(secret.Data["name"]),
+			Server:     string(secret.Data["server"]),
+			SecretName: secret.Name,
+			Project:    string(secret.Data["project"]),
+			Labels:     secret.Labels,
+		})
+	}
+
+	return jsonWithETag(ctx, generateResponse)
+}
+
+// listClusterSecrets lists the ArgoCD cluster secrets of the instance matching
+// the selector, sorted by name.
+func (clustersHandler *ClustersHandler) listClusterSecrets(ctx echo.Context, instance string, selector labels.Selector) ([]corev1.Secret, error) {
+	localClient, err := clustersHandler.k8sClientFactory(loggerFrom(ctx))
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to get k8s client", logging.KeyError, err)
+		return nil, err
+	}
+	return listClusterSecrets(ctx, localClient, instance, selector)
+}
+
+func listClusterSecrets(ctx echo.Context, localClient client.Reader, instance string, selector labels.Selector) ([]corev1.Secret, error) {
+	namespace, reader, err := clusterRegistry(instance, localClient)
+	if err != nil {
+		return nil, err
+	}
+	requirement, err := labels.NewRequirement(clusterSecretTypeLabel, selection.Equals, []string{clusterSecretType})
+	if err != nil {
+		return nil, err
+	}
+
+	secretList := &corev1.SecretList{}
+	err = reader.List(
+		ctx.Request().Context(),
+		secretList,
+		client.InNamespace(namespace),
+		&client.ListOptions{LabelSelector: selector.Add(*requirement)},
+	)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to list cluster secrets", "namespace", namespace, logging.KeyError, err)
+		return nil, err
+	}
+
+	sort.Slice(secretList.Items, func(i, j int) bool { return secretList.Items[i].Name < secretList.Items[j].Name })
+	return secretList.Items, nil
+}
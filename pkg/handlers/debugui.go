@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+//go:embed debugui.html
+var debugUIPage []byte
+
+// DebugUIHandler serves a page where operators try selectors against the
+// clusters, to shorten the feedback loop of authoring ApplicationSets. The
+// page itself holds no data, its requests are authenticated with the admin
+// key entered in it.
+type DebugUIHandler struct {
+	params *GetParamsHandler
+}
+
+func NewDebugUIHandler(params *GetParamsHandler) *DebugUIHandler {
+	return &DebugUIHandler{params: params}
+}
+
+// DebugUIRequest is a selector tried from the page.
+type DebugUIRequest struct {
+	ClusterName string `json:"clusterName"`
+	// Selector is a label selector in the kubectl syntax, e.g.
+	// konflux.ci/type=user,!app.kubernetes.io/instance.
+	Selector          string   `json:"selector"`
+	AllowAll          bool     `json:"allowAll"`
+	ExcludeNamespaces []string `json:"excludeNamespaces"`
+	LabelKeys         []string `json:"labelKeys"`
+}
+
+// Page serves the page.
+func (handler *DebugUIHandler) Page(ctx echo.Context) error {
+	// The page must not be framed, so the key can't be phished through it.
+	ctx.Response().Header().Set("X-Frame-Options", "DENY")
+	ctx.Response().Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	return ctx.HTMLBlob(http.StatusOK, debugUIPage)
+}
+
+// Generate returns the v1alpha2 response of the plugin for a selector tried
+// from the page, with the debug info.
+func (handler *DebugUIHandler) Generate(ctx echo.Context) error {
+	uiReq := &DebugUIRequest{}
+	if err := decodeJson(ctx, uiReq); err != nil {
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("failed to parse request body: %s", err))
+	}
+	selector, err := metav1.ParseToLabelSelector(uiReq.Selector)
+	if err != nil {
+		return classifiedErrorResponse(ctx, generrors.ErrSelectorInvalid, fmt.Sprintf("failed to parse label selector: %s", err))
+	}
+	req := &v1alpha2.GenerateRequest{Input: v1alpha2.Input{Parameters: v1alpha2.InParameters{
+		LabelSelector:     *selector,
+		ClusterName:       uiReq.ClusterName,
+		AllowAll:          uiReq.AllowAll,
+		ExcludeNamespaces: uiReq.ExcludeNamespaces,
+		LabelKeys:         uiReq.LabelKeys,
+		Debug:             true,
+	}}, ClusterRef: uiReq.ClusterName}
+
+	start := time.Now()
+	localClient, err := handler.params.k8sClientFactory(loggerFrom(ctx))
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to get k8s client", logging.KeyError, err)
+		httpErr := generateError(err, "failed to get k8s client")
+		recordGenerate(ctx, req, start, nil, httpErr)
+		return generateErrorJSON(ctx, httpErr)
+	}
+	response, httpErr := generate(ctx, localClient, handler.params.remoteClients, handler.params.responses, handler.params.snapshots, req)
+	if httpErr != nil {
+		return generateErrorJSON(ctx, httpErr)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const (
+	eventStreamHeartbeatInterval = 30 * time.Second
+	eventStreamRetryInterval     = time.Second
+)
+
+type NamespaceEventsHandler struct {
+	k8sClientFactory K8sClientFactory
+	remoteClients    *RemoteClientCache
+	localWatchClient client.WithWatch
+	// shutdownCtx is canceled to end the streams when the server shuts down.
+	shutdownCtx context.Context
+	shutdown    context.CancelFunc
+}
+
+func NewNamespaceEventsHandler(k8sClientFactory K8sClientFactory, remoteClients *RemoteClientCache, localWatchClient client.WithWatch) *NamespaceEventsHandler {
+	shutdownCtx, shutdown := context.WithCancel(context.Background())
+	return &NamespaceEventsHandler{
+		k8sClientFactory: k8sClientFactory,
+		remoteClients:    remoteClients,
+		localWatchClient: localWatchClient,
+		shutdownCtx:      shutdownCtx,
+		shutdown:         shutdown,
+	}
+}
+
+// Shutdown ends the streams being served, which would otherwise keep the
+// server from shutting down. Clients reconnect to another replica.
+func (eventsHandler *NamespaceEventsHandler) Shutdown() {
+	eventsHandler.shutdown()
+}
+
+// StreamNamespaceEvents streams server-sent events for namespaces starting or
+// stopping to match the label selector. The namespaces matching the selector
+// when the stream starts are sent as "added" events.
+func (eventsHandler *NamespaceEventsHandler) StreamNamespaceEvents(ctx echo.Context) error {
+	target, httpErr := resolveWatchTarget(ctx, eventsHandler.k8sClientFactory, eventsHandler.remoteClients, eventsHandler.localWatchClient)
+	if httpErr != nil {
+		return generateErrorJSON(ctx, httpErr)
+	}
+
+	response := ctx.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	// The stream is long lived, so it must not be cut by the server write timeout.
+	if err := http.NewResponseController(response).SetWriteDeadline(time.Time{}); err != nil {
+		loggerFrom(ctx).Warn("Failed to clear the write deadline of the event stream", logging.KeyError, err)
+	}
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+
+	stream := &namespaceEventStream{
+		ctx:         ctx,
+		client:      target.client,
+		selector:    target.selector,
+		clusterName: target.clusterName,
+		known:       map[string]struct{}{},
+	}
+	streamCtx, cancel := context.WithCancel(ctx.Request().Context())
+	defer cancel()
+	stopOnShutdown := context.AfterFunc(eventsHandler.shutdownCtx, cancel)
+	defer stopOnShutdown()
+	stream.run(streamCtx)
+	return nil
+}
+
+// watchTarget is the cluster and the selector of a request watching
+// namespaces.
+type watchTarget struct {
+	client      client.WithWatch
+	selector    labels.Selector
+	clusterName string
+}
+
+// resolveWatchTarget checks a request for the namespaces matching the
+// labelSelector query parameter on the cluster of the clusterName one, in the
+// ArgoCD instance of the instance one, and returns the client to watch them with. The local cluster is watched with
+// localWatchClient. An empty selector requires the allowAll query parameter.
+func resolveWatchTarget(ctx echo.Context, k8sClientFactory K8sClientFactory, remoteClients *RemoteClientCache, localWatchClient client.WithWatch) (*watchTarget, *echo.HTTPError) {
+	selector, err := labels.Parse(ctx.QueryParam("labelSelector"))
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to parse label selector", logging.KeyError, err)
+		return nil, generateError(generrors.ErrSelectorInvalid, fmt.Sprintf("failed to parse label selector: %s", err))
+	}
+	if err := getPolicy().checkMatchAll(ctx.QueryParam("allowAll") == "true", selector); err != nil {
+		return nil, generateError(err, err.Error())
+	}
+	if err := getPolicy().checkSelectorLimits(selector); err != nil {
+		return nil, generateError(err, err.Error())
+	}
+	clusterName, err := clusterRef(ctx.QueryParam("instance"), ctx.QueryParam("clusterName"))
+	if err != nil {
+		return nil, generateError(generrors.ErrInvalidRequest, err.Error())
+	}
+	if err := checkVisibility(ctx, "", clusterName, selector); err != nil {
+		return nil, generateError(err, err.Error())
+	}
+	if selector, err = scopeSelector(ctx, selector); err != nil {
+		return nil, generateError(generrors.Wrap(generrors.ErrRequestDenied, err), fmt.Sprintf("invalid tenants: %s", err))
+	}
+
+	watchClient := localWatchClient
+	policy := getPolicy()
+	selector = policy.requireLabels(selector)
+	if !policy.clusterAllowed(clusterName) {
+		return nil, generateError(generrors.ErrClusterForbidden, fmt.Sprintf("cluster %s isn't allowed", clusterName))
+	}
+	if err := policy.authorize(ctx, authorizationRequest{ClusterName: clusterName, Selector: selector}); err != nil {
+		return nil, generateError(err, "request denied by the authorization policy")
+	}
+	if clusterName != "" {
+		localClient, err := k8sClientFactory(loggerFrom(ctx))
+		if err != nil {
+			loggerFrom(ctx).Error("Failed to get k8s client", logging.KeyError, err)
+			return nil, generateError(err, "failed to get k8s client")
+		}
+		watchClient, _, err = remoteClients.getClient(ctx, localClient, clusterName)
+		if err != nil {
+			return nil, generateError(err, fmt.Sprintf("failed to create remote client: %s", generrors.KindOf(err)))
+		}
+	} else if watchClient == nil {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, "watching the local cluster isn't available")
+	}
+	return &watchTarget{client: watchClient, selector: selector, clusterName: clusterName}, nil
+}
+
+type namespaceEventStream struct {
+	ctx         echo.Context
+	client      client.WithWatch
+	selector    labels.Selector
+	clusterName string
+	// known holds the namespaces that were already sent as "added".
+	known map[string]struct{}
+}
+
+func (stream *namespaceEventStream) run(ctx context.Context) {
+	heartbeat := time.NewTicker(eventStreamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		resourceVersion, err := stream.sync(ctx)
+		if err == nil {
+			err = stream.watch(ctx, resourceVersion, heartbeat.C)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			loggerFrom(stream.ctx).Error("Failed to watch namespaces", logging.KeyError, err)
+			if err := stream.send(v1alpha1.NamespaceEvent{Type: v1alpha1.NamespaceEventError, Message: err.Error()}); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventStreamRetryInterval):
+		}
+	}
+}
+
+// sync lists the matching namespaces and sends events for the differences
+// with the namespaces that were already sent. It returns the resource version
+// of the list for starting a watch from.
+func (stream *namespaceEventStream) sync(ctx context.Context) (string, error) {
+	nsList := generator.NewNamespaceList()
+	if err := generator.ListNamespacePages(ctx, stream.client, nsList, stream.selector); err != nil {
+		return "", err
+	}
+
+	current := map[string]struct{}{}
+	for _, namespace := range nsList.Items {
+		current[namespace.Name] = struct{}{}
+		if err := stream.added(namespace.Name); err != nil {
+			return "", err
+		}
+	}
+	for name := range stream.known {
+		if _, ok := current[name]; !ok {
+			if err := stream.removed(name); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return nsList.ResourceVersion, nil
+}
+
+// watch sends events until the watch is closed by the API server. A nil error
+// means the caller should sync again and restart the watch.
+func (stream *namespaceEventStream) watch(ctx context.Context, resourceVersion string, heartbeat <-chan time.Time) error {
+	watcher, err := stream.client.Watch(ctx, generator.NewNamespaceList(), &client.ListOptions{
+		LabelSelector: stream.selector,
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat:
+			if _, err := fmt.Fprint(stream.ctx.Response(), ": keep-alive\n\n"); err != nil {
+				return err
+			}
+			stream.ctx.Response().Flush()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			namespace, isNamespace := event.Object.(*metav1.PartialObjectMetadata)
+			switch {
+			case event.Type == watch.Error:
+				// Most likely the resource version is too old, start over.
+				loggerFrom(stream.ctx).Debug("Namespace watch returned an error", "object", event.Object)
+				return nil
+			case !isNamespace:
+				continue
+			case event.Type == watch.Added:
+				err = stream.added(namespace.Name)
+			case event.Type == watch.Deleted:
+				err = stream.removed(namespace.Name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (stream *namespaceEventStream) added(name string) error {
+	if _, ok := stream.known[name]; ok {
+		return nil
+	}
+	stream.known[name] = struct{}{}
+	return stream.send(v1alpha1.NamespaceEvent{Type: v1alpha1.NamespaceEventAdded, Namespace: name})
+}
+
+func (stream *namespaceEventStream) removed(name string) error {
+	if _, ok := stream.known[name]; !ok {
+		return nil
+	}
+	delete(stream.known, name)
+	return stream.send(v1alpha1.NamespaceEvent{Type: v1alpha1.NamespaceEventRemoved, Namespace: name})
+}
+
+func (stream *namespaceEventStream) send(event v1alpha1.NamespaceEvent) error {
+	// The events name the cluster secret like the generated parameters.
+	_, event.ClusterName = splitClusterRef(stream.clusterName)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	response := stream.ctx.Response()
+	if _, err := fmt.Fprintf(response, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	response.Flush()
+	return nil
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// Explain takes the same request as GetParams and reports, for every
+// namespace of the cluster, whether it matched and the result of each filter.
+func (paramsHandler *GetParamsHandler) Explain(ctx echo.Context) error {
+	req, err := decodeGenerateRequest(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to parse request body", logging.KeyError, err)
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("failed to parse request body: %s", err))
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&req.Input.Parameters.LabelSelector)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to parse label selector", logging.KeyError, err)
+		return classifiedErrorResponse(ctx, generrors.ErrSelectorInvalid, fmt.Sprintf("failed to parse label selector: %s", err))
+	}
+	if err := getPolicy().checkMatchAll(req.Input.Parameters.AllowAll, selector); err != nil {
+		return classifiedErrorResponse(ctx, err, err.Error())
+	}
+	if err := getPolicy().checkSelectorLimits(selector); err != nil {
+		return classifiedErrorResponse(ctx, err, err.Error())
+	}
+	if err := checkVisibility(ctx, req.ApplicationSetName, req.ClusterRef, selector); err != nil {
+		return classifiedErrorResponse(ctx, err, err.Error())
+	}
+	if selector, err = scopeSelector(ctx, selector); err != nil {
+		return classifiedErrorResponse(ctx, generrors.Wrap(generrors.ErrRequestDenied, err), fmt.Sprintf("invalid tenants: %s", err))
+	}
+
+	policy := getPolicy()
+	if !policy.clusterAllowed(req.ClusterRef) {
+		return classifiedErrorResponse(ctx, generrors.ErrClusterForbidden, fmt.Sprintf("cluster %s isn't allowed", req.ClusterRef))
+	}
+	authzReq := authorizationRequest{ApplicationSet: req.ApplicationSetName, ClusterName: req.ClusterRef, Selector: selector}
+	if err := policy.authorize(ctx, authzReq); err != nil {
+		return classifiedErrorResponse(ctx, err, "request denied by the authorization policy")
+	}
+
+	localClient, err := paramsHandler.k8sClientFactory(loggerFrom(ctx))
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to get k8s client", logging.KeyError, err)
+		return classifiedErrorResponse(ctx, err, "failed to get k8s client")
+	}
+
+	// All the namespaces are candidates, so the filters are evaluated here
+	// instead of on the API server.
+	nsList := generator.NewNamespaceList()
+	if err := listNamespaces(ctx, localClient, paramsHandler.remoteClients, req.ClusterRef, nsList, labels.Everything()); err != nil {
+		return classifiedErrorResponse(ctx, err, fmt.Sprintf("failed to list namespaces: %s", generrors.KindOf(err)))
+	}
+
+	// The namespaces left out by the policy of the generator are never
+	// returned, whatever the request, so they aren't explained either.
+	serverFilters := policyFilters(policy)
+	filters := append(selectorFilters(selector), regoFilters(ctx, policy, req, selector)...)
+	filters = append(filters, excludeFilters(req.Input.Parameters.ExcludeNamespaces)...)
+	filters = append(filters, expiryFilter(time.Now()))
+	filters = append(filters, settlingFilter(policy.SettlingPeriod, time.Now())...)
+	explainResponse := &v1alpha1.ExplainResponse{Namespaces: make([]v1alpha1.NamespaceExplanation, 0, len(nsList.Items))}
+	for i := range nsList.Items {
+		// The namespaces of other tenants, or outside of the label domains
+		// visible to the consumer, aren't explained, as that would reveal
+		// them.
+		if !inTenantScope(ctx, &nsList.Items[i]) || !namespaceVisible(ctx, req.ApplicationSetName, req.ClusterRef, &nsList.Items[i]) {
+			continue
+		}
+		if !matchesFilters(&nsList.Items[i], serverFilters) {
+			continue
+		}
+		explainResponse.Namespaces = append(explainResponse.Namespaces, explainNamespace(&nsList.Items[i], filters))
+	}
+
+	return ctx.JSON(http.StatusOK, explainResponse)
+}
+
+func explainNamespace(namespace *metav1.PartialObjectMetadata, filters []namespaceFilter) v1alpha1.NamespaceExplanation {
+	explanation := v1alpha1.NamespaceExplanation{
+		Namespace: namespace.Name,
+		Matched:   true,
+		Filters:   make([]v1alpha1.FilterResult, 0, len(filters)),
+	}
+	for _, filter := range filters {
+		matched := filter.matches(namespace)
+		explanation.Filters = append(explanation.Filters, v1alpha1.FilterResult{Filter: filter.description, Matched: matched})
+		explanation.Matched = explanation.Matched && matched
+	}
+	return explanation
+}
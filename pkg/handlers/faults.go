@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/features"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// Malformations of the injected malformed results.
+const (
+	// MalformationEmpty returns no namespaces.
+	MalformationEmpty = "empty"
+	// MalformationDuplicates returns every namespace twice.
+	MalformationDuplicates = "duplicates"
+	// MalformationTruncated returns the first half of the namespaces.
+	MalformationTruncated = "truncated"
+	// MalformationInvalidNames returns names which aren't valid namespace
+	// names.
+	MalformationInvalidNames = "invalidNames"
+)
+
+// Fault describes the faults injected in the requests for a cluster.
+type Fault struct {
+	// Latency is added before listing the namespaces.
+	Latency time.Duration
+	// ErrorRate is the fraction of the requests failing with Error.
+	ErrorRate float64
+	Error     *generrors.Kind
+	// MalformedRate is the fraction of the successful requests whose result
+	// is malformed as described by Malformation.
+	MalformedRate float64
+	Malformation  string
+}
+
+var currentFaults atomic.Pointer[map[string]Fault]
+
+// SetFaults sets the faults injected in the requests for the clusters, keyed
+// by cluster name, the local cluster being in-cluster. They're only injected
+// when the FaultInjection feature gate is enabled.
+func SetFaults(faults map[string]Fault) {
+	currentFaults.Store(&faults)
+}
+
+// errInjectedFault is the cause of the injected failures, so they can be
+// told apart in the logs.
+var errInjectedFault = fmt.Errorf("fault injected by the %s feature gate", features.FaultInjection)
+
+// injectFault injects the latency and the failure of the faults of the
+// cluster, and returns the malformation to apply to the result, if any.
+func injectFault(ctx echo.Context, clusterName string) (string, error) {
+	if !features.Enabled(features.FaultInjection) {
+		return "", nil
+	}
+	faults := currentFaults.Load()
+	if faults == nil {
+		return "", nil
+	}
+	if clusterName == "" {
+		clusterName = audit.LocalCluster
+	}
+	fault, ok := (*faults)[clusterName]
+	if !ok {
+		return "", nil
+	}
+
+	if fault.Latency > 0 {
+		metrics.InjectedFaults.WithLabelValues(clusterName, "latency").Inc()
+		timer := time.NewTimer(fault.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Request().Context().Done():
+			return "", context.Cause(ctx.Request().Context())
+		}
+	}
+	if fault.ErrorRate > 0 && rand.Float64() < fault.ErrorRate {
+		metrics.InjectedFaults.WithLabelValues(clusterName, "error").Inc()
+		kind := fault.Error
+		if kind == nil {
+			kind = generrors.ErrClusterUnreachable
+		}
+		loggerFrom(ctx).Warn("Injecting a failure", "code", kind.Code)
+		return "", generrors.Wrap(kind, errInjectedFault)
+	}
+	if fault.MalformedRate > 0 && rand.Float64() < fault.MalformedRate {
+		metrics.InjectedFaults.WithLabelValues(clusterName, "malformed").Inc()
+		malformation := fault.Malformation
+		if malformation == "" {
+			malformation = MalformationDuplicates
+		}
+		loggerFrom(ctx).Warn("Injecting a malformed result", "malformation", malformation)
+		return malformation, nil
+	}
+	return "", nil
+}
+
+// malformNamespaces malforms the listed namespaces as described by
+// malformation.
+func malformNamespaces(nsList *metav1.PartialObjectMetadataList, malformation string) {
+	switch malformation {
+	case MalformationEmpty:
+		nsList.Items = nil
+	case MalformationDuplicates:
+		nsList.Items = append(nsList.Items, nsList.Items...)
+	case MalformationTruncated:
+		nsList.Items = nsList.Items[:len(nsList.Items)/2]
+	case MalformationInvalidNames:
+		for i := range nsList.Items {
+			nsList.Items[i].Name = "Invalid_" + nsList.Items[i].Name + "."
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/expiry"
+)
+
+// ReadyAnnotation marks a namespace as ready when set to true, e.g. by the
+// controller bootstrapping it, so it's returned before its settling period
+// elapses.
+const ReadyAnnotation = "generator.konflux-ci.dev/ready"
+
+// namespaceFilter decides whether a namespace is part of the result.
+type namespaceFilter struct {
+	// description identifies the filter in explanations.
+	description string
+	matches     func(namespace *metav1.PartialObjectMetadata) bool
+}
+
+// selectorFilters returns a filter for each requirement of the selector, so
+// explanations can point at the exact requirement excluding a namespace.
+func selectorFilters(selector labels.Selector) []namespaceFilter {
+	requirements, _ := selector.Requirements()
+
+	filters := make([]namespaceFilter, 0, len(requirements))
+	for _, requirement := range requirements {
+		requirement := requirement
+		filters = append(filters, namespaceFilter{
+			description: fmt.Sprintf("labelSelector: %s", requirement.String()),
+			matches: func(namespace *metav1.PartialObjectMetadata) bool {
+				return requirement.Matches(labels.Set(namespace.Labels))
+			},
+		})
+	}
+
+	return filters
+}
+
+// excludeFilters returns a filter dropping the given namespaces.
+func excludeFilters(names []string) []namespaceFilter {
+	if len(names) == 0 {
+		return nil
+	}
+
+	excluded := sets.New(names...)
+	return []namespaceFilter{{
+		description: fmt.Sprintf("excludeNamespaces: %s", strings.Join(names, ",")),
+		matches: func(namespace *metav1.PartialObjectMetadata) bool {
+			return !excluded.Has(namespace.Name)
+		},
+	}}
+}
+
+// expiryFilter returns a filter dropping the namespaces expired at the given
+// time, see pkg/expiry.
+func expiryFilter(now time.Time) namespaceFilter {
+	return namespaceFilter{
+		description: fmt.Sprintf("%s: expired", expiry.TTLAnnotation),
+		matches: func(namespace *metav1.PartialObjectMetadata) bool {
+			return !expiry.Expired(namespace, now)
+		},
+	}
+}
+
+// settlingFilter returns a filter dropping the namespaces still settling at
+// the given time, so Applications aren't generated into them before the
+// controllers bootstrapping namespaces are done.
+func settlingFilter(period time.Duration, now time.Time) []namespaceFilter {
+	if period <= 0 {
+		return nil
+	}
+	return []namespaceFilter{{
+		description: fmt.Sprintf("policy settlingPeriod: %s", period),
+		matches: func(namespace *metav1.PartialObjectMetadata) bool {
+			return !settling(namespace, period, now)
+		},
+	}}
+}
+
+// settling reports whether a namespace was created less than period before
+// now and isn't annotated as ready.
+func settling(namespace *metav1.PartialObjectMetadata, period time.Duration, now time.Time) bool {
+	return period > 0 && namespace.Annotations[ReadyAnnotation] != "true" && now.Sub(namespace.CreationTimestamp.Time) < period
+}
+
+// policyFilters returns the filters of the server-wide policy.
+func policyFilters(policy *Policy) []namespaceFilter {
+	filters := excludeFilters(policy.ExcludeNamespaces)
+	for _, requirement := range policy.requiredLabels {
+		requirement := requirement
+		filters = append(filters, namespaceFilter{
+			description: fmt.Sprintf("requiredLabels: %s", requirement.String()),
+			matches: func(namespace *metav1.PartialObjectMetadata) bool {
+				return requirement.Matches(labels.Set(namespace.Labels))
+			},
+		})
+	}
+	for i := range filters {
+		filters[i].description = "policy " + filters[i].description
+	}
+	return filters
+}
+
+func matchesFilters(namespace *metav1.PartialObjectMetadata, filters []namespaceFilter) bool {
+	for _, filter := range filters {
+		if !filter.matches(namespace) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,192 +2,533 @@ package handlers
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"golang.org/x/oauth2/google"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/rest"
-	"net/http"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/clusterconfig"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+	"github.com/konflux-ci/namespace-generator/pkg/tracing"
 )
 
+// ArgoCDNamespace is the namespace of the ArgoCD cluster secrets. It's set
+// from the configuration on startup.
+var ArgoCDNamespace = "argocd"
+
 const (
-	ArgoCDNamespace = "argocd"
-	Remote          = "remote"
+	Remote = "remote"
 )
 
-type ClusterSecretConfig struct {
-	ExecProviderConfig struct {
-		APIVersion string   `json:"apiVersion"`
-		Command    string   `json:"command"`
-		Args       []string `json:"args"`
-	} `json:"execProviderConfig,omitempty"`
-	TLSClientConfig struct {
-		Insecure bool   `json:"insecure"`
-		CAData   string `json:"caData"`
-	} `json:"tlsClientConfig"`
-}
-
-var defaultGCPScopes = []string{
-	"https://www.googleapis.com/auth/cloud-platform",
-	"https://www.googleapis.com/auth/userinfo.email",
-}
+// errLocalClientUnavailable is reported when a request is served without a
+// client for the local cluster, which only happens when snapshots are kept.
+var errLocalClientUnavailable = errors.New("the local cluster client isn't available")
 
-type K8sClientFactory func(echo.Logger) (client.Reader, error)
+type K8sClientFactory func(*slog.Logger) (client.Reader, error)
 
 type GetParamsHandler struct {
 	k8sClientFactory K8sClientFactory
+	remoteClients    *RemoteClientCache
+	responses        *ResponseCache
+	snapshots        *SnapshotStore
+	// streamThreshold is the number of parameter sets above which responses
+	// are streamed. Zero disables streaming.
+	streamThreshold int
+	// localWatchClient watches the local namespaces for the requests waiting
+	// for changes.
+	localWatchClient client.WithWatch
+	// shutdownCtx is canceled to end the waits when the server shuts down.
+	shutdownCtx context.Context
+	shutdown    context.CancelFunc
 }
 
-func NewGetParamsHandler(k8sClientFactory K8sClientFactory) *GetParamsHandler {
-	return &GetParamsHandler{k8sClientFactory: k8sClientFactory}
+func NewGetParamsHandler(k8sClientFactory K8sClientFactory, remoteClients *RemoteClientCache, responses *ResponseCache, snapshots *SnapshotStore, streamThreshold int, localWatchClient client.WithWatch) *GetParamsHandler {
+	shutdownCtx, shutdown := context.WithCancel(context.Background())
+	return &GetParamsHandler{
+		k8sClientFactory: k8sClientFactory,
+		remoteClients:    remoteClients,
+		responses:        responses,
+		snapshots:        snapshots,
+		streamThreshold:  streamThreshold,
+		localWatchClient: localWatchClient,
+		shutdownCtx:      shutdownCtx,
+		shutdown:         shutdown,
+	}
+}
+
+// Shutdown ends the requests waiting for changes with their current
+// response, which would otherwise keep the server from shutting down.
+func (paramsHandler *GetParamsHandler) Shutdown() {
+	paramsHandler.shutdown()
 }
 
 // +kubebuilder:rbac:groups=tekton.dev,resources=pipelineruns,verbs=get;list;watch;create;update;patch
 func (paramsHandler *GetParamsHandler) GetParams(ctx echo.Context) error {
-	req := &v1alpha1.GenerateRequest{}
-	err := decodeJson(ctx.Request().Body, req)
+	req, err := decodeGenerateRequest(ctx)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to parse request body", logging.KeyError, err)
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("failed to parse request body: %s", err))
+	}
 
+	start := time.Now()
+	localClient, err := paramsHandler.k8sClientFactory(loggerFrom(ctx))
 	if err != nil {
-		ctx.Logger().Errorf("Failed to parse request body, %s", err)
-		return ctx.NoContent(http.StatusBadRequest)
+		loggerFrom(ctx).Error("Failed to get k8s client", logging.KeyError, err)
+		// Snapshots may still be served without a client.
+		if paramsHandler.snapshots.Len() == 0 {
+			httpErr := generateError(err, "failed to get k8s client")
+			recordGenerate(ctx, req, start, nil, httpErr)
+			return generateErrorJSON(ctx, httpErr)
+		}
+	}
+
+	generateResponse, httpErr := generate(ctx, localClient, paramsHandler.remoteClients, paramsHandler.responses, paramsHandler.snapshots, req)
+	if httpErr != nil {
+		return generateErrorJSON(ctx, httpErr)
+	}
+	// Snapshots are served while the cluster fails, and debug responses
+	// differ every time, so neither waits.
+	if req.Input.Parameters.WaitSeconds > 0 && generateResponse.Stale == nil && !req.Input.Parameters.Debug {
+		generateResponse, httpErr = paramsHandler.waitForChange(ctx, localClient, req, generateResponse)
+		if httpErr != nil {
+			return generateErrorJSON(ctx, httpErr)
+		}
+	}
+	if generateResponse.Stale != nil {
+		ctx.Response().Header().Set(headerWarning, staleWarning)
+		setRetryAfter(ctx, generateResponse.Stale.RetryAfterSeconds)
+	}
+	if generateResponse.Truncated {
+		ctx.Response().Header().Add(headerWarning, truncatedWarning)
+	}
+
+	if paramsHandler.streamThreshold > 0 && len(generateResponse.Output.Parameters) > paramsHandler.streamThreshold {
+		return streamGenerateResponse(ctx, generateResponse)
+	}
+	return jsonWithETag(ctx, generateResponseFor(ctx, generateResponse))
+}
+
+// generate lists the namespaces matching a single request. On failure, the
+// returned error holds the status code and the message for the client.
+func generate(ctx echo.Context, localClient client.Reader, remoteClients *RemoteClientCache, responses *ResponseCache, snapshots *SnapshotStore, req *v1alpha2.GenerateRequest) (*v1alpha2.GenerateResponse, *echo.HTTPError) {
+	start := time.Now()
+	generateResponse, httpErr := generateNamespaces(ctx, localClient, remoteClients, responses, snapshots, req)
+	if httpErr == nil {
+		// Cached and stale responses are checked as well.
+		if httpErr = checkNamespaceQuota(ctx, req, generateResponse); httpErr != nil {
+			generateResponse = nil
+		}
+	}
+	recordGenerate(ctx, req, start, generateResponse, httpErr)
+	return generateResponse, httpErr
+}
+
+// recordGenerate passes the outcome of a generate request to the metrics, the
+// audit and the generation reports.
+func recordGenerate(ctx echo.Context, req *v1alpha2.GenerateRequest, start time.Time, response *v1alpha2.GenerateResponse, httpErr *echo.HTTPError) {
+	applicationSet := requestApplicationSet(ctx, req.ApplicationSetName)
+	result := metrics.ResultSuccess
+	if httpErr != nil {
+		metrics.GenerateErrors.WithLabelValues(generrors.CodeOf(httpErr.Internal)).Inc()
+		result = metrics.ResultError
 	}
+	metrics.ApplicationSetRequests.WithLabelValues(applicationSet.Namespace, applicationSet.Name, result).Inc()
+	metrics.ObserveWithTrace(ctx.Request().Context(), metrics.GenerateDuration.WithLabelValues(result), time.Since(start).Seconds())
+	auditGenerate(ctx, req, start, response, httpErr)
+	reportGenerate(ctx, req, response, httpErr)
+}
 
+func generateNamespaces(ctx echo.Context, localClient client.Reader, remoteClients *RemoteClientCache, responses *ResponseCache, snapshots *SnapshotStore, req *v1alpha2.GenerateRequest) (*v1alpha2.GenerateResponse, *echo.HTTPError) {
 	selector, err := metav1.LabelSelectorAsSelector(&req.Input.Parameters.LabelSelector)
 	if err != nil {
-		ctx.Logger().Errorf("Failed to parse label selector %s, %s", err)
-		return ctx.NoContent(http.StatusBadRequest)
+		loggerFrom(ctx).Error("Failed to parse label selector", logging.KeyError, err)
+		return nil, generateError(generrors.Wrap(generrors.ErrSelectorInvalid, err), fmt.Sprintf("failed to parse label selector: %s", err))
+	}
+	policy := getPolicy()
+	if err := policy.checkMatchAll(req.Input.Parameters.AllowAll, selector); err != nil {
+		return nil, generateError(err, err.Error())
+	}
+	if err := policy.checkSelectorLimits(selector); err != nil {
+		return nil, generateError(err, err.Error())
 	}
+	if err := checkVisibility(ctx, req.ApplicationSetName, req.ClusterRef, selector); err != nil {
+		return nil, generateError(err, err.Error())
+	}
+	if selector, err = scopeSelector(ctx, selector); err != nil {
+		return nil, generateError(generrors.Wrap(generrors.ErrRequestDenied, err), fmt.Sprintf("invalid tenants: %s", err))
+	}
+	selector = policy.requireLabels(selector)
 
-	localClient, err := paramsHandler.k8sClientFactory(ctx.Logger())
+	clusterName := req.ClusterRef
+	logFields := []any{logging.KeyCluster, clusterName, logging.KeySelector, selector.String()}
+	if _, ok := applicationSetIdentity(ctx); !ok {
+		// Otherwise the ApplicationSet is already logged from the headers.
+		logFields = append(logFields, logging.KeyAppSet, req.ApplicationSetName)
+	}
+	ctx = withLogger(ctx, loggerFrom(ctx).With(logFields...))
+	logger := loggerFrom(ctx)
+
+	timeoutSeconds := req.Input.Parameters.TimeoutSeconds
+	if timeoutSeconds < 0 {
+		return nil, generateError(generrors.ErrInvalidRequest, "timeoutSeconds must not be negative")
+	}
+	if req.Input.Parameters.WaitSeconds < 0 {
+		return nil, generateError(generrors.ErrInvalidRequest, "waitSeconds must not be negative")
+	}
+
+	user, err := impersonatedUser(ctx)
 	if err != nil {
-		ctx.Logger().Errorf("Failed to get k8s client: %s", err)
-		return ctx.NoContent(http.StatusInternalServerError)
+		return nil, generateError(err, err.Error())
+	}
+
+	if !policy.clusterAllowed(clusterName) {
+		return nil, generateError(generrors.ErrClusterForbidden, fmt.Sprintf("cluster %s isn't allowed", clusterName))
+	}
+	if err := policy.authorize(ctx, authorizationRequest{ApplicationSet: req.ApplicationSetName, ClusterName: clusterName, Selector: selector}); err != nil {
+		return nil, generateError(err, "request denied by the authorization policy")
 	}
 
-	nsList := &corev1.NamespaceList{}
+	nsList := generator.NewNamespaceList()
 
-	clusterName := req.Input.Parameters.ClusterName
-	if clusterName == "" {
-		ctx.Logger().Debug("No cluster name found in request. Searching for local cluster namespaces")
-		err = getLocalNamespaces(ctx, localClient, nsList, selector)
-	} else {
-		ctx.Logger().Debug(fmt.Sprintf("Found secret name in request '%s'", clusterName))
-		err = getRemoteClusterNamespaces(ctx, localClient, nsList, selector, req)
+	reqCtx, recorder := withStageRecorder(ctx.Request().Context())
+	if timeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(reqCtx, time.Duration(timeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	cacheKey := responseCacheKey(req, selector, policy, user)
+	var cacheGeneration uint64
+	if responses != nil {
+		var cached *v1alpha2.GenerateResponse
+		var ok bool
+		cached, cacheGeneration, ok = responses.get(reqCtx, clusterName, cacheKey)
+		recordCacheHit(reqCtx, "response", ok)
+		if ok {
+			logger.Debug("Serving cached response")
+			generateResponse := *cached
+			if req.Input.Parameters.Debug {
+				generateResponse.Debug = recorder.debugInfo(clusterName, len(generateResponse.Output.Parameters))
+			}
+			return &generateResponse, nil
+		}
+	}
+
+	spanCtx, span := tracing.Start(reqCtx, "Generate", trace.WithAttributes(
+		attribute.String("applicationset.name", req.ApplicationSetName),
+		attribute.String("cluster.name", clusterName),
+		attribute.String("selector", selector.String()),
+	))
+	defer span.End()
+	ctx = withRequestContext(ctx, spanCtx)
+
+	var malformation string
+	if localClient == nil {
+		err = errLocalClientUnavailable
+	} else if malformation, err = injectFault(ctx, clusterName); err == nil {
+		err = listNamespaces(ctx, localClient, remoteClients, clusterName, nsList, selector)
 	}
 	if err != nil {
-		return ctx.NoContent(http.StatusInternalServerError)
+		if saved, ok := snapshots.get(cacheKey); ok {
+			logger.Warn("Serving the last snapshot", "snapshot_at", saved.SavedAt, logging.KeyError, err)
+			generateResponse := &v1alpha2.GenerateResponse{
+				Output: saved.Response.Output,
+				Stale: &v1alpha2.StaleInfo{
+					SnapshotAt:        saved.SavedAt,
+					Reason:            err.Error(),
+					RetryAfterSeconds: retryAfterSeconds(DegradedRetryAfter),
+				},
+			}
+			if req.Input.Parameters.Debug {
+				generateResponse.Debug = recorder.debugInfo(clusterName, len(generateResponse.Output.Parameters))
+			}
+			return generateResponse, nil
+		}
+		if errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+			logger.Error("Request timed out", "timeout_seconds", timeoutSeconds)
+			timeoutErr := &timeoutError{details: recorder.timeoutDetails(timeoutSeconds)}
+			return nil, generateError(timeoutErr, fmt.Sprintf("request timed out after %d seconds", timeoutSeconds))
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// The timeout of a stage expired.
+			timeoutErr := &timeoutError{details: recorder.timeoutDetails(timeoutSeconds)}
+			return nil, generateError(timeoutErr, fmt.Sprintf("request timed out: %s", err))
+		}
+		return nil, generateError(err, fmt.Sprintf("failed to list namespaces: %s", generrors.KindOf(err)))
+	}
+
+	malformNamespaces(nsList, malformation)
+
+	// The label selector was applied by the API server.
+	filters := append(policyFilters(policy), regoFilters(ctx, policy, req, selector)...)
+	filters = append(filters, excludeFilters(req.Input.Parameters.ExcludeNamespaces)...)
+	now := time.Now()
+	filters = append(filters, expiryFilter(now))
+	filters = append(filters, settlingFilter(policy.SettlingPeriod, now)...)
+
+	generateResponse := &v1alpha2.GenerateResponse{}
+	for i := range nsList.Items {
+		namespace := &nsList.Items[i]
+		if !matchesFilters(namespace, filters) {
+			continue
+		}
+		parameters := generator.OutParameters(namespace, req.Input.Parameters.ClusterName, req.Input.Parameters.LabelKeys)
+		generator.AddAnnotations(&parameters, namespace, req.Input.Parameters.AnnotationKeys, req.Input.Parameters.OpenShiftProject)
+		if parameters.Values, err = policy.renderValues(namespace, req.Input.Parameters.ClusterName); err != nil {
+			logger.Error("Failed to render the output templates", "namespace", namespace.Name, logging.KeyError, err)
+			return nil, generateError(err, "failed to render the output templates")
+		}
+		generateResponse.Output.Parameters = append(generateResponse.Output.Parameters, parameters)
+	}
+
+	if maxResults := policy.MaxResults; maxResults > 0 && len(generateResponse.Output.Parameters) > maxResults {
+		logger.Warn("Truncated the response", "namespaces", len(generateResponse.Output.Parameters), "max_results", maxResults)
+		metrics.TruncatedResponses.Inc()
+		truncate(generateResponse, maxResults)
 	}
 
-	generateResponse := &v1alpha1.GenerateResponse{}
-	for _, namespace := range nsList.Items {
-		generateResponse.Output.Parameters = append(
-			generateResponse.Output.Parameters,
-			v1alpha1.OutParameters{
-				Namespace: namespace.Name,
-			},
-		)
+	// Only the size of the response is logged, values rendered from the
+	// output templates may carry anything found in the annotations.
+	logger.Debug("Generated response", "namespaces", len(generateResponse.Output.Parameters))
+
+	// The cached response is a copy, so the debug info isn't cached. Nothing
+	// invalidates the response when a namespace settles, so responses
+	// leaving out settling namespaces aren't cached.
+	if !slices.ContainsFunc(nsList.Items, func(namespace metav1.PartialObjectMetadata) bool {
+		return settling(&namespace, policy.SettlingPeriod, now)
+	}) {
+		cached := *generateResponse
+		responses.set(ctx.Request().Context(), clusterName, cacheKey, cacheGeneration, &cached)
 	}
+	if err := snapshots.save(cacheKey, clusterName, generateResponse); err != nil {
+		logger.Error("Failed to save the snapshot of the response", logging.KeyError, err)
+	}
+
+	if req.Input.Parameters.Debug {
+		generateResponse.Debug = recorder.debugInfo(clusterName, len(generateResponse.Output.Parameters))
+	}
+
+	return generateResponse, nil
+}
+
+// truncate keeps the first namespaces of the response by name, so the same
+// namespaces are returned whatever the order they were listed in.
+func truncate(response *v1alpha2.GenerateResponse, maxResults int) {
+	parameters := response.Output.Parameters
+	sort.SliceStable(parameters, func(i, j int) bool { return parameters[i].Namespace < parameters[j].Namespace })
+	response.Output.Parameters = parameters[:maxResults:maxResults]
+	response.Truncated = true
+	response.Total = len(parameters)
+}
+
+// generateError returns the error of a failed generate request, with the
+// status of the kind err is classified as. err is kept as the internal error,
+// so the kind is reported in the response, the metrics and the audit.
+func generateError(err error, message string) *echo.HTTPError {
+	return echo.NewHTTPError(generrors.KindOf(err).Status, message).SetInternal(err)
+}
+
+// timeoutError carries the details of a timed out request in the internal
+// error of the returned echo.HTTPError.
+type timeoutError struct {
+	details *v1alpha1.TimeoutDetails
+}
 
-	ctx.Logger().Debugf("Cluster Name: '%s' - Response: %+v", clusterName, generateResponse)
+func (err *timeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %d seconds", err.details.TimeoutSeconds)
+}
 
-	return ctx.JSON(http.StatusOK, generateResponse)
+func (err *timeoutError) Unwrap() error {
+	return generrors.ErrTimeout
 }
 
-func getRemoteClusterNamespaces(ctx echo.Context, cl client.Reader, nsList *corev1.NamespaceList, selector labels.Selector, req *v1alpha1.GenerateRequest) error {
-	secretName := req.Input.Parameters.ClusterName
+// generateErrorResponse builds the body returned for a failed generate request.
+func generateErrorResponse(ctx echo.Context, httpErr *echo.HTTPError) *v1alpha1.ErrorResponse {
+	response := &v1alpha1.ErrorResponse{
+		Message:   httpErr.Message.(string),
+		Code:      generrors.CodeOf(httpErr.Internal),
+		RequestID: requestID(ctx),
+	}
+	var timeoutErr *timeoutError
+	if errors.As(httpErr.Internal, &timeoutErr) {
+		response.Timeout = timeoutErr.details
+	}
+	var quotaErr *quotaError
+	if errors.As(httpErr.Internal, &quotaErr) {
+		response.Quota = quotaErr.details
+	}
+	if isDegraded(httpErr.Internal) {
+		response.RetryAfterSeconds = retryAfterSeconds(DegradedRetryAfter)
+	}
+	return response
+}
 
-	// Get the secret from the argocd namespace.
-	secret := &corev1.Secret{}
-	err := cl.Get(context.Background(), client.ObjectKey{Namespace: ArgoCDNamespace, Name: secretName}, secret)
+// listNamespaces lists the namespaces matching the selector on the local
+// cluster, or on the remote cluster if clusterName is set.
+func listNamespaces(ctx echo.Context, localClient client.Reader, remoteClients *RemoteClientCache, clusterName string, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	user, err := impersonatedUser(ctx)
 	if err != nil {
-		ctx.Logger().Errorf("Failed to get secret %s in namespace %s: %v", secretName, ArgoCDNamespace, err)
 		return err
 	}
-	ctx.Logger().Debugf("Found secret %s", secretName)
+	if clusterName == "" {
+		loggerFrom(ctx).Debug("No cluster name found in request. Searching for local cluster namespaces")
+		if user != "" {
+			return getImpersonatedLocalNamespaces(ctx, user, nsList, selector)
+		}
+		return getLocalNamespaces(ctx, localClient, nsList, selector)
+	}
+	loggerFrom(ctx).Debug("Found secret name in request", logging.KeyCluster, clusterName)
+	return getRemoteClusterNamespaces(ctx, localClient, remoteClients, clusterName, user, nsList, selector)
+}
 
-	// Extract connection data from the secret.
-	clusterEndpoint, ok := secret.Data["server"]
-	if !ok {
-		err := fmt.Errorf("secret %s missing 'server' key", secretName)
-		ctx.Logger().Error(err.Error())
+// getRemoteClusterNamespaces lists the namespaces of a remote cluster, as the
+// impersonated user if set.
+func getRemoteClusterNamespaces(ctx echo.Context, cl client.Reader, remoteClients *RemoteClientCache, clusterName, user string, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	remoteClient, server, err := remoteClients.getClient(ctx, cl, clusterName)
+	if err != nil {
 		return err
 	}
+	if user != "" {
+		if remoteClient, err = remoteClients.impersonatedClient(ctx, clusterName, user); err != nil {
+			return err
+		}
+	}
 
-	caBytes, ok := secret.Data["config"]
-	if !ok {
-		err := fmt.Errorf("secret %s missing 'config' key", secretName)
-		ctx.Logger().Error(err.Error())
+	// List namespaces from the remote cluster, filtered by the given label selector.
+	stageCtx, endStage := startStage(ctx.Request().Context(), stageList)
+	spanCtx, span := tracing.Start(stageCtx, "ListNamespaces", trace.WithAttributes(attribute.String("cluster.server", server)))
+	err = withRetry(spanCtx, remoteClients.options.Retry, "list", func() error {
+		// The projects are listed as the generator, impersonated users are
+		// expected to list the namespaces.
+		if user == "" && remoteClients.useProjects(clusterName) {
+			return generator.ListProjectPages(spanCtx, remoteClient, nsList, selector)
+		}
+		err := listRemoteNamespaces(ctx, spanCtx, remoteClient, remoteClients, clusterName, nsList, selector)
+		if user == "" && apierrors.IsForbidden(err) && remoteClients.options.ProjectsFallback {
+			return listProjectsInstead(ctx, spanCtx, remoteClient, remoteClients, clusterName, nsList, selector, err)
+		}
 		return err
+	})
+	tracing.End(span, err)
+	endStage(err)
+	remoteClients.recordResult(clusterName, err)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to list namespaces on remote cluster", "server", server, logging.KeyError, err)
+		if user != "" && apierrors.IsForbidden(err) {
+			return classifyImpersonatedError(err)
+		}
+		return generator.ClassifyRemoteError(err)
 	}
 
-	var configObj ClusterSecretConfig
-	if err := json.Unmarshal(caBytes, &configObj); err != nil {
-		ctx.Logger().Errorf("failed to unmarshal secret config: %v", err)
-		return err
+	return nil
+}
+
+// listRemoteNamespaces lists the namespaces of a remote cluster, with a
+// streaming list if the cluster supports them.
+func listRemoteNamespaces(ctx echo.Context, listCtx context.Context, remoteClient client.WithWatch, remoteClients *RemoteClientCache, clusterName string, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	if remoteClients.useWatchList(clusterName) {
+		err := streamNamespaces(listCtx, remoteClient, nsList, selector)
+		if err == nil || !isWatchListRejected(err) {
+			return err
+		}
+		loggerFrom(ctx).Warn("Cluster rejected a streaming list, falling back to paged lists", logging.KeyCluster, clusterName, logging.KeyError, err)
+		remoteClients.disableWatchList(clusterName)
 	}
+	return generator.ListNamespacePages(listCtx, remoteClient, nsList, selector)
+}
 
-	// Decode the inner CA data from base64.
-	decodedCA, err := base64.StdEncoding.DecodeString(configObj.TLSClientConfig.CAData)
-	if err != nil {
-		ctx.Logger().Errorf("Failed to decode CA data: %v", err)
-		return err
+// listProjectsInstead lists the OpenShift projects of a cluster which forbade
+// listing its namespaces, as restricted service accounts may only list the
+// projects they have access to. The projects are listed for the next
+// requests too once they could be. The error of the namespaces is returned
+// if the projects can't be listed either, e.g. on clusters which aren't
+// OpenShift clusters.
+func listProjectsInstead(ctx echo.Context, listCtx context.Context, remoteClient client.WithWatch, remoteClients *RemoteClientCache, clusterName string, nsList *metav1.PartialObjectMetadataList, selector labels.Selector, namespacesErr error) error {
+	if err := generator.ListProjectPages(listCtx, remoteClient, nsList, selector); err != nil {
+		loggerFrom(ctx).Debug("Failed to list the projects of the cluster", logging.KeyCluster, clusterName, logging.KeyError, err)
+		return namespacesErr
 	}
+	loggerFrom(ctx).Warn("Cluster forbids listing namespaces, listing projects instead", logging.KeyCluster, clusterName, logging.KeyError, namespacesErr)
+	remoteClients.enableProjects(clusterName)
+	return nil
+}
 
-	// Use the Google Cloud Workload Identity to get a token.
-	// This code is exactly what argocd-k8s-auth uses.
-	cred, err := google.FindDefaultCredentials(context.Background(), defaultGCPScopes...)
+// getClusterSecret gets an ArgoCD cluster secret by its reference, see
+// clusterRef. It also returns the reader of the ArgoCD instance holding the
+// secret, which reads the CA ConfigMap the secret references.
+func getClusterSecret(ctx echo.Context, localClient client.Reader, ref string) (*corev1.Secret, client.Reader, error) {
+	instance, secretName := splitClusterRef(ref)
+	namespace, reader, err := clusterRegistry(instance, localClient)
 	if err != nil {
-		ctx.Logger().Errorf("failed to get default credentials: %v", err)
-		return err
+		return nil, nil, err
 	}
-	t, err := cred.TokenSource.Token()
+	stageCtx, endStage := startStage(ctx.Request().Context(), stageSecret)
+	spanCtx, span := tracing.Start(stageCtx, "GetClusterSecret", trace.WithAttributes(attribute.String("secret.name", secretName)))
+	secret, err := generator.GetClusterSecret(spanCtx, reader, namespace, secretName)
+	tracing.End(span, err)
+	endStage(err)
 	if err != nil {
-		ctx.Logger().Errorf("failed to get token: %v", err)
-		return err
+		loggerFrom(ctx).Error("Failed to get cluster secret", "secret", secretName, "namespace", namespace, logging.KeyError, err)
+		return nil, nil, err
 	}
+	loggerFrom(ctx).Debug("Found cluster secret", "secret", secretName)
 
-	remoteCfg := &rest.Config{
-		Host: string(clusterEndpoint),
-		TLSClientConfig: rest.TLSClientConfig{
-			CAData: decodedCA,
-		},
-		BearerToken: t.AccessToken,
-	}
+	return secret, reader, nil
+}
 
-	// Create a remote Kubernetes client using controller-runtime.
-	remoteClient, err := client.New(remoteCfg, client.Options{})
+// getRemoteClusterConfig builds the rest config for accessing the cluster
+// described by the given ArgoCD cluster secret, reading its CA ConfigMap with
+// the reader of its ArgoCD instance if it references one. It also returns the version of the
+// config, which changes with the secret and the ConfigMap. Authentication is
+// left to the caller.
+func getRemoteClusterConfig(ctx echo.Context, secretReader client.Reader, secret *corev1.Secret) (*rest.Config, string, error) {
+	cluster, err := clusterconfig.Parse(secret)
 	if err != nil {
-		ctx.Logger().Errorf("Failed to create remote client for cluster at %s: %v", string(clusterEndpoint), err)
-		return err
+		loggerFrom(ctx).Error("Invalid cluster secret", "secret", secret.Name, logging.KeyError, err)
+		return nil, "", err
 	}
-
-	// List namespaces from the remote cluster, filtered by the given label selector.
-	err = remoteClient.List(context.Background(), nsList, &client.ListOptions{LabelSelector: selector})
+	caVersion, err := cluster.LoadCA(ctx.Request().Context(), secretReader)
 	if err != nil {
-		ctx.Logger().Errorf("Failed to list namespaces on remote cluster: %v with error: %v", string(clusterEndpoint), err)
-		return err
+		loggerFrom(ctx).Error("Failed to load the CA bundle of the cluster secret", "secret", secret.Name, logging.KeyError, err)
+		return nil, "", err
 	}
-
-	return nil
+	version := secret.ResourceVersion
+	if caVersion != "" {
+		version += "/" + caVersion
+	}
+	return cluster.RESTConfig(), version, nil
 }
 
-func getLocalNamespaces(ctx echo.Context, cl client.Reader, nsList *corev1.NamespaceList, selector labels.Selector) error {
+func getLocalNamespaces(ctx echo.Context, cl client.Reader, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	stageCtx, endStage := startStage(ctx.Request().Context(), stageList)
+	spanCtx, span := tracing.Start(stageCtx, "ListNamespaces", trace.WithAttributes(attribute.String("cluster.server", "local")))
 	err := cl.List(
-		context.Background(),
+		spanCtx,
 		nsList,
 		&client.ListOptions{LabelSelector: selector},
 	)
+	tracing.End(span, err)
+	endStage(err)
 	if err != nil {
-		ctx.Logger().Errorf("Failed to list namespaces, %s", err)
+		loggerFrom(ctx).Error("Failed to list namespaces", logging.KeyError, err)
 	}
 
 	return err
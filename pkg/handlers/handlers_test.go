@@ -0,0 +1,1806 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/features"
+	"github.com/konflux-ci/namespace-generator/pkg/handlers"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+	"github.com/konflux-ci/namespace-generator/pkg/provisioning"
+	"github.com/konflux-ci/namespace-generator/pkg/recording"
+)
+
+func TestHandlers(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Handlers Suite")
+}
+
+// fakeTokenSource returns a static token, or err when set.
+type fakeTokenSource struct {
+	err   error
+	calls int
+}
+
+func (tokens *fakeTokenSource) Name() string { return "fake" }
+
+func (tokens *fakeTokenSource) Token(context.Context) (*oauth2.Token, error) {
+	tokens.calls++
+	if tokens.err != nil {
+		return nil, tokens.err
+	}
+	return &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func (tokens *fakeTokenSource) Expiry() time.Time { return time.Time{} }
+
+func (tokens *fakeTokenSource) Invalidate() {}
+
+// memoryStore is a shared cache store kept in memory, ignoring the TTLs.
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func (store *memoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	value, ok := store.entries[key]
+	return value, ok, nil
+}
+
+func (store *memoryStore) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.entries[key] = value
+	return nil
+}
+
+func (store *memoryStore) DeletePrefix(_ context.Context, prefix string) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	deleted := 0
+	for key := range store.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(store.entries, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func newFakeClient(objects ...client.Object) client.WithWatch {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+var _ = Describe("GetParamsHandler with a remote cluster", func() {
+	var (
+		tokens        *fakeTokenSource
+		configs       []*rest.Config
+		local         client.WithWatch
+		remote        client.WithWatch
+		remoteClients *handlers.RemoteClientCache
+		responses     *handlers.ResponseCache
+		e             *echo.Echo
+	)
+
+	BeforeEach(func() {
+		tokens = &fakeTokenSource{}
+		configs = nil
+		local = newFakeClient(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "remote1-secret", Namespace: handlers.ArgoCDNamespace},
+			Data: map[string][]byte{
+				"server": []byte("https://remote1:6443"),
+				"config": []byte("{}"),
+			},
+		})
+		remote = newFakeClient(
+			namespace("remote-ns", map[string]string{"konflux.ci/type": "user"}),
+			namespace("other-ns", nil),
+		)
+		remoteClients = handlers.NewRemoteClientCache(tokens, handlers.RemoteClientOptions{
+			ClientFactory: func(_ context.Context, cfg *rest.Config) (client.WithWatch, error) {
+				configs = append(configs, cfg)
+				return remote, nil
+			},
+		})
+		responses = handlers.NewResponseCache(time.Hour, nil)
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, responses, nil, 0, nil)
+
+		e = echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+	})
+
+	getParams := func() *httptest.ResponseRecorder {
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("should list the namespaces with the client of the factory", func() {
+		rec := getParams()
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+		Expect(configs).To(HaveLen(1))
+		Expect(configs[0].Host).To(Equal("https://remote1:6443"))
+		Expect(tokens.calls).To(BeNumerically(">", 0))
+	})
+
+	It("should reuse the client of the cluster", func() {
+		Expect(getParams().Code).To(Equal(http.StatusOK))
+		Expect(getParams().Code).To(Equal(http.StatusOK))
+		Expect(configs).To(HaveLen(1))
+	})
+
+	It("should read the cluster secret of the ArgoCD instance of the request", func(ctx SpecContext) {
+		handlers.SetArgoCDInstances([]handlers.ArgoCDInstance{{Name: "shard-b", Namespace: "argocd-shard-b"}})
+		DeferCleanup(handlers.SetArgoCDInstances, []handlers.ArgoCDInstance(nil))
+		Expect(local.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "remote1-secret", Namespace: "argocd-shard-b"},
+			Data: map[string][]byte{
+				"server": []byte("https://shard-b-remote1:6443"),
+				"config": []byte("{}"),
+			},
+		})).To(Succeed())
+
+		Expect(getParams().Code).To(Equal(http.StatusOK))
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "argocdInstance": "shard-b", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(configs).To(HaveLen(2))
+		Expect(configs[1].Host).To(Equal("https://shard-b-remote1:6443"))
+
+		// The output names the cluster secret, not its reference.
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, v1alpha2.MediaType)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		response := &v1alpha2.GenerateResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Output.Parameters).To(HaveLen(1))
+		Expect(response.Output.Parameters[0].ClusterName).To(Equal("remote1-secret"))
+
+		body = strings.Replace(body, "shard-b", "shard-z", 1)
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should audit the requests with the authenticated caller", func() {
+		var events bytes.Buffer
+		auditor := audit.NewAuditor(audit.NewWriterSink(&events), 10, slog.Default())
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, responses, nil, 0, nil)
+		e = echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		e.POST("/api/v1/getparams.execute", paramsHandler.GetParams, func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(ctx echo.Context) error {
+				handlers.SetCaller(ctx, "system:serviceaccount:argocd:applicationset-controller")
+				return next(ctx)
+			}
+		}, handlers.Audit(auditor))
+
+		Expect(getParams().Code).To(Equal(http.StatusOK))
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(
+			`{"applicationSetName": "team-a", "input": {"parameters": {"clusterName": "missing-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(auditor.Close()).To(Succeed())
+
+		decoder := json.NewDecoder(&events)
+		var success, failure audit.Event
+		Expect(decoder.Decode(&success)).To(Succeed())
+		Expect(decoder.Decode(&failure)).To(Succeed())
+		Expect(success.Caller.Subject).To(Equal("system:serviceaccount:argocd:applicationset-controller"))
+		Expect(success.Caller.Address).To(Equal("192.0.2.1"))
+		Expect(success.Clusters).To(Equal([]string{"remote1-secret"}))
+		Expect(success.Selector).To(Equal("konflux.ci/type=user"))
+		Expect(success.Namespaces).To(Equal(1))
+		Expect(success.Outcome).To(Equal(audit.OutcomeSuccess))
+		Expect(failure.Caller.Subject).To(Equal(success.Caller.Subject))
+		Expect(failure.ApplicationSet).To(Equal("team-a"))
+		Expect(failure.Outcome).To(Equal(audit.OutcomeFailure))
+		Expect(failure.Status).To(Equal(http.StatusNotFound))
+		Expect(failure.ErrorCode).To(Equal("SecretNotFound"))
+	})
+
+	It("should ignore the unknown fields only when allowed", func() {
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}, "newField": true}}}`
+		post := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+		Expect(post().Code).To(Equal(http.StatusBadRequest))
+
+		handlers.AllowUnknownFields = true
+		DeferCleanup(func() { handlers.AllowUnknownFields = false })
+		rec := post()
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+	})
+
+	It("should deny the requests the CEL authorization policy doesn't allow", func() {
+		authorization := handlers.Policy{AuthorizationPolicy: `request.clusterName == "remote1-secret" && request.matchLabels["konflux.ci/type"] == "user"`}
+		Expect(handlers.SetPolicy(authorization)).NotTo(Succeed())
+		Expect(features.Default.Set(map[string]bool{string(features.CELAuthorization): true})).To(Succeed())
+		DeferCleanup(features.Default.Set, map[string]bool{string(features.CELAuthorization): false})
+		Expect(handlers.SetPolicy(authorization)).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+
+		for _, entry := range []struct {
+			cluster  string
+			selector string
+			status   int
+		}{
+			{cluster: "remote1-secret", selector: `{"matchLabels": {"konflux.ci/type": "user"}}`, status: http.StatusOK},
+			{cluster: "remote1-secret", selector: `{"matchLabels": {"konflux.ci/type": "tenant"}}`, status: http.StatusForbidden},
+			{cluster: "remote1-secret", selector: `{"matchExpressions": [{"key": "konflux.ci/type", "operator": "Exists"}]}`, status: http.StatusForbidden},
+			{cluster: "missing-secret", selector: `{"matchLabels": {"konflux.ci/type": "user"}}`, status: http.StatusForbidden},
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(
+				`{"input": {"parameters": {"clusterName": "`+entry.cluster+`", "labelSelector": `+entry.selector+`}}}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(entry.status), "cluster %s, selector %s: %s", entry.cluster, entry.selector, rec.Body.String())
+		}
+
+		// A policy failing to evaluate denies the requests.
+		Expect(handlers.SetPolicy(handlers.Policy{AuthorizationPolicy: `request.matchLabels["team"] == "a"`})).To(Succeed())
+		Expect(getParams().Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should leave out the namespaces the Rego policy denies", func(ctx SpecContext) {
+		Expect(remote.Create(ctx, namespace("prod-ns", map[string]string{"konflux.ci/type": "user", "env": "production"}))).To(Succeed())
+		Expect(handlers.SetPolicy(handlers.Policy{RegoPolicy: `package namespace_generator
+
+import rego.v1
+
+deny contains "dev ApplicationSets can't target production" if {
+	startswith(input.request.applicationSet, "dev-")
+	input.namespace.labels.env == "production"
+}`})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+
+		for _, entry := range []struct {
+			applicationSet string
+			output         string
+		}{
+			{applicationSet: "prod-apps", output: `{"output": {"parameters": [{"namespace": "prod-ns"}, {"namespace": "remote-ns"}]}}`},
+			{applicationSet: "dev-apps", output: `{"output": {"parameters": [{"namespace": "remote-ns"}]}}`},
+		} {
+			body := `{"applicationSetName": "` + entry.applicationSet + `", "input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+			Expect(rec.Body.String()).To(MatchJSON(entry.output), "ApplicationSet %s", entry.applicationSet)
+		}
+
+		Expect(handlers.SetPolicy(handlers.Policy{RegoPolicy: "package namespace_generator\n\ndeny contains"})).NotTo(Succeed())
+	})
+
+	It("should only return the namespaces carrying the required labels", func() {
+		Expect(handlers.SetPolicy(handlers.Policy{RequiredLabels: map[string]string{"konflux.ci/type": "user"}})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+
+		for _, selector := range []string{
+			`{"matchLabels": {"konflux.ci/type": "user"}}`,
+			`{"matchExpressions": [{"key": "team", "operator": "DoesNotExist"}]}`,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(
+				`{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": `+selector+`}}}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+			Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`), "selector %s", selector)
+		}
+	})
+
+	It("should only impersonate the users allowed for the requests", func() {
+		handlers.SetImpersonation(handlers.Impersonation{RequestUsers: []string{"alice"}}, nil)
+		DeferCleanup(handlers.SetImpersonation, handlers.Impersonation{}, (*rest.Config)(nil))
+		impersonate := func(user string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(
+				`{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("Impersonate-User", user)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Expect(impersonate("mallory").Code).To(Equal(http.StatusForbidden))
+		rec := impersonate("alice")
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(configs).To(HaveLen(2))
+		Expect(configs[0].Impersonate.UserName).To(BeEmpty())
+		Expect(configs[1].Impersonate.UserName).To(Equal("alice"))
+
+		// The impersonating client is reused.
+		Expect(impersonate("alice").Code).To(Equal(http.StatusOK))
+		Expect(configs).To(HaveLen(2))
+	})
+
+	It("should reject the bodies larger than the limit", func() {
+		handlers.MaxBodyBytes = 128
+		DeferCleanup(func() { handlers.MaxBodyBytes = 1 << 20 })
+		Expect(getParams().Code).To(Equal(http.StatusOK))
+
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "excludeNamespaces": ["` + strings.Repeat("a", 128) + `"], "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).To(ContainSubstring("request body too large"))
+	})
+
+	It("should merge the results of a v1alpha2 batch", func() {
+		batchHandler := handlers.NewBatchHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, responses, nil, 10)
+		e.POST("/api/v1/getparams.batch", batchHandler.GetParamsBatch)
+
+		body := `[
+			{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}},
+			{"input": {"parameters": {"clusterName": "missing-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}
+		]`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.batch", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+
+		response := &v1alpha2.BatchGenerateResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Parameters).To(Equal([]v1alpha2.OutParameters{{Namespace: "remote-ns", ClusterName: "remote1-secret", Phase: "Active"}}))
+		Expect(response.Warnings).To(BeEmpty())
+		Expect(response.Errors).To(HaveLen(1))
+		Expect(response.Errors[0].Index).To(Equal(1))
+		Expect(response.Errors[0].ClusterName).To(Equal("missing-secret"))
+		Expect(response.Errors[0].Status).To(Equal(http.StatusNotFound))
+		Expect(response.Errors[0].Error.Code).To(Equal("SecretNotFound"))
+	})
+
+	It("should fail the requests of a batch exceeding the quotas of their ApplicationSet", func() {
+		handlers.SetQuotas(handlers.Quotas{ApplicationSets: map[string]handlers.ApplicationSetQuota{
+			"team-a": {MaxClusters: 1},
+			"team-b": {MaxNamespaces: 1},
+		}})
+		DeferCleanup(handlers.SetQuotas, handlers.Quotas{})
+		batchHandler := handlers.NewBatchHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, responses, nil, 10)
+		e.POST("/api/v1/getparams.batch", batchHandler.GetParamsBatch)
+
+		body := `[
+			{"applicationSetName": "team-a", "input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}},
+			{"applicationSetName": "team-a", "input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}},
+			{"applicationSetName": "team-b", "input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchExpressions": [{"key": "team", "operator": "DoesNotExist"}]}}}},
+			{"applicationSetName": "team-b", "input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}
+		]`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.batch", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+
+		response := &v1alpha2.BatchGenerateResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Parameters).To(Equal([]v1alpha2.OutParameters{{Namespace: "remote-ns", ClusterName: "remote1-secret", Phase: "Active"}}))
+		Expect(response.Errors).To(HaveLen(3))
+		for i, batchErr := range response.Errors {
+			Expect(batchErr.Index).To(Equal(i))
+			Expect(batchErr.Status).To(Equal(http.StatusForbidden))
+			Expect(batchErr.Error.Code).To(Equal("QuotaExceeded"))
+		}
+		Expect(response.Errors[0].Error.Quota.Resource).To(Equal("clusters"))
+		Expect(response.Errors[2].Error.Quota.Resource).To(Equal("namespaces"))
+	})
+
+	It("should reject the selectors over the limits of the policy", func() {
+		Expect(handlers.SetPolicy(handlers.Policy{MaxSelectorRequirements: 1})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user", "env": "dev"}}}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).To(ContainSubstring("SelectorInvalid"))
+		Expect(configs).To(BeEmpty())
+
+		Expect(getParams().Code).To(Equal(http.StatusOK))
+	})
+
+	It("should truncate the responses over the cap of the policy", func(ctx SpecContext) {
+		Expect(handlers.SetPolicy(handlers.Policy{MaxResults: 1})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+		Expect(remote.Create(ctx, namespace("new-ns", map[string]string{"konflux.ci/type": "user"}))).To(Succeed())
+
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Header().Get("Warning")).To(ContainSubstring("truncated"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns", "clusterName": "remote1-secret", "phase": "Active"}]}, "truncated": true, "total": 2}`))
+	})
+
+	It("should render the output templates with the sprig functions", func() {
+		Expect(handlers.SetPolicy(handlers.Policy{OutputTemplates: map[string]string{"env": `{{ env "HOME" }}`}})).NotTo(Succeed())
+		Expect(handlers.SetPolicy(handlers.Policy{OutputTemplates: map[string]string{
+			"hash": `{{ .Namespace | sha256sum | trunc 8 }}`,
+			"team": `{{ index .Labels "team" | default "none" | upper }}`,
+		}})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns", "clusterName": "remote1-secret", "phase": "Active", "values": {"hash": "9f9999c0", "team": "NONE"}}]}}`))
+	})
+
+	It("should return the metadata of the OpenShift projects", func(ctx SpecContext) {
+		project := namespace("project-ns", map[string]string{"konflux.ci/type": "user"})
+		project.Annotations = map[string]string{
+			"openshift.io/display-name": "My Project",
+			"openshift.io/requester":    "alice",
+			"example.com/owner":         "team-a",
+		}
+		Expect(remote.Create(ctx, project)).To(Succeed())
+
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}, "annotationKeys": ["example.com/owner"], "openshiftProject": true}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [
+			{"namespace": "project-ns", "clusterName": "remote1-secret", "phase": "Active", "annotations": {"example.com/owner": "team-a"}, "project": {"displayName": "My Project", "requester": "alice"}},
+			{"namespace": "remote-ns", "clusterName": "remote1-secret", "phase": "Active"}
+		]}}`))
+	})
+
+	It("should list the projects of the clusters forbidding to list their namespaces", func() {
+		listed := map[string]int{}
+		remote = interceptor.NewClient(remote, interceptor.Funcs{
+			List: func(ctx context.Context, cl client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				kind := list.GetObjectKind().GroupVersionKind().Kind
+				listed[kind]++
+				if kind == "NamespaceList" {
+					return apierrors.NewForbidden(corev1.Resource("namespaces"), "", errors.New("cannot list namespaces"))
+				}
+				project := metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "project-ns", Labels: map[string]string{"konflux.ci/type": "user"}}}
+				list.(*metav1.PartialObjectMetadataList).Items = []metav1.PartialObjectMetadata{project}
+				return nil
+			},
+		})
+		remoteClients = handlers.NewRemoteClientCache(tokens, handlers.RemoteClientOptions{
+			ProjectsFallback: true,
+			ClientFactory: func(context.Context, *rest.Config) (client.WithWatch, error) {
+				return remote, nil
+			},
+		})
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil, nil, 0, nil)
+		e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+
+		for i := 0; i < 2; i++ {
+			rec := getParams()
+			Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+			Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "project-ns"}]}}`))
+		}
+		Expect(listed).To(Equal(map[string]int{"NamespaceList": 1, "ProjectList": 2}))
+	})
+
+	It("should only retry the transient failures, up to the attempts", func() {
+		for _, entry := range []struct {
+			err      error
+			failures int
+			status   int
+			lists    int
+		}{
+			{err: apierrors.NewServerTimeout(corev1.Resource("namespaces"), "list", 1), failures: 2, status: http.StatusOK, lists: 3},
+			{err: apierrors.NewTooManyRequests("slow down", 1), failures: 5, status: http.StatusBadGateway, lists: 3},
+			{err: apierrors.NewBadRequest("invalid selector"), failures: 5, status: http.StatusBadGateway, lists: 1},
+		} {
+			lists := 0
+			failing := interceptor.NewClient(remote, interceptor.Funcs{
+				List: func(ctx context.Context, cl client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+					lists++
+					if lists <= entry.failures {
+						return entry.err
+					}
+					return cl.List(ctx, list, opts...)
+				},
+			})
+			remoteClients = handlers.NewRemoteClientCache(tokens, handlers.RemoteClientOptions{
+				Retry: handlers.RetryConfig{Attempts: 3, InitialBackoff: time.Millisecond},
+				ClientFactory: func(context.Context, *rest.Config) (client.WithWatch, error) {
+					return failing, nil
+				},
+			})
+			paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+				return local, nil
+			}, remoteClients, nil, nil, 0, nil)
+			e = echo.New()
+			e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+
+			rec := getParams()
+			Expect(rec.Code).To(Equal(entry.status), "%v: %s", entry.err, rec.Body.String())
+			Expect(lists).To(Equal(entry.lists), "%v", entry.err)
+		}
+	})
+
+	It("should only inject the faults of the cluster with the FaultInjection gate enabled", func() {
+		// The responses aren't cached, so every request is generated.
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil, nil, 0, nil)
+		e = echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+
+		handlers.SetFaults(map[string]handlers.Fault{"remote1-secret": {ErrorRate: 1, Error: generrors.ErrClusterUnreachable}})
+		DeferCleanup(handlers.SetFaults, map[string]handlers.Fault(nil))
+		rec := getParams()
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+
+		Expect(features.Default.Set(map[string]bool{string(features.FaultInjection): true})).To(Succeed())
+		DeferCleanup(features.Default.Set, map[string]bool{string(features.FaultInjection): false})
+		rec = getParams()
+		Expect(rec.Code).To(Equal(http.StatusBadGateway), rec.Body.String())
+		Expect(rec.Body.String()).To(ContainSubstring(`"code":"ClusterUnreachable"`))
+
+		handlers.SetFaults(map[string]handlers.Fault{"remote2-secret": {ErrorRate: 1}})
+		rec = getParams()
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+
+		handlers.SetFaults(map[string]handlers.Fault{"remote1-secret": {MalformedRate: 1, Malformation: handlers.MalformationDuplicates}})
+		rec = getParams()
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}, {"namespace": "remote-ns"}]}}`))
+	})
+
+	Context("when the cluster doesn't answer", func() {
+		BeforeEach(func() {
+			hanging := interceptor.NewClient(remote, interceptor.Funcs{
+				List: func(ctx context.Context, _ client.WithWatch, _ client.ObjectList, _ ...client.ListOption) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			})
+			remoteClients = handlers.NewRemoteClientCache(tokens, handlers.RemoteClientOptions{
+				ClientFactory: func(context.Context, *rest.Config) (client.WithWatch, error) {
+					return hanging, nil
+				},
+			})
+		})
+
+		serve := func(timeouts handlers.StageTimeouts, body string) *v1alpha1.ErrorResponse {
+			paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+				return local, nil
+			}, remoteClients, nil, nil, 0, nil)
+			e = echo.New()
+			e.HTTPErrorHandler = handlers.HTTPErrorHandler
+			e.Use(handlers.WithStageTimeouts(timeouts))
+			e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusGatewayTimeout), rec.Body.String())
+			response := &v1alpha1.ErrorResponse{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+			Expect(response.Code).To(Equal("Timeout"))
+			Expect(response.Timeout).NotTo(BeNil())
+			return response
+		}
+
+		stageNames := func(stages []v1alpha1.StageTiming) []string {
+			var names []string
+			for _, stage := range stages {
+				names = append(names, stage.Name)
+			}
+			return names
+		}
+
+		It("should report the stage whose timeout expired", func() {
+			body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+			response := serve(handlers.StageTimeouts{Secret: time.Minute, List: 50 * time.Millisecond}, body)
+			Expect(response.Timeout.Stage).To(Equal("list"))
+			Expect(response.Timeout.TimeoutSeconds).To(BeZero())
+			Expect(response.Timeout.ElapsedMillis).To(BeNumerically(">=", 50))
+			Expect(stageNames(response.Timeout.CompletedStages)).To(Equal([]string{"secret", "auth", "client"}))
+		})
+
+		It("should report the stage in progress when the request timed out", func() {
+			body := `{"input": {"parameters": {"clusterName": "remote1-secret", "timeoutSeconds": 1, "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+			response := serve(handlers.StageTimeouts{}, body)
+			Expect(response.Message).To(Equal("request timed out after 1 seconds"))
+			Expect(response.Timeout.Stage).To(Equal("list"))
+			Expect(response.Timeout.TimeoutSeconds).To(Equal(1))
+			Expect(response.Timeout.ElapsedMillis).To(BeNumerically(">=", 1000))
+			Expect(stageNames(response.Timeout.CompletedStages)).To(Equal([]string{"secret", "auth", "client"}))
+		})
+	})
+
+	It("should serve the last snapshot while the cluster fails, until it's too old", func() {
+		failing := false
+		flaky := interceptor.NewClient(remote, interceptor.Funcs{
+			List: func(ctx context.Context, cl client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				if failing {
+					return apierrors.NewServiceUnavailable("the API server is unavailable")
+				}
+				return cl.List(ctx, list, opts...)
+			},
+		})
+		remoteClients = handlers.NewRemoteClientCache(tokens, handlers.RemoteClientOptions{
+			ClientFactory: func(context.Context, *rest.Config) (client.WithWatch, error) {
+				return flaky, nil
+			},
+		})
+		dir := GinkgoT().TempDir()
+		serve := func(snapshots *handlers.SnapshotStore) *httptest.ResponseRecorder {
+			paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+				return local, nil
+			}, remoteClients, nil, snapshots, 0, nil)
+			e = echo.New()
+			e.HTTPErrorHandler = handlers.HTTPErrorHandler
+			e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+			body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		snapshots, err := handlers.NewSnapshotStore(dir, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		rec := serve(snapshots)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Header().Get("Warning")).To(BeEmpty())
+
+		// The snapshot outlives the restarts.
+		failing = true
+		snapshots, err = handlers.NewSnapshotStore(dir, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshots.Len()).To(Equal(1))
+		rec = serve(snapshots)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Header().Get("Warning")).To(Equal(`110 - "Response is Stale"`))
+		Expect(rec.Header().Get("Retry-After")).To(Equal(strconv.Itoa(int(handlers.DegradedRetryAfter.Seconds()))))
+		response := &v1alpha2.GenerateResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Output.Parameters).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"Namespace":   Equal("remote-ns"),
+			"ClusterName": Equal("remote1-secret"),
+		})))
+		Expect(response.Stale).NotTo(BeNil())
+		Expect(response.Stale.Reason).To(ContainSubstring("the API server is unavailable"))
+		Expect(response.Stale.SnapshotAt).To(BeTemporally("~", time.Now(), time.Minute))
+		Expect(response.Stale.RetryAfterSeconds).To(Equal(int(handlers.DegradedRetryAfter.Seconds())))
+
+		// The snapshots older than the max age aren't served.
+		snapshots.SetMaxAge(time.Nanosecond)
+		rec = serve(snapshots)
+		Expect(rec.Code).To(Equal(http.StatusBadGateway), rec.Body.String())
+		Expect(rec.Header().Get("Warning")).To(BeEmpty())
+	})
+
+	It("should leave out the namespaces still settling", func(ctx SpecContext) {
+		Expect(handlers.SetPolicy(handlers.Policy{SettlingPeriod: time.Hour})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+		newNamespace := namespace("new-ns", map[string]string{"konflux.ci/type": "user"})
+		newNamespace.CreationTimestamp = metav1.Now()
+		Expect(remote.Create(ctx, newNamespace)).To(Succeed())
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+
+		newNamespace.Annotations = map[string]string{handlers.ReadyAnnotation: "true"}
+		Expect(remote.Update(ctx, newNamespace)).To(Succeed())
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns"}, {"namespace": "remote-ns"}]}}`))
+	})
+
+	It("should apply the visibility policies of the authenticated caller", func() {
+		handlers.EnforceVisibilityPolicies(true)
+		DeferCleanup(handlers.EnforceVisibilityPolicies, false)
+		handlers.SetVisibilityPolicies([]generatorv1alpha1.NamespaceVisibilityPolicy{{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+			Spec: generatorv1alpha1.NamespaceVisibilityPolicySpec{
+				Consumers:    []generatorv1alpha1.Consumer{{Caller: "team-a", ApplicationSet: "team-a"}},
+				LabelDomains: []string{"konflux.ci"},
+			},
+		}})
+		Expect(handlers.SetPolicy(handlers.Policy{AllowMatchAll: true})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil, nil, 0, nil)
+		e.POST("/api/v1/explain", paramsHandler.Explain)
+		e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(ctx echo.Context) error {
+				handlers.SetCaller(ctx, ctx.Request().Header.Get("X-Test-Caller"))
+				return next(ctx)
+			}
+		})
+
+		for _, entry := range []struct {
+			caller   string
+			selector string
+			status   int
+		}{
+			{caller: "team-a", selector: `{"matchLabels": {"konflux.ci/type": "user"}}`, status: http.StatusOK},
+			{caller: "team-b", selector: `{"matchLabels": {"konflux.ci/type": "user"}}`, status: http.StatusForbidden},
+			{caller: "", selector: `{"matchLabels": {"konflux.ci/type": "user"}}`, status: http.StatusForbidden},
+			{caller: "team-a", selector: `{"matchLabels": {"team": "a"}}`, status: http.StatusForbidden},
+			{caller: "team-a", selector: `{}`, status: http.StatusForbidden},
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(
+				`{"applicationSetName": "team-a", "input": {"parameters": {"clusterName": "remote1-secret", "allowAll": true, "labelSelector": `+entry.selector+`}}}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("X-Test-Caller", entry.caller)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(entry.status), "caller %q, selector %s: %s", entry.caller, entry.selector, rec.Body.String())
+		}
+
+		// The namespaces without a label in the domains aren't explained.
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", strings.NewReader(
+			`{"applicationSetName": "team-a", "input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-Test-Caller", "team-a")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		explanation := &v1alpha1.ExplainResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), explanation)).To(Succeed())
+		Expect(explanation.Namespaces).To(HaveLen(1))
+		Expect(explanation.Namespaces[0].Namespace).To(Equal("remote-ns"))
+	})
+
+	It("should not explain the namespaces excluded by the policy", func() {
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil, nil, 0, nil)
+		e.POST("/api/v1/explain", paramsHandler.Explain)
+
+		for _, entry := range []struct {
+			policy     handlers.Policy
+			namespaces []string
+		}{
+			{policy: handlers.Policy{}, namespaces: []string{"other-ns", "remote-ns"}},
+			{policy: handlers.Policy{RequiredLabels: map[string]string{"konflux.ci/type": "user"}}, namespaces: []string{"remote-ns"}},
+			{policy: handlers.Policy{ExcludeNamespaces: []string{"remote-ns"}}, namespaces: []string{"other-ns"}},
+		} {
+			Expect(handlers.SetPolicy(entry.policy)).To(Succeed())
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", strings.NewReader(
+				`{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchExpressions": [{"key": "team", "operator": "DoesNotExist"}]}}}}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+			explanation := &v1alpha1.ExplainResponse{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), explanation)).To(Succeed())
+			var namespaces []string
+			for _, namespace := range explanation.Namespaces {
+				namespaces = append(namespaces, namespace.Namespace)
+			}
+			Expect(namespaces).To(ConsistOf(entry.namespaces), "policy %+v", entry.policy)
+		}
+	})
+
+	It("should reject the empty selectors without allowAll on every read path", func() {
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil, nil, 0, nil)
+		e.POST("/api/v1/explain", paramsHandler.Explain)
+		eventsHandler := handlers.NewNamespaceEventsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil)
+		e.GET("/api/v1/namespaces/events", eventsHandler.StreamNamespaceEvents)
+		changesHandler := handlers.NewNamespaceChangesHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil, handlers.ChangeFeedOptions{}, slog.Default())
+		defer changesHandler.Shutdown()
+		e.GET("/api/v1/namespaces/changes", changesHandler.ListNamespaceChanges)
+		serve := func(req *http.Request) *httptest.ResponseRecorder {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+		explain := func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/api/v1/explain", strings.NewReader(`{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {}}}}`))
+		}
+
+		for _, req := range []*http.Request{
+			explain(),
+			httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/events?clusterName=remote1-secret", nil),
+			httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/changes?clusterName=remote1-secret", nil),
+			httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/changes?clusterName=remote1-secret&allowAll=true", nil),
+		} {
+			rec := serve(req)
+			Expect(rec.Code).To(Equal(http.StatusForbidden), req.URL.String())
+			Expect(rec.Body.String()).To(ContainSubstring("MatchAllForbidden"))
+		}
+
+		Expect(handlers.SetPolicy(handlers.Policy{AllowMatchAll: true})).To(Succeed())
+		DeferCleanup(handlers.SetPolicy, handlers.Policy{})
+		rec := serve(httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/changes?clusterName=remote1-secret&allowAll=true", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		full := &v1alpha1.NamespaceChanges{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), full)).To(Succeed())
+		Expect(full.Added).To(ConsistOf("remote-ns", "other-ns"))
+		Expect(serve(explain()).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should report the failures of the token source", func() {
+		tokens.err = errors.New("no credentials")
+		rec := getParams()
+		Expect(rec.Code).To(Equal(http.StatusBadGateway))
+		Expect(rec.Body.String()).To(ContainSubstring("AuthFailed"))
+		Expect(rec.Body.String()).To(ContainSubstring(`"retryAfterSeconds":30`))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("30"))
+		Expect(configs).To(BeEmpty())
+	})
+
+	It("should invalidate the cached responses when the namespaces change", func(ctx SpecContext) {
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+		Expect(remote.Create(ctx, namespace("new-ns", map[string]string{"konflux.ci/type": "user"}))).To(Succeed())
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+
+		invalidatorCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		invalidator := handlers.NewCacheInvalidator(nil, remoteClients, responses, handlers.InvalidationOptions{ResyncInterval: time.Hour}, slog.Default())
+		go invalidator.Run(invalidatorCtx)
+		Eventually(func() string {
+			return getParams().Body.String()
+		}).Should(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns"}, {"namespace": "remote-ns"}]}}`))
+
+		Expect(remote.Delete(ctx, namespace("remote-ns", nil))).To(Succeed())
+		Eventually(func() string {
+			return getParams().Body.String()
+		}).Should(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns"}]}}`))
+	})
+
+	It("should keep the cached responses until they're invalidated", func(ctx SpecContext) {
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+		Expect(remote.Create(ctx, namespace("new-ns", map[string]string{"konflux.ci/type": "user"}))).To(Succeed())
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+
+		count, err := responses.InvalidateCluster(ctx, "other-secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(BeZero())
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+
+		count, err = responses.InvalidateCluster(ctx, "remote1-secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(1))
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns"}, {"namespace": "remote-ns"}]}}`))
+	})
+
+	It("should drop the cached responses once they expire", func(ctx SpecContext) {
+		responses = handlers.NewResponseCache(100*time.Millisecond, nil)
+		paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, responses, nil, 0, nil)
+		e = echo.New()
+		e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+		Expect(remote.Create(ctx, namespace("new-ns", map[string]string{"konflux.ci/type": "user"}))).To(Succeed())
+		Expect(getParams().Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+		Eventually(func() string {
+			return getParams().Body.String()
+		}).Should(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns"}, {"namespace": "remote-ns"}]}}`))
+	})
+
+	It("should share the cached responses between the replicas", func(ctx SpecContext) {
+		store := &memoryStore{entries: map[string][]byte{}}
+		replicas := make([]*handlers.ResponseCache, 2)
+		for i := range replicas {
+			replicas[i] = handlers.NewResponseCache(time.Hour, store)
+		}
+		serve := func(replica *handlers.ResponseCache) string {
+			paramsHandler := handlers.NewGetParamsHandler(func(*slog.Logger) (client.Reader, error) {
+				return local, nil
+			}, remoteClients, replica, nil, 0, nil)
+			e = echo.New()
+			e.POST("/api/v1/getparams.execute", paramsHandler.GetParams)
+			return getParams().Body.String()
+		}
+
+		Expect(serve(replicas[0])).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+		Expect(store.entries).To(HaveLen(1))
+		Expect(remote.Create(ctx, namespace("new-ns", map[string]string{"konflux.ci/type": "user"}))).To(Succeed())
+		Expect(serve(replicas[1])).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns"}]}}`))
+
+		// Invalidating the cluster on a replica drops the shared responses.
+		_, err := replicas[0].InvalidateCluster(ctx, "remote1-secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.entries).To(BeEmpty())
+		Expect(serve(replicas[1])).To(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns"}, {"namespace": "remote-ns"}]}}`))
+	})
+
+	It("should hold the request until the namespaces change", func(ctx SpecContext) {
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}, "waitSeconds": 30}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			e.ServeHTTP(rec, req)
+		}()
+
+		Consistently(done, "200ms").ShouldNot(BeClosed())
+		Expect(remote.Create(ctx, namespace("new-ns", map[string]string{"konflux.ci/type": "user"}))).To(Succeed())
+		Eventually(done).Should(BeClosed())
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "new-ns", "clusterName": "remote1-secret", "phase": "Active"}, {"namespace": "remote-ns", "clusterName": "remote1-secret", "phase": "Active"}]}}`))
+	})
+
+	It("should respond right away when the client is behind", func() {
+		body := `{"input": {"parameters": {"clusterName": "remote1-secret", "labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}, "waitSeconds": 30}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAccept, handlers.MediaTypeV1alpha2)
+		req.Header.Set("If-None-Match", `"outdated"`)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "remote-ns", "clusterName": "remote1-secret", "phase": "Active"}]}}`))
+	})
+
+	It("should list the namespace changes", func(ctx SpecContext) {
+		changesHandler := handlers.NewNamespaceChangesHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, remoteClients, nil, handlers.ChangeFeedOptions{}, slog.Default())
+		defer changesHandler.Shutdown()
+		e.GET("/api/v1/namespaces/changes", changesHandler.ListNamespaceChanges)
+		listChanges := func(since string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/namespaces/changes?clusterName=remote1-secret&labelSelector=konflux.ci/type%3Duser&since="+since, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := listChanges("")
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		full := &v1alpha1.NamespaceChanges{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), full)).To(Succeed())
+		Expect(full.Full).To(BeTrue())
+		Expect(full.Added).To(Equal([]string{"remote-ns"}))
+
+		// The fake client starts the resource versions of its objects
+		// over, so only the cursor errors are checked.
+		Expect(listChanges(full.Cursor).Body.String()).To(MatchJSON(`{"cursor": "` + full.Cursor + `", "added": [], "removed": []}`))
+		Expect(listChanges("1").Code).To(Equal(http.StatusGone))
+		Expect(listChanges("not-a-cursor").Code).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("ClustersHandler", func() {
+	clusterSecret := func(name, project string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: handlers.ArgoCDNamespace,
+				Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster"},
+			},
+			Data: map[string][]byte{
+				"name":    []byte(name),
+				"server":  []byte("https://" + name + ":6443"),
+				"project": []byte(project),
+			},
+		}
+	}
+
+	getClusterParams := func(routeProject, project string) *httptest.ResponseRecorder {
+		local := newFakeClient(clusterSecret("team-a1", "team-a"), clusterSecret("team-b1", "team-b"), clusterSecret("shared", ""))
+		clustersHandler := handlers.NewClustersHandler(func(*slog.Logger) (client.Reader, error) {
+			return local, nil
+		}, handlers.NewRemoteClientCache(&fakeTokenSource{}, handlers.RemoteClientOptions{}), routeProject)
+		e := echo.New()
+		e.POST("/clusters/api/v1/getparams.execute", clustersHandler.GetClusterParams)
+
+		body := `{"input": {"parameters": {"labelSelector": {}, "project": "` + project + `"}}}`
+		req := httptest.NewRequest(http.MethodPost, "/clusters/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	clusterNames := func(rec *httptest.ResponseRecorder) []string {
+		response := &v1alpha1.ClusterGenerateResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		var names []string
+		for _, parameters := range response.Output.Parameters {
+			names = append(names, parameters.Name)
+		}
+		return names
+	}
+
+	It("should only generate the clusters of the project of the request", func() {
+		rec := getClusterParams("", "team-a")
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(clusterNames(rec)).To(Equal([]string{"team-a1"}))
+
+		rec = getClusterParams("", "")
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(clusterNames(rec)).To(Equal([]string{"shared", "team-a1", "team-b1"}))
+	})
+
+	It("should restrict the requests to the project of the route", func() {
+		rec := getClusterParams("team-b", "")
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(clusterNames(rec)).To(Equal([]string{"team-b1"}))
+
+		rec = getClusterParams("team-b", "team-a")
+		Expect(rec.Code).To(Equal(http.StatusForbidden), rec.Body.String())
+	})
+})
+
+var _ = Describe("ClusterProber", func() {
+	var (
+		failing       atomic.Bool
+		created       int
+		local         client.WithWatch
+		remoteClients *handlers.RemoteClientCache
+		prober        *handlers.ClusterProber
+		ctx           echo.Context
+	)
+
+	BeforeEach(func() {
+		failing.Store(false)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if failing.Load() {
+				http.Error(w, "etcd is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			_, _ = w.Write([]byte(`{"gitVersion": "v1.29.0"}`))
+		}))
+		DeferCleanup(server.Close)
+
+		local = newFakeClient(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "remote1-secret",
+				Namespace: handlers.ArgoCDNamespace,
+				Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster"},
+			},
+			Data: map[string][]byte{
+				"server": []byte(server.URL),
+				"config": []byte("{}"),
+			},
+		})
+		created = 0
+		remoteClients = handlers.NewRemoteClientCache(&fakeTokenSource{}, handlers.RemoteClientOptions{
+			ClientFactory: func(context.Context, *rest.Config) (client.WithWatch, error) {
+				created++
+				return newFakeClient(), nil
+			},
+		})
+		prober = handlers.NewClusterProber(remoteClients, handlers.ProbeOptions{Timeout: 10 * time.Second, Concurrency: 2})
+		ctx = echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	})
+
+	health := func() []v1alpha1.ClusterHealth {
+		rec := httptest.NewRecorder()
+		Expect(prober.Health(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec))).To(Succeed())
+		response := &v1alpha1.ClusterHealthResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		return response.Clusters
+	}
+
+	It("should count the consecutive failures and keep the last healthy probe", func() {
+		prober.ProbeAll(ctx, local)
+		clusters := health()
+		Expect(clusters).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"ClusterName":         Equal("remote1-secret"),
+			"Healthy":             BeTrue(),
+			"Version":             Equal("v1.29.0"),
+			"LastHealthy":         Not(BeNil()),
+			"ConsecutiveFailures": BeZero(),
+		})))
+		lastHealthy := *clusters[0].LastHealthy
+		Expect(testutil.ToFloat64(metrics.ClusterHealthy.WithLabelValues("remote1-secret"))).To(Equal(1.0))
+
+		failing.Store(true)
+		prober.ProbeAll(ctx, local)
+		prober.ProbeAll(ctx, local)
+		clusters = health()
+		Expect(clusters).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"Healthy":             BeFalse(),
+			"ConsecutiveFailures": Equal(2),
+			"Code":                Equal("ClusterUnreachable"),
+			"Error":               Not(BeEmpty()),
+		})))
+		Expect(*clusters[0].LastHealthy).To(BeTemporally("==", lastHealthy))
+		Expect(testutil.ToFloat64(metrics.ClusterHealthy.WithLabelValues("remote1-secret"))).To(BeZero())
+
+		failing.Store(false)
+		prober.ProbeAll(ctx, local)
+		clusters = health()
+		Expect(clusters).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"Healthy":             BeTrue(),
+			"ConsecutiveFailures": BeZero(),
+			"Code":                BeEmpty(),
+			"Error":               BeEmpty(),
+		})))
+		Expect(*clusters[0].LastHealthy).To(BeTemporally(">", lastHealthy))
+	})
+
+	It("should probe the invalidated clusters with new clients", func() {
+		prober.ProbeAll(ctx, local)
+		prober.ProbeAll(ctx, local)
+		Expect(created).To(Equal(1))
+
+		Expect(remoteClients.Invalidate("remote1-secret")).To(BeTrue())
+		prober.ProbeAll(ctx, local)
+		Expect(created).To(Equal(2))
+		Expect(health()).To(ConsistOf(MatchFields(IgnoreExtras, Fields{
+			"Healthy":             BeTrue(),
+			"ConsecutiveFailures": BeZero(),
+		})))
+	})
+
+	It("should forget the clusters whose secret was deleted", func() {
+		prober.ProbeAll(ctx, local)
+		Expect(health()).To(HaveLen(1))
+
+		Expect(local.Delete(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "remote1-secret", Namespace: handlers.ArgoCDNamespace},
+		})).To(Succeed())
+		prober.ProbeAll(ctx, local)
+		Expect(health()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Register", func() {
+	register := func(opts handlers.Options) (*echo.Echo, []string) {
+		opts.K8sClientFactory = func(*slog.Logger) (client.Reader, error) {
+			return newFakeClient(namespace("team-a", map[string]string{"konflux.ci/type": "user"})), nil
+		}
+		opts.RemoteClients = handlers.NewRemoteClientCache(&fakeTokenSource{}, handlers.RemoteClientOptions{})
+		e := echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		routes := handlers.Register(e, opts)
+		DeferCleanup(routes.NamespaceChanges.Shutdown)
+		DeferCleanup(routes.NamespaceEvents.Shutdown)
+		DeferCleanup(routes.GetParams.Shutdown)
+
+		var registered []string
+		for _, route := range e.Routes() {
+			registered = append(registered, route.Method+" "+route.Path)
+		}
+		return e, registered
+	}
+
+	It("should serve the API with the required options only", func() {
+		e, registered := register(handlers.Options{})
+		Expect(registered).To(ContainElements(
+			"POST /api/v1/getparams.execute",
+			"POST /api/v1/explain",
+			"POST /api/v1/getparams.batch",
+			"GET /api/v1/clusters",
+			"GET /api/v1/namespaces/events",
+			"GET /api/v1/namespaces/changes",
+		))
+		Expect(registered).To(HaveEach(Not(Or(
+			ContainSubstring(" /admin"),
+			ContainSubstring(" /ui"),
+			ContainSubstring(" /v1alpha2"),
+			ContainSubstring(" /clusters"),
+			Equal("POST /api/v1/namespaces"),
+			Equal("GET /api/v1/clusters/health"),
+			Equal("GET /metrics"),
+		))))
+
+		body := `{"input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": {"parameters": [{"namespace": "team-a"}]}}`))
+	})
+
+	It("should only register the optional routes when their options are set", func() {
+		noop := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+		_, registered := register(handlers.Options{
+			AdminMiddleware:            []echo.MiddlewareFunc{noop},
+			ProvisioningAuthentication: noop,
+			V1alpha2Prefix:             "/v1alpha2",
+			ClustersPrefix:             "/clusters",
+			Prober:                     handlers.NewClusterProber(nil, handlers.ProbeOptions{}),
+			Metrics:                    true,
+		})
+		Expect(registered).To(ContainElements(
+			"GET /admin/clients",
+			"DELETE /admin/responses",
+			"GET /admin/orphans",
+			"POST /api/v1/namespaces",
+			"POST /v1alpha2/api/v1/getparams.execute",
+			"POST /v1alpha2/api/v1/explain",
+			"POST /v1alpha2/api/v1/getparams.batch",
+			"POST /clusters/api/v1/getparams.execute",
+			"GET /api/v1/clusters/health",
+			"GET /metrics",
+		))
+		// The debug UI also requires DebugUI.
+		Expect(registered).NotTo(ContainElement("GET /ui"))
+	})
+})
+
+var _ = Describe("RateLimiter", func() {
+	It("should limit the clients separately within the global limit", func() {
+		e := echo.New()
+		e.Use(handlers.RateLimiter(handlers.RateLimitConfig{GlobalRate: 0.001, GlobalBurst: 3, ClientRate: 0.001, ClientBurst: 2}))
+		e.GET("/", func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		})
+		get := func(source string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = source + ":1234"
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Expect(get("10.0.0.1").Code).To(Equal(http.StatusOK))
+		Expect(get("10.0.0.1").Code).To(Equal(http.StatusOK))
+		rec := get("10.0.0.1")
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rec.Header().Get("Retry-After")).NotTo(BeEmpty())
+		// The rejected request didn't take a token of the global bucket.
+		Expect(get("10.0.0.2").Code).To(Equal(http.StatusOK))
+		Expect(get("10.0.0.3").Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should not trust the proxy headers sent by the clients", func() {
+		e := echo.New()
+		e.Use(handlers.RateLimiter(handlers.RateLimitConfig{ClientRate: 0.001, ClientBurst: 1}))
+		e.GET("/", func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		})
+		get := func(forwardedFor string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec.Code
+		}
+		Expect(get("192.0.2.1")).To(Equal(http.StatusOK))
+		Expect(get("192.0.2.2")).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("should key the client buckets by the authenticated caller", func() {
+		e := echo.New()
+		e.Use(handlers.IdentifyApplicationSet(false))
+		e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(ctx echo.Context) error {
+				handlers.SetCaller(ctx, ctx.Request().Header.Get("X-Test-Caller"))
+				return next(ctx)
+			}
+		})
+		e.Use(handlers.RateLimiter(handlers.RateLimitConfig{ClientRate: 0.001, ClientBurst: 1, KeyFunc: handlers.CallerRateLimitKey}))
+		e.GET("/", func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		})
+		get := func(caller, applicationSet string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Test-Caller", caller)
+			req.Header.Set(handlers.HeaderApplicationSetName, applicationSet)
+			req.Header.Set(handlers.HeaderApplicationSetNamespace, "argocd")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec.Code
+		}
+		Expect(get("team-a", "appset-1")).To(Equal(http.StatusOK))
+		Expect(get("team-a", "appset-2")).To(Equal(http.StatusTooManyRequests))
+		Expect(get("team-b", "appset-1")).To(Equal(http.StatusOK))
+	})
+
+	It("should give each ApplicationSet of a caller its own bucket", func() {
+		e := echo.New()
+		e.Use(handlers.IdentifyApplicationSet(false))
+		e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(ctx echo.Context) error {
+				handlers.SetCaller(ctx, handlers.StaticKeyCaller)
+				return next(ctx)
+			}
+		})
+		e.Use(handlers.RateLimiter(handlers.RateLimitConfig{
+			ClientRate:          0.001,
+			ClientBurst:         4,
+			ApplicationSetRate:  0.001,
+			ApplicationSetBurst: 1,
+			KeyFunc:             handlers.CallerRateLimitKey,
+		}))
+		e.GET("/", func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		})
+		get := func(applicationSet string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if applicationSet != "" {
+				req.Header.Set(handlers.HeaderApplicationSetName, applicationSet)
+				req.Header.Set(handlers.HeaderApplicationSetNamespace, "argocd")
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		// A throttled ApplicationSet doesn't throttle the others sharing the
+		// static key.
+		Expect(get("appset-1")).To(Equal(http.StatusOK))
+		Expect(get("appset-1")).To(Equal(http.StatusTooManyRequests))
+		Expect(get("appset-2")).To(Equal(http.StatusOK))
+		// The requests without headers only use the bucket of the caller,
+		// which bounds the requests of all its ApplicationSets.
+		Expect(get("")).To(Equal(http.StatusOK))
+		Expect(get("appset-3")).To(Equal(http.StatusOK))
+		Expect(get("appset-4")).To(Equal(http.StatusTooManyRequests))
+	})
+})
+
+var _ = Describe("SourceAllowlist", func() {
+	It("should reject the clients outside of the allowed networks", func() {
+		_, allowed, err := net.ParseCIDR("10.0.0.0/16")
+		Expect(err).NotTo(HaveOccurred())
+		_, proxies, err := net.ParseCIDR("172.16.0.0/12")
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, entry := range []struct {
+			trustProxies bool
+			source       string
+			forwardedFor string
+			status       int
+		}{
+			{source: "10.0.0.1", status: http.StatusOK},
+			{source: "10.1.0.1", status: http.StatusForbidden},
+			{source: "192.0.2.1", forwardedFor: "10.0.0.1", status: http.StatusForbidden},
+			{trustProxies: true, source: "172.16.0.1", forwardedFor: "10.0.0.1", status: http.StatusOK},
+			{trustProxies: true, source: "172.16.0.1", forwardedFor: "192.0.2.1", status: http.StatusForbidden},
+			{trustProxies: true, source: "192.0.2.1", forwardedFor: "10.0.0.1", status: http.StatusForbidden},
+		} {
+			e := echo.New()
+			if entry.trustProxies {
+				e.IPExtractor = echo.ExtractIPFromXFFHeader(echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false), echo.TrustIPRange(proxies))
+			}
+			e.Use(handlers.SourceAllowlist([]*net.IPNet{allowed}))
+			e.GET("/", func(ctx echo.Context) error {
+				return ctx.NoContent(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = entry.source + ":1234"
+			if entry.forwardedFor != "" {
+				req.Header.Set(echo.HeaderXForwardedFor, entry.forwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(entry.status), "%+v", entry)
+		}
+	})
+})
+
+var _ = Describe("SecretAllowlistReader", func() {
+	It("should only get the allowlisted cluster secrets", func(ctx SpecContext) {
+		secret := func(namespace, name string) *corev1.Secret {
+			return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster"},
+			}}
+		}
+		var gets []string
+		secrets := fake.NewClientBuilder().WithObjects(
+			secret(handlers.ArgoCDNamespace, "remote1-secret"),
+			secret(handlers.ArgoCDNamespace, "other-secret"),
+			secret("other-namespace", "remote1-secret"),
+		).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, cl client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				gets = append(gets, key.String())
+				return cl.Get(ctx, key, obj, opts...)
+			},
+			List: func(context.Context, client.WithWatch, client.ObjectList, ...client.ListOption) error {
+				return errors.New("the secrets must not be listed")
+			},
+		}).Build()
+		reader := handlers.NewSecretAllowlistReader(newFakeClient(), secrets, []string{"remote1-secret", "missing-secret"})
+
+		Expect(reader.Get(ctx, client.ObjectKey{Namespace: handlers.ArgoCDNamespace, Name: "remote1-secret"}, &corev1.Secret{})).To(Succeed())
+		for _, key := range []client.ObjectKey{
+			{Namespace: handlers.ArgoCDNamespace, Name: "other-secret"},
+			{Namespace: "other-namespace", Name: "remote1-secret"},
+			{Namespace: handlers.ArgoCDNamespace, Name: "missing-secret"},
+		} {
+			err := reader.Get(ctx, key, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue(), "%s: %v", key, err)
+		}
+
+		secretList := &corev1.SecretList{}
+		Expect(reader.List(ctx, secretList, client.InNamespace(handlers.ArgoCDNamespace), client.HasLabels{"argocd.argoproj.io/secret-type"})).To(Succeed())
+		Expect(secretList.Items).To(HaveLen(1))
+		Expect(secretList.Items[0].Name).To(Equal("remote1-secret"))
+		Expect(reader.List(ctx, secretList, client.InNamespace("other-namespace"))).To(Succeed())
+		Expect(secretList.Items).To(BeEmpty())
+
+		// The secrets out of the allowlist are never read.
+		Expect(gets).NotTo(ContainElement(handlers.ArgoCDNamespace + "/other-secret"))
+		Expect(gets).NotTo(ContainElement("other-namespace/remote1-secret"))
+	})
+})
+
+var _ = Describe("InFlightLimiter", func() {
+	var (
+		e       *echo.Echo
+		serving chan struct{}
+		done    chan struct{}
+	)
+
+	limit := func(config handlers.InFlightConfig) {
+		serving = make(chan struct{})
+		done = make(chan struct{})
+		e = echo.New()
+		e.Use(handlers.InFlightLimiter(config))
+		e.GET("/", func(ctx echo.Context) error {
+			if ctx.QueryParam("block") != "" {
+				close(serving)
+				<-done
+			}
+			return ctx.NoContent(http.StatusOK)
+		})
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// block serves a request holding its slot until the returned function is
+	// called, which waits for the response.
+	block := func() func() *httptest.ResponseRecorder {
+		result := make(chan *httptest.ResponseRecorder)
+		go func() {
+			defer GinkgoRecover()
+			result <- get("/?block=true")
+		}()
+		Eventually(serving).Should(BeClosed())
+		return func() *httptest.ResponseRecorder {
+			close(done)
+			return <-result
+		}
+	}
+
+	It("should reject the requests when the queue is full", func() {
+		limit(handlers.InFlightConfig{MaxInFlight: 1, QueueTimeout: time.Minute, RetryAfter: 2 * time.Second})
+		rejected := testutil.ToFloat64(metrics.InFlightRejected)
+		release := block()
+
+		rec := get("/")
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("2"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"message": "too many requests in flight", "retryAfterSeconds": 2}`))
+		Expect(testutil.ToFloat64(metrics.InFlightRejected) - rejected).To(BeNumerically("==", 1))
+
+		// The slot is given back once served.
+		Expect(release().Code).To(Equal(http.StatusOK))
+		Expect(get("/").Code).To(Equal(http.StatusOK))
+	})
+
+	It("should reject the queued requests waiting for longer than the timeout", func() {
+		limit(handlers.InFlightConfig{MaxInFlight: 1, MaxQueued: 1, QueueTimeout: 10 * time.Millisecond, RetryAfter: 1500 * time.Millisecond})
+		release := block()
+
+		start := time.Now()
+		rec := get("/")
+		Expect(time.Since(start)).To(BeNumerically(">=", 10*time.Millisecond))
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("2"))
+
+		Expect(release().Code).To(Equal(http.StatusOK))
+		Expect(get("/").Code).To(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("Recover", func() {
+	It("should turn the panics of the handlers into Internal errors", func() {
+		e := echo.New()
+		e.Use(handlers.Recover())
+		e.GET("/panic", func(echo.Context) error {
+			panic("invalid filter")
+		})
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+		Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rec.Body.String()).To(MatchJSON(`{"message": "internal error", "code": "Internal"}`))
+	})
+})
+
+var _ = Describe("AccessLog", func() {
+	It("should log the requests served with the logger of the request", func() {
+		var logs bytes.Buffer
+		e := echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		e.Use(middleware.RequestID())
+		e.Use(handlers.RequestLogger(logging.New(&logs, slog.LevelInfo)))
+		e.Use(handlers.AccessLog(func(ctx echo.Context) bool {
+			return ctx.Request().URL.Path == "/healthz"
+		}))
+		e.Use(handlers.IdentifyApplicationSet(false))
+		e.GET("/api/v1/clusters", func(echo.Context) error {
+			return echo.NewHTTPError(http.StatusNotFound, "not found")
+		})
+		e.GET("/healthz", func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/clusters?access_token=secret-token", nil)
+		req.Header.Set(echo.HeaderXRequestID, "request-1")
+		req.Header.Set(handlers.HeaderApplicationSetName, "team-a")
+		req.Header.Set(handlers.HeaderApplicationSetNamespace, "argocd")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		Expect(lines).To(HaveLen(1))
+		line := map[string]any{}
+		Expect(json.Unmarshal([]byte(lines[0]), &line)).To(Succeed())
+		Expect(line).To(HaveKeyWithValue("msg", "Request served"))
+		Expect(line).To(HaveKeyWithValue("request_id", "request-1"))
+		Expect(line).To(HaveKeyWithValue("appset", "team-a"))
+		Expect(line).To(HaveKeyWithValue("appset_namespace", "argocd"))
+		Expect(line).To(HaveKeyWithValue("method", http.MethodGet))
+		Expect(line).To(HaveKeyWithValue("uri", "/api/v1/clusters?access_token="+logging.Redacted))
+		Expect(line).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusNotFound)))
+	})
+})
+
+var _ = Describe("Record", func() {
+	It("should record the requests within the size limits", func() {
+		handlers.MaxBodyBytes = 64
+		DeferCleanup(func() { handlers.MaxBodyBytes = 1 << 20 })
+		dir := GinkgoT().TempDir()
+		recorder, err := recording.NewRecorder(dir, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		e := echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		e.POST("/api/v1/getparams.execute", func(ctx echo.Context) error {
+			if _, err := io.ReadAll(ctx.Request().Body); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			return ctx.String(http.StatusOK, strings.Repeat("a", 2<<20))
+		}, handlers.Record(recorder))
+
+		for _, body := range []string{`{"input": {}}`, strings.Repeat("a", 1<<20)} {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/getparams.execute", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer static-key")
+			e.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		records, err := recording.Load(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Body).To(Equal(`{"input": {}}`))
+		Expect(records[0].Headers).NotTo(HaveKey("Authorization"))
+		Expect(records[0].Response.Status).To(Equal(http.StatusOK))
+		Expect(records[0].Response.Body).To(HaveLen(1 << 20))
+		Expect(records[0].Response.Truncated).To(BeTrue())
+		// The bodies over the limit are read up to it.
+		Expect(records[1].Body).To(HaveLen(64))
+		Expect(records[1].Response.Status).To(Equal(http.StatusBadRequest))
+		Expect(records[1].Response.Body).To(ContainSubstring("request body too large"))
+		Expect(records[1].Response.Truncated).To(BeFalse())
+	})
+})
+
+var _ = Describe("OrphansHandler", func() {
+	It("should list the namespaces no claim claims", func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(generatorv1alpha1.AddToScheme(scheme)).To(Succeed())
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			namespace("claimed", map[string]string{"konflux.ci/type": "user"}),
+			namespace("leaked", map[string]string{"konflux.ci/type": "user"}),
+			namespace("manual", map[string]string{"konflux.ci/type": "user"}),
+			namespace("system", nil),
+			&generatorv1alpha1.NamespaceClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "claims", Name: "claimed"}},
+		).Build()
+		leaked := &corev1.Namespace{}
+		Expect(cl.Get(context.Background(), client.ObjectKey{Name: "leaked"}, leaked)).To(Succeed())
+		leaked.Annotations = map[string]string{provisioning.ClaimAnnotation: "claims/leaked"}
+		Expect(cl.Update(context.Background(), leaked)).To(Succeed())
+
+		e := echo.New()
+		e.GET("/admin/orphans", handlers.NewOrphansHandler(cl).ListOrphans)
+		req := httptest.NewRequest(http.MethodGet, "/admin/orphans?labelSelector=konflux.ci/type%3Duser", nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+
+		response := &v1alpha1.OrphanedNamespacesResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Orphans).To(HaveLen(2))
+		Expect(response.Orphans[0].Namespace).To(Equal("leaked"))
+		Expect(response.Orphans[0].Reason).To(Equal(v1alpha1.OrphanReasonClaimDeleted))
+		Expect(response.Orphans[0].Claim).To(Equal("claims/leaked"))
+		Expect(response.Orphans[1].Namespace).To(Equal("manual"))
+		Expect(response.Orphans[1].Reason).To(Equal(v1alpha1.OrphanReasonUnclaimed))
+	})
+})
+
+var _ = Describe("ProvisionHandler", func() {
+	It("should provision a namespace from a template and return its parameters", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(rbacv1.AddToScheme(scheme)).To(Succeed())
+		Expect(networkingv1.AddToScheme(scheme)).To(Succeed())
+		Expect(generatorv1alpha1.AddToScheme(scheme)).To(Succeed())
+		cl := fake.NewClientBuilder().WithScheme(scheme).
+			WithStatusSubresource(&generatorv1alpha1.NamespaceClaim{}).
+			WithObjects(&generatorv1alpha1.NamespaceTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "preview"},
+				Spec: generatorv1alpha1.NamespaceTemplateSpec{
+					Labels: map[string]string{"konflux.ci/type": "preview"},
+				},
+			}).
+			Build()
+		controllerCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go provisioning.NewController(cl, provisioning.Options{ResyncInterval: time.Hour}, slog.Default()).Run(controllerCtx)
+
+		e := echo.New()
+		provisionHandler := handlers.NewProvisionHandler(cl, handlers.ProvisionOptions{ClaimsNamespace: "claims", Timeout: 10 * time.Second})
+		e.POST("/api/v1/namespaces", provisionHandler.ProvisionNamespace)
+		provision := func(body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/namespaces", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := provision(`{"namespace": "pr-42", "template": "preview", "labels": {"pr": "42"}, "labelKeys": ["pr"]}`)
+		Expect(rec.Code).To(Equal(http.StatusCreated), rec.Body.String())
+		response := &v1alpha2.ProvisionNamespaceResponse{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), response)).To(Succeed())
+		Expect(response.Claim).To(Equal("claims/pr-42"))
+		Expect(response.Parameters).To(Equal(&v1alpha2.OutParameters{Namespace: "pr-42", Labels: map[string]string{"pr": "42"}, Phase: "Active"}))
+
+		// Retrying returns the same namespace, while another template
+		// conflicts.
+		Expect(provision(`{"namespace": "pr-42", "template": "preview"}`).Code).To(Equal(http.StatusOK))
+		Expect(provision(`{"namespace": "pr-42", "template": "other"}`).Code).To(Equal(http.StatusConflict))
+
+		rec = provision(`{"namespace": "pr-43", "template": "missing"}`)
+		Expect(rec.Code).To(Equal(http.StatusConflict), rec.Body.String())
+		Expect(rec.Body.String()).To(ContainSubstring(provisioning.ReasonTemplateNotFound))
+		Expect(cl.Get(ctx, client.ObjectKey{Namespace: "claims", Name: "pr-43"}, &generatorv1alpha1.NamespaceClaim{})).NotTo(Succeed())
+	})
+
+	It("should serve the provisioning requests with the middleware of the API", func() {
+		_, network, err := net.ParseCIDR("10.0.0.0/8")
+		Expect(err).NotTo(HaveOccurred())
+		var events bytes.Buffer
+		auditor := audit.NewAuditor(audit.NewWriterSink(&events), 10, slog.Default())
+		e := echo.New()
+		e.HTTPErrorHandler = handlers.HTTPErrorHandler
+		routes := handlers.Register(e, handlers.Options{
+			K8sClientFactory: func(*slog.Logger) (client.Reader, error) {
+				return newFakeClient(), nil
+			},
+			LiveClient:                 newFakeClient(),
+			RemoteClients:              handlers.NewRemoteClientCache(&fakeTokenSource{}, handlers.RemoteClientOptions{}),
+			Middleware:                 []echo.MiddlewareFunc{handlers.SourceAllowlist([]*net.IPNet{network})},
+			Authentication:             middleware.KeyAuth(func(key string, _ echo.Context) (bool, error) { return key == "api", nil }),
+			AuthenticatedMiddleware:    []echo.MiddlewareFunc{handlers.Audit(auditor)},
+			ProvisioningAuthentication: middleware.KeyAuth(func(key string, _ echo.Context) (bool, error) { return key == "provisioning", nil }),
+		})
+		defer routes.NamespaceChanges.Shutdown()
+		provision := func(source, key string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/namespaces", strings.NewReader(`{"template": "preview"}`))
+			req.RemoteAddr = source + ":1234"
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+key)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		Expect(provision("192.0.2.1", "provisioning")).To(Equal(http.StatusForbidden))
+		Expect(provision("10.0.0.1", "api")).To(Equal(http.StatusUnauthorized))
+		Expect(provision("10.0.0.1", "provisioning")).To(Equal(http.StatusBadRequest))
+		Expect(auditor.Close()).To(Succeed())
+		Expect(events.String()).To(ContainSubstring(`"action":"provision"`))
+		Expect(events.String()).To(ContainSubstring(`"status":400`))
+	})
+})
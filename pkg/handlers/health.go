@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/konflux-ci/namespace-generator/pkg/auth"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// HealthCheck reports whether a dependency of the server is usable.
+type HealthCheck func(ctx context.Context) error
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+type HealthHandler struct {
+	readinessChecks []namedHealthCheck
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func NewHealthHandler(readinessChecks map[string]HealthCheck) *HealthHandler {
+	handler := &HealthHandler{}
+	for name, check := range readinessChecks {
+		handler.readinessChecks = append(handler.readinessChecks, namedHealthCheck{name: name, check: check})
+	}
+	sort.Slice(handler.readinessChecks, func(i, j int) bool {
+		return handler.readinessChecks[i].name < handler.readinessChecks[j].name
+	})
+	return handler
+}
+
+// Healthz is the liveness probe. It only verifies that the server is able to
+// serve requests.
+func (healthHandler *HealthHandler) Healthz(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, &healthResponse{Status: "ok"})
+}
+
+// Readyz is the readiness probe. It runs all the readiness checks and returns
+// 503 if any of them fails.
+func (healthHandler *HealthHandler) Readyz(ctx echo.Context) error {
+	response := &healthResponse{Status: "ok", Checks: map[string]string{}}
+	status := http.StatusOK
+
+	for _, namedCheck := range healthHandler.readinessChecks {
+		checkCtx, cancel := context.WithTimeout(ctx.Request().Context(), healthCheckTimeout)
+		err := namedCheck.check(checkCtx)
+		cancel()
+		if err != nil {
+			loggerFrom(ctx).Error("Readiness check failed", "check", namedCheck.name, logging.KeyError, err)
+			response.Checks[namedCheck.name] = err.Error()
+			response.Status = "failed"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[namedCheck.name] = "ok"
+	}
+
+	return ctx.JSON(status, response)
+}
+
+// NamespaceListCheck verifies that the given client is able to list namespaces.
+func NamespaceListCheck(cl client.Reader) HealthCheck {
+	return func(ctx context.Context) error {
+		return cl.List(ctx, generator.NewNamespaceList(), client.Limit(1))
+	}
+}
+
+// CloudCredentialsCheck verifies that a token can be obtained from the auth
+// provider. Tokens are required for accessing remote clusters.
+func CloudCredentialsCheck(provider auth.Provider) HealthCheck {
+	return func(ctx context.Context) error {
+		_, err := provider.Token(ctx)
+		return err
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/tracing"
+)
+
+// headerImpersonateUser selects the user a request lists namespaces as,
+// among the users allowed by the configuration.
+const headerImpersonateUser = "Impersonate-User"
+
+// Impersonation lists namespaces as another identity, so the namespaces
+// returned are bounded by its RBAC rather than the generator's permissions.
+type Impersonation struct {
+	// User is impersonated by every route. Empty lists namespaces as the
+	// generator.
+	User string
+	// Groups are impersonated along with the user.
+	Groups []string
+	// RouteUsers overrides the user per route path.
+	RouteUsers map[string]string
+	// RequestUsers may be selected by requests with the Impersonate-User
+	// header.
+	RequestUsers []string
+}
+
+// impersonator keeps a client of the local cluster per impersonated user.
+// The clients read from the API server, as the local cache is filled with
+// the generator's permissions.
+type impersonator struct {
+	Impersonation
+	localConfig *rest.Config
+
+	mu           sync.Mutex
+	localClients map[string]client.Reader
+}
+
+var impersonation = &impersonator{}
+
+// SetImpersonation sets the identities namespaces are listed as. It's called
+// once on startup, before serving requests.
+func SetImpersonation(config Impersonation, localConfig *rest.Config) {
+	impersonation = &impersonator{
+		Impersonation: config,
+		localConfig:   localConfig,
+		localClients:  map[string]client.Reader{},
+	}
+}
+
+// impersonatedUser returns the user the namespaces of the request are listed
+// as, or an empty string to list them as the generator.
+func impersonatedUser(ctx echo.Context) (string, error) {
+	if user := ctx.Request().Header.Get(headerImpersonateUser); user != "" {
+		if !slices.Contains(impersonation.RequestUsers, user) {
+			return "", generrors.Wrap(generrors.ErrImpersonationForbidden, fmt.Errorf("requests may not impersonate %s", user))
+		}
+		return user, nil
+	}
+	if user, ok := impersonation.RouteUsers[ctx.Path()]; ok {
+		return user, nil
+	}
+	return impersonation.User, nil
+}
+
+// impersonationConfig returns the identity a client impersonates for the user.
+func impersonationConfig(user string) rest.ImpersonationConfig {
+	return rest.ImpersonationConfig{UserName: user, Groups: impersonation.Groups}
+}
+
+// localClient returns a client of the local cluster impersonating the user.
+func (impersonator *impersonator) localClient(user string) (client.Reader, error) {
+	impersonator.mu.Lock()
+	defer impersonator.mu.Unlock()
+
+	if localClient, ok := impersonator.localClients[user]; ok {
+		return localClient, nil
+	}
+	if impersonator.localConfig == nil {
+		return nil, errLocalClientUnavailable
+	}
+	cfg := rest.CopyConfig(impersonator.localConfig)
+	cfg.Impersonate = impersonationConfig(user)
+	localClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+	impersonator.localClients[user] = localClient
+	return localClient, nil
+}
+
+// getImpersonatedLocalNamespaces lists the namespaces of the local cluster
+// matching the selector as the user.
+func getImpersonatedLocalNamespaces(ctx echo.Context, user string, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	localClient, err := impersonation.localClient(user)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to create an impersonating client", "user", user, logging.KeyError, err)
+		return err
+	}
+
+	stageCtx, endStage := startStage(ctx.Request().Context(), stageList)
+	spanCtx, span := tracing.Start(stageCtx, "ListNamespaces", trace.WithAttributes(
+		attribute.String("cluster.server", "local"),
+		attribute.String("impersonate.user", user),
+	))
+	err = generator.ListNamespacePages(spanCtx, localClient, nsList, selector)
+	tracing.End(span, err)
+	endStage(err)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to list namespaces", "user", user, logging.KeyError, err)
+		return classifyImpersonatedError(err)
+	}
+	return nil
+}
+
+// impersonatedClient returns a client of the remote cluster impersonating the
+// user. The clients are kept with the client of the cluster, so they're
+// rebuilt along with it when its secret changes.
+func (cache *RemoteClientCache) impersonatedClient(ctx echo.Context, secretName, user string) (client.WithWatch, error) {
+	cache.mu.Lock()
+	entry, ok := cache.entries[secretName]
+	var impersonatedClient client.WithWatch
+	if ok {
+		impersonatedClient = entry.impersonated[user]
+	}
+	cache.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no client for cluster %s", secretName)
+	}
+	if impersonatedClient != nil {
+		return impersonatedClient, nil
+	}
+
+	cfg := rest.CopyConfig(entry.config)
+	cfg.Impersonate = impersonationConfig(user)
+	impersonatedClient, err := cache.options.ClientFactory(ctx.Request().Context(), cfg)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to create an impersonating client", logging.KeyCluster, secretName, "user", user, logging.KeyError, err)
+		return nil, generator.ClassifyRemoteError(err)
+	}
+	cache.mu.Lock()
+	entry.impersonated[user] = impersonatedClient
+	cache.mu.Unlock()
+	return impersonatedClient, nil
+}
+
+// classifyImpersonatedError classifies the errors of the calls made as an
+// impersonated user. The credentials of the generator were accepted when
+// the user isn't allowed to list namespaces, or the generator isn't allowed
+// to impersonate it.
+func classifyImpersonatedError(err error) error {
+	if apierrors.IsForbidden(err) {
+		return generrors.Wrap(generrors.ErrImpersonationForbidden, err)
+	}
+	return err
+}
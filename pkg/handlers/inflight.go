@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// inFlightReleaseKey is the key of the function releasing the slot of the
+// request in the echo context.
+const inFlightReleaseKey = "inFlightRelease"
+
+// InFlightConfig configures limiting the number of requests served at the
+// same time. A zero MaxInFlight disables the limit.
+type InFlightConfig struct {
+	MaxInFlight int
+	// MaxQueued is the number of requests waiting for a slot. Requests
+	// arriving when the queue is full are rejected immediately.
+	MaxQueued int
+	// QueueTimeout is how long a request waits for a slot before being rejected.
+	QueueTimeout time.Duration
+	// RetryAfter is the delay suggested to rejected clients.
+	RetryAfter time.Duration
+}
+
+// InFlightLimiter returns a middleware that serves at most MaxInFlight
+// requests at the same time. Excess requests are queued, and rejected with
+// 503 and a Retry-After header when the queue is full or they waited for
+// longer than QueueTimeout. This protects the generator and the API servers
+// during refresh storms.
+func InFlightLimiter(config InFlightConfig) echo.MiddlewareFunc {
+	if config.MaxInFlight <= 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+
+	slots := make(chan struct{}, config.MaxInFlight)
+	queue := make(chan struct{}, config.MaxInFlight+max(config.MaxQueued, 0))
+
+	reject := func(ctx echo.Context, reason string) error {
+		loggerFrom(ctx).Warn("Rejecting request", "reason", reason)
+		metrics.InFlightRejected.Inc()
+		return retryAfterResponse(ctx, http.StatusServiceUnavailable, "too many requests in flight", config.RetryAfter)
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			// The queue holds both the requests being served and the waiting ones.
+			select {
+			case queue <- struct{}{}:
+			default:
+				return reject(ctx, "the queue is full")
+			}
+			if err := acquire(ctx, slots, config.QueueTimeout); err != nil {
+				<-queue
+				return reject(ctx, err.Error())
+			}
+
+			metrics.InFlightRequests.Inc()
+			release := sync.OnceFunc(func() {
+				metrics.InFlightRequests.Dec()
+				<-slots
+				<-queue
+			})
+			defer release()
+			ctx.Set(inFlightReleaseKey, release)
+			return next(ctx)
+		}
+	}
+}
+
+// acquire takes a slot, waiting at most for the given timeout.
+func acquire(ctx echo.Context, slots chan struct{}, timeout time.Duration) error {
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errors.New("timed out waiting in the queue")
+	case <-ctx.Request().Context().Done():
+		return ctx.Request().Context().Err()
+	}
+}
+
+// releaseInFlight gives the slot of the request back before it's served,
+// e.g. before waiting for changes. It does nothing when the request isn't
+// limited.
+func releaseInFlight(ctx echo.Context) {
+	if release, ok := ctx.Get(inFlightReleaseKey).(func()); ok {
+		release()
+	}
+}
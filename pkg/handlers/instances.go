@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+)
+
+// ArgoCDInstance is an ArgoCD control plane whose cluster secrets the
+// generator reads besides the ones of ArgoCDNamespace, for sharded ArgoCD
+// deployments.
+type ArgoCDInstance struct {
+	Name string
+	// Namespace holds the cluster secrets of the instance.
+	Namespace string
+	// Reader reads the cluster secrets of the instance and the CA ConfigMaps
+	// they reference. Nil reads them from the local cluster.
+	Reader client.Reader
+}
+
+var argoCDInstances = map[string]ArgoCDInstance{}
+
+// SetArgoCDInstances sets the ArgoCD instances requests can select. It's
+// called once on startup, before serving requests.
+func SetArgoCDInstances(instances []ArgoCDInstance) {
+	argoCDInstances = make(map[string]ArgoCDInstance, len(instances))
+	for _, instance := range instances {
+		argoCDInstances[instance.Name] = instance
+	}
+}
+
+// clusterRef returns the name a cluster secret of the instance is referred to
+// by, which the caches and the filters are keyed by. The secrets of
+// ArgoCDNamespace keep their name, the others are prefixed by the name of
+// their instance, e.g. shard-b/remote1.
+func clusterRef(instance, secretName string) (string, error) {
+	if instance == "" || secretName == "" {
+		return secretName, nil
+	}
+	if _, ok := argoCDInstances[instance]; !ok {
+		return "", fmt.Errorf("unknown ArgoCD instance %s", instance)
+	}
+	return instance + "/" + secretName, nil
+}
+
+// resolveClusterRef sets the ClusterRef of a request, which the caches, the
+// filters and the quotas are keyed by so they tell apart the clusters of the
+// ArgoCD instances. The ClusterName of the request is left as is, as it's
+// returned in the output parameters.
+func resolveClusterRef(req *v1alpha2.GenerateRequest) error {
+	ref, err := clusterRef(req.Input.Parameters.ArgoCDInstance, req.Input.Parameters.ClusterName)
+	if err != nil {
+		return err
+	}
+	req.ClusterRef = ref
+	return nil
+}
+
+// clusterRegistry returns the namespace and the reader of the cluster secrets
+// of an instance, defaulting to ArgoCDNamespace and the local client.
+func clusterRegistry(instance string, localClient client.Reader) (string, client.Reader, error) {
+	if instance == "" {
+		return ArgoCDNamespace, localClient, nil
+	}
+	argoCDInstance, ok := argoCDInstances[instance]
+	if !ok {
+		return "", nil, generrors.Wrap(generrors.ErrInvalidRequest, fmt.Errorf("unknown ArgoCD instance %s", instance))
+	}
+	if argoCDInstance.Reader != nil {
+		return argoCDInstance.Namespace, argoCDInstance.Reader, nil
+	}
+	return argoCDInstance.Namespace, localClient, nil
+}
+
+// splitClusterRef returns the instance and the secret name of a cluster
+// reference, see clusterRef.
+func splitClusterRef(ref string) (string, string) {
+	if instance, secretName, ok := strings.Cut(ref, "/"); ok {
+		return instance, secretName
+	}
+	return "", ref
+}
+
+// listClusterRefs returns the references of the cluster secrets of all the
+// ArgoCD instances, for the work done on every cluster such as probing them.
+func listClusterRefs(ctx echo.Context, localClient client.Reader) ([]string, error) {
+	instances := make([]string, 0, len(argoCDInstances))
+	for name := range argoCDInstances {
+		instances = append(instances, name)
+	}
+	sort.Strings(instances)
+	var refs []string
+	for _, instance := range append([]string{""}, instances...) {
+		secrets, err := listClusterSecrets(ctx, localClient, instance, labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range secrets {
+			ref, _ := clusterRef(instance, secret.Name)
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
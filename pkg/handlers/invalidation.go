@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const invalidationRetryInterval = 5 * time.Second
+
+// InvalidationOptions configures a CacheInvalidator.
+type InvalidationOptions struct {
+	// ResyncInterval is how often the remote clusters with a cached client
+	// start being watched.
+	ResyncInterval time.Duration
+	// WatchSecrets watches the ArgoCD cluster secrets, which requires the
+	// permission to list and watch them.
+	WatchSecrets bool
+}
+
+// CacheInvalidator drops the cached responses of a cluster as soon as its
+// namespaces change, and the cached client and responses of a cluster as
+// soon as its cluster secret changes, instead of waiting for them to expire.
+// The local cluster is watched with the given client, and the remote
+// clusters with their cached client.
+type CacheInvalidator struct {
+	localClient   client.WithWatch
+	remoteClients *RemoteClientCache
+	responses     *ResponseCache
+	options       InvalidationOptions
+	logger        *slog.Logger
+}
+
+func NewCacheInvalidator(localClient client.WithWatch, remoteClients *RemoteClientCache, responses *ResponseCache, options InvalidationOptions, logger *slog.Logger) *CacheInvalidator {
+	return &CacheInvalidator{
+		localClient:   localClient,
+		remoteClients: remoteClients,
+		responses:     responses,
+		options:       options,
+		logger:        logger,
+	}
+}
+
+// clusterWatch is a running watch of the namespaces of a cluster.
+type clusterWatch struct {
+	client client.WithWatch
+	cancel context.CancelFunc
+}
+
+// Run watches the namespaces and the cluster secrets until the context is
+// done.
+func (invalidator *CacheInvalidator) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if invalidator.options.WatchSecrets && invalidator.localClient != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			invalidator.retry(ctx, "cluster secrets", invalidator.watchSecrets)
+		}()
+	}
+
+	ticker := time.NewTicker(invalidator.options.ResyncInterval)
+	defer ticker.Stop()
+	watches := map[string]*clusterWatch{}
+	for {
+		clients := invalidator.remoteClients.Clients()
+		if invalidator.localClient != nil {
+			clients[""] = invalidator.localClient
+		}
+		for clusterName, w := range watches {
+			// The client was dropped or rebuilt, e.g. after its cluster
+			// secret changed.
+			if clients[clusterName] != w.client {
+				w.cancel()
+				delete(watches, clusterName)
+			}
+		}
+		for clusterName, cl := range clients {
+			if _, ok := watches[clusterName]; ok {
+				continue
+			}
+			watchCtx, cancelWatch := context.WithCancel(ctx)
+			watches[clusterName] = &clusterWatch{client: cl, cancel: cancelWatch}
+			wg.Add(1)
+			go func(clusterName string, cl client.WithWatch) {
+				defer wg.Done()
+				namespaces := &namespaceWatch{invalidator: invalidator, client: cl, clusterName: clusterName}
+				invalidator.retry(watchCtx, "namespaces of cluster "+clusterName, namespaces.run)
+			}(clusterName, cl)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retry runs the watch again when it ends, until the context is done.
+func (invalidator *CacheInvalidator) retry(ctx context.Context, watched string, run func(context.Context) error) {
+	for {
+		err := run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			invalidator.logger.Error("Failed to watch for invalidating the caches", "watched", watched, logging.KeyError, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(invalidationRetryInterval):
+		}
+	}
+}
+
+func (invalidator *CacheInvalidator) invalidateResponses(ctx context.Context, clusterName, reason string) {
+	count, err := invalidator.responses.InvalidateCluster(ctx, clusterName)
+	if err != nil {
+		invalidator.logger.Error("Failed to invalidate the shared responses", logging.KeyCluster, clusterName, logging.KeyError, err)
+	}
+	if count > 0 {
+		invalidator.logger.Debug("Invalidated the cached responses", logging.KeyCluster, clusterName, "reason", reason, "count", count)
+	}
+}
+
+// namespaceWatch invalidates the responses of a cluster when namespaces are
+// added or deleted, or when their labels or annotations change. Other
+// changes, e.g. of the status, don't change the responses.
+type namespaceWatch struct {
+	invalidator *CacheInvalidator
+	client      client.WithWatch
+	clusterName string
+	// fingerprints hash the labels and annotations of the namespaces.
+	fingerprints map[string]uint64
+}
+
+// run lists the namespaces and watches them until the watch is closed. The
+// responses are invalidated after listing, as changes may have been missed
+// while the watch was down.
+func (namespaces *namespaceWatch) run(ctx context.Context) error {
+	nsList := generator.NewNamespaceList()
+	if err := generator.ListNamespacePages(ctx, namespaces.client, nsList, labels.Everything()); err != nil {
+		return err
+	}
+	namespaces.fingerprints = make(map[string]uint64, len(nsList.Items))
+	for i := range nsList.Items {
+		namespaces.fingerprints[nsList.Items[i].Name] = fingerprint(&nsList.Items[i])
+	}
+	namespaces.invalidator.invalidateResponses(ctx, namespaces.clusterName, "resync")
+
+	watcher, err := namespaces.client.Watch(ctx, generator.NewNamespaceList(), &client.ListOptions{
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     nsList.ResourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type == watch.Error {
+				// Most likely the resource version is too old, start over.
+				return nil
+			}
+			object, ok := event.Object.(metav1.Object)
+			if !ok || event.Type == watch.Bookmark {
+				continue
+			}
+			name := object.GetName()
+			previous, known := namespaces.fingerprints[name]
+			if event.Type == watch.Deleted {
+				delete(namespaces.fingerprints, name)
+				namespaces.invalidator.invalidateResponses(ctx, namespaces.clusterName, "namespace "+name+" deleted")
+				continue
+			}
+			current := fingerprint(object)
+			namespaces.fingerprints[name] = current
+			if !known || previous != current {
+				namespaces.invalidator.invalidateResponses(ctx, namespaces.clusterName, "namespace "+name+" changed")
+			}
+		}
+	}
+}
+
+// fingerprint hashes the labels and annotations of a namespace, which are
+// all the responses are generated from besides its name.
+func fingerprint(object metav1.Object) uint64 {
+	hash := fnv.New64a()
+	for _, values := range []map[string]string{object.GetLabels(), object.GetAnnotations()} {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			hash.Write([]byte(key))
+			hash.Write([]byte{0})
+			hash.Write([]byte(values[key]))
+			hash.Write([]byte{0})
+		}
+		hash.Write([]byte{1})
+	}
+	return hash.Sum64()
+}
+
+// watchSecrets drops the cached client and responses of a cluster when its
+// cluster secret is updated or deleted. Only the metadata of the secrets is
+// watched, the credentials are read by the requests.
+func (invalidator *CacheInvalidator) watchSecrets(ctx context.Context) error {
+	requirement, err := labels.NewRequirement(clusterSecretTypeLabel, selection.Equals, []string{clusterSecretType})
+	if err != nil {
+		return err
+	}
+	secretList := &metav1.PartialObjectMetadataList{}
+	secretList.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("SecretList"))
+	watcher, err := invalidator.localClient.Watch(ctx, secretList, &client.ListOptions{
+		Namespace:     ArgoCDNamespace,
+		LabelSelector: labels.NewSelector().Add(*requirement),
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type == watch.Error {
+				return nil
+			}
+			object, ok := event.Object.(metav1.Object)
+			// Clients are only created once their secret exists.
+			if !ok || (event.Type != watch.Modified && event.Type != watch.Deleted) {
+				continue
+			}
+			secretName := object.GetName()
+			if invalidator.remoteClients.Invalidate(secretName) {
+				invalidator.logger.Info("Invalidated the cached remote client after its cluster secret changed", logging.KeyCluster, secretName)
+			}
+			invalidator.invalidateResponses(ctx, secretName, "cluster secret changed")
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// RequestLogger returns a middleware that passes a logger including the
+// request ID in every line down the context of each request. It must be
+// registered after the RequestID middleware.
+func RequestLogger(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			requestLogger := logger.With(logging.KeyRequestID, requestID(ctx))
+			ctx.SetRequest(ctx.Request().WithContext(logging.WithLogger(ctx.Request().Context(), requestLogger)))
+			return next(ctx)
+		}
+	}
+}
+
+// AccessLog returns a middleware that logs a line for every request served,
+// with the logger of the request, so the line is redacted and carries the
+// request ID like the others. It must be registered after RequestLogger.
+func AccessLog(skipper middleware.Skipper) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if skipper != nil && skipper(ctx) {
+				return next(ctx)
+			}
+
+			start := time.Now()
+			err := next(ctx)
+			if err != nil {
+				// The error handler writes the status logged, and ignores
+				// the committed responses when the error is handled again.
+				ctx.Error(err)
+			}
+
+			req := ctx.Request()
+			logger := loggerFrom(ctx)
+			// The ApplicationSet is identified in a context derived from
+			// this one.
+			if identity, ok := applicationSetIdentity(ctx); ok {
+				logger = logger.With(logging.KeyAppSet, identity.Name, logging.KeyAppSetNamespace, identity.Namespace)
+			}
+			logger.Info("Request served",
+				"method", req.Method,
+				"uri", req.RequestURI,
+				"status", ctx.Response().Status,
+				"latency_seconds", time.Since(start).Seconds(),
+				"remote_ip", ctx.RealIP(),
+				"user_agent", req.UserAgent(),
+				"bytes_out", ctx.Response().Size,
+			)
+			return err
+		}
+	}
+}
+
+// loggerFrom returns the logger of the request.
+func loggerFrom(ctx echo.Context) *slog.Logger {
+	return logging.FromContext(ctx.Request().Context())
+}
+
+// withLogger returns a context whose request carries the logger, so the
+// fields added to it are logged by the code it's passed down to.
+func withLogger(ctx echo.Context, logger *slog.Logger) echo.Context {
+	return withRequestContext(ctx, logging.WithLogger(ctx.Request().Context(), logger))
+}
+
+// HTTPErrorHandler writes errors returned by handlers and middlewares as
+// ErrorResponse bodies so they always carry the request ID.
+func HTTPErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	message := http.StatusText(status)
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		if msg, ok := httpErr.Message.(string); ok {
+			message = msg
+		} else {
+			message = http.StatusText(status)
+		}
+	} else {
+		loggerFrom(ctx).Error("Request failed", logging.KeyError, err)
+	}
+
+	if ctx.Request().Method == http.MethodHead {
+		err = ctx.NoContent(status)
+	} else {
+		err = errorResponse(ctx, status, message)
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to write the error response", logging.KeyError, err)
+	}
+}
+
+func errorResponse(ctx echo.Context, status int, message string) error {
+	return ctx.JSON(status, &v1alpha1.ErrorResponse{
+		Message:   message,
+		RequestID: requestID(ctx),
+	})
+}
+
+// classifiedErrorResponse writes the response of a failure with the status
+// and the code of the kind err is classified as.
+func classifiedErrorResponse(ctx echo.Context, err error, message string) error {
+	kind := generrors.KindOf(err)
+	return ctx.JSON(kind.Status, &v1alpha1.ErrorResponse{
+		Message:   message,
+		Code:      kind.Code,
+		RequestID: requestID(ctx),
+	})
+}
+
+func requestID(ctx echo.Context) string {
+	return ctx.Response().Header().Get(echo.HeaderXRequestID)
+}
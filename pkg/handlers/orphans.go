@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/provisioning"
+)
+
+// localClusterServer is the server of the Applications deploying to the
+// cluster running ArgoCD.
+const localClusterServer = "https://kubernetes.default.svc"
+
+// OrphansHandler reports the namespaces of the local cluster which are no
+// longer desired by their source, e.g. leaked tenant namespaces.
+type OrphansHandler struct {
+	// liveClient lists the namespaces and their sources from the API
+	// server, as the cache only holds the namespaces.
+	liveClient client.Reader
+}
+
+func NewOrphansHandler(liveClient client.Reader) *OrphansHandler {
+	return &OrphansHandler{liveClient: liveClient}
+}
+
+// ListOrphans serves GET /admin/orphans?labelSelector=&source=, listing the
+// namespaces matching the selector which no NamespaceClaim claims, with the
+// claims source, or to which no Application deploys, with the applications
+// source.
+func (orphansHandler *OrphansHandler) ListOrphans(ctx echo.Context) error {
+	logger := loggerFrom(ctx)
+	if orphansHandler.liveClient == nil {
+		return errorResponse(ctx, http.StatusServiceUnavailable, "orphans can't be listed without a client of the local cluster")
+	}
+	selector, err := labels.Parse(ctx.QueryParam("labelSelector"))
+	if err != nil {
+		return classifiedErrorResponse(ctx, generrors.ErrSelectorInvalid, fmt.Sprintf("failed to parse label selector: %s", err))
+	}
+	source := ctx.QueryParam("source")
+	if source == "" {
+		source = v1alpha1.OrphanSourceClaims
+	}
+	if source != v1alpha1.OrphanSourceClaims && source != v1alpha1.OrphanSourceApplications {
+		return errorResponse(ctx, http.StatusBadRequest, fmt.Sprintf("unknown source %q, expected %s or %s", source, v1alpha1.OrphanSourceClaims, v1alpha1.OrphanSourceApplications))
+	}
+
+	reqCtx := ctx.Request().Context()
+	nsList := generator.NewNamespaceList()
+	if err := generator.ListNamespacePages(reqCtx, orphansHandler.liveClient, nsList, selector); err != nil {
+		logger.Error("Failed to list namespaces", logging.KeyError, err)
+		return classifiedErrorResponse(ctx, err, "failed to list namespaces")
+	}
+
+	var orphans []v1alpha1.OrphanedNamespace
+	if source == v1alpha1.OrphanSourceClaims {
+		orphans, err = orphansHandler.unclaimed(ctx, nsList.Items)
+	} else {
+		orphans, err = orphansHandler.withoutApplication(ctx, nsList.Items)
+	}
+	if err != nil {
+		logger.Error("Failed to list the sources of the namespaces", "source", source, logging.KeyError, err)
+		return classifiedErrorResponse(ctx, err, fmt.Sprintf("failed to list the %s", source))
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Namespace < orphans[j].Namespace })
+	return ctx.JSON(http.StatusOK, &v1alpha1.OrphanedNamespacesResponse{Source: source, Orphans: orphans})
+}
+
+// unclaimed returns the namespaces which no NamespaceClaim claims.
+func (orphansHandler *OrphansHandler) unclaimed(ctx echo.Context, namespaces []metav1.PartialObjectMetadata) ([]v1alpha1.OrphanedNamespace, error) {
+	claimList := &generatorv1alpha1.NamespaceClaimList{}
+	if err := orphansHandler.liveClient.List(ctx.Request().Context(), claimList); err != nil {
+		return nil, err
+	}
+	claimed := map[string]bool{}
+	for i := range claimList.Items {
+		claimed[claimList.Items[i].NamespaceName()] = true
+	}
+
+	orphans := []v1alpha1.OrphanedNamespace{}
+	for _, ns := range namespaces {
+		if claimed[ns.Name] {
+			continue
+		}
+		orphan := v1alpha1.OrphanedNamespace{
+			Namespace:         ns.Name,
+			Reason:            v1alpha1.OrphanReasonUnclaimed,
+			CreationTimestamp: ns.CreationTimestamp,
+		}
+		if claim := ns.Annotations[provisioning.ClaimAnnotation]; claim != "" {
+			orphan.Reason = v1alpha1.OrphanReasonClaimDeleted
+			orphan.Claim = claim
+		}
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
+
+// withoutApplication returns the namespaces to which no Application of the
+// ArgoCD namespace deploys.
+func (orphansHandler *OrphansHandler) withoutApplication(ctx echo.Context, namespaces []metav1.PartialObjectMetadata) ([]v1alpha1.OrphanedNamespace, error) {
+	appList := &unstructured.UnstructuredList{}
+	appList.SetAPIVersion("argoproj.io/v1alpha1")
+	appList.SetKind("ApplicationList")
+	if err := orphansHandler.liveClient.List(ctx.Request().Context(), appList, client.InNamespace(ArgoCDNamespace)); err != nil {
+		return nil, err
+	}
+	targeted := map[string]bool{}
+	for _, app := range appList.Items {
+		destination, _, _ := unstructured.NestedStringMap(app.Object, "spec", "destination")
+		if !deploysLocally(destination) {
+			continue
+		}
+		targeted[destination["namespace"]] = true
+	}
+
+	orphans := []v1alpha1.OrphanedNamespace{}
+	for _, ns := range namespaces {
+		if targeted[ns.Name] {
+			continue
+		}
+		orphans = append(orphans, v1alpha1.OrphanedNamespace{
+			Namespace:         ns.Name,
+			Reason:            v1alpha1.OrphanReasonNoApplication,
+			CreationTimestamp: ns.CreationTimestamp,
+		})
+	}
+	return orphans, nil
+}
+
+// deploysLocally reports whether the destination of an Application is the
+// cluster running ArgoCD.
+func deploysLocally(destination map[string]string) bool {
+	if destination["name"] != "" {
+		return destination["name"] == audit.LocalCluster
+	}
+	return destination["server"] == "" || destination["server"] == localClusterServer
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	sprig "github.com/go-task/slim-sprig"
+	"github.com/google/cel-go/cel"
+	"github.com/open-policy-agent/opa/rego"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/features"
+)
+
+// Policy holds the server-wide rules applied to every request on top of the
+// parameters of the request. It's read from the configuration and can be
+// replaced while serving.
+type Policy struct {
+	// ExcludeNamespaces are never returned.
+	ExcludeNamespaces []string
+	// AllowedClusters restricts the remote clusters requests can target to
+	// the named cluster secrets. Empty allows all of them.
+	AllowedClusters []string
+	// DeniedClusters are rejected even if they're allowed.
+	DeniedClusters []string
+	// OutputTemplates are rendered for every namespace into the values of
+	// its output, keyed by the name of the value.
+	OutputTemplates map[string]string
+	// RegoPolicy is the source of a Rego policy denying namespaces, see
+	// regoDenyQuery. Empty denies nothing.
+	RegoPolicy string
+	// AuthorizationPolicy is a CEL expression the requests must satisfy, see
+	// compileAuthorizationPolicy. Empty allows all the requests. It requires
+	// the CELAuthorization feature gate.
+	AuthorizationPolicy string
+	// AllowMatchAll lets the requests setting allowAll use an empty label
+	// selector, which matches all the namespaces.
+	AllowMatchAll bool
+	// RequiredLabels must be carried by every namespace returned, on top of
+	// the label selector of the request.
+	RequiredLabels map[string]string
+	// MaxSelectorRequirements bounds the requirements of the label selector
+	// of a request, and MaxSelectorValues the values of its requirements.
+	// Zero disables the limit.
+	MaxSelectorRequirements int
+	MaxSelectorValues       int
+	// MaxResults bounds the namespaces returned by a request, see truncate.
+	// Zero disables the limit.
+	MaxResults int
+	// AuthorizationCostLimit bounds the cost of evaluating the authorization
+	// policy for a request, which is denied when it's exceeded. Zero
+	// disables the limit.
+	AuthorizationCostLimit uint64
+	// SettlingPeriod leaves out the namespaces created less than that long
+	// ago, unless they carry ReadyAnnotation, see settlingFilter. Zero
+	// disables it.
+	SettlingPeriod time.Duration
+
+	requiredLabels labels.Requirements
+	templates      map[string]*template.Template
+	regoQuery      *rego.PreparedEvalQuery
+	authorization  cel.Program
+}
+
+// templateFuncs are the sprig functions output templates can use, such as
+// trim, replace, sha256sum and default. Only the functions returning the same
+// result for the same input are kept, as responses are cached and compared
+// with their ETag, which also leaves out reading the environment.
+var templateFuncs = func() template.FuncMap {
+	funcs := sprig.HermeticTxtFuncMap()
+	delete(funcs, "randInt")
+	delete(funcs, "ago")
+	return funcs
+}()
+
+// outputTemplateData is the data output templates are rendered with.
+type outputTemplateData struct {
+	Namespace   string
+	ClusterName string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+var currentPolicy atomic.Pointer[Policy]
+
+func init() {
+	currentPolicy.Store(&Policy{})
+}
+
+// SetPolicy replaces the policy applied to the next requests. Requests being
+// served keep the policy they started with. The current policy is kept if
+// the output templates or the policies can't be parsed.
+func SetPolicy(policy Policy) error {
+	policy.templates = map[string]*template.Template{}
+	for name, text := range policy.OutputTemplates {
+		tmpl, err := template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid output template %s: %w", name, err)
+		}
+		policy.templates[name] = tmpl
+	}
+	policy.requiredLabels = nil
+	for key, value := range policy.RequiredLabels {
+		requirement, err := labels.NewRequirement(key, selection.Equals, []string{value})
+		if err != nil {
+			return fmt.Errorf("invalid required label %s: %w", key, err)
+		}
+		policy.requiredLabels = append(policy.requiredLabels, *requirement)
+	}
+	sort.Sort(labels.ByKey(policy.requiredLabels))
+	if policy.RegoPolicy != "" {
+		query, err := compileRegoPolicy(policy.RegoPolicy)
+		if err != nil {
+			return fmt.Errorf("invalid Rego policy: %w", err)
+		}
+		policy.regoQuery = query
+	}
+	if policy.AuthorizationPolicy != "" {
+		if !features.Enabled(features.CELAuthorization) {
+			return fmt.Errorf("the authorization policy requires the %s feature gate", features.CELAuthorization)
+		}
+		program, err := compileAuthorizationPolicy(policy.AuthorizationPolicy, policy.AuthorizationCostLimit)
+		if err != nil {
+			return fmt.Errorf("invalid authorization policy: %w", err)
+		}
+		policy.authorization = program
+	}
+
+	currentPolicy.Store(&policy)
+	return nil
+}
+
+func getPolicy() *Policy {
+	return currentPolicy.Load()
+}
+
+// checkMatchAll returns ErrMatchAllForbidden for an empty selector, unless
+// both the request and the policy allow matching all the namespaces. An empty
+// selector is more likely a mistake than a wish to generate Applications into
+// every namespace of a cluster.
+func (policy *Policy) checkMatchAll(allowAll bool, selector labels.Selector) error {
+	if !selector.Empty() {
+		return nil
+	}
+	if !allowAll {
+		return generrors.Wrap(generrors.ErrMatchAllForbidden, errors.New("the empty label selector matches all the namespaces, allowAll must be set to return them"))
+	}
+	if !policy.AllowMatchAll {
+		return generrors.Wrap(generrors.ErrMatchAllForbidden, errors.New("the server doesn't allow matching all the namespaces"))
+	}
+	return nil
+}
+
+// checkSelectorLimits returns ErrSelectorInvalid for a selector with more
+// requirements or values than the policy allows, which would be expensive to
+// evaluate against every namespace.
+func (policy *Policy) checkSelectorLimits(selector labels.Selector) error {
+	requirements, _ := selector.Requirements()
+	if limit := policy.MaxSelectorRequirements; limit > 0 && len(requirements) > limit {
+		return generrors.Wrap(generrors.ErrSelectorInvalid, fmt.Errorf("the label selector has %d requirements, more than the maximum of %d", len(requirements), limit))
+	}
+	values := 0
+	for _, requirement := range requirements {
+		values += requirement.Values().Len()
+	}
+	if limit := policy.MaxSelectorValues; limit > 0 && values > limit {
+		return generrors.Wrap(generrors.ErrSelectorInvalid, fmt.Errorf("the label selector has %d values, more than the maximum of %d", values, limit))
+	}
+	return nil
+}
+
+// requireLabels adds the required labels to the selector, so the namespaces
+// lacking them aren't even listed.
+func (policy *Policy) requireLabels(selector labels.Selector) labels.Selector {
+	return selector.Add(policy.requiredLabels...)
+}
+
+// clusterAllowed reports whether requests may target the named cluster. The
+// local cluster is always allowed.
+func (policy *Policy) clusterAllowed(clusterName string) bool {
+	if clusterName == "" {
+		return true
+	}
+	if slices.Contains(policy.DeniedClusters, clusterName) {
+		return false
+	}
+	return len(policy.AllowedClusters) == 0 || slices.Contains(policy.AllowedClusters, clusterName)
+}
+
+// renderValues renders the output templates for a namespace. It returns nil
+// when there are no templates.
+func (policy *Policy) renderValues(namespace *metav1.PartialObjectMetadata, clusterName string) (map[string]string, error) {
+	if len(policy.templates) == 0 {
+		return nil, nil
+	}
+
+	data := outputTemplateData{
+		Namespace:   namespace.Name,
+		ClusterName: clusterName,
+		Labels:      namespace.Labels,
+		Annotations: namespace.Annotations,
+	}
+	values := make(map[string]string, len(policy.templates))
+	for name, tmpl := range policy.templates {
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		values[name] = out.String()
+	}
+	return values, nil
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// ProbeOptions configures the background probes of the remote clusters.
+type ProbeOptions struct {
+	Interval time.Duration
+	// Timeout bounds each probe, from getting the cluster secret to the
+	// response of the API server.
+	Timeout time.Duration
+	// Concurrency is the number of clusters probed at the same time.
+	Concurrency int
+}
+
+// ClusterProber periodically probes the clusters with an ArgoCD cluster
+// secret, so unreachable clusters are detected before the requests for them
+// fail. A probe goes through the same steps as a request, then calls the
+// /version endpoint of the API server.
+type ClusterProber struct {
+	remoteClients *RemoteClientCache
+	options       ProbeOptions
+
+	mu     sync.RWMutex
+	health map[string]*v1alpha1.ClusterHealth
+}
+
+func NewClusterProber(remoteClients *RemoteClientCache, options ProbeOptions) *ClusterProber {
+	return &ClusterProber{
+		remoteClients: remoteClients,
+		options:       options,
+		health:        map[string]*v1alpha1.ClusterHealth{},
+	}
+}
+
+// Run probes the clusters right away and then every interval, until the
+// context of the request is done.
+func (prober *ClusterProber) Run(ctx echo.Context, localClient client.Reader) {
+	ticker := time.NewTicker(prober.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		prober.ProbeAll(ctx, localClient)
+		select {
+		case <-ctx.Request().Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProbeAll probes all the clusters with a cluster secret, in all the ArgoCD
+// instances. Clusters whose secret was deleted are forgotten.
+func (prober *ClusterProber) ProbeAll(ctx echo.Context, localClient client.Reader) {
+	refs, err := listClusterRefs(ctx, localClient)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to list the clusters to probe", logging.KeyError, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, max(prober.options.Concurrency, 1))
+	known := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		known[ref] = true
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(secretName string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			prober.probe(ctx, localClient, secretName)
+		}(ref)
+	}
+	wg.Wait()
+
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	for secretName := range prober.health {
+		if !known[secretName] {
+			delete(prober.health, secretName)
+			metrics.ClusterHealthy.DeleteLabelValues(secretName)
+			metrics.ClusterProbeLatency.DeleteLabelValues(secretName)
+		}
+	}
+}
+
+// probe probes a cluster and records its health. Only changes of the health
+// are logged, so a cluster staying down isn't logged on every probe.
+func (prober *ClusterProber) probe(ctx echo.Context, localClient client.Reader, secretName string) {
+	probeCtx, cancel := context.WithTimeout(ctx.Request().Context(), prober.options.Timeout)
+	defer cancel()
+	ctx = withRequestContext(ctx, probeCtx)
+
+	start := time.Now()
+	info, server, err := prober.remoteClients.serverVersion(ctx, localClient, secretName)
+	latency := time.Since(start)
+
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+
+	health, ok := prober.health[secretName]
+	if !ok {
+		health = &v1alpha1.ClusterHealth{ClusterName: secretName}
+		prober.health[secretName] = health
+	}
+	wasHealthy := !ok || health.Healthy
+	if server != "" {
+		health.Server = server
+	}
+	health.LastProbe = start
+	health.LatencyMillis = latency.Milliseconds()
+	health.Healthy = err == nil
+	metrics.ClusterProbeLatency.WithLabelValues(secretName).Set(latency.Seconds())
+
+	if err != nil {
+		health.ConsecutiveFailures++
+		health.Error = logging.RedactString(err.Error())
+		health.Code = generrors.CodeOf(err)
+		metrics.ClusterHealthy.WithLabelValues(secretName).Set(0)
+		if wasHealthy {
+			loggerFrom(ctx).Warn("Cluster probe failed", logging.KeyCluster, secretName, "server", server, "code", health.Code, logging.KeyError, err)
+		}
+		return
+	}
+
+	health.ConsecutiveFailures = 0
+	health.Error, health.Code = "", ""
+	health.Version = info.GitVersion
+	health.LastHealthy = &start
+	metrics.ClusterHealthy.WithLabelValues(secretName).Set(1)
+	if !wasHealthy {
+		loggerFrom(ctx).Info("Cluster probe succeeded again", logging.KeyCluster, secretName, "server", server)
+	}
+}
+
+// Health serves the health of the probed clusters sorted by cluster name.
+func (prober *ClusterProber) Health(ctx echo.Context) error {
+	prober.mu.RLock()
+	healthResponse := &v1alpha1.ClusterHealthResponse{Clusters: make([]v1alpha1.ClusterHealth, 0, len(prober.health))}
+	for _, health := range prober.health {
+		healthResponse.Clusters = append(healthResponse.Clusters, *health)
+	}
+	prober.mu.RUnlock()
+
+	sort.Slice(healthResponse.Clusters, func(i, j int) bool {
+		return healthResponse.Clusters[i].ClusterName < healthResponse.Clusters[j].ClusterName
+	})
+	return ctx.JSON(http.StatusOK, healthResponse)
+}
+
+// serverVersion calls the /version endpoint of the API server of the
+// cluster, with the cached client and token used by the requests. The
+// outcome is recorded as the reachability of the cluster. A client
+// invalidated while it's probed is still used, the next probe creates a new
+// one.
+func (cache *RemoteClientCache) serverVersion(ctx echo.Context, localClient client.Reader, secretName string) (*version.Info, string, error) {
+	entry, err := cache.getEntry(ctx, localClient, secretName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	info := &version.Info{}
+	body, err := entry.discovery.RESTClient().Get().AbsPath("/version").Do(ctx.Request().Context()).Raw()
+	if err == nil {
+		err = json.Unmarshal(body, info)
+	}
+	cache.recordResult(secretName, err)
+	if err != nil {
+		return nil, entry.server, generator.ClassifyRemoteError(err)
+	}
+	return info, entry.server, nil
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/provisioning"
+)
+
+// provisionPollInterval is how often the claim of a provisioned namespace is
+// checked until it's ready.
+const provisionPollInterval = 500 * time.Millisecond
+
+// rejectedClaimReasons are the reasons of the Ready condition which
+// provisioning the claim again doesn't change.
+var rejectedClaimReasons = sets.New(
+	provisioning.ReasonClaimNotAllowed,
+	provisioning.ReasonNamespaceTaken,
+	provisioning.ReasonNamespaceExpired,
+	provisioning.ReasonTemplateNotFound,
+	provisioning.ReasonTemplateInvalid,
+)
+
+// ProvisionOptions configures a ProvisionHandler.
+type ProvisionOptions struct {
+	// ClaimsNamespace holds the NamespaceClaims created for the requests.
+	ClaimsNamespace string
+	// Timeout bounds the wait for a namespace to be provisioned.
+	Timeout time.Duration
+}
+
+// ProvisionHandler provisions namespaces from NamespaceTemplates, and returns
+// their parameters, so CI systems can deploy to a namespace in the call
+// creating it. The namespaces are provisioned by the NamespaceClaim
+// controller, which must be enabled.
+type ProvisionHandler struct {
+	liveClient client.Client
+	options    ProvisionOptions
+}
+
+func NewProvisionHandler(liveClient client.Client, options ProvisionOptions) *ProvisionHandler {
+	return &ProvisionHandler{liveClient: liveClient, options: options}
+}
+
+// ProvisionNamespace serves POST /api/v1/namespaces. It creates the
+// NamespaceClaim of the namespace, waits for the namespace to be provisioned
+// and returns its parameters, with 201 when the claim was created and 200
+// when it already existed with the same template.
+func (provisionHandler *ProvisionHandler) ProvisionNamespace(ctx echo.Context) error {
+	logger := loggerFrom(ctx)
+	if provisionHandler.liveClient == nil {
+		return errorResponse(ctx, http.StatusServiceUnavailable, "namespaces can't be provisioned without a client of the local cluster")
+	}
+	start := time.Now()
+	req := &v1alpha2.ProvisionNamespaceRequest{}
+	defer func() {
+		auditProvision(ctx, req.Namespace, start)
+	}()
+	if err := decodeJson(ctx, req); err != nil {
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("failed to decode the request: %s", err))
+	}
+	if errs := validation.IsDNS1123Label(req.Namespace); len(errs) > 0 {
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, fmt.Sprintf("invalid namespace %q: %s", req.Namespace, errs[0]))
+	}
+	if req.Template == "" {
+		return classifiedErrorResponse(ctx, generrors.ErrInvalidRequest, "the template is required")
+	}
+	logger = logger.With("namespace", req.Namespace, "template", req.Template)
+
+	reqCtx, cancel := context.WithTimeout(ctx.Request().Context(), provisionHandler.options.Timeout)
+	defer cancel()
+
+	claim := &generatorv1alpha1.NamespaceClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: provisionHandler.options.ClaimsNamespace, Name: req.Namespace},
+		Spec: generatorv1alpha1.NamespaceClaimSpec{
+			Template:    req.Template,
+			Labels:      req.Labels,
+			Annotations: req.Annotations,
+		},
+	}
+	claimName := claim.Namespace + "/" + claim.Name
+	status := http.StatusCreated
+	err := provisionHandler.liveClient.Create(reqCtx, claim)
+	if apierrors.IsAlreadyExists(err) {
+		// Retried requests get the namespace provisioned by the first one.
+		status = http.StatusOK
+		err = provisionHandler.liveClient.Get(reqCtx, client.ObjectKeyFromObject(claim), claim)
+		if err == nil && claim.Spec.Template != req.Template {
+			return errorResponse(ctx, http.StatusConflict, fmt.Sprintf("NamespaceClaim %s already exists with template %q", claimName, claim.Spec.Template))
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to create the NamespaceClaim", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusInternalServerError, fmt.Sprintf("failed to create NamespaceClaim %s", claimName))
+	}
+
+	ready, err := provisionHandler.waitReady(reqCtx, client.ObjectKeyFromObject(claim))
+	switch {
+	case errors.Is(reqCtx.Err(), context.DeadlineExceeded):
+		// The claim is kept, so the namespace is provisioned in the
+		// background and the request can be retried.
+		return classifiedErrorResponse(ctx, generrors.ErrTimeout, fmt.Sprintf("namespace %s wasn't provisioned in %s", req.Namespace, provisionHandler.options.Timeout))
+	case err != nil:
+		logger.Error("Failed to get the NamespaceClaim", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusInternalServerError, fmt.Sprintf("failed to get NamespaceClaim %s", claimName))
+	case ready.Status != metav1.ConditionTrue:
+		if status == http.StatusCreated {
+			// The claim would only hold the name of the namespace.
+			if err := provisionHandler.liveClient.Delete(context.WithoutCancel(reqCtx), claim); client.IgnoreNotFound(err) != nil {
+				logger.Error("Failed to delete the rejected NamespaceClaim", logging.KeyError, err)
+			}
+		}
+		return errorResponse(ctx, http.StatusConflict, fmt.Sprintf("NamespaceClaim %s was rejected: %s: %s", claimName, ready.Reason, ready.Message))
+	}
+
+	ns := &metav1.PartialObjectMetadata{}
+	ns.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Namespace"))
+	if err := provisionHandler.liveClient.Get(reqCtx, client.ObjectKey{Name: claim.NamespaceName()}, ns); err != nil {
+		logger.Error("Failed to get the provisioned namespace", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusInternalServerError, fmt.Sprintf("failed to get namespace %s", claim.NamespaceName()))
+	}
+	response := &v1alpha2.ProvisionNamespaceResponse{Claim: claimName}
+	policy := getPolicy()
+	filters := append(policyFilters(policy), expiryFilter(time.Now()))
+	for _, filter := range filters {
+		if !filter.matches(ns) {
+			response.ExcludedBy = filter.description
+			return ctx.JSON(status, response)
+		}
+	}
+	parameters := generator.OutParameters(ns, "", req.LabelKeys)
+	if parameters.Values, err = policy.renderValues(ns, ""); err != nil {
+		logger.Error("Failed to render the output templates", logging.KeyError, err)
+		return errorResponse(ctx, http.StatusInternalServerError, "failed to render the output templates")
+	}
+	response.Parameters = &parameters
+	logger.Info("Provisioned the namespace", "claim", claimName)
+	return ctx.JSON(status, response)
+}
+
+// waitReady returns the Ready condition of a claim once the controller
+// provisioned its current generation, and was either done or rejected it.
+// Provisioning failures are retried by the controller, so they're waited
+// out.
+func (provisionHandler *ProvisionHandler) waitReady(ctx context.Context, key client.ObjectKey) (*metav1.Condition, error) {
+	ticker := time.NewTicker(provisionPollInterval)
+	defer ticker.Stop()
+	for {
+		claim := &generatorv1alpha1.NamespaceClaim{}
+		if err := provisionHandler.liveClient.Get(ctx, key, claim); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		ready := meta.FindStatusCondition(claim.Status.Conditions, generatorv1alpha1.NamespaceClaimReady)
+		if ready != nil && ready.ObservedGeneration == claim.Generation &&
+			(ready.Status == metav1.ConditionTrue || rejectedClaimReasons.Has(ready.Reason)) {
+			return ready, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// Resources limited by the quotas.
+const (
+	quotaNamespaces = "namespaces"
+	quotaClusters   = "clusters"
+)
+
+// ApplicationSetQuota caps what a single ApplicationSet may generate, so a
+// misconfigured ApplicationSet can't create thousands of Applications. Zero
+// fields are unlimited.
+type ApplicationSetQuota struct {
+	// MaxNamespaces is the number of namespaces a generate request may return.
+	MaxNamespaces int
+	// MaxClusters is the number of clusters a batch may target, or the
+	// clusters plugin may return.
+	MaxClusters int
+}
+
+// Quotas are the quotas of the ApplicationSets.
+type Quotas struct {
+	// Default applies to the ApplicationSets without their own quota.
+	Default ApplicationSetQuota
+	// ApplicationSets are the quotas of some ApplicationSets, keyed by
+	// namespace/name or by name.
+	ApplicationSets map[string]ApplicationSetQuota
+}
+
+var quotas Quotas
+
+// SetQuotas sets the quotas of the ApplicationSets. It's called once on
+// startup, before serving requests.
+func SetQuotas(config Quotas) {
+	quotas = config
+}
+
+// quotaFor returns the quota of the ApplicationSet.
+func quotaFor(applicationSet ApplicationSetIdentity) ApplicationSetQuota {
+	if applicationSet.Namespace != "" {
+		if quota, ok := quotas.ApplicationSets[applicationSet.String()]; ok {
+			return quota
+		}
+	}
+	if quota, ok := quotas.ApplicationSets[applicationSet.Name]; ok {
+		return quota
+	}
+	return quotas.Default
+}
+
+// quotaError carries the details of an exceeded quota in the internal error
+// of the returned echo.HTTPError.
+type quotaError struct {
+	details *v1alpha1.QuotaDetails
+}
+
+func (err *quotaError) Error() string {
+	return fmt.Sprintf("ApplicationSet %s would get %d %s, exceeding its quota of %d",
+		err.details.ApplicationSet, err.details.Requested, err.details.Resource, err.details.Limit)
+}
+
+func (err *quotaError) Unwrap() error {
+	return generrors.ErrQuotaExceeded
+}
+
+// checkQuota returns an error if requested exceeds the limit, zero being
+// unlimited.
+func checkQuota(applicationSet ApplicationSetIdentity, resource string, limit, requested int) *echo.HTTPError {
+	if limit <= 0 || requested <= limit {
+		return nil
+	}
+	err := &quotaError{details: &v1alpha1.QuotaDetails{
+		ApplicationSet: applicationSet.String(),
+		Resource:       resource,
+		Limit:          limit,
+		Requested:      requested,
+	}}
+	return generateError(err, err.Error())
+}
+
+// checkNamespaceQuota checks the number of namespaces of the response
+// against the quota of the ApplicationSet of the request.
+func checkNamespaceQuota(ctx echo.Context, req *v1alpha2.GenerateRequest, response *v1alpha2.GenerateResponse) *echo.HTTPError {
+	applicationSet := requestApplicationSet(ctx, req.ApplicationSetName)
+	httpErr := checkQuota(applicationSet, quotaNamespaces, quotaFor(applicationSet).MaxNamespaces, len(response.Output.Parameters))
+	if httpErr != nil {
+		loggerFrom(ctx).Warn("Namespace quota exceeded", "namespaces", len(response.Output.Parameters))
+	}
+	return httpErr
+}
+
+// checkBatchClusterQuotas checks the number of clusters each ApplicationSet
+// of a batch targets against its quota. The returned errors are those of the
+// requests at the same index, nil for the requests within their quota.
+func checkBatchClusterQuotas(ctx echo.Context, reqs []*v1alpha2.GenerateRequest) []*echo.HTTPError {
+	applicationSets := make([]ApplicationSetIdentity, len(reqs))
+	clusters := map[ApplicationSetIdentity]map[string]bool{}
+	for i, req := range reqs {
+		applicationSet := requestApplicationSet(ctx, req.ApplicationSetName)
+		applicationSets[i] = applicationSet
+		if clusters[applicationSet] == nil {
+			clusters[applicationSet] = map[string]bool{}
+		}
+		clusters[applicationSet][req.ClusterRef] = true
+	}
+
+	exceeded := map[ApplicationSetIdentity]*echo.HTTPError{}
+	for applicationSet, targets := range clusters {
+		if httpErr := checkQuota(applicationSet, quotaClusters, quotaFor(applicationSet).MaxClusters, len(targets)); httpErr != nil {
+			loggerFrom(ctx).Warn("Cluster quota exceeded", logging.KeyAppSet, applicationSet.Name, "clusters", len(targets))
+			exceeded[applicationSet] = httpErr
+		}
+	}
+
+	errs := make([]*echo.HTTPError, len(reqs))
+	for i, applicationSet := range applicationSets {
+		errs[i] = exceeded[applicationSet]
+	}
+	return errs
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig configures the token buckets used for rate limiting.
+// A zero rate disables the respective limiter.
+type RateLimitConfig struct {
+	// GlobalRate is the number of requests per second allowed across all clients.
+	GlobalRate  float64
+	GlobalBurst int
+	// ClientRate is the number of requests per second allowed for a single client.
+	ClientRate  float64
+	ClientBurst int
+	// ApplicationSetRate is the number of requests per second allowed for a
+	// single ApplicationSet of a client, identified by its headers, so the
+	// ApplicationSets sharing a client, e.g. the static key, don't throttle
+	// each other. The requests must pass the client bucket too, so the
+	// clients can't get more requests by sending other headers.
+	ApplicationSetRate  float64
+	ApplicationSetBurst int
+	// ClientExpiry is the duration after which the buckets of an idle client
+	// or ApplicationSet are dropped.
+	ClientExpiry time.Duration
+	// KeyFunc extracts the identity of the client from the request.
+	// Defaults to the client IP, see clientIP.
+	KeyFunc func(echo.Context) string
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	config RateLimitConfig
+	global *rate.Limiter
+
+	mu          sync.Mutex
+	clients     map[string]*clientLimiter
+	lastCleanup time.Time
+}
+
+// RateLimiter returns a middleware that rejects requests exceeding the
+// configured global or per-client rates with 429 and a Retry-After header.
+func RateLimiter(config RateLimitConfig) echo.MiddlewareFunc {
+	if config.KeyFunc == nil {
+		config.KeyFunc = clientIP
+	}
+	if config.ClientExpiry == 0 {
+		config.ClientExpiry = 3 * time.Minute
+	}
+
+	limiter := &rateLimiter{
+		config:      config,
+		clients:     map[string]*clientLimiter{},
+		lastCleanup: time.Now(),
+	}
+	if config.GlobalRate > 0 {
+		limiter.global = rate.NewLimiter(rate.Limit(config.GlobalRate), max(config.GlobalBurst, 1))
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			key := config.KeyFunc(ctx)
+			applicationSet := requestApplicationSet(ctx, "").String()
+			if delay := limiter.reserve(key, applicationSet); delay > 0 {
+				loggerFrom(ctx).Warn("Rate limit exceeded", "client", key)
+				return retryAfterResponse(ctx, http.StatusTooManyRequests, "rate limit exceeded", delay)
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// reserve takes a token from the global, the client and the ApplicationSet
+// buckets. If any of them is empty, no token is taken and the duration until
+// the request would be allowed is returned. The requests without an
+// ApplicationSet only take from the global and the client buckets.
+func (limiter *rateLimiter) reserve(key, applicationSet string) time.Duration {
+	now := time.Now()
+
+	var reservations []*rate.Reservation
+	if limiter.global != nil {
+		reservations = append(reservations, limiter.global.ReserveN(now, 1))
+	}
+	if clientLimiter := limiter.bucket(key, limiter.config.ClientRate, limiter.config.ClientBurst, now); clientLimiter != nil {
+		reservations = append(reservations, clientLimiter.ReserveN(now, 1))
+	}
+	if applicationSet != "" {
+		// The headers can't contain NUL, so the keys can't collide.
+		applicationSetLimiter := limiter.bucket(key+"\x00"+applicationSet, limiter.config.ApplicationSetRate, limiter.config.ApplicationSetBurst, now)
+		if applicationSetLimiter != nil {
+			reservations = append(reservations, applicationSetLimiter.ReserveN(now, 1))
+		}
+	}
+
+	var delay time.Duration
+	for _, reservation := range reservations {
+		delay = max(delay, reservation.DelayFrom(now))
+	}
+	if delay > 0 {
+		for _, reservation := range reservations {
+			reservation.CancelAt(now)
+		}
+	}
+
+	return delay
+}
+
+// bucket returns the bucket of the key, creating it with the given rate and
+// burst, or nil if the rate is zero.
+func (limiter *rateLimiter) bucket(key string, limit float64, burst int, now time.Time) *rate.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if now.Sub(limiter.lastCleanup) > limiter.config.ClientExpiry {
+		for k, client := range limiter.clients {
+			if now.Sub(client.lastSeen) > limiter.config.ClientExpiry {
+				delete(limiter.clients, k)
+			}
+		}
+		limiter.lastCleanup = now
+	}
+
+	client, ok := limiter.clients[key]
+	if !ok {
+		client = &clientLimiter{
+			limiter: rate.NewLimiter(rate.Limit(limit), max(burst, 1)),
+		}
+		limiter.clients[key] = client
+	}
+	client.lastSeen = now
+
+	return client.limiter
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/recording"
+)
+
+// maxRecordedResponse is the size of the response bodies kept in the
+// records. The larger ones are truncated, so they can't be compared when
+// replayed.
+const maxRecordedResponse = 1 << 20
+
+// Record returns a middleware that records the requests served by the next
+// handlers and their responses with the recorder. A nil recorder disables
+// recording. It must be registered after the authentication, so only the
+// requests of the plugin are recorded, and after the compression, so the
+// responses are recorded as served before being compressed.
+//
+// The request bodies are bounded by MaxBodyBytes, like when they're decoded,
+// and only the first maxRecordedResponse bytes of the responses are kept.
+func Record(recorder *recording.Recorder) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if recorder == nil {
+			return next
+		}
+		return func(ctx echo.Context) error {
+			// Event streams don't end, they would be recorded forever.
+			if ctx.Path() == "/api/v1/namespaces/events" {
+				return next(ctx)
+			}
+
+			start := time.Now()
+			req := ctx.Request()
+			// The body is recorded as the handlers read it, so it's never
+			// buffered beyond the limit.
+			var body bytes.Buffer
+			if req.Body != nil {
+				input := http.MaxBytesReader(ctx.Response(), req.Body, MaxBodyBytes)
+				req.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(input, &body), input}
+			}
+			response := ctx.Response()
+			writer := &recordingWriter{ResponseWriter: response.Writer}
+			response.Writer = writer
+			defer func() { response.Writer = writer.ResponseWriter }()
+
+			err := next(ctx)
+			if err != nil {
+				// The response of the failures is written by the error
+				// handler, which ignores the committed responses when the
+				// error is handled again.
+				ctx.Error(err)
+			}
+
+			record := recording.NewRecord(start, requestID(ctx), req, body.Bytes(), response.Status, writer.body.Bytes(), writer.truncated)
+			if _, err := recorder.Record(record); err != nil {
+				loggerFrom(ctx).Error("Failed to record the request", logging.KeyError, err)
+			}
+			return err
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the first maxRecordedResponse bytes of the
+// response written.
+type recordingWriter struct {
+	http.ResponseWriter
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (writer *recordingWriter) Write(data []byte) (int, error) {
+	if remaining := maxRecordedResponse - writer.body.Len(); len(data) > remaining {
+		writer.body.Write(data[:max(remaining, 0)])
+		writer.truncated = true
+	} else {
+		writer.body.Write(data)
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *recordingWriter) Flush() {
+	_ = http.NewResponseController(writer.ResponseWriter).Flush()
+}
+
+func (writer *recordingWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
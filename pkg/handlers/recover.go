@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/labstack/echo/v4"
+
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// Recover returns a middleware turning the panics of the handlers into
+// Internal error responses, carrying the request ID, instead of dropping the
+// connection. The panic is logged with its stack by the logger of the
+// request, so it must be registered after RequestLogger.
+func Recover() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) (err error) {
+			defer func() {
+				value := recover()
+				if value == nil {
+					return
+				}
+				if value == http.ErrAbortHandler {
+					// Aborts the response on purpose, see http.Handler.
+					panic(value)
+				}
+				recordPanic(ctx, value)
+				if ctx.Response().Committed {
+					err = nil
+					return
+				}
+				err = classifiedErrorResponse(ctx, generrors.ErrInternal, "internal error")
+			}()
+			return next(ctx)
+		}
+	}
+}
+
+// recordPanic logs a panic recovered while serving a request, with the stack
+// of the goroutine which panicked, and counts it.
+func recordPanic(ctx echo.Context, value any) {
+	metrics.Panics.WithLabelValues(ctx.Path()).Inc()
+	loggerFrom(ctx).Error("Recovered from a panic",
+		"panic", fmt.Sprint(value),
+		"method", ctx.Request().Method,
+		"route", ctx.Path(),
+		"stack", string(debug.Stack()),
+	)
+}
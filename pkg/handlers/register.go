@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// Options are the dependencies and the settings of the routes registered by
+// Register.
+type Options struct {
+	// K8sClientFactory returns the client of the local cluster. It's
+	// required.
+	K8sClientFactory K8sClientFactory
+	// LiveClient is an uncached client of the local cluster, used to watch
+	// its namespaces. Namespace events and changes of the local cluster
+	// aren't served without it, and requests for the local cluster don't
+	// wait for changes.
+	LiveClient client.WithWatch
+	// RemoteClients caches the clients of the remote clusters. It's
+	// required.
+	RemoteClients *RemoteClientCache
+	// Responses, Snapshots and Prober are optional.
+	Responses *ResponseCache
+	Snapshots *SnapshotStore
+	Prober    *ClusterProber
+
+	// Middleware runs, in order, before the handlers of the API routes and
+	// of the provisioning one, followed by their authentication and then by
+	// AuthenticatedMiddleware. It should set the request IDs and the request
+	// loggers if the server doesn't, see RequestLogger, and restrict the
+	// sources, see SourceAllowlist. Without Authentication, it must
+	// authenticate the requests itself.
+	Middleware []echo.MiddlewareFunc
+	// Authentication authenticates the requests of the API routes, e.g. with
+	// the KeyAuth middleware.
+	Authentication echo.MiddlewareFunc
+	// AuthenticatedMiddleware runs once the requests are authenticated, e.g.
+	// RateLimiter keyed by CallerRateLimitKey and Audit.
+	AuthenticatedMiddleware []echo.MiddlewareFunc
+	// AdminMiddleware runs before the handlers of the admin routes, which
+	// are only registered when it's set. It must authenticate the requests.
+	AdminMiddleware []echo.MiddlewareFunc
+	// ProvisioningAuthentication authenticates the requests provisioning
+	// namespaces on POST /api/v1/namespaces, which is only registered when
+	// it's set, in place of Authentication as the callers create namespaces.
+	// It requires LiveClient.
+	ProvisioningAuthentication echo.MiddlewareFunc
+	Provisioning               ProvisionOptions
+
+	// InFlight limits the requests generating parameters.
+	InFlight        InFlightConfig
+	StreamThreshold int
+	BatchMaxSize    int
+	ChangeFeed      ChangeFeedOptions
+	// Logger logs the failures of the work done in the background, e.g.
+	// watching the namespaces for the change feed. It defaults to
+	// slog.Default().
+	Logger *slog.Logger
+	// V1alpha2Prefix and ClustersPrefix are the prefixes of the v1alpha2 and
+	// clusters plugins, which are only registered when set.
+	V1alpha2Prefix string
+	ClustersPrefix string
+	// ClustersProject restricts the clusters plugin to the clusters of an
+	// ArgoCD project. Empty lets the requests choose the project.
+	ClustersProject string
+	// DebugUI serves the page trying selectors on /ui. Its requests are
+	// served by the admin routes, so it requires AdminMiddleware.
+	DebugUI bool
+	// Metrics registers /metrics, serving metrics.Registry. Servers already
+	// serving their own registry may register the collectors of
+	// metrics.Registry in it instead.
+	Metrics bool
+}
+
+// Routes are the handlers registered by Register which the caller manages.
+type Routes struct {
+	// NamespaceEvents and GetParams must be shut down before the server, so
+	// the streams and the requests waiting for changes don't hold up draining
+	// the requests.
+	NamespaceEvents *NamespaceEventsHandler
+	GetParams       *GetParamsHandler
+	// NamespaceChanges must be shut down to stop watching the clusters.
+	NamespaceChanges *NamespaceChangesHandler
+}
+
+// chain returns the middleware of the routes authenticated by authentication.
+func (opts Options) chain(authentication echo.MiddlewareFunc) []echo.MiddlewareFunc {
+	chain := slices.Clip(opts.Middleware)
+	if authentication != nil {
+		chain = append(chain, authentication)
+	}
+	return append(chain, opts.AuthenticatedMiddleware...)
+}
+
+// Register registers the routes of the generator, so it can be mounted
+// inside an existing server instead of running as a separate deployment.
+// Errors are written as ErrorResponse bodies when HTTPErrorHandler is the
+// error handler of the server.
+func Register(e *echo.Echo, opts Options) *Routes {
+	getParamsHandler := NewGetParamsHandler(opts.K8sClientFactory, opts.RemoteClients, opts.Responses, opts.Snapshots, opts.StreamThreshold, opts.LiveClient)
+	clustersHandler := NewClustersHandler(opts.K8sClientFactory, opts.RemoteClients, opts.ClustersProject)
+	batchHandler := NewBatchHandler(opts.K8sClientFactory, opts.RemoteClients, opts.Responses, opts.Snapshots, opts.BatchMaxSize)
+	namespaceEventsHandler := NewNamespaceEventsHandler(opts.K8sClientFactory, opts.RemoteClients, opts.LiveClient)
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	namespaceChangesHandler := NewNamespaceChangesHandler(opts.K8sClientFactory, opts.RemoteClients, opts.LiveClient, opts.ChangeFeed, logger)
+
+	// The limit is shared by all the endpoints generating parameters.
+	inFlightLimiter := InFlightLimiter(opts.InFlight)
+
+	apiMiddleware := opts.chain(opts.Authentication)
+	api := e.Group("/api", apiMiddleware...)
+	api.POST("/v1/getparams.execute", getParamsHandler.GetParams, inFlightLimiter)
+	api.POST("/v1/explain", getParamsHandler.Explain)
+	api.POST("/v1/getparams.batch", batchHandler.GetParamsBatch, inFlightLimiter)
+	api.GET("/v1/clusters", clustersHandler.ListClusters)
+	api.GET("/v1/clusters/:name/check", clustersHandler.Check)
+	if opts.Prober != nil {
+		api.GET("/v1/clusters/health", opts.Prober.Health)
+	}
+	api.GET("/v1/namespaces/events", namespaceEventsHandler.StreamNamespaceEvents)
+	api.GET("/v1/namespaces/changes", namespaceChangesHandler.ListNamespaceChanges)
+
+	if opts.ProvisioningAuthentication != nil {
+		provisionHandler := NewProvisionHandler(opts.LiveClient, opts.Provisioning)
+		e.POST("/api/v1/namespaces", provisionHandler.ProvisionNamespace, opts.chain(opts.ProvisioningAuthentication)...)
+	}
+
+	// ArgoCD can't set the Accept header, so v1alpha2 is also served under a
+	// prefix which can be added to the base URL of the plugin.
+	if opts.V1alpha2Prefix != "" {
+		v1alpha2API := e.Group(opts.V1alpha2Prefix+"/api", append(slices.Clip(apiMiddleware), APIVersion(v1alpha2.Version))...)
+		v1alpha2API.POST("/v1/getparams.execute", getParamsHandler.GetParams, inFlightLimiter)
+		v1alpha2API.POST("/v1/explain", getParamsHandler.Explain)
+		v1alpha2API.POST("/v1/getparams.batch", batchHandler.GetParamsBatch, inFlightLimiter)
+	}
+	// ArgoCD appends the plugin path to the base URL, so the clusters plugin
+	// is served under its own prefix.
+	if opts.ClustersPrefix != "" {
+		clustersPlugin := e.Group(opts.ClustersPrefix+"/api", apiMiddleware...)
+		clustersPlugin.POST("/v1/getparams.execute", clustersHandler.GetClusterParams)
+	}
+
+	if len(opts.AdminMiddleware) > 0 {
+		admin := e.Group("/admin", opts.AdminMiddleware...)
+		adminHandler := NewAdminHandler(opts.RemoteClients, opts.Responses)
+		admin.GET("/clients", adminHandler.ListClients)
+		admin.DELETE("/clients", adminHandler.InvalidateClients)
+		admin.DELETE("/clients/:cluster", adminHandler.InvalidateClient)
+		admin.DELETE("/tokens", adminHandler.InvalidateTokens)
+		admin.DELETE("/responses", adminHandler.InvalidateResponses)
+		admin.GET("/orphans", NewOrphansHandler(opts.LiveClient).ListOrphans)
+
+		if opts.DebugUI {
+			debugUIHandler := NewDebugUIHandler(getParamsHandler)
+			e.GET("/ui", debugUIHandler.Page)
+			admin.GET("/ui/clusters", clustersHandler.ListClusters)
+			admin.POST("/ui/generate", debugUIHandler.Generate)
+		}
+	}
+
+	if opts.Metrics {
+		e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+	}
+
+	return &Routes{
+		NamespaceEvents:  namespaceEventsHandler,
+		GetParams:        getParamsHandler,
+		NamespaceChanges: namespaceChangesHandler,
+	}
+}
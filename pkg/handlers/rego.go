@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"github.com/open-policy-agent/opa/rego"
+	"k8s.io/apimachinery/pkg/labels"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// regoDenyQuery is evaluated against every candidate namespace. Policies deny
+// a namespace by adding a message to the deny set of their package, e.g.
+//
+//	package namespace_generator
+//
+//	import rego.v1
+//
+//	deny contains "namespaces must have an owner" if not input.namespace.labels.owner
+const regoDenyQuery = "data.namespace_generator.deny"
+
+// regoInput is the input the Rego policy is evaluated with.
+type regoInput struct {
+	Namespace regoNamespace `json:"namespace"`
+	Request   regoRequest   `json:"request"`
+}
+
+type regoNamespace struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type regoRequest struct {
+	ApplicationSet string `json:"applicationSet"`
+	// ClusterName is empty for the local cluster.
+	ClusterName   string `json:"clusterName"`
+	LabelSelector string `json:"labelSelector"`
+}
+
+// compileRegoPolicy prepares the deny query of the policy, so it isn't
+// compiled again for every namespace.
+func compileRegoPolicy(source string) (*rego.PreparedEvalQuery, error) {
+	query, err := rego.New(
+		rego.Query(regoDenyQuery),
+		rego.Module("policy.rego", source),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &query, nil
+}
+
+// regoFilters returns a filter dropping the namespaces denied by the Rego
+// policy, or nil if there is no policy. Namespaces are denied when the policy
+// fails to evaluate, so a broken policy doesn't leak namespaces.
+func regoFilters(ctx echo.Context, policy *Policy, req *v1alpha2.GenerateRequest, selector labels.Selector) []namespaceFilter {
+	if policy.regoQuery == nil {
+		return nil
+	}
+
+	request := regoRequest{
+		ApplicationSet: req.ApplicationSetName,
+		ClusterName:    req.ClusterRef,
+		LabelSelector:  selector.String(),
+	}
+	logged := false
+	return []namespaceFilter{{
+		description: fmt.Sprintf("policy rego: %s", regoDenyQuery),
+		matches: func(namespace *metav1.PartialObjectMetadata) bool {
+			denials, err := evalRegoDeny(ctx.Request().Context(), policy.regoQuery, regoInput{
+				Namespace: regoNamespace{Name: namespace.Name, Labels: namespace.Labels, Annotations: namespace.Annotations},
+				Request:   request,
+			})
+			if err != nil {
+				// The error is the same for every namespace.
+				if !logged {
+					loggerFrom(ctx).Error("Failed to evaluate the Rego policy, denying the namespaces", logging.KeyError, err)
+					logged = true
+				}
+				return false
+			}
+			if len(denials) > 0 {
+				loggerFrom(ctx).Debug("Namespace denied by the Rego policy", "namespace", namespace.Name, "reasons", denials)
+				return false
+			}
+			return true
+		},
+	}}
+}
+
+// evalRegoDeny returns the messages of the deny set for the input.
+func evalRegoDeny(ctx context.Context, query *rego.PreparedEvalQuery, input regoInput) ([]any, error) {
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	// An undefined deny set denies nothing.
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, nil
+	}
+	denials, ok := results[0].Expressions[0].Value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a set, got %T", regoDenyQuery, results[0].Expressions[0].Value)
+	}
+	return denials, nil
+}
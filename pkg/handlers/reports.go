@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	"github.com/konflux-ci/namespace-generator/pkg/generationreport"
+)
+
+type reporterKey struct{}
+
+// GenerationReports returns a middleware that makes the outcome of the
+// generation requests served by the next handlers added to the reports of
+// their route and cluster. A nil reporter disables the reports.
+func GenerationReports(reporter *generationreport.Reporter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if reporter == nil {
+			return next
+		}
+		return func(ctx echo.Context) error {
+			return next(withRequestContext(ctx, context.WithValue(ctx.Request().Context(), reporterKey{}, reporter)))
+		}
+	}
+}
+
+// reportGenerate records the outcome of a generate request, if the reports
+// are enabled. Responses served from a snapshot are failures of the cluster.
+func reportGenerate(ctx echo.Context, req *v1alpha2.GenerateRequest, response *v1alpha2.GenerateResponse, httpErr *echo.HTTPError) {
+	reporter, ok := ctx.Request().Context().Value(reporterKey{}).(*generationreport.Reporter)
+	if !ok {
+		return
+	}
+
+	cluster := req.ClusterRef
+	if cluster == "" {
+		cluster = audit.LocalCluster
+	}
+	switch {
+	case httpErr != nil:
+		message, _ := httpErr.Message.(string)
+		reporter.Record(ctx.Path(), cluster, 0, errors.New(message))
+	case response.Stale != nil:
+		reporter.Record(ctx.Path(), cluster, 0, errors.New(response.Stale.Reason))
+	default:
+		reporter.Record(ctx.Path(), cluster, len(response.Output.Parameters), nil)
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+	"github.com/konflux-ci/namespace-generator/pkg/sharedcache"
+)
+
+// ResponseCache caches generate responses for a fixed duration. ArgoCD
+// refreshes every ApplicationSet on a timer, so most requests are repeated
+// while nothing changed. A nil cache caches nothing.
+//
+// When a shared store is given, responses are also shared with the other
+// replicas. The local entries are checked first.
+type ResponseCache struct {
+	store sharedcache.Store
+
+	mu          sync.Mutex
+	ttl         time.Duration
+	entries     map[string]responseCacheEntry
+	lastCleanup time.Time
+	// generations are incremented when the responses of a cluster are
+	// invalidated, so the responses listed before aren't cached.
+	generations map[string]uint64
+}
+
+type responseCacheEntry struct {
+	response    *v1alpha2.GenerateResponse
+	clusterName string
+	expires     time.Time
+}
+
+const (
+	responseCacheKeyPrefix = "response:"
+	sharedStoreTimeout     = time.Second
+)
+
+// NewResponseCache returns a cache keeping responses for the given duration,
+// or nil if the duration isn't positive. The store is optional.
+func NewResponseCache(ttl time.Duration, store sharedcache.Store) *ResponseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &ResponseCache{
+		ttl:         ttl,
+		store:       store,
+		entries:     map[string]responseCacheEntry{},
+		lastCleanup: time.Now(),
+		generations: map[string]uint64{},
+	}
+}
+
+// responseCacheKey identifies the requests having the same response. The
+// selector is normalized, and the fields which don't affect the namespaces
+// returned are left out. The ApplicationSet name only affects them when a
+// Rego policy is set, and the impersonated user bounds them.
+func responseCacheKey(req *v1alpha2.GenerateRequest, selector labels.Selector, policy *Policy, user string) string {
+	parameters := req.Input.Parameters
+	key := struct {
+		Selector          string   `json:"selector"`
+		ClusterName       string   `json:"clusterName"`
+		ExcludeNamespaces []string `json:"excludeNamespaces"`
+		LabelKeys         []string `json:"labelKeys"`
+		AnnotationKeys    []string `json:"annotationKeys,omitempty"`
+		OpenShiftProject  bool     `json:"openshiftProject,omitempty"`
+		ApplicationSet    string   `json:"applicationSet,omitempty"`
+		ImpersonatedUser  string   `json:"impersonatedUser,omitempty"`
+	}{
+		Selector:          selector.String(),
+		ClusterName:       req.ClusterRef,
+		ExcludeNamespaces: sortedCopy(parameters.ExcludeNamespaces),
+		LabelKeys:         sortedCopy(parameters.LabelKeys),
+		AnnotationKeys:    sortedCopy(parameters.AnnotationKeys),
+		OpenShiftProject:  parameters.OpenShiftProject,
+		ImpersonatedUser:  user,
+	}
+	// A Rego policy may deny namespaces depending on the ApplicationSet.
+	if policy.regoQuery != nil {
+		key.ApplicationSet = req.ApplicationSetName
+	}
+
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sortedCopy(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return values
+}
+
+// sharedKey returns the key of a response in the shared store. The cluster
+// name is part of it so the responses of a cluster can be deleted together.
+func sharedKey(clusterName, key string) string {
+	return responseCacheKeyPrefix + clusterName + ":" + key
+}
+
+// get returns the cached response. On a miss, it returns the generation of
+// the responses of the cluster to pass to set.
+func (cache *ResponseCache) get(ctx context.Context, clusterName, key string) (*v1alpha2.GenerateResponse, uint64, bool) {
+	if cache == nil {
+		return nil, 0, false
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[key]
+	generation := cache.generations[clusterName]
+	cache.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.response, generation, true
+	}
+
+	response, ok := cache.getShared(ctx, sharedKey(clusterName, key))
+	return response, generation, ok
+}
+
+func (cache *ResponseCache) getShared(ctx context.Context, key string) (*v1alpha2.GenerateResponse, bool) {
+	if cache.store == nil {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+	defer cancel()
+	data, ok, err := cache.store.Get(ctx, key)
+	if err != nil {
+		metrics.SharedCacheErrors.WithLabelValues("response").Inc()
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	response := &v1alpha2.GenerateResponse{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, false
+	}
+	return response, true
+}
+
+// set caches the response, unless the responses of the cluster were
+// invalidated since the given generation was returned by get.
+func (cache *ResponseCache) set(ctx context.Context, clusterName, key string, generation uint64, response *v1alpha2.GenerateResponse) {
+	if cache == nil {
+		return
+	}
+
+	now := time.Now()
+	cache.mu.Lock()
+	if cache.generations[clusterName] != generation {
+		cache.mu.Unlock()
+		return
+	}
+	if now.Sub(cache.lastCleanup) > cache.ttl {
+		for k, entry := range cache.entries {
+			if now.After(entry.expires) {
+				delete(cache.entries, k)
+			}
+		}
+		cache.lastCleanup = now
+	}
+	cache.entries[key] = responseCacheEntry{response: response, clusterName: clusterName, expires: now.Add(cache.ttl)}
+	ttl := cache.ttl
+	cache.mu.Unlock()
+
+	cache.setShared(ctx, sharedKey(clusterName, key), response, ttl)
+
+	// The shared response is deleted if the cluster was invalidated while it
+	// was being stored.
+	cache.mu.Lock()
+	invalidated := cache.generations[clusterName] != generation
+	cache.mu.Unlock()
+	if invalidated && cache.store != nil {
+		ctx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+		defer cancel()
+		if _, err := cache.store.DeletePrefix(ctx, sharedKey(clusterName, key)); err != nil {
+			metrics.SharedCacheErrors.WithLabelValues("response").Inc()
+		}
+	}
+}
+
+func (cache *ResponseCache) setShared(ctx context.Context, key string, response *v1alpha2.GenerateResponse, ttl time.Duration) {
+	if cache.store == nil {
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sharedStoreTimeout)
+	defer cancel()
+	if err := cache.store.Set(ctx, key, data, ttl); err != nil {
+		metrics.SharedCacheErrors.WithLabelValues("response").Inc()
+	}
+}
+
+// SetTTL changes how long the responses cached from now on are kept.
+func (cache *ResponseCache) SetTTL(ttl time.Duration) {
+	if cache == nil {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.ttl = ttl
+}
+
+// InvalidateAll drops all the cached responses, including the shared ones,
+// and returns how many were cached locally.
+func (cache *ResponseCache) InvalidateAll(ctx context.Context) (int, error) {
+	if cache == nil {
+		return 0, nil
+	}
+
+	cache.mu.Lock()
+	count := len(cache.entries)
+	cache.entries = map[string]responseCacheEntry{}
+	cache.mu.Unlock()
+
+	if cache.store != nil {
+		if _, err := cache.store.DeletePrefix(ctx, responseCacheKeyPrefix); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// InvalidateCluster drops the cached responses of the cluster, including the
+// shared ones, and returns how many were cached locally. The responses being
+// generated for the cluster aren't cached.
+func (cache *ResponseCache) InvalidateCluster(ctx context.Context, clusterName string) (int, error) {
+	if cache == nil {
+		return 0, nil
+	}
+
+	cache.mu.Lock()
+	cache.generations[clusterName]++
+	count := 0
+	for key, entry := range cache.entries {
+		if entry.clusterName == clusterName {
+			delete(cache.entries, key)
+			count++
+		}
+	}
+	cache.mu.Unlock()
+
+	if cache.store != nil {
+		if _, err := cache.store.DeletePrefix(ctx, sharedKey(clusterName, "")); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
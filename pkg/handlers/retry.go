@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// RetryConfig configures retrying calls to remote clusters which failed with
+// a transient error. Attempts includes the first call, so 1 disables retries.
+type RetryConfig struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// delay returns the jittered delay before the given retry, starting at 1.
+func (config RetryConfig) delay(retry int) time.Duration {
+	delay := config.InitialBackoff
+	for i := 1; i < retry && (config.MaxBackoff <= 0 || delay < config.MaxBackoff); i++ {
+		delay *= 2
+	}
+	delay = wait.Jitter(delay, 0.5)
+	if config.MaxBackoff > 0 {
+		delay = min(delay, config.MaxBackoff)
+	}
+	return delay
+}
+
+// withRetry calls fn until it succeeds, fails with an error which isn't
+// transient, or the attempts are exhausted. Waiting between attempts stops
+// when the context is done.
+func withRetry(ctx context.Context, config RetryConfig, operation string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= config.Attempts || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		metrics.RemoteRetries.WithLabelValues(operation).Inc()
+
+		timer := time.NewTimer(config.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// isTransient reports whether the error is likely to go away when retrying,
+// such as timeouts, throttling and reset connections.
+func isTransient(err error) bool {
+	if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err) {
+		return true
+	}
+	if utilnet.IsConnectionReset(err) || utilnet.IsConnectionRefused(err) || utilnet.IsProbableEOF(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+)
+
+// DegradedRetryAfter is the delay suggested to the clients of stale responses
+// and of requests failing because a cluster can't be reached. It's set from
+// the configuration on startup. Zero suggests no delay.
+var DegradedRetryAfter = 30 * time.Second
+
+// retryAfterSeconds rounds the delay up to the second, as sent in the
+// Retry-After header.
+func retryAfterSeconds(delay time.Duration) int {
+	return int(math.Ceil(delay.Seconds()))
+}
+
+// setRetryAfter sets the Retry-After header, unless seconds is zero.
+func setRetryAfter(ctx echo.Context, seconds int) {
+	if seconds > 0 {
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
+
+// retryAfterResponse writes the response of a request rejected to shed load,
+// with the delay before retrying in the Retry-After header and in the body.
+func retryAfterResponse(ctx echo.Context, status int, message string, delay time.Duration) error {
+	seconds := retryAfterSeconds(delay)
+	setRetryAfter(ctx, seconds)
+	return ctx.JSON(status, &v1alpha1.ErrorResponse{
+		Message:           message,
+		RequestID:         requestID(ctx),
+		RetryAfterSeconds: seconds,
+	})
+}
+
+// isDegraded reports whether a generate request failed because a cluster
+// can't be reached or doesn't answer in time, which retrying later may fix.
+func isDegraded(err error) bool {
+	switch generrors.KindOf(err) {
+	case generrors.ErrClusterUnreachable, generrors.ErrAuthFailed, generrors.ErrTimeout:
+		return true
+	}
+	return false
+}
+
+// generateErrorJSON writes the response of a failed generate request, with a
+// Retry-After header when retrying later may succeed.
+func generateErrorJSON(ctx echo.Context, httpErr *echo.HTTPError) error {
+	response := generateErrorResponse(ctx, httpErr)
+	setRetryAfter(ctx, response.RetryAfterSeconds)
+	return ctx.JSON(httpErr.Code, response)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// secretAllowlistReader reads the cluster secrets with GETs of the allowed
+// names only, for installations which don't grant the generator the
+// permission to list or watch secrets. The other objects are read from the
+// embedded reader.
+type secretAllowlistReader struct {
+	client.Reader
+	secrets client.Reader
+	names   []string
+}
+
+// NewSecretAllowlistReader returns a reader getting the cluster secrets with
+// the names from the secrets reader, which shouldn't be a cache as it would
+// watch the secrets. Listing the secrets gets each of the names, so the
+// other secrets are never read, and getting another secret returns NotFound.
+func NewSecretAllowlistReader(reader, secrets client.Reader, names []string) client.Reader {
+	return &secretAllowlistReader{Reader: reader, secrets: secrets, names: names}
+}
+
+func (reader *secretAllowlistReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*corev1.Secret); !ok {
+		return reader.Reader.Get(ctx, key, obj, opts...)
+	}
+	if key.Namespace != ArgoCDNamespace || !slices.Contains(reader.names, key.Name) {
+		return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+	return reader.secrets.Get(ctx, key, obj, opts...)
+}
+
+func (reader *secretAllowlistReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	secretList, ok := list.(*corev1.SecretList)
+	if !ok {
+		return reader.Reader.List(ctx, list, opts...)
+	}
+
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+	selector := listOptions.LabelSelector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+
+	secretList.Items = nil
+	if listOptions.Namespace != "" && listOptions.Namespace != ArgoCDNamespace {
+		return nil
+	}
+	for _, name := range reader.names {
+		secret := corev1.Secret{}
+		err := reader.secrets.Get(ctx, client.ObjectKey{Namespace: ArgoCDNamespace, Name: name}, &secret)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if selector.Matches(labels.Set(secret.Labels)) {
+			secretList.Items = append(secretList.Items, secret)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+)
+
+const (
+	snapshotFileSuffix = ".json"
+
+	headerWarning = "Warning"
+	// staleWarning is the standard warning for stale responses.
+	staleWarning = `110 - "Response is Stale"`
+	// truncatedWarning tells the clients which only read the output that it
+	// was truncated.
+	truncatedWarning = `199 - "Response is truncated"`
+)
+
+// SnapshotStore persists the last successful response of each request to a
+// directory, so they can be served, marked as stale, when the clusters can't
+// be listed. This avoids returning errors or empty results while the caches
+// warm up after a restart. A nil store persists nothing.
+type SnapshotStore struct {
+	dir string
+
+	mu        sync.Mutex
+	maxAge    time.Duration
+	snapshots map[string]*snapshot
+}
+
+type snapshot struct {
+	SavedAt     time.Time                  `json:"savedAt"`
+	ClusterName string                     `json:"clusterName,omitempty"`
+	Response    *v1alpha2.GenerateResponse `json:"response"`
+
+	// output is the marshalled output, for skipping writes when the output
+	// didn't change.
+	output []byte
+}
+
+// NewSnapshotStore loads the snapshots saved in the directory. Snapshots
+// older than maxAge are never served and are removed.
+func NewSnapshotStore(dir string, maxAge time.Duration) (*SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	store := &SnapshotStore{dir: dir, maxAge: maxAge, snapshots: map[string]*snapshot{}}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotFileSuffix) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		saved, err := readSnapshot(path)
+		if err != nil || store.expired(saved) {
+			_ = os.Remove(path)
+			continue
+		}
+		store.snapshots[strings.TrimSuffix(entry.Name(), snapshotFileSuffix)] = saved
+	}
+
+	return store, nil
+}
+
+func readSnapshot(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	saved := &snapshot{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		return nil, err
+	}
+	saved.output, err = json.Marshal(saved.Response.Output)
+	return saved, err
+}
+
+func (store *SnapshotStore) expired(saved *snapshot) bool {
+	return store.maxAge > 0 && time.Since(saved.SavedAt) > store.maxAge
+}
+
+// SetMaxAge changes the age above which snapshots aren't served.
+func (store *SnapshotStore) SetMaxAge(maxAge time.Duration) {
+	if store == nil {
+		return
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.maxAge = maxAge
+}
+
+// Len returns the number of snapshots which can be served.
+func (store *SnapshotStore) Len() int {
+	if store == nil {
+		return 0
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return len(store.snapshots)
+}
+
+// get returns the snapshot saved for the request key, if it isn't too old.
+func (store *SnapshotStore) get(key string) (*snapshot, bool) {
+	if store == nil {
+		return nil, false
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	saved, ok := store.snapshots[key]
+	if !ok || store.expired(saved) {
+		return nil, false
+	}
+	return saved, true
+}
+
+// save persists the response of the request key. The file is only rewritten
+// when the output changed or the snapshot is getting old.
+func (store *SnapshotStore) save(key string, clusterName string, response *v1alpha2.GenerateResponse) error {
+	if store == nil {
+		return nil
+	}
+
+	output, err := json.Marshal(response.Output)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	if previous, ok := store.snapshots[key]; ok && bytes.Equal(previous.output, output) {
+		// Unchanged snapshots are refreshed before they expire.
+		if store.maxAge == 0 || now.Sub(previous.SavedAt) < store.maxAge/2 {
+			return nil
+		}
+	}
+
+	saved := &snapshot{SavedAt: now, ClusterName: clusterName, Response: &v1alpha2.GenerateResponse{Output: response.Output}, output: output}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash never leaves a partial file.
+	path := filepath.Join(store.dir, key+snapshotFileSuffix)
+	tmp, err := os.CreateTemp(store.dir, key+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	store.snapshots[key] = saved
+	return nil
+}
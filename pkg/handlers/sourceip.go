@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// clientIP returns the IP of the client of the request. The proxy headers are
+// only trusted when the server sets an IPExtractor, which only trusts them
+// from its proxies. Otherwise the address of the connection is used, as the
+// default of echo trusts the headers of any client.
+func clientIP(ctx echo.Context) string {
+	if ctx.Echo().IPExtractor == nil {
+		return echo.ExtractIPDirect()(ctx.Request())
+	}
+	return ctx.RealIP()
+}
+
+// SourceAllowlist returns a middleware rejecting with 403 the requests whose
+// client IP isn't in one of the networks, so the API only answers the
+// expected clients even if the NetworkPolicies are misconfigured. The client
+// IP is the one returned by the IPExtractor of the server, which decides
+// which proxy headers are trusted.
+//
+// Requests received on the Unix domain socket aren't checked, as they don't
+// have an IP and can only come from the pod.
+func SourceAllowlist(networks []*net.IPNet) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if _, _, err := net.SplitHostPort(ctx.Request().RemoteAddr); err != nil {
+				return next(ctx)
+			}
+			ip := net.ParseIP(clientIP(ctx))
+			for _, network := range networks {
+				if ip != nil && network.Contains(ip) {
+					return next(ctx)
+				}
+			}
+			loggerFrom(ctx).Warn("Rejecting request from a source which isn't allowed", "source", clientIP(ctx))
+			return errorResponse(ctx, http.StatusForbidden, "source address isn't allowed")
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+type stageRecorderKey struct{}
+
+// stageRecorder records how long each stage of a generate request took, so
+// a timed out request can report how far it got, along with the details
+// reported by debug responses.
+type stageRecorder struct {
+	start time.Time
+
+	mu           sync.Mutex
+	stages       []v1alpha1.StageTiming
+	current      string
+	server       string
+	authProvider string
+	cacheHits    map[string]bool
+}
+
+func withStageRecorder(ctx context.Context) (context.Context, *stageRecorder) {
+	recorder := &stageRecorder{start: time.Now()}
+	return context.WithValue(ctx, stageRecorderKey{}, recorder), recorder
+}
+
+// Stages of a generate request.
+const (
+	stageSecret = "secret"
+	stageAuth   = "auth"
+	stageClient = "client"
+	stageList   = "list"
+)
+
+// StageTimeouts bound the stages of requests, so a single slow dependency
+// can't consume the whole time of a request. A zero timeout disables it.
+type StageTimeouts struct {
+	Secret time.Duration
+	Auth   time.Duration
+	Client time.Duration
+	List   time.Duration
+}
+
+func (timeouts StageTimeouts) forStage(name string) time.Duration {
+	switch name {
+	case stageSecret:
+		return timeouts.Secret
+	case stageAuth:
+		return timeouts.Auth
+	case stageClient:
+		return timeouts.Client
+	case stageList:
+		return timeouts.List
+	}
+	return 0
+}
+
+type stageTimeoutsKey struct{}
+
+// WithStageTimeouts returns a middleware applying the given timeouts to the
+// stages of the requests.
+func WithStageTimeouts(timeouts StageTimeouts) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			reqCtx := context.WithValue(ctx.Request().Context(), stageTimeoutsKey{}, timeouts)
+			ctx.SetRequest(ctx.Request().WithContext(reqCtx))
+			return next(ctx)
+		}
+	}
+}
+
+// startStage marks the beginning of a stage of the request. The returned
+// context is bound by the timeout of the stage, and the returned function
+// ends the stage. Stages are recorded in metrics, and in the recorder of the
+// request if it has one.
+func startStage(ctx context.Context, name string) (context.Context, func(err error)) {
+	stageCtx, cancel := ctx, context.CancelFunc(func() {})
+	timeouts, _ := ctx.Value(stageTimeoutsKey{}).(StageTimeouts)
+	if timeout := timeouts.forStage(name); timeout > 0 {
+		stageCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	start := time.Now()
+	recorder := recorderFrom(ctx)
+	if recorder != nil {
+		recorder.mu.Lock()
+		recorder.current = name
+		recorder.mu.Unlock()
+	}
+
+	return stageCtx, func(err error) {
+		defer cancel()
+
+		duration := time.Since(start)
+		result := metrics.ResultSuccess
+		if err != nil {
+			result = metrics.ResultError
+			if errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+				result = metrics.ResultTimeout
+			}
+		}
+		metrics.ObserveWithTrace(stageCtx, metrics.StageDuration.WithLabelValues(name, result), duration.Seconds())
+
+		// The stage which timed out, or whose request did, stays the current
+		// one, as reported by timeoutDetails.
+		if recorder == nil || result == metrics.ResultTimeout {
+			return
+		}
+		timing := v1alpha1.StageTiming{Name: name, DurationMillis: duration.Milliseconds()}
+		if err != nil {
+			timing.Error = err.Error()
+		}
+
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		recorder.stages = append(recorder.stages, timing)
+		recorder.current = ""
+	}
+}
+
+func recorderFrom(ctx context.Context) *stageRecorder {
+	recorder, _ := ctx.Value(stageRecorderKey{}).(*stageRecorder)
+	return recorder
+}
+
+// recordCacheHit records whether the named cache had the entry the request
+// looked up.
+func recordCacheHit(ctx context.Context, cache string, hit bool) {
+	if recorder := recorderFrom(ctx); recorder != nil {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		if recorder.cacheHits == nil {
+			recorder.cacheHits = map[string]bool{}
+		}
+		recorder.cacheHits[cache] = hit
+	}
+}
+
+// recordRemote records the remote cluster the request was served from.
+func recordRemote(ctx context.Context, server, authProvider string) {
+	if recorder := recorderFrom(ctx); recorder != nil {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		recorder.server = server
+		recorder.authProvider = authProvider
+	}
+}
+
+// debugInfo returns the diagnostics of a completed request.
+func (recorder *stageRecorder) debugInfo(clusterName string, namespaceCount int) *v1alpha1.DebugInfo {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	return &v1alpha1.DebugInfo{
+		TotalMillis:    time.Since(recorder.start).Milliseconds(),
+		Stages:         append([]v1alpha1.StageTiming(nil), recorder.stages...),
+		ClusterName:    clusterName,
+		Server:         recorder.server,
+		AuthProvider:   recorder.authProvider,
+		CacheHits:      recorder.cacheHits,
+		NamespaceCount: namespaceCount,
+	}
+}
+
+// timeoutDetails describes how far the request got before timing out.
+func (recorder *stageRecorder) timeoutDetails(timeoutSeconds int) *v1alpha1.TimeoutDetails {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	return &v1alpha1.TimeoutDetails{
+		TimeoutSeconds:  timeoutSeconds,
+		ElapsedMillis:   time.Since(recorder.start).Milliseconds(),
+		Stage:           recorder.current,
+		CompletedStages: append([]v1alpha1.StageTiming(nil), recorder.stages...),
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+)
+
+const streamBufferSize = 32 * 1024
+
+// streamGenerateResponse writes the response in the requested version of the
+// API, encoding one parameter set at a time instead of encoding the whole
+// response in memory first. Streamed responses have no ETag, as it would
+// require encoding the response before writing it.
+func streamGenerateResponse(ctx echo.Context, response *v1alpha2.GenerateResponse) error {
+	isV1alpha2 := requestedAPIVersion(ctx) == v1alpha2.Version
+
+	ctx.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	ctx.Response().WriteHeader(http.StatusOK)
+
+	writer := bufio.NewWriterSize(ctx.Response(), streamBufferSize)
+	encoder := json.NewEncoder(writer)
+
+	if _, err := writer.WriteString(`{"output":{"parameters":[`); err != nil {
+		return err
+	}
+	for i, parameters := range response.Output.Parameters {
+		if i > 0 {
+			if err := writer.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		var err error
+		if isV1alpha2 {
+			err = encoder.Encode(parameters)
+		} else {
+			err = encoder.Encode(v1alpha1.OutParameters{Namespace: parameters.Namespace})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := writer.WriteString("]}"); err != nil {
+		return err
+	}
+
+	if err := writeJSONField(writer, encoder, "debug", response.Debug, response.Debug != nil); err != nil {
+		return err
+	}
+	if err := writeJSONField(writer, encoder, "stale", response.Stale, isV1alpha2 && response.Stale != nil); err != nil {
+		return err
+	}
+	if err := writeJSONField(writer, encoder, "truncated", response.Truncated, isV1alpha2 && response.Truncated); err != nil {
+		return err
+	}
+	if err := writeJSONField(writer, encoder, "total", response.Total, isV1alpha2 && response.Truncated); err != nil {
+		return err
+	}
+
+	if err := writer.WriteByte('}'); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func writeJSONField(writer *bufio.Writer, encoder *json.Encoder, name string, value any, include bool) error {
+	if !include {
+		return nil
+	}
+	if _, err := writer.WriteString(`,"` + name + `":`); err != nil {
+		return err
+	}
+	return encoder.Encode(value)
+}
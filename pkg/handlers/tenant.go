@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/tenant"
+)
+
+const tenantScopeKey = "tenantScope"
+
+// SetTenantScope restricts the namespaces returned for the request to the
+// tenants of its caller. It's called by the authentication middleware once
+// the caller's token is verified.
+func SetTenantScope(ctx echo.Context, scope *tenant.Scope) {
+	ctx.Set(tenantScopeKey, scope)
+}
+
+// tenantScope returns the tenant scope of the request, or nil if the caller
+// isn't scoped to tenants.
+func tenantScope(ctx echo.Context) *tenant.Scope {
+	scope, _ := ctx.Get(tenantScopeKey).(*tenant.Scope)
+	return scope
+}
+
+// scopeSelector adds the tenant scope of the request to the selector, so
+// callers only see the namespaces of their tenants. As the scope is part of
+// the selector, it's also part of the response cache key.
+func scopeSelector(ctx echo.Context, selector labels.Selector) (labels.Selector, error) {
+	scope := tenantScope(ctx)
+	if scope == nil {
+		return selector, nil
+	}
+	requirement, err := labels.NewRequirement(scope.Label, selection.In, scope.Tenants)
+	if err != nil {
+		return nil, err
+	}
+	return selector.Add(*requirement), nil
+}
+
+// inTenantScope reports whether the namespace belongs to one of the tenants
+// of the request.
+func inTenantScope(ctx echo.Context, namespace *metav1.PartialObjectMetadata) bool {
+	scope := tenantScope(ctx)
+	return scope == nil || slices.Contains(scope.Tenants, namespace.Labels[scope.Label])
+}
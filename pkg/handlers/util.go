@@ -1,14 +1,73 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
 )
 
-func decodeJson(input io.ReadCloser, v any) error {
+// AllowUnknownFields makes the handlers accept the requests with fields they
+// don't know, such as the ones added by newer ArgoCD releases, instead of
+// rejecting them. It's set from the configuration on startup.
+var AllowUnknownFields = false
+
+// MaxBodyBytes bounds the size of the request bodies decoded by the handlers.
+// It's set from the configuration on startup.
+var MaxBodyBytes int64 = 1 << 20
+
+// unknownFieldPrefix starts the errors of encoding/json for unknown fields.
+const unknownFieldPrefix = "json: unknown field "
+
+// decodeJson decodes the body of the request, rejecting the unknown fields
+// unless AllowUnknownFields is set, in which case the first one is logged.
+// Bodies larger than MaxBodyBytes fail with a *http.MaxBytesError.
+func decodeJson(ctx echo.Context, v any) error {
 	// Can't use Echo's Bind method since it allows UnknownFields
+	input := http.MaxBytesReader(ctx.Response(), ctx.Request().Body, MaxBodyBytes)
 	defer input.Close()
-	decoder := json.NewDecoder(input)
+	body, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
 	decoder.DisallowUnknownFields()
-	return decoder.Decode(v)
+	err = decoder.Decode(v)
+	if err == nil || !AllowUnknownFields || !strings.HasPrefix(err.Error(), unknownFieldPrefix) {
+		return err
+	}
+	loggerFrom(ctx).Warn("Ignoring unknown request fields", "field", strings.TrimPrefix(err.Error(), unknownFieldPrefix))
+	metrics.UnknownFields.WithLabelValues(ctx.Path()).Inc()
+	// The strict decoding may have set some of the fields before failing.
+	reflect.ValueOf(v).Elem().SetZero()
+	return json.NewDecoder(bytes.NewReader(body)).Decode(v)
+}
+
+// requestContext overrides the request of an echo context, so a derived
+// context.Context can be passed down without mutating the shared context.
+type requestContext struct {
+	echo.Context
+	request *http.Request
+}
+
+func (ctx *requestContext) Request() *http.Request {
+	return ctx.request
+}
+
+func withRequestContext(ctx echo.Context, reqCtx context.Context) echo.Context {
+	return &requestContext{Context: ctx, request: ctx.Request().WithContext(reqCtx)}
+}
+
+// NewBackgroundContext returns an echo context for work done outside of a
+// request, such as warming up caches. It has no response to write to.
+func NewBackgroundContext(e *echo.Echo, ctx context.Context) echo.Context {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	return e.NewContext(req, nil)
 }
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+)
+
+const (
+	apiVersionKey = "apiVersion"
+	// MediaTypeV1alpha2 selects v1alpha2 when sent in the Accept header.
+	MediaTypeV1alpha2 = v1alpha2.MediaType
+)
+
+// APIVersion returns a middleware serving the requests of a route group with
+// the given version of the API, regardless of the Accept header.
+func APIVersion(version string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set(apiVersionKey, version)
+			return next(ctx)
+		}
+	}
+}
+
+// requestedAPIVersion returns the version of the API the client asked for,
+// defaulting to v1alpha1 which is what ArgoCD sends.
+func requestedAPIVersion(ctx echo.Context) string {
+	if version, ok := ctx.Get(apiVersionKey).(string); ok {
+		return version
+	}
+	if strings.Contains(ctx.Request().Header.Get(echo.HeaderAccept), MediaTypeV1alpha2) {
+		return v1alpha2.Version
+	}
+	return "v1alpha1"
+}
+
+// decodeGenerateRequest decodes a request of the requested version of the API
+// and converts it to v1alpha2, referring to its cluster by its clusterRef.
+func decodeGenerateRequest(ctx echo.Context) (*v1alpha2.GenerateRequest, error) {
+	req := &v1alpha2.GenerateRequest{}
+	if requestedAPIVersion(ctx) == v1alpha2.Version {
+		if err := decodeJson(ctx, req); err != nil {
+			return nil, err
+		}
+	} else {
+		v1alpha1Req := &v1alpha1.GenerateRequest{}
+		if err := decodeJson(ctx, v1alpha1Req); err != nil {
+			return nil, err
+		}
+		req = v1alpha2.ConvertRequestFromV1alpha1(v1alpha1Req)
+	}
+	if err := resolveClusterRef(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// generateResponseFor converts the response to the requested version of the API.
+func generateResponseFor(ctx echo.Context, response *v1alpha2.GenerateResponse) any {
+	if requestedAPIVersion(ctx) == v1alpha2.Version {
+		return response
+	}
+	return v1alpha2.ConvertResponseToV1alpha1(response)
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/audit"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+)
+
+var (
+	visibilityEnforced atomic.Bool
+	// visibilityPolicies is nil until the policies are first loaded.
+	visibilityPolicies atomic.Pointer[[]generatorv1alpha1.NamespaceVisibilityPolicy]
+)
+
+// EnforceVisibilityPolicies makes the requests subject to the
+// NamespaceVisibilityPolicies, or not. Requests are denied until the policies
+// are set, so they aren't served unrestricted on startup.
+func EnforceVisibilityPolicies(enforce bool) {
+	visibilityEnforced.Store(enforce)
+	if !enforce {
+		visibilityPolicies.Store(nil)
+	}
+}
+
+// SetVisibilityPolicies replaces the NamespaceVisibilityPolicies applied to
+// the next requests.
+func SetVisibilityPolicies(policies []generatorv1alpha1.NamespaceVisibilityPolicy) {
+	visibilityPolicies.Store(&policies)
+}
+
+// checkVisibility returns ErrVisibilityDenied unless the request is allowed by
+// one of the NamespaceVisibilityPolicies of its consumer. Requests without a
+// policy are denied, as the consumers are matched on fields a caller could
+// change to leave the policies meant for it.
+func checkVisibility(ctx echo.Context, applicationSet, clusterName string, selector labels.Selector) error {
+	if !visibilityEnforced.Load() {
+		return nil
+	}
+	policies := visibilityPolicies.Load()
+	if policies == nil {
+		return generrors.Wrap(generrors.ErrVisibilityDenied, errors.New("the NamespaceVisibilityPolicies aren't loaded yet"))
+	}
+
+	if clusterName == "" {
+		clusterName = audit.LocalCluster
+	}
+	var matched []string
+	for _, policy := range *policies {
+		if !consumerMatches(ctx, policy.Spec.Consumers, applicationSet) {
+			continue
+		}
+		if clusterVisible(policy.Spec, clusterName) && labelDomainsVisible(policy.Spec, selector) {
+			return nil
+		}
+		matched = append(matched, policy.Name)
+	}
+	if len(matched) == 0 {
+		return generrors.Wrap(generrors.ErrVisibilityDenied, fmt.Errorf("no NamespaceVisibilityPolicy allows %s to query cluster %s", consumerName(ctx, applicationSet), clusterName))
+	}
+	return generrors.Wrap(generrors.ErrVisibilityDenied, fmt.Errorf("the NamespaceVisibilityPolicies %s don't allow querying cluster %s with selector %q", strings.Join(matched, ", "), clusterName, selector))
+}
+
+// namespaceVisible reports whether the namespace is visible to the consumer of
+// the request on the cluster: one of its policies allowing the cluster has no
+// label domains, or the namespace has a label in one of them.
+func namespaceVisible(ctx echo.Context, applicationSet, clusterName string, namespace *metav1.PartialObjectMetadata) bool {
+	if !visibilityEnforced.Load() {
+		return true
+	}
+	policies := visibilityPolicies.Load()
+	if policies == nil {
+		return false
+	}
+
+	if clusterName == "" {
+		clusterName = audit.LocalCluster
+	}
+	for _, policy := range *policies {
+		if !consumerMatches(ctx, policy.Spec.Consumers, applicationSet) || !clusterVisible(policy.Spec, clusterName) {
+			continue
+		}
+		if len(policy.Spec.LabelDomains) == 0 {
+			return true
+		}
+		for key := range namespace.Labels {
+			if labelDomainAllowed(policy.Spec.LabelDomains, labelDomain(key)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// consumerMatches reports whether the request is one of the consumers. The
+// caller is authenticated, while the ApplicationSet is sent by the caller and
+// only tells apart the requests of a caller.
+func consumerMatches(ctx echo.Context, consumers []generatorv1alpha1.Consumer, applicationSet string) bool {
+	caller := requestCaller(ctx)
+	for _, consumer := range consumers {
+		if (consumer.Caller == "" || consumer.Caller == caller) &&
+			(consumer.ApplicationSet == "" || consumer.ApplicationSet == applicationSet) &&
+			(consumer.Route == "" || consumer.Route == ctx.Path()) {
+			return true
+		}
+	}
+	return false
+}
+
+// consumerName describes the consumer of the request in the errors.
+func consumerName(ctx echo.Context, applicationSet string) string {
+	name := "caller " + requestCaller(ctx)
+	if applicationSet != "" {
+		name += " of ApplicationSet " + applicationSet
+	}
+	return name
+}
+
+func clusterVisible(spec generatorv1alpha1.NamespaceVisibilityPolicySpec, clusterName string) bool {
+	return len(spec.Clusters) == 0 || slices.Contains(spec.Clusters, clusterName)
+}
+
+// labelDomainsVisible reports whether all the label keys of the selector are
+// in the label domains of the policy. A selector without requirements matches
+// all the namespaces, so it's only visible without label domains.
+func labelDomainsVisible(spec generatorv1alpha1.NamespaceVisibilityPolicySpec, selector labels.Selector) bool {
+	if len(spec.LabelDomains) == 0 {
+		return true
+	}
+	requirements, _ := selector.Requirements()
+	if len(requirements) == 0 {
+		return false
+	}
+	for _, requirement := range requirements {
+		if !labelDomainAllowed(spec.LabelDomains, labelDomain(requirement.Key())) {
+			return false
+		}
+	}
+	return true
+}
+
+// labelDomain returns the domain of a label key, "" for the keys without one.
+func labelDomain(key string) string {
+	domain, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return ""
+	}
+	return domain
+}
+
+func labelDomainAllowed(allowed []string, domain string) bool {
+	for _, allowedDomain := range allowed {
+		if domain == allowedDomain || (allowedDomain != "" && strings.HasSuffix(domain, "."+allowedDomain)) {
+			return true
+		}
+	}
+	return false
+}
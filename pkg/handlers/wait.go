@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+const (
+	// maxWaitSeconds bounds waitSeconds, so the clients come back now and
+	// then, e.g. to a replica which was started since.
+	maxWaitSeconds    = 300
+	waitRetryInterval = time.Second
+)
+
+// waitForChange holds a request until its response no longer has the ETag
+// of the If-None-Match header, or of the given response without one, and
+// returns the response to send. The matching namespaces are watched, and the
+// response is generated again on every event. The given response is returned
+// when waitSeconds elapse, or when the namespaces can't be watched.
+func (paramsHandler *GetParamsHandler) waitForChange(ctx echo.Context, localClient client.Reader, req *v1alpha2.GenerateRequest, response *v1alpha2.GenerateResponse) (*v1alpha2.GenerateResponse, *echo.HTTPError) {
+	logger := loggerFrom(ctx)
+	etag, err := responseETag(ctx, response)
+	if err != nil {
+		return response, nil
+	}
+	baseline := ctx.Request().Header.Get(headerIfNoneMatch)
+	if baseline == "" {
+		baseline = etag
+	} else if !etagMatches(baseline, etag) {
+		// The client is already behind.
+		return response, nil
+	}
+
+	clusterName := req.ClusterRef
+	watchClient := paramsHandler.localWatchClient
+	// The local namespaces are listed again with the live client, as the
+	// cached one may not have seen the events yet.
+	reader := client.Reader(watchClient)
+	if clusterName != "" {
+		if localClient == nil {
+			return response, nil
+		}
+		if watchClient, _, err = paramsHandler.remoteClients.getClient(ctx, localClient, clusterName); err != nil {
+			logger.Warn("Failed to get the client for waiting for changes", logging.KeyError, err)
+			return response, nil
+		}
+		reader = localClient
+	} else if watchClient == nil {
+		return response, nil
+	}
+
+	// The selector of the request matches at least the namespaces of the
+	// response, the policy and the tenants only narrow it down.
+	selector, err := metav1.LabelSelectorAsSelector(&req.Input.Parameters.LabelSelector)
+	if err != nil {
+		return response, nil
+	}
+
+	// Waiting requests don't take the slots of the requests being served,
+	// and may be held for longer than the server write timeout.
+	releaseInFlight(ctx)
+	if err := http.NewResponseController(ctx.Response()).SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("Failed to clear the write deadline of the waiting request", logging.KeyError, err)
+	}
+
+	start := time.Now()
+	waitSeconds := min(req.Input.Parameters.WaitSeconds, maxWaitSeconds)
+	waitCtx, cancel := context.WithTimeout(ctx.Request().Context(), time.Duration(waitSeconds)*time.Second)
+	defer cancel()
+	stopOnShutdown := context.AfterFunc(paramsHandler.shutdownCtx, cancel)
+	defer stopOnShutdown()
+
+	for {
+		resourceVersion, err := latestResourceVersion(waitCtx, watchClient, selector)
+		if err == nil {
+			// The namespaces may have changed before the watch starts, or
+			// the response may have been cached.
+			current, httpErr := paramsHandler.regenerate(ctx, reader, req)
+			if httpErr != nil {
+				return nil, httpErr
+			}
+			if currentETag, err := responseETag(ctx, current); err == nil && !etagMatches(baseline, currentETag) {
+				logger.Debug("Response changed while waiting", "waited", time.Since(start).String())
+				metrics.WaitedRequests.WithLabelValues("changed").Inc()
+				return current, nil
+			}
+			response = current
+			err = waitForEvent(waitCtx, watchClient, selector, resourceVersion)
+		}
+		if waitCtx.Err() != nil {
+			metrics.WaitedRequests.WithLabelValues("unchanged").Inc()
+			return response, nil
+		}
+		if err != nil {
+			logger.Warn("Failed to watch namespaces for changes", logging.KeyError, err)
+			select {
+			case <-waitCtx.Done():
+				metrics.WaitedRequests.WithLabelValues("unchanged").Inc()
+				return response, nil
+			case <-time.After(waitRetryInterval):
+			}
+		}
+	}
+}
+
+// regenerate generates the response of a waiting request again, bypassing
+// the caches. The request was already recorded.
+func (paramsHandler *GetParamsHandler) regenerate(ctx echo.Context, reader client.Reader, req *v1alpha2.GenerateRequest) (*v1alpha2.GenerateResponse, *echo.HTTPError) {
+	response, httpErr := generateNamespaces(ctx, reader, paramsHandler.remoteClients, nil, nil, req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := checkNamespaceQuota(ctx, req, response); httpErr != nil {
+		return nil, httpErr
+	}
+	return response, nil
+}
+
+// responseETag returns the ETag the response is sent with.
+func responseETag(ctx echo.Context, response *v1alpha2.GenerateResponse) (string, error) {
+	body, err := json.Marshal(generateResponseFor(ctx, response))
+	if err != nil {
+		return "", err
+	}
+	return computeETag(body), nil
+}
+
+// latestResourceVersion returns the resource version to watch the matching
+// namespaces from, without listing all of them.
+func latestResourceVersion(ctx context.Context, cl client.WithWatch, selector labels.Selector) (string, error) {
+	nsList := generator.NewNamespaceList()
+	if err := cl.List(ctx, nsList, client.MatchingLabelsSelector{Selector: selector}, client.Limit(1)); err != nil {
+		return "", err
+	}
+	return nsList.ResourceVersion, nil
+}
+
+// waitForEvent returns when a matching namespace changes, or when the watch
+// is closed. A nil error means the response should be generated again.
+func waitForEvent(ctx context.Context, cl client.WithWatch, selector labels.Selector, resourceVersion string) error {
+	watcher, err := cl.Watch(ctx, generator.NewNamespaceList(), &client.ListOptions{
+		LabelSelector: selector,
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type != watch.Bookmark {
+				// Errors mostly mean the resource version is too old, which
+				// is handled by starting over too.
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// WarmUp builds the clients of all the clusters with an ArgoCD cluster secret,
+// in all the ArgoCD instances, and lists a namespace on each of them, so the
+// first requests after a deployment don't pay for minting tokens and opening
+// connections. At most concurrency clusters are warmed up at the same time.
+func (cache *RemoteClientCache) WarmUp(ctx echo.Context, localClient client.Reader, concurrency int) {
+	refs, err := listClusterRefs(ctx, localClient)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to warm up remote clients", logging.KeyError, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	semaphore := make(chan struct{}, max(concurrency, 1))
+
+	for _, ref := range refs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(secretName string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			remoteClient, _, err := cache.getClient(ctx, localClient, secretName)
+			if err == nil {
+				err = remoteClient.List(ctx.Request().Context(), generator.NewNamespaceList(), client.Limit(1))
+				cache.recordResult(secretName, err)
+			}
+			if err != nil {
+				loggerFrom(ctx).Warn("Failed to warm up remote client", logging.KeyCluster, secretName, logging.KeyError, err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(ref)
+	}
+	wg.Wait()
+
+	loggerFrom(ctx).Info("Warmed up remote clients", "count", len(refs)-failed, "failed", failed)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+)
+
+// initialEventsEndAnnotation marks the bookmark sent once a streaming list
+// sent all the objects.
+const initialEventsEndAnnotation = "k8s.io/initial-events-end"
+
+// minWatchListVersion is the first Kubernetes version that supports streaming
+// lists. Older API servers ignore sendInitialEvents and never send the end
+// bookmark.
+var minWatchListVersion = version.MajorMinor(1, 27)
+
+var errWatchListClosed = errors.New("the streaming list was closed before all the namespaces were sent")
+
+// serverSupportsWatchList reports whether the API server is recent enough to
+// support streaming lists. The WatchList feature gate may still be disabled,
+// which is detected when listing.
+func serverSupportsWatchList(discoveryClient discovery.ServerVersionInterface) (bool, error) {
+	info, err := discoveryClient.ServerVersion()
+	if err != nil {
+		return false, err
+	}
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return false, err
+	}
+	return serverVersion.AtLeast(minWatchListVersion), nil
+}
+
+// streamNamespaces lists the namespaces matching the selector with a
+// streaming list (WatchList), which spares the API server from building the
+// whole list in memory.
+func streamNamespaces(ctx context.Context, cl client.WithWatch, nsList *metav1.PartialObjectMetadataList, selector labels.Selector) error {
+	watcher, err := cl.Watch(ctx, generator.NewNamespaceList(), &client.ListOptions{
+		LabelSelector: selector,
+		Raw: &metav1.ListOptions{
+			SendInitialEvents:    ptr.To(true),
+			ResourceVersionMatch: metav1.ResourceVersionMatchNotOlderThan,
+			AllowWatchBookmarks:  true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	nsList.Items = nil
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return errWatchListClosed
+			}
+			switch event.Type {
+			case watch.Error:
+				return apierrors.FromObject(event.Object)
+			case watch.Added:
+				if namespace, ok := event.Object.(*metav1.PartialObjectMetadata); ok {
+					nsList.Items = append(nsList.Items, *namespace)
+				}
+			case watch.Bookmark:
+				bookmark, ok := event.Object.(*metav1.PartialObjectMetadata)
+				if ok && bookmark.Annotations[initialEventsEndAnnotation] == "true" {
+					nsList.ResourceVersion = bookmark.ResourceVersion
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// isWatchListRejected reports whether the API server rejected a streaming
+// list, which happens when the WatchList feature gate is disabled.
+func isWatchListRejected(err error) bool {
+	return apierrors.IsInvalid(err) || apierrors.IsBadRequest(err)
+}
@@ -0,0 +1,115 @@
+// Package leader runs the background tasks which must only run on one of the
+// replicas, such as the ones calling the API servers on behalf of all the
+// replicas.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+// Task is a background task run while the replica is the leader. Its context
+// is canceled when the leadership is lost.
+type Task func(ctx context.Context)
+
+// Config configures the lease the replicas compete for.
+type Config struct {
+	LeaseName      string
+	LeaseNamespace string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+// Elector runs its tasks while the replica holds the lease. A replica losing
+// the lease stops the tasks and competes for it again.
+type Elector struct {
+	config   Config
+	lock     resourcelock.Interface
+	identity string
+	logger   *slog.Logger
+}
+
+// NewElector returns an elector competing for the lease with the given
+// clientset. The replica is identified by its hostname, which is the name of
+// its pod.
+func NewElector(clientset kubernetes.Interface, config Config, logger *slog.Logger) (*Elector, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	// The hostname is made unique, in case a replica is restarted before its
+	// lease expired.
+	identity := fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: config.LeaseNamespace, Name: config.LeaseName},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	return &Elector{config: config, lock: lock, identity: identity, logger: logger}, nil
+}
+
+// Run competes for the lease and runs the tasks while holding it, until the
+// context is done. The lease is released when the context is done, so
+// another replica takes over without waiting for it to expire.
+func (elector *Elector) Run(ctx context.Context, tasks ...Task) {
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            elector.lock,
+			LeaseDuration:   elector.config.LeaseDuration,
+			RenewDeadline:   elector.config.RenewDeadline,
+			RetryPeriod:     elector.config.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            elector.config.LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					elector.logger.Info("Started leading, running the leader tasks", "identity", elector.identity)
+					metrics.Leader.Set(1)
+					runTasks(leaderCtx, tasks)
+				},
+				OnStoppedLeading: func() {
+					metrics.Leader.Set(0)
+					elector.logger.Info("Stopped leading", "identity", elector.identity)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != elector.identity {
+						elector.logger.Info("Another replica is leading", "leader", identity)
+					}
+				},
+			},
+		})
+	}
+}
+
+// RunWithoutElection runs the tasks until the context is done, for
+// deployments running a single replica.
+func RunWithoutElection(ctx context.Context, tasks ...Task) {
+	runTasks(ctx, tasks)
+}
+
+// runTasks runs the tasks and waits for them to return.
+func runTasks(ctx context.Context, tasks []Task) {
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task Task) {
+			defer wg.Done()
+			task(ctx)
+		}(task)
+	}
+	wg.Wait()
+}
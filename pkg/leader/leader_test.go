@@ -0,0 +1,115 @@
+package leader_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+
+	"github.com/konflux-ci/namespace-generator/pkg/leader"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+func TestLeader(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Leader Suite")
+}
+
+var _ = Describe("Elector", func() {
+	It("should run the tasks only while holding the lease", func(ctx SpecContext) {
+		lease := &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "namespace-generator", Namespace: "ns-gen"},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To("other-replica"),
+				LeaseDurationSeconds: ptr.To[int32](3600),
+				AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+				RenewTime:            &metav1.MicroTime{Time: time.Now()},
+			},
+		}
+		clientset := fake.NewSimpleClientset(lease)
+		// The reactors can't be added while the clientset is used.
+		var unavailable atomic.Bool
+		clientset.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+			if unavailable.Load() {
+				return true, nil, errors.New("the API server is unavailable")
+			}
+			return false, nil, nil
+		})
+		elector, err := leader.NewElector(clientset, leader.Config{
+			LeaseName:      "namespace-generator",
+			LeaseNamespace: "ns-gen",
+			LeaseDuration:  time.Second,
+			RenewDeadline:  500 * time.Millisecond,
+			RetryPeriod:    100 * time.Millisecond,
+		}, logging.New(GinkgoWriter, slog.LevelInfo))
+		Expect(err).NotTo(HaveOccurred())
+
+		started := make(chan struct{}, 2)
+		stopped := make(chan struct{}, 2)
+		task := func(ctx context.Context) {
+			started <- struct{}{}
+			<-ctx.Done()
+			stopped <- struct{}{}
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			elector.Run(runCtx, task)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+
+		// The tasks wait while another replica holds the lease.
+		Consistently(started, time.Second).ShouldNot(Receive())
+		Expect(testutil.ToFloat64(metrics.Leader)).To(BeZero())
+
+		lease.Spec.HolderIdentity = ptr.To("")
+		_, err = clientset.CoordinationV1().Leases("ns-gen").Update(ctx, lease, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(started, 5*time.Second).Should(Receive())
+		Expect(testutil.ToFloat64(metrics.Leader)).To(Equal(1.0))
+
+		// The lease can't be renewed anymore, so the tasks stop.
+		unavailable.Store(true)
+		Eventually(stopped, 5*time.Second).Should(Receive())
+		Expect(testutil.ToFloat64(metrics.Leader)).To(BeZero())
+		Consistently(started, time.Second).ShouldNot(Receive())
+	})
+
+	It("should run the tasks until the context is done without election", func(ctx SpecContext) {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		var ran [2]bool
+		go func() {
+			defer close(done)
+			leader.RunWithoutElection(runCtx, func(ctx context.Context) {
+				ran[0] = true
+				<-ctx.Done()
+			}, func(ctx context.Context) {
+				ran[1] = true
+				<-ctx.Done()
+			})
+		}()
+		Consistently(done, 100*time.Millisecond).ShouldNot(BeClosed())
+		cancel()
+		Eventually(done).Should(BeClosed())
+		Expect(ran).To(Equal([2]bool{true, true}))
+	})
+})
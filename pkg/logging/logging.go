@@ -0,0 +1,43 @@
+// Package logging sets up the structured logger of the generator and carries
+// request scoped loggers in contexts.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// Keys of the fields shared by the log lines of a request.
+const (
+	KeyRequestID = "request_id"
+	KeyAppSet    = "appset"
+	// KeyAppSetNamespace is the namespace of the ApplicationSet, when the
+	// request identifies it.
+	KeyAppSetNamespace = "appset_namespace"
+	KeyCluster         = "cluster"
+	KeySelector        = "selector"
+	KeyError           = "error"
+)
+
+type loggerKey struct{}
+
+// New returns a logger writing JSON lines at the given level or above.
+// Credentials are redacted from the lines whatever their level.
+func New(output io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(NewRedactingHandler(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: level})))
+}
+
+// WithLogger returns a context carrying the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or the default
+// logger if there's none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
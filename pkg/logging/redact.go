@@ -0,0 +1,146 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"k8s.io/client-go/rest"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Redacted replaces the sensitive values in the log lines.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are the keys of the attributes whose values are never logged.
+var sensitiveKeys = map[string]struct{}{
+	"token":         {},
+	"access_token":  {},
+	"refresh_token": {},
+	"id_token":      {},
+	"bearer_token":  {},
+	"bearertoken":   {},
+	"authorization": {},
+	"password":      {},
+	"ca_data":       {},
+	"cadata":        {},
+	"cert_data":     {},
+	"key_data":      {},
+	"client_secret": {},
+}
+
+// sensitivePatterns match the credentials found in strings, such as error
+// messages, along with their replacements.
+var sensitivePatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?s)-----BEGIN [A-Z ]+-----.*?-----END [A-Z ]+-----`), Redacted},
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), "${1}" + Redacted},
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted},
+	{regexp.MustCompile(`ya29\.[A-Za-z0-9_\-.]+`), Redacted},
+	{regexp.MustCompile(`(?i)("?(?:access_token|refresh_token|id_token|bearerToken|caData|certData|keyData|password|client_secret)"?\s*[:=]\s*"?)[^\s",}]+`), "${1}" + Redacted},
+}
+
+// RedactString replaces the credentials found in the string.
+func RedactString(value string) string {
+	for _, sensitive := range sensitivePatterns {
+		value = sensitive.pattern.ReplaceAllString(value, sensitive.replacement)
+	}
+	return value
+}
+
+// redactingHandler redacts the credentials in the records before passing
+// them to the next handler, whatever their level.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler returns a handler removing tokens, CA data and the
+// content of secrets from the records passed to the next handler.
+func NewRedactingHandler(next slog.Handler) slog.Handler {
+	return &redactingHandler{next: next}
+}
+
+func (handler *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return handler.next.Enabled(ctx, level)
+}
+
+func (handler *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, RedactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return handler.next.Handle(ctx, redacted)
+}
+
+func (handler *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redacted = append(redacted, redactAttr(attr))
+	}
+	return &redactingHandler{next: handler.next.WithAttrs(redacted)}
+}
+
+func (handler *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: handler.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	if _, ok := sensitiveKeys[strings.ToLower(attr.Key)]; ok {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, RedactString(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, groupAttr := range group {
+			redacted = append(redacted, redactAttr(groupAttr))
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		return slog.Any(attr.Key, redactAny(value.Any()))
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// redactAny replaces the values which may hold credentials. Secrets and
+// client configs are reduced to what identifies them, raw bytes are dropped
+// and errors and stringers are logged as redacted strings.
+func redactAny(value any) any {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case *corev1.Secret:
+		if value == nil {
+			return nil
+		}
+		return secretSummary(value)
+	case corev1.Secret:
+		return secretSummary(&value)
+	case *rest.Config:
+		if value == nil {
+			return nil
+		}
+		return map[string]string{"host": value.Host}
+	case error:
+		return RedactString(value.Error())
+	case fmt.Stringer:
+		return RedactString(value.String())
+	case []byte:
+		return Redacted
+	}
+	return value
+}
+
+func secretSummary(secret *corev1.Secret) map[string]string {
+	return map[string]string{"namespace": secret.Namespace, "name": secret.Name, "data": Redacted}
+}
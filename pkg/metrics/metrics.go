@@ -0,0 +1,238 @@
+// Package metrics holds the Prometheus metrics of the generator.
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const namespace = "namespace_generator"
+
+// Results of an operation reported in metrics.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	ResultTimeout = "timeout"
+	ResultDropped = "dropped"
+)
+
+var (
+	// Registry holds the metrics served by Handler.
+	Registry = prometheus.NewRegistry()
+
+	// StageDuration observes the stages of generate requests, such as getting
+	// the cluster secret, minting a token or listing namespaces.
+	StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of the stages of generate requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"stage", "result"})
+
+	// GenerateDuration observes the generate requests, from decoding the
+	// request to writing the response, by result.
+	GenerateDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "generate_duration_seconds",
+		Help:      "Duration of generate requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"result"})
+
+	// Panics counts the panics recovered while serving requests, by route.
+	Panics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panics_total",
+		Help:      "Number of panics recovered while serving requests.",
+	}, []string{"route"})
+
+	// RemoteRetries counts the calls to remote clusters retried after a
+	// transient error.
+	RemoteRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "remote_retries_total",
+		Help:      "Number of calls to remote clusters retried after a transient error.",
+	}, []string{"operation"})
+
+	// SharedCacheErrors counts the failed calls to the shared cache store.
+	// The generator falls back to its local caches when they fail.
+	SharedCacheErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shared_cache_errors_total",
+		Help:      "Number of failed calls to the shared cache store.",
+	}, []string{"cache"})
+
+	// TokenRefreshes counts the background refreshes of the cached token.
+	TokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Number of background refreshes of the cached token.",
+	}, []string{"result"})
+
+	// InFlightRequests is the number of generate requests being served.
+	InFlightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "in_flight_requests",
+		Help:      "Number of generate requests being served.",
+	})
+
+	// InFlightRejected counts the generate requests rejected because too
+	// many requests were in flight.
+	InFlightRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "in_flight_rejected_total",
+		Help:      "Number of generate requests rejected because too many requests were in flight.",
+	})
+
+	// GenerateErrors counts the failed generate requests by the code of
+	// their error, e.g. SecretNotFound or ClusterUnreachable.
+	GenerateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generate_errors_total",
+		Help:      "Number of failed generate requests by error code.",
+	}, []string{"code"})
+
+	// TruncatedResponses counts the generate responses truncated to the
+	// maximum number of results.
+	TruncatedResponses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "truncated_responses_total",
+		Help:      "Number of generate responses truncated to the maximum number of results.",
+	})
+
+	// UnknownFields counts the requests whose unknown fields were ignored,
+	// by route.
+	UnknownFields = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unknown_request_fields_total",
+		Help:      "Number of requests whose unknown fields were ignored.",
+	}, []string{"route"})
+
+	// ApplicationSetRequests counts the generate requests by the
+	// ApplicationSet they were made for and their result. The namespace is
+	// only known when the request identifies the ApplicationSet with headers.
+	ApplicationSetRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "applicationset_requests_total",
+		Help:      "Number of generate requests by ApplicationSet and result.",
+	}, []string{"applicationset_namespace", "applicationset", "result"})
+
+	// ClusterHealthy is 1 when the last probe of the cluster succeeded.
+	ClusterHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_healthy",
+		Help:      "Whether the last probe of the remote cluster succeeded.",
+	}, []string{"cluster"})
+
+	// ClusterProbeLatency is the duration of the last probe of the cluster.
+	ClusterProbeLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_probe_latency_seconds",
+		Help:      "Duration of the last probe of the remote cluster.",
+	}, []string{"cluster"})
+
+	// Leader is 1 while the replica runs the leader tasks.
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether the replica is the leader running the leader tasks.",
+	})
+
+	// AuditEvents counts the audit events by whether they were written to
+	// the sink, failed or were dropped.
+	AuditEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_events_total",
+		Help:      "Number of audit events written, failed or dropped.",
+	}, []string{"result"})
+
+	// InjectedFaults counts the faults injected in the requests by cluster
+	// and fault, when fault injection is enabled.
+	InjectedFaults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injected_faults_total",
+		Help:      "Number of faults injected in the requests.",
+	}, []string{"cluster", "fault"})
+
+	// ApplicationSetRefreshes counts the ApplicationSets refreshed after
+	// their namespaces changed, by result.
+	ApplicationSetRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "applicationset_refreshes_total",
+		Help:      "Number of ApplicationSets refreshed after their namespaces changed.",
+	}, []string{"result"})
+
+	// WaitedRequests counts the requests held until their response changed,
+	// by whether it changed before the wait ended.
+	WaitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "waited_requests_total",
+		Help:      "Number of requests held until their response changed.",
+	}, []string{"result"})
+
+	// NamespaceClaimReconciles counts the NamespaceClaims provisioned, by
+	// result.
+	NamespaceClaimReconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "namespace_claim_reconciles_total",
+		Help:      "Number of times the NamespaceClaims were provisioned.",
+	}, []string{"result"})
+	// ExpiredNamespaces counts the namespaces expired after their TTL, by
+	// action, labeled or deleted.
+	ExpiredNamespaces = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expired_namespaces_total",
+		Help:      "Number of namespaces expired after their TTL.",
+	}, []string{"action"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		StageDuration,
+		GenerateDuration,
+		Panics,
+		RemoteRetries,
+		SharedCacheErrors,
+		InFlightRequests,
+		InFlightRejected,
+		GenerateErrors,
+		TruncatedResponses,
+		UnknownFields,
+		ApplicationSetRequests,
+		ClusterHealthy,
+		ClusterProbeLatency,
+		TokenRefreshes,
+		AuditEvents,
+		InjectedFaults,
+		ApplicationSetRefreshes,
+		WaitedRequests,
+		NamespaceClaimReconciles,
+		ExpiredNamespaces,
+		Leader,
+	)
+}
+
+// Handler serves the metrics of Registry. The exemplars are only served to
+// the scrapers negotiating the OpenMetrics format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// ObserveWithTrace observes a duration with the trace ID of the span of the
+// context as an exemplar, so a slow bucket links to a trace of it. Contexts
+// without a sampled span, e.g. when tracing is disabled, are observed
+// without exemplars.
+func ObserveWithTrace(ctx context.Context, observer prometheus.Observer, seconds float64) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+		exemplarObserver.ObserveWithExemplar(seconds, prometheus.Labels{"trace_id": spanContext.TraceID().String()})
+		return
+	}
+	observer.Observe(seconds)
+}
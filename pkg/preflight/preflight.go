@@ -0,0 +1,130 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// Check describes a permission the service account is expected to have.
+type Check struct {
+	Namespace string `json:"namespace,omitempty"`
+	Verb      string `json:"verb"`
+	Group     string `json:"group"`
+	Resource  string `json:"resource"`
+	// Name restricts the check to a single resource.
+	Name string `json:"name,omitempty"`
+}
+
+func (c Check) String() string {
+	resource := c.Resource
+	if c.Group != "" {
+		resource = fmt.Sprintf("%s.%s", c.Resource, c.Group)
+	}
+	if c.Name != "" {
+		resource = fmt.Sprintf("%s/%s", resource, c.Name)
+	}
+	if c.Namespace == "" {
+		return fmt.Sprintf("%s %s", c.Verb, resource)
+	}
+	return fmt.Sprintf("%s %s in namespace %s", c.Verb, resource, c.Namespace)
+}
+
+type Result struct {
+	Check
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Status struct {
+	// Completed is false until the checks ran at least once.
+	Completed bool      `json:"completed"`
+	Degraded  bool      `json:"degraded"`
+	CheckedAt time.Time `json:"checkedAt,omitempty"`
+	Results   []Result  `json:"results,omitempty"`
+}
+
+// DefaultChecks returns the permissions required for serving requests. When
+// the cluster secret names are set, only the permission to get each of them
+// is required. The permission to create Events is only required when the
+// Events are emitted.
+func DefaultChecks(argoCDNamespace string, clusterSecretNames []string, events bool) []Check {
+	checks := []Check{
+		{Verb: "list", Resource: "namespaces"},
+		{Verb: "watch", Resource: "namespaces"},
+	}
+	if len(clusterSecretNames) == 0 {
+		checks = append(checks, Check{Namespace: argoCDNamespace, Verb: "get", Resource: "secrets"})
+	}
+	for _, name := range clusterSecretNames {
+		checks = append(checks, Check{Namespace: argoCDNamespace, Verb: "get", Resource: "secrets", Name: name})
+	}
+	if events {
+		checks = append(checks, Check{Namespace: argoCDNamespace, Verb: "create", Resource: "events"})
+	}
+	return checks
+}
+
+// Checker verifies the permissions of the service account using
+// SelfSubjectAccessReviews and keeps the status of the last run.
+type Checker struct {
+	client client.Client
+	checks []Check
+
+	mu     sync.RWMutex
+	status Status
+}
+
+func NewChecker(cl client.Client, checks []Check) *Checker {
+	return &Checker{client: cl, checks: checks}
+}
+
+// Run executes all the checks and returns the resulting status.
+// A check that can't be evaluated is considered as failed.
+func (checker *Checker) Run(ctx context.Context) Status {
+	status := Status{Completed: true, CheckedAt: time.Now()}
+
+	for _, check := range checker.checks {
+		result := Result{Check: check}
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: check.Namespace,
+					Verb:      check.Verb,
+					Group:     check.Group,
+					Resource:  check.Resource,
+					Name:      check.Name,
+				},
+			},
+		}
+		if err := checker.client.Create(ctx, review); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Allowed = review.Status.Allowed
+			result.Reason = review.Status.Reason
+		}
+		if !result.Allowed {
+			status.Degraded = true
+		}
+		status.Results = append(status.Results, result)
+	}
+
+	checker.mu.Lock()
+	checker.status = status
+	checker.mu.Unlock()
+
+	return status
+}
+
+// Status returns the status of the last run.
+func (checker *Checker) Status() Status {
+	checker.mu.RLock()
+	defer checker.mu.RUnlock()
+	return checker.status
+}
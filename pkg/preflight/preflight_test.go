@@ -0,0 +1,82 @@
+package preflight_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/preflight"
+)
+
+func TestPreflight(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Preflight Suite")
+}
+
+var _ = Describe("DefaultChecks", func() {
+	It("should only check the permission to create Events when they're emitted", func() {
+		events := preflight.Check{Namespace: "argocd", Verb: "create", Resource: "events"}
+		Expect(preflight.DefaultChecks("argocd", nil, true)).To(ContainElement(events))
+		Expect(preflight.DefaultChecks("argocd", nil, false)).NotTo(ContainElement(events))
+		Expect(preflight.DefaultChecks("argocd", []string{"remote1-secret"}, false)).To(Equal([]preflight.Check{
+			{Verb: "list", Resource: "namespaces"},
+			{Verb: "watch", Resource: "namespaces"},
+			{Namespace: "argocd", Verb: "get", Resource: "secrets", Name: "remote1-secret"},
+		}))
+	})
+})
+
+var _ = Describe("Checker", func() {
+	It("should report the denied permissions as degraded", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(authorizationv1.AddToScheme(scheme)).To(Succeed())
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+				review := obj.(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				switch {
+				case attributes.Resource == "namespaces":
+					review.Status.Allowed = true
+				// Only the allowlisted secrets can be read.
+				case attributes.Resource == "secrets":
+					review.Status.Allowed = attributes.Name == "remote1-secret"
+					if !review.Status.Allowed {
+						review.Status.Reason = "no RBAC policy matched"
+					}
+				default:
+					return errors.New("connection refused")
+				}
+				return nil
+			},
+		}).Build()
+		checker := preflight.NewChecker(cl, preflight.DefaultChecks("argocd", nil, true))
+		Expect(checker.Status().Completed).To(BeFalse())
+
+		status := checker.Run(ctx)
+		Expect(status.Completed).To(BeTrue())
+		Expect(status.Degraded).To(BeTrue())
+		Expect(checker.Status()).To(Equal(status))
+		results := map[string]preflight.Result{}
+		for _, result := range status.Results {
+			results[result.Resource+" "+result.Verb] = result
+		}
+		Expect(results["namespaces list"].Allowed).To(BeTrue())
+		Expect(results["secrets get"]).To(MatchFields(IgnoreExtras, Fields{"Allowed": BeFalse(), "Reason": Equal("no RBAC policy matched")}))
+		Expect(results["events create"]).To(MatchFields(IgnoreExtras, Fields{"Allowed": BeFalse(), "Error": Equal("connection refused")}))
+
+		// Without the Events, the permission to create them isn't required.
+		checker = preflight.NewChecker(cl, preflight.DefaultChecks("argocd", []string{"remote1-secret"}, false))
+		Expect(checker.Run(ctx).Degraded).To(BeFalse())
+	})
+})
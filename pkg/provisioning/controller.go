@@ -0,0 +1,552 @@
+// Package provisioning creates the namespaces requested by the
+// NamespaceClaim resources, along with their quota and RoleBindings. The
+// provisioned namespaces carry the labels of their claim, so the generator
+// serves them like any other namespace.
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/expiry"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+	"github.com/konflux-ci/namespace-generator/pkg/metrics"
+)
+
+const (
+	// ClaimAnnotation is set on the provisioned namespaces to the claim
+	// owning them, as namespace/name. Namespaces without it are never
+	// changed.
+	ClaimAnnotation = "generator.konflux-ci.dev/claim"
+	// managedLabelsAnnotation and managedAnnotationsAnnotation list the keys
+	// set from the claim, so the keys removed from the claim are removed
+	// from the namespace.
+	managedLabelsAnnotation      = "generator.konflux-ci.dev/claim-labels"
+	managedAnnotationsAnnotation = "generator.konflux-ci.dev/claim-annotations"
+	// Finalizer holds the claims with the Delete policy until their namespace
+	// is deleted.
+	Finalizer = "generator.konflux-ci.dev/namespace-claim"
+
+	// ManagedByLabel marks the resources created in the provisioned
+	// namespaces, so the stale ones are deleted.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedBy      = "namespace-generator"
+	// QuotaName is the name of the ResourceQuota of the claims.
+	QuotaName = "namespace-claim"
+	// roleBindingPrefix prefixes the name of the bound ClusterRole in the
+	// names of the RoleBindings.
+	roleBindingPrefix = "namespace-claim-"
+
+	watchRetryInterval = 5 * time.Second
+)
+
+// Reasons of the Ready condition of the claims.
+const (
+	ReasonProvisioned          = "Provisioned"
+	ReasonClaimNotAllowed      = "ClaimNotAllowed"
+	ReasonNamespaceTaken       = "NamespaceTaken"
+	ReasonNamespaceTerminating = "NamespaceTerminating"
+	ReasonNamespaceExpired     = "NamespaceExpired"
+	ReasonProvisioningFailed   = "ProvisioningFailed"
+)
+
+// Options configures a Controller.
+type Options struct {
+	// Namespaces are the namespaces the claims are accepted from. Empty
+	// accepts them from all namespaces.
+	Namespaces []string
+	// ResyncInterval is how often all the claims are provisioned again.
+	ResyncInterval time.Duration
+	// DefaultRoleBindings are created in every provisioned namespace, the
+	// subjects of the claims binding the same ClusterRole being added to
+	// them.
+	DefaultRoleBindings []generatorv1alpha1.NamespaceRoleBinding
+}
+
+// Controller provisions the namespaces of the NamespaceClaims. It must only
+// run on one replica.
+type Controller struct {
+	client  client.WithWatch
+	options Options
+	logger  *slog.Logger
+
+	// templatesChanged provisions all the claims again after a
+	// NamespaceTemplate changed.
+	templatesChanged chan struct{}
+}
+
+func NewController(cl client.WithWatch, options Options, logger *slog.Logger) *Controller {
+	return &Controller{
+		client:           cl,
+		options:          options,
+		logger:           logger,
+		templatesChanged: make(chan struct{}, 1),
+	}
+}
+
+// claimError fails the provisioning of a claim for a reason reported in its
+// status, rather than for a failure of the API server.
+type claimError struct {
+	reason  string
+	message string
+}
+
+func (err *claimError) Error() string {
+	return err.message
+}
+
+// Run provisions the claims until the context is done.
+func (controller *Controller) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		controller.watchTemplates(ctx)
+	}()
+
+	for {
+		resourceVersion, err := controller.sync(ctx)
+		if err == nil {
+			err = controller.watch(ctx, resourceVersion)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			controller.logger.Error("Failed to watch NamespaceClaims", logging.KeyError, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetryInterval):
+			}
+		}
+	}
+}
+
+// sync provisions all the claims and returns the resource version to start
+// watching from.
+func (controller *Controller) sync(ctx context.Context) (string, error) {
+	claimList := &generatorv1alpha1.NamespaceClaimList{}
+	if err := controller.client.List(ctx, claimList); err != nil {
+		return "", err
+	}
+	for i := range claimList.Items {
+		controller.reconcile(ctx, &claimList.Items[i])
+	}
+	return claimList.ResourceVersion, nil
+}
+
+// watch provisions the claims as they change, until the watch is closed by
+// the API server or the resync interval elapses. A nil error means the
+// caller should sync again and restart the watch.
+func (controller *Controller) watch(ctx context.Context, resourceVersion string) error {
+	w, err := controller.client.Watch(ctx, &generatorv1alpha1.NamespaceClaimList{}, &client.ListOptions{
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	resync := time.NewTimer(controller.options.ResyncInterval)
+	defer resync.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-resync.C:
+			return nil
+		case <-controller.templatesChanged:
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			switch event.Type {
+			case watch.Error:
+				// Most likely the resource version is too old, start over.
+				controller.logger.Debug("NamespaceClaim watch returned an error", "object", event.Object)
+				return nil
+			case watch.Added, watch.Modified:
+				// Deleted claims were finalized while being deleted, if
+				// needed.
+				if claim, ok := event.Object.(*generatorv1alpha1.NamespaceClaim); ok {
+					controller.reconcile(ctx, claim)
+				}
+			}
+		}
+	}
+}
+
+// reconcile provisions the namespace of a claim and reports it in the status
+// of the claim. Failures are retried on the next event or resync.
+func (controller *Controller) reconcile(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim) {
+	logger := controller.logger.With("claim", claim.Namespace+"/"+claim.Name)
+	template, err := controller.provision(ctx, claim)
+	if err != nil && claim.DeletionTimestamp != nil {
+		metrics.NamespaceClaimReconciles.WithLabelValues("error").Inc()
+		logger.Error("Failed to finalize the NamespaceClaim", logging.KeyError, err)
+		return
+	}
+	if claim.DeletionTimestamp != nil {
+		metrics.NamespaceClaimReconciles.WithLabelValues("deleted").Inc()
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:    generatorv1alpha1.NamespaceClaimReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonProvisioned,
+		Message: "The namespace is provisioned",
+	}
+	var claimErr *claimError
+	switch {
+	case errors.As(err, &claimErr):
+		metrics.NamespaceClaimReconciles.WithLabelValues("rejected").Inc()
+		logger.Info("NamespaceClaim rejected", "reason", claimErr.reason, logging.KeyError, err)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = claimErr.reason
+		condition.Message = claimErr.message
+	case err != nil:
+		metrics.NamespaceClaimReconciles.WithLabelValues("error").Inc()
+		logger.Error("Failed to provision the NamespaceClaim", logging.KeyError, err)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonProvisioningFailed
+		condition.Message = err.Error()
+	default:
+		metrics.NamespaceClaimReconciles.WithLabelValues("provisioned").Inc()
+	}
+	if err := controller.updateStatus(ctx, claim, template, condition); err != nil && !apierrors.IsNotFound(err) {
+		logger.Error("Failed to update the status of the NamespaceClaim", logging.KeyError, err)
+	}
+}
+
+// updateStatus sets the condition and the stamped template on the claim,
+// only calling the API server when the status changes. The template is kept
+// when the provisioning failed.
+func (controller *Controller) updateStatus(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim, template *generatorv1alpha1.NamespaceTemplate, condition metav1.Condition) error {
+	status := claim.Status.DeepCopy()
+	status.ObservedGeneration = claim.Generation
+	status.Namespace = ""
+	if condition.Status == metav1.ConditionTrue {
+		status.Namespace = claim.NamespaceName()
+		status.Template = nil
+		if template != nil {
+			status.Template = &generatorv1alpha1.AppliedTemplate{Name: template.Name, Generation: template.Generation}
+		}
+	}
+	condition.ObservedGeneration = claim.Generation
+	meta.SetStatusCondition(&status.Conditions, condition)
+	if equality.Semantic.DeepEqual(status, &claim.Status) {
+		return nil
+	}
+	claim.Status = *status
+	return controller.client.Status().Update(ctx, claim)
+}
+
+// provision provisions the namespace of a claim and returns the template
+// stamped into it, or deletes it when the claim with the Delete policy is
+// being deleted.
+func (controller *Controller) provision(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim) (*generatorv1alpha1.NamespaceTemplate, error) {
+	if claim.DeletionTimestamp != nil {
+		return nil, controller.finalize(ctx, claim)
+	}
+	if !controller.allowed(claim) {
+		return nil, &claimError{
+			reason:  ReasonClaimNotAllowed,
+			message: fmt.Sprintf("NamespaceClaims aren't accepted in namespace %s", claim.Namespace),
+		}
+	}
+	// The finalizer is added before creating the namespace, so it's deleted
+	// even if the claim is deleted right away.
+	if (claim.Spec.DeletionPolicy == generatorv1alpha1.NamespaceDeletionDelete) != controllerutil.ContainsFinalizer(claim, Finalizer) {
+		if claim.Spec.DeletionPolicy == generatorv1alpha1.NamespaceDeletionDelete {
+			controllerutil.AddFinalizer(claim, Finalizer)
+		} else {
+			controllerutil.RemoveFinalizer(claim, Finalizer)
+		}
+		if err := controller.client.Update(ctx, claim); err != nil {
+			return nil, fmt.Errorf("failed to update the finalizers of the claim: %w", err)
+		}
+	}
+
+	template, err := controller.getTemplate(ctx, claim)
+	if err != nil {
+		return nil, err
+	}
+	if err := controller.ensureNamespace(ctx, claim, template); err != nil {
+		return nil, err
+	}
+	if err := controller.ensureQuota(ctx, claim); err != nil {
+		return nil, err
+	}
+	if err := controller.ensureRoleBindings(ctx, claim); err != nil {
+		return nil, err
+	}
+	if template == nil && claim.Status.Template == nil {
+		return nil, nil
+	}
+	return template, controller.stampTemplate(ctx, claim, template)
+}
+
+func (controller *Controller) allowed(claim *generatorv1alpha1.NamespaceClaim) bool {
+	return len(controller.options.Namespaces) == 0 || slices.Contains(controller.options.Namespaces, claim.Namespace)
+}
+
+// finalize deletes the namespace of a claim being deleted, and removes the
+// finalizer once the namespace is gone.
+func (controller *Controller) finalize(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim) error {
+	if !controllerutil.ContainsFinalizer(claim, Finalizer) {
+		return nil
+	}
+	ns := &corev1.Namespace{}
+	err := controller.client.Get(ctx, client.ObjectKey{Name: claim.NamespaceName()}, ns)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return err
+	case ns.Annotations[ClaimAnnotation] != claimKey(claim):
+		// The namespace isn't the claim's to delete.
+	default:
+		if ns.DeletionTimestamp == nil {
+			controller.logger.Info("Deleting the namespace of the deleted NamespaceClaim", "namespace", ns.Name)
+			if err := controller.client.Delete(ctx, ns); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+		// The finalizer is removed by the resync after the namespace is
+		// gone.
+		return nil
+	}
+	controllerutil.RemoveFinalizer(claim, Finalizer)
+	return controller.client.Update(ctx, claim)
+}
+
+func claimKey(claim *generatorv1alpha1.NamespaceClaim) string {
+	return claim.Namespace + "/" + claim.Name
+}
+
+// ensureNamespace creates the namespace of a claim, or sets the labels and
+// annotations of the claim and its template on it.
+func (controller *Controller) ensureNamespace(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim, template *generatorv1alpha1.NamespaceTemplate) error {
+	ns := &corev1.Namespace{}
+	err := controller.client.Get(ctx, client.ObjectKey{Name: claim.NamespaceName()}, ns)
+	if apierrors.IsNotFound(err) {
+		ns = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: claim.NamespaceName()}}
+		applyMetadata(ns, claim, template)
+		// The TTL of a namespace which is gone counts from the claim, else
+		// the namespaces deleted when they expire would be created again.
+		ns.CreationTimestamp = claim.CreationTimestamp
+		if expiry.Expired(ns, time.Now()) {
+			return &claimError{
+				reason:  ReasonNamespaceExpired,
+				message: fmt.Sprintf("the TTL of namespace %s expired", ns.Name),
+			}
+		}
+		ns.CreationTimestamp = metav1.Time{}
+		controller.logger.Info("Creating the namespace of the NamespaceClaim", "claim", claimKey(claim), "namespace", ns.Name)
+		return controller.client.Create(ctx, ns)
+	}
+	if err != nil {
+		return err
+	}
+
+	if owner := ns.Annotations[ClaimAnnotation]; owner != claimKey(claim) {
+		message := fmt.Sprintf("namespace %s already exists", ns.Name)
+		if owner != "" {
+			message = fmt.Sprintf("namespace %s is claimed by %s", ns.Name, owner)
+		}
+		return &claimError{reason: ReasonNamespaceTaken, message: message}
+	}
+	if ns.DeletionTimestamp != nil {
+		return &claimError{
+			reason:  ReasonNamespaceTerminating,
+			message: fmt.Sprintf("namespace %s is being deleted", ns.Name),
+		}
+	}
+	previous := ns.DeepCopy()
+	applyMetadata(ns, claim, template)
+	if equality.Semantic.DeepEqual(previous.ObjectMeta, ns.ObjectMeta) {
+		return nil
+	}
+	return controller.client.Update(ctx, ns)
+}
+
+// applyMetadata sets the labels and annotations of the claim and its
+// template on its namespace, removing the ones set from an earlier version
+// of them.
+func applyMetadata(ns *corev1.Namespace, claim *generatorv1alpha1.NamespaceClaim, template *generatorv1alpha1.NamespaceTemplate) {
+	annotations := ns.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	labels, claimAnnotations := claim.Spec.Labels, claim.Spec.Annotations
+	if template != nil {
+		labels = merge(template.Spec.Labels, labels)
+		claimAnnotations = merge(template.Spec.Annotations, claimAnnotations)
+	}
+	ns.Labels = applyManaged(ns.Labels, labels, annotations, managedLabelsAnnotation)
+	annotations = applyManaged(annotations, claimAnnotations, annotations, managedAnnotationsAnnotation)
+	annotations[ClaimAnnotation] = claimKey(claim)
+	ns.Annotations = annotations
+}
+
+// merge returns the entries of both maps, the overrides winning.
+func merge(defaults, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(defaults)+len(overrides))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
+
+// applyManaged sets the desired entries, and removes the entries listed by
+// the annotation which are no longer desired. The annotation lists the keys
+// of the desired entries afterwards.
+func applyManaged(current, desired, annotations map[string]string, annotation string) map[string]string {
+	if current == nil {
+		current = map[string]string{}
+	}
+	for _, key := range strings.Split(annotations[annotation], ",") {
+		if _, ok := desired[key]; !ok {
+			delete(current, key)
+		}
+	}
+	keys := make([]string, 0, len(desired))
+	for key, value := range desired {
+		current[key] = value
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		delete(annotations, annotation)
+	} else {
+		annotations[annotation] = strings.Join(keys, ",")
+	}
+	return current
+}
+
+// ensureQuota creates or updates the ResourceQuota of the claim, or deletes
+// it when the claim sets no quota.
+func (controller *Controller) ensureQuota(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim) error {
+	quota := &corev1.ResourceQuota{}
+	err := controller.client.Get(ctx, client.ObjectKey{Namespace: claim.NamespaceName(), Name: QuotaName}, quota)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if len(claim.Spec.Quota) == 0 {
+		if exists && quota.Labels[ManagedByLabel] == ManagedBy {
+			return client.IgnoreNotFound(controller.client.Delete(ctx, quota))
+		}
+		return nil
+	}
+	if !exists {
+		quota = &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: claim.NamespaceName(),
+				Name:      QuotaName,
+				Labels:    map[string]string{ManagedByLabel: ManagedBy},
+			},
+			Spec: corev1.ResourceQuotaSpec{Hard: claim.Spec.Quota},
+		}
+		return controller.client.Create(ctx, quota)
+	}
+	if equality.Semantic.DeepEqual(quota.Spec.Hard, claim.Spec.Quota) {
+		return nil
+	}
+	quota.Spec.Hard = claim.Spec.Quota
+	return controller.client.Update(ctx, quota)
+}
+
+// ensureRoleBindings creates a RoleBinding per ClusterRole bound by default
+// or by the claim, and deletes the ones no longer bound.
+func (controller *Controller) ensureRoleBindings(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim) error {
+	desired := map[string]*rbacv1.RoleBinding{}
+	bindings := append(slices.Clip(controller.options.DefaultRoleBindings), claim.Spec.RoleBindings...)
+	for _, binding := range bindings {
+		name := roleBindingPrefix + binding.ClusterRole
+		roleBinding, ok := desired[name]
+		if !ok {
+			roleBinding = &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: claim.NamespaceName(),
+					Name:      name,
+					Labels:    map[string]string{ManagedByLabel: ManagedBy},
+				},
+				RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: binding.ClusterRole},
+			}
+			desired[name] = roleBinding
+		}
+		for _, subject := range binding.Subjects {
+			if !slices.Contains(roleBinding.Subjects, subject) {
+				roleBinding.Subjects = append(roleBinding.Subjects, subject)
+			}
+		}
+	}
+
+	existing := &rbacv1.RoleBindingList{}
+	if err := controller.client.List(ctx, existing, client.InNamespace(claim.NamespaceName()), client.MatchingLabels{ManagedByLabel: ManagedBy}); err != nil {
+		return err
+	}
+	for i := range existing.Items {
+		roleBinding := &existing.Items[i]
+		want, ok := desired[roleBinding.Name]
+		if !ok {
+			if err := controller.client.Delete(ctx, roleBinding); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			continue
+		}
+		delete(desired, roleBinding.Name)
+		if equality.Semantic.DeepEqual(roleBinding.RoleRef, want.RoleRef) && equality.Semantic.DeepEqual(roleBinding.Subjects, want.Subjects) {
+			continue
+		}
+		// The role of a RoleBinding can't be changed.
+		if roleBinding.RoleRef != want.RoleRef {
+			if err := controller.client.Delete(ctx, roleBinding); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			desired[roleBinding.Name] = want
+			continue
+		}
+		roleBinding.Subjects = want.Subjects
+		if err := controller.client.Update(ctx, roleBinding); err != nil {
+			return err
+		}
+	}
+	for _, roleBinding := range desired {
+		if err := controller.client.Create(ctx, roleBinding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
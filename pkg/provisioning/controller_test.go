@@ -0,0 +1,191 @@
+package provisioning_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/expiry"
+	"github.com/konflux-ci/namespace-generator/pkg/provisioning"
+)
+
+func TestProvisioning(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Provisioning Suite")
+}
+
+var _ = Describe("Controller", func() {
+	var (
+		cl     client.WithWatch
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(rbacv1.AddToScheme(scheme)).To(Succeed())
+		Expect(networkingv1.AddToScheme(scheme)).To(Succeed())
+		Expect(generatorv1alpha1.AddToScheme(scheme)).To(Succeed())
+		cl = fake.NewClientBuilder().WithScheme(scheme).
+			WithStatusSubresource(&generatorv1alpha1.NamespaceClaim{}).
+			WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "existing"}}).
+			Build()
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		controller := provisioning.NewController(cl, provisioning.Options{
+			Namespaces:     []string{"claims"},
+			ResyncInterval: time.Hour,
+			DefaultRoleBindings: []generatorv1alpha1.NamespaceRoleBinding{{
+				ClusterRole: "view",
+				Subjects:    []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.GroupKind, Name: "auditors"}},
+			}},
+		}, slog.Default())
+		go controller.Run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	claim := func(namespace, name string, spec generatorv1alpha1.NamespaceClaimSpec) *generatorv1alpha1.NamespaceClaim {
+		return &generatorv1alpha1.NamespaceClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       spec,
+		}
+	}
+
+	readyReason := func(ctx context.Context, namespace, name string) func() string {
+		return func() string {
+			current := &generatorv1alpha1.NamespaceClaim{}
+			if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, current); err != nil {
+				return err.Error()
+			}
+			for _, condition := range current.Status.Conditions {
+				if condition.Type == generatorv1alpha1.NamespaceClaimReady {
+					return condition.Reason
+				}
+			}
+			return ""
+		}
+	}
+
+	It("should provision the namespace of a claim", func(ctx SpecContext) {
+		Expect(cl.Create(ctx, claim("claims", "team-a", generatorv1alpha1.NamespaceClaimSpec{
+			Labels: map[string]string{"konflux.ci/type": "user"},
+			Quota:  corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+			RoleBindings: []generatorv1alpha1.NamespaceRoleBinding{{
+				ClusterRole: "edit",
+				Subjects:    []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "alice"}},
+			}},
+		}))).To(Succeed())
+		Eventually(readyReason(ctx, "claims", "team-a")).Should(Equal(provisioning.ReasonProvisioned))
+
+		ns := &corev1.Namespace{}
+		Expect(cl.Get(ctx, client.ObjectKey{Name: "team-a"}, ns)).To(Succeed())
+		Expect(ns.Labels).To(HaveKeyWithValue("konflux.ci/type", "user"))
+		Expect(ns.Annotations).To(HaveKeyWithValue(provisioning.ClaimAnnotation, "claims/team-a"))
+
+		quota := &corev1.ResourceQuota{}
+		Expect(cl.Get(ctx, client.ObjectKey{Namespace: "team-a", Name: provisioning.QuotaName}, quota)).To(Succeed())
+		Expect(quota.Spec.Hard.Pods().String()).To(Equal("10"))
+
+		roleBindings := &rbacv1.RoleBindingList{}
+		Expect(cl.List(ctx, roleBindings, client.InNamespace("team-a"))).To(Succeed())
+		Expect(roleBindings.Items).To(HaveLen(2))
+	})
+
+	It("should reject the claims of other namespaces and the existing namespaces", func(ctx SpecContext) {
+		Expect(cl.Create(ctx, claim("elsewhere", "team-b", generatorv1alpha1.NamespaceClaimSpec{}))).To(Succeed())
+		Expect(cl.Create(ctx, claim("claims", "existing", generatorv1alpha1.NamespaceClaimSpec{}))).To(Succeed())
+		Eventually(readyReason(ctx, "elsewhere", "team-b")).Should(Equal(provisioning.ReasonClaimNotAllowed))
+		Eventually(readyReason(ctx, "claims", "existing")).Should(Equal(provisioning.ReasonNamespaceTaken))
+		Expect(cl.Get(ctx, client.ObjectKey{Name: "team-b"}, &corev1.Namespace{})).NotTo(Succeed())
+	})
+
+	It("should remove the labels removed from the claim", func(ctx SpecContext) {
+		teamC := claim("claims", "team-c", generatorv1alpha1.NamespaceClaimSpec{
+			Labels: map[string]string{"konflux.ci/type": "user", "team": "c"},
+		})
+		Expect(cl.Create(ctx, teamC)).To(Succeed())
+		Eventually(readyReason(ctx, "claims", "team-c")).Should(Equal(provisioning.ReasonProvisioned))
+
+		Expect(cl.Get(ctx, client.ObjectKeyFromObject(teamC), teamC)).To(Succeed())
+		teamC.Spec.Labels = map[string]string{"konflux.ci/type": "user"}
+		Expect(cl.Update(ctx, teamC)).To(Succeed())
+		Eventually(func() map[string]string {
+			ns := &corev1.Namespace{}
+			Expect(cl.Get(ctx, client.ObjectKey{Name: "team-c"}, ns)).To(Succeed())
+			return ns.Labels
+		}).Should(Equal(map[string]string{"konflux.ci/type": "user"}))
+	})
+
+	It("should stamp the template of a claim", func(ctx SpecContext) {
+		template := &generatorv1alpha1.NamespaceTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+			Spec: generatorv1alpha1.NamespaceTemplateSpec{
+				Labels: map[string]string{"konflux.ci/type": "user", "tier": "free"},
+				LimitRanges: []generatorv1alpha1.TemplateLimitRange{{
+					Name: "defaults",
+					Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+						Type:    corev1.LimitTypeContainer,
+						Default: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+					}}},
+				}},
+				NetworkPolicies: []generatorv1alpha1.TemplateNetworkPolicy{{
+					Name: "deny-all",
+					Spec: networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}},
+				}},
+			},
+		}
+		Expect(cl.Create(ctx, template)).To(Succeed())
+		Expect(cl.Create(ctx, claim("claims", "team-d", generatorv1alpha1.NamespaceClaimSpec{
+			Template: "tenant",
+			Labels:   map[string]string{"tier": "paid"},
+		}))).To(Succeed())
+		Eventually(readyReason(ctx, "claims", "team-d")).Should(Equal(provisioning.ReasonProvisioned))
+
+		ns := &corev1.Namespace{}
+		Expect(cl.Get(ctx, client.ObjectKey{Name: "team-d"}, ns)).To(Succeed())
+		Expect(ns.Labels).To(Equal(map[string]string{"konflux.ci/type": "user", "tier": "paid"}))
+		Expect(cl.Get(ctx, client.ObjectKey{Namespace: "team-d", Name: "defaults"}, &corev1.LimitRange{})).To(Succeed())
+		Expect(cl.Get(ctx, client.ObjectKey{Namespace: "team-d", Name: "deny-all"}, &networkingv1.NetworkPolicy{})).To(Succeed())
+
+		// Changing the template stamps it again.
+		template.Spec.NetworkPolicies = nil
+		Expect(cl.Update(ctx, template)).To(Succeed())
+		Eventually(func() error {
+			return cl.Get(ctx, client.ObjectKey{Namespace: "team-d", Name: "deny-all"}, &networkingv1.NetworkPolicy{})
+		}).Should(MatchError(ContainSubstring("not found")))
+	})
+
+	It("should not provision the expired namespaces again", func(ctx SpecContext) {
+		preview := claim("claims", "preview", generatorv1alpha1.NamespaceClaimSpec{
+			Annotations: map[string]string{expiry.TTLAnnotation: "1h"},
+		})
+		preview.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		Expect(cl.Create(ctx, preview)).To(Succeed())
+		Eventually(readyReason(ctx, "claims", "preview")).Should(Equal(provisioning.ReasonNamespaceExpired))
+		Expect(cl.Get(ctx, client.ObjectKey{Name: "preview"}, &corev1.Namespace{})).NotTo(Succeed())
+	})
+
+	It("should report the missing templates", func(ctx SpecContext) {
+		Expect(cl.Create(ctx, claim("claims", "team-e", generatorv1alpha1.NamespaceClaimSpec{Template: "missing"}))).To(Succeed())
+		Eventually(readyReason(ctx, "claims", "team-e")).Should(Equal(provisioning.ReasonTemplateNotFound))
+	})
+})
@@ -0,0 +1,278 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// TemplateLabel is set on the resources stamped from a NamespaceTemplate to
+// the name of the template. The resources with it which are no longer in the
+// template of their claim are deleted.
+const TemplateLabel = "generator.konflux-ci.dev/template"
+
+// Reasons of the Ready condition of the claims using a template.
+const (
+	ReasonTemplateNotFound = "TemplateNotFound"
+	ReasonTemplateInvalid  = "TemplateInvalid"
+)
+
+// stampedKind is a kind of resource stamped from the templates.
+type stampedKind struct {
+	kind    string
+	newList func() client.ObjectList
+	// desired returns the resources of the kind in the template, without
+	// their namespace and labels.
+	desired func(template *generatorv1alpha1.NamespaceTemplate) []client.Object
+	// copySpec copies the spec of the desired resource to the existing one,
+	// and reports whether it changed.
+	copySpec func(existing, desired client.Object) bool
+}
+
+var stampedKinds = []stampedKind{
+	{
+		kind:    "ResourceQuota",
+		newList: func() client.ObjectList { return &corev1.ResourceQuotaList{} },
+		desired: func(template *generatorv1alpha1.NamespaceTemplate) []client.Object {
+			objects := make([]client.Object, 0, len(template.Spec.ResourceQuotas))
+			for _, quota := range template.Spec.ResourceQuotas {
+				objects = append(objects, &corev1.ResourceQuota{
+					ObjectMeta: metav1.ObjectMeta{Name: quota.Name},
+					Spec:       *quota.Spec.DeepCopy(),
+				})
+			}
+			return objects
+		},
+		copySpec: func(existing, desired client.Object) bool {
+			existingQuota, desiredQuota := existing.(*corev1.ResourceQuota), desired.(*corev1.ResourceQuota)
+			if equality.Semantic.DeepEqual(existingQuota.Spec, desiredQuota.Spec) {
+				return false
+			}
+			existingQuota.Spec = desiredQuota.Spec
+			return true
+		},
+	},
+	{
+		kind:    "LimitRange",
+		newList: func() client.ObjectList { return &corev1.LimitRangeList{} },
+		desired: func(template *generatorv1alpha1.NamespaceTemplate) []client.Object {
+			objects := make([]client.Object, 0, len(template.Spec.LimitRanges))
+			for _, limitRange := range template.Spec.LimitRanges {
+				objects = append(objects, &corev1.LimitRange{
+					ObjectMeta: metav1.ObjectMeta{Name: limitRange.Name},
+					Spec:       *limitRange.Spec.DeepCopy(),
+				})
+			}
+			return objects
+		},
+		copySpec: func(existing, desired client.Object) bool {
+			existingRange, desiredRange := existing.(*corev1.LimitRange), desired.(*corev1.LimitRange)
+			if equality.Semantic.DeepEqual(existingRange.Spec, desiredRange.Spec) {
+				return false
+			}
+			existingRange.Spec = desiredRange.Spec
+			return true
+		},
+	},
+	{
+		kind:    "NetworkPolicy",
+		newList: func() client.ObjectList { return &networkingv1.NetworkPolicyList{} },
+		desired: func(template *generatorv1alpha1.NamespaceTemplate) []client.Object {
+			objects := make([]client.Object, 0, len(template.Spec.NetworkPolicies))
+			for _, policy := range template.Spec.NetworkPolicies {
+				objects = append(objects, &networkingv1.NetworkPolicy{
+					ObjectMeta: metav1.ObjectMeta{Name: policy.Name},
+					Spec:       *policy.Spec.DeepCopy(),
+				})
+			}
+			return objects
+		},
+		copySpec: func(existing, desired client.Object) bool {
+			existingPolicy, desiredPolicy := existing.(*networkingv1.NetworkPolicy), desired.(*networkingv1.NetworkPolicy)
+			if equality.Semantic.DeepEqual(existingPolicy.Spec, desiredPolicy.Spec) {
+				return false
+			}
+			existingPolicy.Spec = desiredPolicy.Spec
+			return true
+		},
+	},
+}
+
+// getTemplate returns the template of a claim, nil if it has none.
+func (controller *Controller) getTemplate(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim) (*generatorv1alpha1.NamespaceTemplate, error) {
+	if claim.Spec.Template == "" {
+		return nil, nil
+	}
+	template := &generatorv1alpha1.NamespaceTemplate{}
+	if err := controller.client.Get(ctx, client.ObjectKey{Name: claim.Spec.Template}, template); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, &claimError{
+				reason:  ReasonTemplateNotFound,
+				message: fmt.Sprintf("NamespaceTemplate %s doesn't exist", claim.Spec.Template),
+			}
+		}
+		return nil, err
+	}
+	if err := validateTemplate(template); err != nil {
+		return nil, &claimError{
+			reason:  ReasonTemplateInvalid,
+			message: fmt.Sprintf("invalid NamespaceTemplate %s: %s", template.Name, err),
+		}
+	}
+	return template, nil
+}
+
+// validateTemplate checks the names of the resources of a template, the
+// rest being validated by the API server when they're stamped.
+func validateTemplate(template *generatorv1alpha1.NamespaceTemplate) error {
+	for _, kind := range stampedKinds {
+		names := map[string]bool{}
+		for _, object := range kind.desired(template) {
+			name := object.GetName()
+			switch {
+			case name == "":
+				return fmt.Errorf("a %s has no name", kind.kind)
+			case names[name]:
+				return fmt.Errorf("%s %s is declared twice", kind.kind, name)
+			case kind.kind == "ResourceQuota" && name == QuotaName:
+				return fmt.Errorf("the name of ResourceQuota %s is reserved for the quota of the claims", name)
+			}
+			names[name] = true
+		}
+	}
+	return nil
+}
+
+// stampTemplate creates or updates the resources of the template in the
+// namespace of the claim, and deletes the stamped resources which are no
+// longer in it. A nil template deletes all the stamped resources.
+func (controller *Controller) stampTemplate(ctx context.Context, claim *generatorv1alpha1.NamespaceClaim, template *generatorv1alpha1.NamespaceTemplate) error {
+	for _, kind := range stampedKinds {
+		desired := map[string]client.Object{}
+		if template != nil {
+			for _, object := range kind.desired(template) {
+				object.SetNamespace(claim.NamespaceName())
+				object.SetLabels(map[string]string{ManagedByLabel: ManagedBy, TemplateLabel: template.Name})
+				desired[object.GetName()] = object
+			}
+		}
+
+		list := kind.newList()
+		if err := controller.client.List(ctx, list, client.InNamespace(claim.NamespaceName()), client.HasLabels{TemplateLabel}); err != nil {
+			return fmt.Errorf("failed to list the %s resources: %w", kind.kind, err)
+		}
+		existing, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range existing {
+			object := item.(client.Object)
+			want, ok := desired[object.GetName()]
+			if !ok {
+				if err := controller.client.Delete(ctx, object); client.IgnoreNotFound(err) != nil {
+					return fmt.Errorf("failed to delete %s %s: %w", kind.kind, object.GetName(), err)
+				}
+				continue
+			}
+			delete(desired, object.GetName())
+			changed := kind.copySpec(object, want)
+			if object.GetLabels()[TemplateLabel] != template.Name {
+				labels := object.GetLabels()
+				labels[TemplateLabel] = template.Name
+				object.SetLabels(labels)
+				changed = true
+			}
+			if !changed {
+				continue
+			}
+			if err := controller.client.Update(ctx, object); err != nil {
+				return fmt.Errorf("failed to update %s %s: %w", kind.kind, object.GetName(), err)
+			}
+		}
+		for _, object := range desired {
+			if err := controller.client.Create(ctx, object); err != nil {
+				return fmt.Errorf("failed to create %s %s: %w", kind.kind, object.GetName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// watchTemplates provisions all the claims again when a NamespaceTemplate
+// changes, until the context is done.
+func (controller *Controller) watchTemplates(ctx context.Context) {
+	for listed := false; ; listed = true {
+		err := controller.followTemplates(ctx, listed)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			controller.logger.Error("Failed to watch NamespaceTemplates", logging.KeyError, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// followTemplates lists the templates and watches them until the watch is
+// closed. The changes missed before listing again are assumed to be
+// changes.
+func (controller *Controller) followTemplates(ctx context.Context, relist bool) error {
+	templateList := &generatorv1alpha1.NamespaceTemplateList{}
+	if err := controller.client.List(ctx, templateList); err != nil {
+		return err
+	}
+	if relist {
+		controller.notifyTemplatesChanged()
+	}
+	w, err := controller.client.Watch(ctx, &generatorv1alpha1.NamespaceTemplateList{}, &client.ListOptions{
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     templateList.ResourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok || event.Type == watch.Error {
+				// Most likely the resource version is too old, start over.
+				return nil
+			}
+			if event.Type != watch.Bookmark {
+				controller.notifyTemplatesChanged()
+			}
+		}
+	}
+}
+
+func (controller *Controller) notifyTemplatesChanged() {
+	select {
+	case controller.templatesChanged <- struct{}{}:
+	default:
+		// The claims are already due to be provisioned again.
+	}
+}
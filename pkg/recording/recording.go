@@ -0,0 +1,166 @@
+// Package recording records the API requests served by the generator and
+// their responses to files, so incidents involving unexpected plugin inputs
+// can be reproduced by replaying them against a dev instance. Records are
+// sanitized: the credentials are never written.
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+// recordedHeaders are the request headers kept in the records. The others,
+// such as Authorization and cookies, are dropped.
+var recordedHeaders = []string{
+	"Accept",
+	"Content-Type",
+	"User-Agent",
+	"X-ApplicationSet-Name",
+	"X-ApplicationSet-Namespace",
+}
+
+// Record is a request served by the generator and its response.
+type Record struct {
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"requestID,omitempty"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Query     string            `json:"query,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	Response  Response          `json:"response"`
+}
+
+// Response is the recorded response of a request.
+type Response struct {
+	Status int    `json:"status"`
+	Body   string `json:"body,omitempty"`
+	// Truncated is set when the body exceeded the recorded size.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// NewRecord returns the sanitized record of a request and its response.
+func NewRecord(start time.Time, requestID string, req *http.Request, body []byte, status int, responseBody []byte, truncated bool) Record {
+	record := Record{
+		Time:      start,
+		RequestID: requestID,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Query:     redactQuery(req.URL.RawQuery),
+		Body:      logging.RedactString(string(body)),
+		Response: Response{
+			Status:    status,
+			Body:      logging.RedactString(string(responseBody)),
+			Truncated: truncated,
+		},
+	}
+	for _, header := range recordedHeaders {
+		if value := req.Header.Get(header); value != "" {
+			if record.Headers == nil {
+				record.Headers = map[string]string{}
+			}
+			record.Headers[header] = value
+		}
+	}
+	return record
+}
+
+// redactQuery redacts the parameters of a query one by one, so redacting a
+// credential doesn't drop the parameters following it.
+func redactQuery(query string) string {
+	parameters := strings.Split(query, "&")
+	for i, parameter := range parameters {
+		parameters[i] = logging.RedactString(parameter)
+	}
+	return strings.Join(parameters, "&")
+}
+
+// Recorder writes each record to its own file in a directory, up to a
+// maximum number of files, so recording can't fill the disk.
+type Recorder struct {
+	dir        string
+	maxRecords int
+
+	mu       sync.Mutex
+	recorded int
+	sequence int
+}
+
+// NewRecorder returns a recorder writing to dir, which is created if it
+// doesn't exist. The records already in dir count towards maxRecords, zero
+// being unlimited.
+func NewRecorder(dir string, maxRecords int) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir, maxRecords: maxRecords, recorded: len(existing)}, nil
+}
+
+// Record writes a record. It returns false without writing it once the
+// maximum number of records is reached.
+func (recorder *Recorder) Record(record Record) (bool, error) {
+	recorder.mu.Lock()
+	if recorder.maxRecords > 0 && recorder.recorded >= recorder.maxRecords {
+		recorder.mu.Unlock()
+		return false, nil
+	}
+	recorder.recorded++
+	recorder.sequence++
+	sequence := recorder.sequence
+	recorder.mu.Unlock()
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	// The names sort in the order the requests were served.
+	name := fmt.Sprintf("%s-%06d.json", record.Time.UTC().Format("20060102T150405.000000000"), sequence)
+	return true, os.WriteFile(filepath.Join(recorder.dir, name), data, 0o600)
+}
+
+// Load reads the records of a directory, or a single record file, in the
+// order they were recorded.
+func Load(path string) ([]Record, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	records := make([]Record, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("invalid record %s: %w", file, err)
+		}
+		if record.Method == "" || !strings.HasPrefix(record.Path, "/") {
+			return nil, fmt.Errorf("invalid record %s: %w", file, errors.New("the method or the path is missing"))
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
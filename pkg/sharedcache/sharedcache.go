@@ -0,0 +1,81 @@
+// Package sharedcache provides a cache store shared by the replicas of the
+// generator, so cache hit rates don't degrade with the number of replicas.
+package sharedcache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store is a key value store with expiring entries.
+type Store interface {
+	// Get returns the value of the key, or false if the key doesn't exist.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set sets the value of the key, which expires after the given TTL.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// DeletePrefix deletes the keys starting with the given prefix and
+	// returns how many were deleted.
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// RedisStore stores entries in Redis. All the keys are prefixed, so the
+// database can be shared with other applications.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore returns a store for the Redis server at the given URL, e.g.
+// redis://:password@redis:6379/0.
+func NewRedisStore(url string) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStore{client: redis.NewClient(options), prefix: "namespace-generator:"}, nil
+}
+
+func (store *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := store.client.Get(ctx, store.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (store *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return store.client.Set(ctx, store.prefix+key, value, ttl).Err()
+}
+
+func (store *RedisStore) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	var keys []string
+	iter := store.client.Scan(ctx, 0, store.prefix+prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	deleted, err := store.client.Del(ctx, keys...).Result()
+	return int(deleted), err
+}
+
+// Ping verifies that the Redis server is reachable.
+func (store *RedisStore) Ping(ctx context.Context) error {
+	return store.client.Ping(ctx).Err()
+}
+
+// Close closes the connections to the Redis server.
+func (store *RedisStore) Close() error {
+	return store.client.Close()
+}
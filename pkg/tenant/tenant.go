@@ -0,0 +1,126 @@
+// Package tenant scopes requests to the tenants of their caller. Callers
+// presenting a JWT, such as a ServiceAccount token, are only shown the
+// namespaces labeled with one of the tenants found in its claims.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Config configures how tokens are verified and mapped to tenants.
+type Config struct {
+	// Issuer must match the iss claim of the tokens.
+	Issuer string
+	// JWKSURL serves the keys the tokens are signed with.
+	JWKSURL string
+	// Audiences accepted in the aud claim. Empty accepts any audience.
+	Audiences []string
+	// Claim is the path of the claim holding the tenants, with "/" between
+	// the keys of nested claims, e.g. kubernetes.io/namespace. The claim may
+	// be a string or a list of strings.
+	Claim string
+	// Label is the namespace label holding the tenant of a namespace.
+	Label string
+}
+
+// Scope restricts the namespaces returned to a caller to the ones whose
+// label is one of the tenants.
+type Scope struct {
+	Label   string
+	Tenants []string
+	// Subject is the sub claim of the token, identifying its caller.
+	Subject string
+}
+
+// Verifier verifies tokens and returns the scope of their caller.
+type Verifier struct {
+	verifier  *oidc.IDTokenVerifier
+	audiences []string
+	claim     []string
+	label     string
+}
+
+// NewVerifier returns a verifier fetching the signing keys with the given
+// HTTP client. The keys are fetched again when a token is signed with an
+// unknown key, so rotated keys are picked up.
+func NewVerifier(ctx context.Context, config Config, httpClient *http.Client) *Verifier {
+	keySet := oidc.NewRemoteKeySet(oidc.ClientContext(ctx, httpClient), config.JWKSURL)
+	return &Verifier{
+		// The audience is checked against all the accepted audiences below.
+		verifier:  oidc.NewVerifier(config.Issuer, keySet, &oidc.Config{SkipClientIDCheck: true}),
+		audiences: config.Audiences,
+		claim:     strings.Split(config.Claim, "/"),
+		label:     config.Label,
+	}
+}
+
+// IsJWT reports whether the token looks like a JWT rather than a static key.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+// Verify checks the signature, the issuer, the audience and the expiry of the
+// token, and returns the scope of its tenants. Tokens without tenants are
+// rejected, as they would see no namespace.
+func (verifier *Verifier) Verify(ctx context.Context, rawToken string) (*Scope, error) {
+	token, err := verifier.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	if len(verifier.audiences) > 0 && !slices.ContainsFunc(token.Audience, func(audience string) bool {
+		return slices.Contains(verifier.audiences, audience)
+	}) {
+		return nil, fmt.Errorf("the token audience %v isn't accepted", token.Audience)
+	}
+
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+	tenants, err := claimValues(claims, verifier.claim)
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return nil, errors.New("the token has no tenant")
+	}
+	return &Scope{Label: verifier.label, Tenants: tenants, Subject: token.Subject}, nil
+}
+
+// claimValues returns the strings of the claim at the given path.
+func claimValues(claims map[string]any, path []string) ([]string, error) {
+	var value any = claims
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("the claim %s isn't set", strings.Join(path, "/"))
+		}
+		if value, ok = object[key]; !ok {
+			return nil, fmt.Errorf("the claim %s isn't set", strings.Join(path, "/"))
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		return []string{value}, nil
+	case []any:
+		tenants := make([]string, 0, len(value))
+		for _, item := range value {
+			tenant, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("the claim %s must hold strings", strings.Join(path, "/"))
+			}
+			tenants = append(tenants, tenant)
+		}
+		return tenants, nil
+	default:
+		return nil, fmt.Errorf("the claim %s must be a string or a list of strings", strings.Join(path, "/"))
+	}
+}
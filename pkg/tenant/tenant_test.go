@@ -0,0 +1,135 @@
+package tenant_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/namespace-generator/pkg/tenant"
+)
+
+func TestTenant(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tenant Suite")
+}
+
+const issuer = "https://issuer.example.com"
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a JWT with the given claims signed by the key.
+func sign(key *rsa.PrivateKey, claims map[string]any) string {
+	header := encode([]byte(`{"alg":"RS256","kid":"signing-key"}`))
+	payload, err := json.Marshal(claims)
+	Expect(err).NotTo(HaveOccurred())
+	signed := header + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).NotTo(HaveOccurred())
+	return signed + "." + encode(signature)
+}
+
+var _ = Describe("Verifier", func() {
+	var (
+		key      *rsa.PrivateKey
+		verifier *tenant.Verifier
+		claims   func(tenants any) map[string]any
+	)
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		keys, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "signing-key",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   encode(key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+		Expect(err).NotTo(HaveOccurred())
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(keys)
+		}))
+		DeferCleanup(server.Close)
+
+		// The keys are fetched with the context of the verifier, which
+		// outlives the setup of the spec.
+		verifier = tenant.NewVerifier(context.Background(), tenant.Config{
+			Issuer:    issuer,
+			JWKSURL:   server.URL,
+			Audiences: []string{"namespace-generator"},
+			Claim:     "konflux.ci/tenants",
+			Label:     "konflux.ci/tenant",
+		}, server.Client())
+		claims = func(tenants any) map[string]any {
+			return map[string]any{
+				"iss": issuer,
+				"sub": "system:serviceaccount:team-a:deployer",
+				"aud": "namespace-generator",
+				"exp": time.Now().Add(time.Hour).Unix(),
+				"konflux.ci": map[string]any{
+					"tenants": tenants,
+				},
+			}
+		}
+	})
+
+	It("should scope the caller to the tenants of a verified token", func(ctx SpecContext) {
+		scope, err := verifier.Verify(ctx, sign(key, claims([]string{"team-a", "team-b"})))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scope).To(Equal(&tenant.Scope{
+			Label:   "konflux.ci/tenant",
+			Tenants: []string{"team-a", "team-b"},
+			Subject: "system:serviceaccount:team-a:deployer",
+		}))
+	})
+
+	It("should reject the forged tenant claims", func(ctx SpecContext) {
+		// The payload of a valid token is swapped for one claiming another
+		// tenant.
+		parts := strings.Split(sign(key, claims("team-a")), ".")
+		payload, err := json.Marshal(claims("team-b"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = verifier.Verify(ctx, parts[0]+"."+encode(payload)+"."+parts[2])
+		Expect(err).To(HaveOccurred())
+
+		// A token signed with another key is rejected as well.
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = verifier.Verify(ctx, sign(otherKey, claims("team-b")))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject the tokens of another issuer or audience, or without tenants", func(ctx SpecContext) {
+		for _, mutate := range []func(map[string]any){
+			func(claims map[string]any) { claims["iss"] = "https://other.example.com" },
+			func(claims map[string]any) { claims["aud"] = "other" },
+			func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+			func(claims map[string]any) { delete(claims, "konflux.ci") },
+			func(claims map[string]any) { claims["konflux.ci"] = map[string]any{"tenants": []string{}} },
+			func(claims map[string]any) { claims["konflux.ci"] = map[string]any{"tenants": 42} },
+		} {
+			tokenClaims := claims("team-a")
+			mutate(tokenClaims)
+			_, err := verifier.Verify(ctx, sign(key, tokenClaims))
+			Expect(err).To(HaveOccurred(), "claims %v", tokenClaims)
+		}
+	})
+})
@@ -1,10 +1,13 @@
 package server_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/exec"
@@ -24,7 +27,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
 	"github.com/konflux-ci/namespace-generator/pkg/test/utils"
+	"github.com/konflux-ci/namespace-generator/pkg/version"
 )
 
 func TestNSGen(t *testing.T) {
@@ -44,7 +49,7 @@ var _ = BeforeSuite(func() {
 	k8sClient = utils.StartTestEnv(schema, testEnv)
 
 	serverProcess, serverCancelFunc = utils.CreateServer(
-		"../../../cmd/main.go",
+		"../../../cmd",
 		[]string{
 			"NS_GEN_USE_HTTP=true",
 			fmt.Sprintf("NS_GEN_KEY_PATH=%s", createKeyFile()),
@@ -132,6 +137,72 @@ var _ = Describe("Test namespace-generator server", func() {
 		})
 	})
 
+	When("the label selector is empty", func() {
+		It("should refuse to match all the namespaces", func() {
+			body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {}}}}`
+			request, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "bearer password")
+
+			response, err := httpClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusForbidden))
+
+			errorResponse := &v1alpha1.ErrorResponse{}
+			err = json.NewDecoder(response.Body).Decode(errorResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errorResponse.Code).To(Equal("MatchAllForbidden"))
+		})
+	})
+
+	When("the client accepts gzip", func() {
+		It("should still return a decodable response", func() {
+			body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+			request, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "bearer password")
+			// Setting the header explicitly disables the transparent
+			// decompression of the http client.
+			request.Header.Set("Accept-Encoding", "gzip")
+
+			response, err := httpClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+			reader := io.Reader(response.Body)
+			if response.Header.Get("Content-Encoding") == "gzip" {
+				reader, err = gzip.NewReader(response.Body)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			parsedResponse := &v1alpha1.GenerateResponse{}
+			err = json.NewDecoder(reader).Decode(parsedResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsedResponse.Output.Parameters).NotTo(BeEmpty())
+		})
+	})
+
+	When("the request carries the ETag of the current result", func() {
+		It("should return status 304", func() {
+			body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+			request, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "bearer password")
+			response, err := httpClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			etag := response.Header.Get("ETag")
+			Expect(etag).NotTo(BeEmpty())
+
+			request, err = http.NewRequest("POST", endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "bearer password")
+			request.Header.Set("If-None-Match", etag)
+			response, err = httpClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusNotModified))
+		})
+	})
+
 	Context("request without a bearer token", func() {
 		It("should return status 400", func() {
 			request, err := http.NewRequest("POST", endpoint, nil)
@@ -154,6 +225,39 @@ var _ = Describe("Test namespace-generator server", func() {
 		})
 	})
 
+	Context("request with an invalid label selector", func() {
+		It("should return status 400 with the request ID", func() {
+			body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {"matchExpressions": [{"key": "a", "operator": "Bad"}]}}}}`
+			request, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "bearer password")
+			request.Header.Set("X-Request-ID", "test-request-id")
+			response, err := httpClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(response.Header.Get("X-Request-ID")).To(Equal("test-request-id"))
+
+			errorResponse := &v1alpha1.ErrorResponse{}
+			err = json.NewDecoder(response.Body).Decode(errorResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errorResponse.RequestID).To(Equal("test-request-id"))
+		})
+	})
+
+	Context("request with an invalid ApplicationSet identity", func() {
+		It("should return status 400", func() {
+			body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+			request, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "bearer password")
+			request.Header.Set("X-ApplicationSet-Name", "test-app")
+			request.Header.Set("X-ApplicationSet-Namespace", "Not A Namespace")
+			response, err := httpClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+
 	Context("request with a broken body", func() {
 		It("should return status 400", func() {
 			request, err := http.NewRequest("POST", endpoint, strings.NewReader("{}"))
@@ -165,3 +269,210 @@ var _ = Describe("Test namespace-generator server", func() {
 		})
 	})
 })
+
+var _ = Describe("Test namespace-generator version endpoint", func() {
+	It("should return the build information", func() {
+		response, err := http.Get("http://localhost:5000/version")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		info := &version.Info{}
+		err = json.NewDecoder(response.Body).Decode(info)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Version).NotTo(BeEmpty())
+		Expect(info.GoVersion).NotTo(BeEmpty())
+	})
+})
+
+var _ = Describe("Test namespace-generator health endpoints", func() {
+	It("should report liveness", func() {
+		response, err := http.Get("http://localhost:5000/healthz")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("should report readiness when namespaces can be listed", func() {
+		response, err := http.Get("http://localhost:5000/readyz")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("Test namespace-generator namespace events endpoint", func() {
+	It("should stream the namespaces matching the selector", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		request, err := http.NewRequestWithContext(
+			ctx,
+			"GET",
+			"http://localhost:5000/api/v1/namespaces/events?labelSelector=konflux.ci/type=user",
+			nil,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(response.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		var namespaces []string
+		scanner := bufio.NewScanner(response.Body)
+		for len(namespaces) < 2 && scanner.Scan() {
+			data, found := strings.CutPrefix(scanner.Text(), "data: ")
+			if !found {
+				continue
+			}
+			event := &v1alpha1.NamespaceEvent{}
+			Expect(json.Unmarshal([]byte(data), event)).To(Succeed())
+			Expect(event.Type).To(Equal(v1alpha1.NamespaceEventAdded))
+			namespaces = append(namespaces, event.Namespace)
+		}
+		Expect(namespaces).To(ConsistOf("ns1", "ns2"))
+	})
+})
+
+var _ = Describe("Test namespace-generator batch endpoint", func() {
+	It("should return the result of each request keyed by its index", func() {
+		body := `[
+			{"applicationSetName": "a", "input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}},
+			{"applicationSetName": "b", "input": {"parameters": {"labelSelector": {"matchExpressions": [{"key": "a", "operator": "Bad"}]}}}}
+		]`
+		request, err := http.NewRequest("POST", "http://localhost:5000/api/v1/getparams.batch", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		batchResponse := &v1alpha1.BatchGenerateResponse{}
+		err = json.NewDecoder(response.Body).Decode(batchResponse)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(batchResponse.Results).To(HaveLen(2))
+
+		Expect(batchResponse.Results[0].Status).To(Equal(http.StatusOK))
+		Expect(batchResponse.Results[0].Output.Parameters).To(ConsistOf(
+			v1alpha1.OutParameters{Namespace: "ns1"},
+			v1alpha1.OutParameters{Namespace: "ns2"},
+		))
+		Expect(batchResponse.Results[1].Status).To(Equal(http.StatusBadRequest))
+		Expect(batchResponse.Results[1].Error).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("Test namespace-generator explain endpoint", func() {
+	It("should explain which namespaces matched", func() {
+		body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}}}}`
+		request, err := http.NewRequest("POST", "http://localhost:5000/api/v1/explain", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		explainResponse := &v1alpha1.ExplainResponse{}
+		err = json.NewDecoder(response.Body).Decode(explainResponse)
+		Expect(err).NotTo(HaveOccurred())
+
+		matched := map[string]bool{}
+		for _, explanation := range explainResponse.Namespaces {
+			matched[explanation.Namespace] = explanation.Matched
+		}
+		Expect(matched).To(HaveKeyWithValue("ns1", true))
+		Expect(matched).To(HaveKeyWithValue("ns2", true))
+		Expect(matched).To(HaveKeyWithValue("ns3", false))
+	})
+})
+
+var _ = Describe("Test namespace-generator admin endpoints", func() {
+	It("should list the cached remote clients", func() {
+		request, err := http.NewRequest("GET", "http://localhost:5000/admin/clients", nil)
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		clientsResponse := &v1alpha1.RemoteClientsResponse{}
+		err = json.NewDecoder(response.Body).Decode(clientsResponse)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clientsResponse.Clients).To(BeEmpty())
+	})
+
+	It("should require authentication", func() {
+		response, err := http.Get("http://localhost:5000/admin/clients")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
+
+var _ = Describe("Test namespace-generator clusters endpoints", func() {
+	It("should list the cluster secrets", func() {
+		request, err := http.NewRequest("GET", "http://localhost:5000/api/v1/clusters", nil)
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		clustersResponse := &v1alpha1.ClustersResponse{}
+		err = json.NewDecoder(response.Body).Decode(clustersResponse)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clustersResponse.Clusters).To(BeEmpty())
+	})
+
+	It("should generate parameters for the clusters plugin", func() {
+		body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {}}}}`
+		request, err := http.NewRequest("POST", "http://localhost:5000/clusters/api/v1/getparams.execute", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		generateResponse := &v1alpha1.ClusterGenerateResponse{}
+		err = json.NewDecoder(response.Body).Decode(generateResponse)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(generateResponse.Output.Parameters).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Test namespace-generator v1alpha2 API", func() {
+	It("should exclude namespaces and copy labels", func() {
+		body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {"matchLabels": {"konflux.ci/type": "user"}}, "excludeNamespaces": ["ns2"], "labelKeys": ["konflux.ci/type"]}}}`
+		request, err := http.NewRequest("POST", "http://localhost:5000/v1alpha2/api/v1/getparams.execute", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		generateResponse := &v1alpha2.GenerateResponse{}
+		err = json.NewDecoder(response.Body).Decode(generateResponse)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(generateResponse.Output.Parameters).To(ConsistOf(v1alpha2.OutParameters{
+			Namespace: "ns1",
+			Labels:    map[string]string{"konflux.ci/type": "user"},
+			Phase:     "Active",
+		}))
+	})
+
+	It("should reject v1alpha2 fields in v1alpha1 requests", func() {
+		body := `{"applicationSetName": "test-app", "input": {"parameters": {"labelSelector": {}, "excludeNamespaces": ["ns2"]}}}`
+		request, err := http.NewRequest("POST", "http://localhost:5000/api/v1/getparams.execute", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "bearer password")
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,142 @@
+package testing
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+)
+
+// NewFixtureReader returns a fake client of a cluster holding the Namespaces
+// and Secrets of the YAML files in dir and its subdirectories. A file may
+// hold several documents. Secrets without a namespace are put in the ArgoCD
+// namespace, as they're usually cluster secrets, and their stringData is
+// moved to data like the API server does. Other kinds are rejected, so a
+// typo doesn't silently leave an object out.
+func NewFixtureReader(dir string) (client.WithWatch, error) {
+	objects, err := loadFixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(), nil
+}
+
+// FixtureClientFactory returns a factory of the handlers returning the fake
+// client of the fixtures in dir, so the handlers serve canned cluster states.
+// The fixtures are loaded once.
+func FixtureClientFactory(dir string) (func(*slog.Logger) (client.Reader, error), error) {
+	reader, err := NewFixtureReader(dir)
+	if err != nil {
+		return nil, err
+	}
+	return func(*slog.Logger) (client.Reader, error) {
+		return reader, nil
+	}, nil
+}
+
+func loadFixtures(dir string) ([]client.Object, error) {
+	var objects []client.Object
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		fileObjects, err := loadFixtureFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load fixture %s: %w", path, err)
+		}
+		objects = append(objects, fileObjects...)
+		return nil
+	})
+	return objects, err
+}
+
+func loadFixtureFile(path string) ([]client.Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var objects []client.Object
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(file))
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(document)) == 0 {
+			continue
+		}
+		object, err := decodeFixture(document)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+}
+
+func decodeFixture(document []byte) (client.Object, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(document, &typeMeta); err != nil {
+		return nil, err
+	}
+	if typeMeta.APIVersion != "v1" {
+		return nil, fmt.Errorf("unsupported apiVersion %q, only v1 Namespaces and Secrets are supported", typeMeta.APIVersion)
+	}
+
+	switch typeMeta.Kind {
+	case "Namespace":
+		namespace := &corev1.Namespace{}
+		if err := yaml.UnmarshalStrict(document, namespace); err != nil {
+			return nil, err
+		}
+		return namespace, nil
+	case "Secret":
+		secret := &corev1.Secret{}
+		if err := yaml.UnmarshalStrict(document, secret); err != nil {
+			return nil, err
+		}
+		if secret.Namespace == "" {
+			secret.Namespace = generator.DefaultArgoCDNamespace
+		}
+		for key, value := range secret.StringData {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[key] = []byte(value)
+		}
+		secret.StringData = nil
+		return secret, nil
+	default:
+		return nil, fmt.Errorf("unsupported kind %q, only Namespaces and Secrets are supported", typeMeta.Kind)
+	}
+}
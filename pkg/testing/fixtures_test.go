@@ -0,0 +1,39 @@
+package testing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+	nsgentesting "github.com/konflux-ci/namespace-generator/pkg/testing"
+)
+
+var _ = Describe("NewFixtureReader", func() {
+	It("should read the namespaces and the secrets of the fixtures", func() {
+		reader, err := nsgentesting.NewFixtureReader("testdata/fixtures")
+		Expect(err).NotTo(HaveOccurred())
+
+		nsList := generator.NewNamespaceList()
+		Expect(reader.List(context.Background(), nsList, client.MatchingLabels{"konflux.ci/type": "user"})).To(Succeed())
+		Expect(nsList.Items).To(HaveLen(2))
+
+		secret := &corev1.Secret{}
+		Expect(reader.Get(context.Background(), client.ObjectKey{Namespace: "argocd", Name: "remote1"}, secret)).To(Succeed())
+		Expect(string(secret.Data["server"])).To(Equal("https://remote1.example.com"))
+	})
+
+	It("should reject unsupported kinds", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "configmap.yaml"), []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n"), 0o600)).To(Succeed())
+		_, err := nsgentesting.NewFixtureReader(dir)
+		Expect(err).To(MatchError(ContainSubstring(`unsupported kind "ConfigMap"`)))
+	})
+})
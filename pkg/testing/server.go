@@ -0,0 +1,249 @@
+// Package testing serves a fake namespace-generator backed by fixture
+// namespaces, so ApplicationSet authors and downstream projects can test
+// their templates against the responses of the plugin without a cluster.
+// The namespaces are selected and shaped by the same code as the server, but
+// the caches, policies and limits of the server aren't applied.
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	nsgenclient "github.com/konflux-ci/namespace-generator/pkg/client"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	"github.com/konflux-ci/namespace-generator/pkg/generator"
+)
+
+// Fixtures are the namespaces served by a fake server.
+type Fixtures struct {
+	// Namespaces are the namespaces of the local cluster.
+	Namespaces []corev1.Namespace
+	// Clusters are the namespaces of the remote clusters, keyed by the name
+	// of their ArgoCD cluster secret.
+	Clusters map[string][]corev1.Namespace
+}
+
+// Options configures a fake server.
+type Options struct {
+	// Token is the key the requests must be authenticated with. Requests
+	// aren't authenticated without one.
+	Token string
+	// AllowMatchAll allows the requests with an empty label selector and
+	// allowAll to match all the namespaces.
+	AllowMatchAll bool
+}
+
+// Server is a fake namespace-generator. It serves the plugin requests of
+// both API versions, under the same paths as the server, and the list of
+// clusters.
+type Server struct {
+	// URL is the base URL of the server, as set in the plugin ConfigMap.
+	URL string
+
+	server  *httptest.Server
+	options Options
+
+	mu       sync.Mutex
+	local    client.WithWatch
+	clusters map[string]client.WithWatch
+	failures map[string]*generrors.Kind
+	requests []v1alpha2.GenerateRequest
+}
+
+// NewServer starts a fake server serving the fixtures. It must be closed
+// when done.
+func NewServer(fixtures Fixtures, options Options) *Server {
+	server := &Server{
+		options:  options,
+		local:    newFakeCluster(fixtures.Namespaces),
+		clusters: map[string]client.WithWatch{},
+		failures: map[string]*generrors.Kind{},
+	}
+	for name, namespaces := range fixtures.Clusters {
+		server.clusters[name] = newFakeCluster(namespaces)
+	}
+
+	var authMiddleware []echo.MiddlewareFunc
+	if options.Token != "" {
+		authMiddleware = append(authMiddleware, middleware.KeyAuth(func(key string, _ echo.Context) (bool, error) {
+			return key == options.Token, nil
+		}))
+	}
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	api := e.Group("/api", authMiddleware...)
+	api.POST("/v1/getparams.execute", server.getParams)
+	api.GET("/v1/clusters", server.listClusters)
+	// v1alpha2 is also served under a prefix, as ArgoCD can't set the Accept
+	// header.
+	v1alpha2API := e.Group("/v1alpha2/api", authMiddleware...)
+	v1alpha2API.POST("/v1/getparams.execute", func(ctx echo.Context) error {
+		ctx.Request().Header.Set(echo.HeaderAccept, v1alpha2.MediaType)
+		return server.getParams(ctx)
+	})
+
+	server.server = httptest.NewServer(e)
+	server.URL = server.server.URL
+	return server
+}
+
+func newFakeCluster(namespaces []corev1.Namespace) client.WithWatch {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := range namespaces {
+		builder = builder.WithObjects(namespaces[i].DeepCopy())
+	}
+	return builder.Build()
+}
+
+// Close shuts the server down.
+func (server *Server) Close() {
+	server.server.Close()
+}
+
+// Client returns a client of the server, which doesn't retry the requests.
+func (server *Server) Client() *nsgenclient.Client {
+	options := nsgenclient.Options{
+		HTTPClient: server.server.Client(),
+		Retry:      &nsgenclient.RetryConfig{Attempts: 1},
+	}
+	if server.options.Token != "" {
+		options.Token = nsgenclient.StaticToken(server.options.Token)
+	}
+	cl, err := nsgenclient.New(server.URL, options)
+	if err != nil {
+		// The URL of an httptest server is always valid.
+		panic(err)
+	}
+	return cl
+}
+
+// SetNamespaces replaces the namespaces of a cluster, the local cluster when
+// clusterName is empty. Setting the namespaces of an unknown cluster adds it.
+func (server *Server) SetNamespaces(clusterName string, namespaces ...corev1.Namespace) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if clusterName == "" {
+		server.local = newFakeCluster(namespaces)
+		return
+	}
+	server.clusters[clusterName] = newFakeCluster(namespaces)
+}
+
+// FailCluster makes the requests for a cluster fail with the given kind of
+// error, such as errors.ErrClusterUnreachable, until it's called with nil.
+func (server *Server) FailCluster(clusterName string, kind *generrors.Kind) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if kind == nil {
+		delete(server.failures, clusterName)
+		return
+	}
+	server.failures[clusterName] = kind
+}
+
+// Requests returns the generate requests received so far, converted to
+// v1alpha2.
+func (server *Server) Requests() []v1alpha2.GenerateRequest {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return append([]v1alpha2.GenerateRequest(nil), server.requests...)
+}
+
+func (server *Server) getParams(ctx echo.Context) error {
+	v1alpha2Requested := strings.Contains(ctx.Request().Header.Get(echo.HeaderAccept), v1alpha2.MediaType)
+	var req *v1alpha2.GenerateRequest
+	if v1alpha2Requested {
+		req = &v1alpha2.GenerateRequest{}
+		if err := decodeJSON(ctx, req); err != nil {
+			return errorResponse(ctx, generrors.ErrInvalidRequest, err)
+		}
+	} else {
+		v1alpha1Req := &v1alpha1.GenerateRequest{}
+		if err := decodeJSON(ctx, v1alpha1Req); err != nil {
+			return errorResponse(ctx, generrors.ErrInvalidRequest, err)
+		}
+		req = v1alpha2.ConvertRequestFromV1alpha1(v1alpha1Req)
+	}
+
+	server.mu.Lock()
+	server.requests = append(server.requests, *req)
+	local := server.local
+	server.mu.Unlock()
+
+	parameters := req.Input.Parameters
+	if selector, err := metav1.LabelSelectorAsSelector(&parameters.LabelSelector); err == nil && selector.Empty() {
+		if !parameters.AllowAll || !server.options.AllowMatchAll {
+			return errorResponse(ctx, generrors.ErrMatchAllForbidden, errors.New("the empty label selector matches all the namespaces"))
+		}
+	}
+
+	gen := generator.New(local, generator.Options{RemoteReaders: server.remoteReader})
+	response, err := gen.Generate(ctx.Request().Context(), req)
+	if err != nil {
+		return errorResponse(ctx, generrors.KindOf(err), err)
+	}
+	if v1alpha2Requested {
+		return ctx.JSON(http.StatusOK, response)
+	}
+	return ctx.JSON(http.StatusOK, v1alpha2.ConvertResponseToV1alpha1(response))
+}
+
+func (server *Server) remoteReader(_ context.Context, clusterName string) (client.Reader, error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if kind, ok := server.failures[clusterName]; ok {
+		return nil, generrors.Wrap(kind, fmt.Errorf("cluster %s is set to fail", clusterName))
+	}
+	cluster, ok := server.clusters[clusterName]
+	if !ok {
+		return nil, generrors.Wrap(generrors.ErrSecretNotFound, fmt.Errorf("cluster secret %s not found", clusterName))
+	}
+	return cluster, nil
+}
+
+func (server *Server) listClusters(ctx echo.Context) error {
+	server.mu.Lock()
+	response := &v1alpha1.ClustersResponse{Clusters: make([]v1alpha1.ClusterInfo, 0, len(server.clusters))}
+	for name := range server.clusters {
+		response.Clusters = append(response.Clusters, v1alpha1.ClusterInfo{SecretName: name, Name: name, AuthProvider: "fake"})
+	}
+	server.mu.Unlock()
+	sort.Slice(response.Clusters, func(i, j int) bool {
+		return response.Clusters[i].SecretName < response.Clusters[j].SecretName
+	})
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// decodeJSON decodes the body of a request, rejecting the unknown fields like
+// the server does.
+func decodeJSON(ctx echo.Context, v any) error {
+	defer ctx.Request().Body.Close()
+	decoder := json.NewDecoder(ctx.Request().Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func errorResponse(ctx echo.Context, kind *generrors.Kind, err error) error {
+	return ctx.JSON(kind.Status, &v1alpha1.ErrorResponse{Message: err.Error(), Code: kind.Code})
+}
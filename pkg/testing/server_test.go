@@ -0,0 +1,81 @@
+package testing_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/api/v1alpha2"
+	"github.com/konflux-ci/namespace-generator/pkg/client"
+	generrors "github.com/konflux-ci/namespace-generator/pkg/errors"
+	nsgentesting "github.com/konflux-ci/namespace-generator/pkg/testing"
+)
+
+func TestTesting(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Testing Suite")
+}
+
+func namespace(name string, labels map[string]string) corev1.Namespace {
+	return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+var _ = Describe("Server", func() {
+	var server *nsgentesting.Server
+
+	BeforeEach(func() {
+		server = nsgentesting.NewServer(nsgentesting.Fixtures{
+			Namespaces: []corev1.Namespace{
+				namespace("ns1", map[string]string{"konflux.ci/type": "user", "team": "a"}),
+				namespace("ns2", nil),
+			},
+			Clusters: map[string][]corev1.Namespace{
+				"remote1": {namespace("remote-ns", map[string]string{"konflux.ci/type": "user"})},
+			},
+		}, nsgentesting.Options{Token: "secret"})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	userNamespaces := func() *client.RequestBuilder {
+		return client.NewRequest().MatchLabels(map[string]string{"konflux.ci/type": "user"})
+	}
+
+	It("should serve the namespaces of the local cluster", func() {
+		response, err := server.Client().Generate(context.Background(), userNamespaces().LabelKeys("team").Build())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Output.Parameters).To(Equal([]v1alpha2.OutParameters{
+			{Namespace: "ns1", Labels: map[string]string{"team": "a"}, Phase: "Active"},
+		}))
+		Expect(server.Requests()).To(HaveLen(1))
+	})
+
+	It("should serve the namespaces of the remote clusters", func() {
+		response, err := server.Client().Generate(context.Background(), userNamespaces().Cluster("remote1").Build())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Output.Parameters).To(Equal([]v1alpha2.OutParameters{
+			{Namespace: "remote-ns", ClusterName: "remote1", Phase: "Active"},
+		}))
+	})
+
+	It("should fail the clusters without a fixture or set to fail", func() {
+		_, err := server.Client().Generate(context.Background(), userNamespaces().Cluster("remote2").Build())
+		Expect(client.ErrorCode(err)).To(Equal(generrors.ErrSecretNotFound.Code))
+
+		server.FailCluster("remote1", generrors.ErrClusterUnreachable)
+		_, err = server.Client().Generate(context.Background(), userNamespaces().Cluster("remote1").Build())
+		Expect(client.ErrorCode(err)).To(Equal(generrors.ErrClusterUnreachable.Code))
+	})
+
+	It("should reject the requests matching all the namespaces", func() {
+		_, err := server.Client().Generate(context.Background(), client.NewRequest().AllowAll().Build())
+		Expect(client.ErrorCode(err)).To(Equal(generrors.ErrMatchAllForbidden.Code))
+	})
+})
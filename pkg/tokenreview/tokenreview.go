@@ -0,0 +1,160 @@
+// Package tokenreview authenticates the callers presenting a Kubernetes
+// ServiceAccount token by sending it to the API server in a TokenReview. The
+// tokens must be bound to one of the accepted audiences and expire, so a token
+// minted for another service, or a long-lived token, can't invoke the
+// generator.
+package tokenreview
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// cacheTTL bounds how long a reviewed token is accepted without reviewing it
+// again, so a deleted ServiceAccount is rejected soon after.
+const cacheTTL = time.Minute
+
+// Config configures the accepted tokens.
+type Config struct {
+	// Audiences the tokens must be bound to, at least one.
+	Audiences []string
+	// MaxLifetime rejects the tokens valid for longer. Zero accepts any
+	// lifetime, but the tokens must still expire.
+	MaxLifetime time.Duration
+}
+
+// Reviewer authenticates tokens with TokenReviews.
+type Reviewer struct {
+	client client.Client
+	config Config
+	now    func() time.Time
+
+	mu       sync.Mutex
+	reviewed map[[sha256.Size]byte]reviewedToken
+}
+
+type reviewedToken struct {
+	user    string
+	expires time.Time
+}
+
+func NewReviewer(cl client.Client, config Config) *Reviewer {
+	return &Reviewer{
+		client:   cl,
+		config:   config,
+		now:      time.Now,
+		reviewed: map[[sha256.Size]byte]reviewedToken{},
+	}
+}
+
+// claims are the claims of the token checked before reviewing it.
+type claims struct {
+	Expiry   *int64 `json:"exp"`
+	IssuedAt *int64 `json:"iat"`
+}
+
+// Review returns the user of the token if the API server authenticates it for
+// one of the audiences, and it expires within the maximum lifetime.
+func (reviewer *Reviewer) Review(ctx context.Context, token string) (string, error) {
+	expiry, err := reviewer.checkExpiry(token)
+	if err != nil {
+		return "", err
+	}
+
+	key := sha256.Sum256([]byte(token))
+	now := reviewer.now()
+	reviewer.mu.Lock()
+	cached, ok := reviewer.reviewed[key]
+	reviewer.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.user, nil
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: reviewer.config.Audiences,
+		},
+	}
+	if err := reviewer.client.Create(ctx, review); err != nil {
+		return "", fmt.Errorf("failed to review the token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return "", fmt.Errorf("the token isn't authenticated: %s", review.Status.Error)
+		}
+		return "", errors.New("the token isn't authenticated")
+	}
+	// Authenticators ignoring the audiences of the review authenticate the
+	// tokens of any audience, so the audiences of the token are checked too.
+	if !slices.ContainsFunc(review.Status.Audiences, func(audience string) bool {
+		return slices.Contains(reviewer.config.Audiences, audience)
+	}) {
+		return "", fmt.Errorf("the token audiences %v aren't accepted", review.Status.Audiences)
+	}
+
+	user := review.Status.User.Username
+	reviewer.mu.Lock()
+	defer reviewer.mu.Unlock()
+	for key, reviewed := range reviewer.reviewed {
+		if !now.Before(reviewed.expires) {
+			delete(reviewer.reviewed, key)
+		}
+	}
+	reviewer.reviewed[key] = reviewedToken{user: user, expires: minTime(now.Add(cacheTTL), expiry)}
+	return user, nil
+}
+
+// checkExpiry returns the expiry of the token, and an error if it has none,
+// is expired or is valid for longer than the maximum lifetime. The API server
+// checks the expiry as well, but accepts the legacy tokens which never expire.
+func (reviewer *Reviewer) checkExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("the token isn't a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid token payload: %w", err)
+	}
+	var tokenClaims claims
+	if err := json.Unmarshal(payload, &tokenClaims); err != nil {
+		return time.Time{}, fmt.Errorf("invalid token claims: %w", err)
+	}
+
+	if tokenClaims.Expiry == nil {
+		return time.Time{}, errors.New("the token doesn't expire")
+	}
+	expiry := time.Unix(*tokenClaims.Expiry, 0)
+	if !reviewer.now().Before(expiry) {
+		return time.Time{}, errors.New("the token is expired")
+	}
+	if reviewer.config.MaxLifetime > 0 {
+		if tokenClaims.IssuedAt == nil {
+			return time.Time{}, errors.New("the token has no issue time")
+		}
+		if lifetime := expiry.Sub(time.Unix(*tokenClaims.IssuedAt, 0)); lifetime > reviewer.config.MaxLifetime {
+			return time.Time{}, fmt.Errorf("the token is valid for %s, longer than the maximum of %s", lifetime, reviewer.config.MaxLifetime)
+		}
+	}
+	return expiry, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
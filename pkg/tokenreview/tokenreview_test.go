@@ -0,0 +1,103 @@
+package tokenreview_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+
+	"github.com/konflux-ci/namespace-generator/pkg/tokenreview"
+)
+
+func TestTokenReview(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TokenReview Suite")
+}
+
+// token returns an unsigned JWT with the given claims, which is enough as the
+// signature is checked by the API server.
+func token(claims map[string]any) string {
+	payload, err := json.Marshal(claims)
+	Expect(err).NotTo(HaveOccurred())
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
+
+var _ = Describe("Reviewer", func() {
+	var (
+		// audiences are the audiences the API server authenticates the
+		// tokens for.
+		audiences []string
+		reviews   int
+		reviewer  *tokenreview.Reviewer
+	)
+
+	BeforeEach(func() {
+		audiences = []string{"namespace-generator"}
+		reviews = 0
+		scheme := runtime.NewScheme()
+		Expect(authenticationv1.AddToScheme(scheme)).To(Succeed())
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+				reviews++
+				review := obj.(*authenticationv1.TokenReview)
+				review.Status = authenticationv1.TokenReviewStatus{
+					Authenticated: true,
+					User:          authenticationv1.UserInfo{Username: "system:serviceaccount:argocd:applicationset-controller"},
+					Audiences:     audiences,
+				}
+				return nil
+			},
+		}).Build()
+		reviewer = tokenreview.NewReviewer(cl, tokenreview.Config{Audiences: []string{"namespace-generator"}, MaxLifetime: 2 * time.Hour})
+	})
+
+	It("should authenticate the tokens bound to an accepted audience", func(ctx SpecContext) {
+		now := time.Now()
+		valid := token(map[string]any{"iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+		user, err := reviewer.Review(ctx, valid)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(user).To(Equal("system:serviceaccount:argocd:applicationset-controller"))
+
+		// The reviewed tokens are cached.
+		_, err = reviewer.Review(ctx, valid)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reviews).To(Equal(1))
+	})
+
+	It("should reject the tokens of another audience", func(ctx SpecContext) {
+		audiences = []string{"https://kubernetes.default.svc"}
+		now := time.Now()
+		_, err := reviewer.Review(ctx, token(map[string]any{"iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}))
+		Expect(err).To(MatchError(ContainSubstring("aren't accepted")))
+	})
+
+	It("should reject the tokens without a bounded lifetime before reviewing them", func(ctx SpecContext) {
+		now := time.Now()
+		for _, entry := range []struct {
+			claims map[string]any
+			err    string
+		}{
+			{claims: map[string]any{"iat": now.Unix()}, err: "doesn't expire"},
+			{claims: map[string]any{"iat": now.Add(-2 * time.Hour).Unix(), "exp": now.Add(-time.Hour).Unix()}, err: "expired"},
+			{claims: map[string]any{"exp": now.Add(time.Hour).Unix()}, err: "no issue time"},
+			{claims: map[string]any{"iat": now.Unix(), "exp": now.Add(24 * time.Hour).Unix()}, err: "longer than the maximum"},
+		} {
+			_, err := reviewer.Review(ctx, token(entry.claims))
+			Expect(err).To(MatchError(ContainSubstring(entry.err)), "claims %v", entry.claims)
+		}
+		_, err := reviewer.Review(ctx, "not-a-jwt")
+		Expect(err).To(HaveOccurred())
+		Expect(reviews).To(BeZero())
+	})
+})
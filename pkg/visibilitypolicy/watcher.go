@@ -0,0 +1,126 @@
+// Package visibilitypolicy follows the NamespaceVisibilityPolicy resources
+// restricting what the consumers of the generator may query.
+package visibilitypolicy
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/logging"
+)
+
+const watchRetryInterval = 5 * time.Second
+
+// Watcher calls onChange with all the NamespaceVisibilityPolicy resources,
+// sorted by name, when any of them changes.
+type Watcher struct {
+	client   client.WithWatch
+	logger   *slog.Logger
+	onChange func(policies []generatorv1alpha1.NamespaceVisibilityPolicy)
+
+	policies map[string]generatorv1alpha1.NamespaceVisibilityPolicy
+}
+
+func NewWatcher(cl client.WithWatch, logger *slog.Logger, onChange func(policies []generatorv1alpha1.NamespaceVisibilityPolicy)) *Watcher {
+	return &Watcher{client: cl, logger: logger, onChange: onChange}
+}
+
+// Run follows the resources until the context is done.
+func (watcher *Watcher) Run(ctx context.Context) {
+	for {
+		resourceVersion, err := watcher.sync(ctx)
+		if err == nil {
+			err = watcher.watch(ctx, resourceVersion)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			watcher.logger.Error("Failed to watch NamespaceVisibilityPolicies", logging.KeyError, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// sync reports the current policies and returns the resource version to start
+// watching from.
+func (watcher *Watcher) sync(ctx context.Context) (string, error) {
+	policyList := &generatorv1alpha1.NamespaceVisibilityPolicyList{}
+	if err := watcher.client.List(ctx, policyList); err != nil {
+		return "", err
+	}
+
+	watcher.policies = map[string]generatorv1alpha1.NamespaceVisibilityPolicy{}
+	for _, policy := range policyList.Items {
+		watcher.policies[policy.Name] = policy
+	}
+	watcher.report()
+	return policyList.ResourceVersion, nil
+}
+
+// watch reports changes until the watch is closed by the API server. A nil
+// error means the caller should sync again and restart the watch.
+func (watcher *Watcher) watch(ctx context.Context, resourceVersion string) error {
+	w, err := watcher.client.Watch(ctx, &generatorv1alpha1.NamespaceVisibilityPolicyList{}, &client.ListOptions{
+		Raw: &metav1.ListOptions{
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			policy, isPolicy := event.Object.(*generatorv1alpha1.NamespaceVisibilityPolicy)
+			switch event.Type {
+			case watch.Error:
+				// Most likely the resource version is too old, start over.
+				watcher.logger.Debug("NamespaceVisibilityPolicy watch returned an error", "object", event.Object)
+				return nil
+			case watch.Added, watch.Modified:
+				if isPolicy {
+					watcher.policies[policy.Name] = *policy
+					watcher.report()
+				}
+			case watch.Deleted:
+				if isPolicy {
+					delete(watcher.policies, policy.Name)
+					watcher.report()
+				}
+			}
+		}
+	}
+}
+
+func (watcher *Watcher) report() {
+	policies := make([]generatorv1alpha1.NamespaceVisibilityPolicy, 0, len(watcher.policies))
+	for _, policy := range watcher.policies {
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+	watcher.onChange(policies)
+}
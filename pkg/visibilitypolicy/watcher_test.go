@@ -0,0 +1,62 @@
+package visibilitypolicy_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	generatorv1alpha1 "github.com/konflux-ci/namespace-generator/pkg/api/generator/v1alpha1"
+	"github.com/konflux-ci/namespace-generator/pkg/visibilitypolicy"
+)
+
+func TestVisibilityPolicy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "VisibilityPolicy Suite")
+}
+
+func policy(name string, callers ...string) *generatorv1alpha1.NamespaceVisibilityPolicy {
+	consumers := make([]generatorv1alpha1.Consumer, 0, len(callers))
+	for _, caller := range callers {
+		consumers = append(consumers, generatorv1alpha1.Consumer{Caller: caller})
+	}
+	return &generatorv1alpha1.NamespaceVisibilityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: generatorv1alpha1.NamespaceVisibilityPolicySpec{
+			Consumers:    consumers,
+			LabelDomains: []string{"konflux.ci"},
+		},
+	}
+}
+
+var _ = Describe("Watcher", func() {
+	It("should report the policies whenever they change", func(ctx SpecContext) {
+		scheme := runtime.NewScheme()
+		Expect(generatorv1alpha1.AddToScheme(scheme)).To(Succeed())
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy("team-b", "team-b")).Build()
+
+		reports := make(chan []string, 10)
+		watcherCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go visibilitypolicy.NewWatcher(cl, slog.Default(), func(policies []generatorv1alpha1.NamespaceVisibilityPolicy) {
+			names := make([]string, 0, len(policies))
+			for _, policy := range policies {
+				names = append(names, policy.Name)
+			}
+			reports <- names
+		}).Run(watcherCtx)
+
+		Eventually(reports).Should(Receive(Equal([]string{"team-b"})))
+		Expect(cl.Create(ctx, policy("team-a", "team-a"))).To(Succeed())
+		Eventually(reports).Should(Receive(Equal([]string{"team-a", "team-b"})))
+		Expect(cl.Delete(ctx, policy("team-b"))).To(Succeed())
+		Eventually(reports).Should(Receive(Equal([]string{"team-a"})))
+	})
+})